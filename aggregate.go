@@ -0,0 +1,91 @@
+package datastore
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "strings"
+)
+
+// aggregateFuncs are the CQL aggregate functions accepted by Aggregate.
+var aggregateFuncs = map[string]bool{
+  "count": true,
+  "min":   true,
+  "max":   true,
+  "sum":   true,
+  "avg":   true,
+}
+
+// parseAggregate validates the aggregate expression expr, a function applied
+// to a column, and returns it normalized.
+func parseAggregate(codec *structCodec, expr string) (string, error) {
+  e := strings.TrimSpace(expr)
+  open := strings.Index(e, "(")
+  if open < 0 || !strings.HasSuffix(e, ")") {
+    return "", fmt.Errorf("datastore: invalid aggregate %q", expr)
+  }
+  fn := strings.ToLower(strings.TrimSpace(e[:open]))
+  col := strings.TrimSpace(e[open+1 : len(e)-1])
+  if !aggregateFuncs[fn] {
+    return "", fmt.Errorf("datastore: unknown aggregate function %q in %q",
+      fn, expr)
+  }
+  if col == "*" {
+    if fn != "count" {
+      return "", fmt.Errorf("datastore: invalid aggregate %q", expr)
+    }
+  } else if !codec.hasColumn(col) {
+    return "", fmt.Errorf("datastore: fieldname %s not found in %q", col,
+      expr)
+  }
+  return fmt.Sprintf("%s(%s)", fn, col), nil
+}
+
+// Aggregate computes the aggregate expr over the rows matching the query
+// filters and stores the result in dst, a pointer to a value of the type
+// CQL returns: expr is one of count, min, max, sum or avg applied to a
+// column, e.g. "max(bid)", or count(*). Projection, order and limit are
+// ignored.
+func (q *Query) Aggregate(session Session, expr string, dst interface{},
+  opts ...Option) error {
+  return q.AggregateContext(context.Background(), session, expr, dst,
+    opts...)
+}
+
+// AggregateContext is like Aggregate but executes the query with ctx.
+func (q *Query) AggregateContext(ctx context.Context, session Session,
+  expr string, dst interface{}, opts ...Option) error {
+
+  if q.err != nil {
+    return q.err
+  }
+  if q.raw != nil {
+    return errors.New("datastore: cannot aggregate a raw query")
+  }
+  if _, post := q.splitFilters(); len(post) > 0 {
+    return errors.New("datastore: cannot aggregate a post-filtered query")
+  }
+  agg, err := parseAggregate(q.codec, expr)
+  if err != nil {
+    return err
+  }
+  whereClause, args, err := getWhereClause(q.codec, q.filter)
+  if err != nil {
+    return err
+  }
+  if args, err = bindParams(args, q.params); err != nil {
+    return err
+  }
+  o := newQueryOptions(ctx, q.opts, opts)
+  cql := fmt.Sprintf("SELECT %s FROM %s%s", agg, o.tableOf(q.codec),
+    whereClause)
+  if q.allowFiltering {
+    cql = cql + " ALLOW FILTERING"
+  }
+
+  stmt := newStatement(o, cql, args)
+  iter, cancel := run(ctx, session, stmt)
+  defer cancel()
+  iter.Scan(dst)
+  return iter.Close()
+}
@@ -0,0 +1,84 @@
+package datastore
+
+import (
+  "fmt"
+  "reflect"
+  "time"
+)
+
+// AutoTime returns the current time as set into the columns tagged
+// autocreatetime or autoupdatetime, in UTC and truncated to the millisecond
+// precision of CQL timestamps, so that the saved entities equal their
+// loaded copies. It can be replaced, e.g. by tests.
+//
+// Saves set the autocreatetime columns of the entity if zero and its
+// autoupdatetime columns always, after its BeforeSave hook. An UpdateQuery
+// also sets the autoupdatetime columns it does not update explicitly. As
+// saves are upserts, the creation time of an entity saved again with a zero
+// field is overwritten: load it first.
+var AutoTime = func() time.Time {
+  return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+// checkAutoTimeField checks the field f of the column tag set by saves.
+func checkAutoTimeField(tag *structTag, f reflect.StructField) error {
+  if tag.autoTime == "" {
+    return nil
+  }
+  if f.Type != typeOfTime {
+    return fmt.Errorf("datastore: auto%stime field %s must be a time.Time",
+      tag.autoTime, f.Name)
+  }
+  if tag.partitionKey || tag.clusteringKey || tag.function != "" ||
+    tag.version {
+    return fmt.Errorf("datastore: auto%stime field %s cannot have key, "+
+      "function or version options", tag.autoTime, f.Name)
+  }
+  return nil
+}
+
+// setAutoTimes sets the columns of the struct v tagged autocreatetime or
+// autoupdatetime to the current time.
+func (codec *structCodec) setAutoTimes(v reflect.Value) {
+  if len(codec.autoTimes) == 0 {
+    return
+  }
+  now := reflect.ValueOf(AutoTime())
+  for _, i := range codec.autoTimes {
+    f := v.FieldByIndex(codec.byIndex[i].index)
+    if codec.byIndex[i].autoTime == "update" || f.IsZero() {
+      f.Set(now)
+    }
+  }
+}
+
+// autoUpdates returns updates along with the assignments of the current time
+// to the autoupdatetime columns they do not update.
+func (codec *structCodec) autoUpdates(updates []update) []update {
+  var res []update
+  for _, i := range codec.autoTimes {
+    tag := codec.byIndex[i]
+    if tag.autoTime != "update" || hasUpdate(updates, tag.name) {
+      continue
+    }
+    if res == nil {
+      res = append(make([]update, 0, len(updates)+1), updates...)
+    }
+    res = append(res, update{FieldName: tag.name, Op: assign,
+      Value: AutoTime()})
+  }
+  if res == nil {
+    return updates
+  }
+  return res
+}
+
+// hasUpdate reports whether updates update the column name.
+func hasUpdate(updates []update, name string) bool {
+  for _, u := range updates {
+    if u.FieldName == name {
+      return true
+    }
+  }
+  return false
+}
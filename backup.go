@@ -0,0 +1,248 @@
+package datastore
+
+import (
+  "bufio"
+  "context"
+  "encoding/binary"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io"
+  "reflect"
+)
+
+// backupFormat identifies the backups written by Backup.
+const backupFormat = "datastore/backup/v1"
+
+// backupSplits is the number of token ranges a backup reads one by one.
+const backupSplits = 64
+
+// maxBackupRecord bounds the length of the records Restore accepts, keeping
+// a corrupted length from allocating without limit.
+const maxBackupRecord = 256 << 20
+
+// backupHeader is the first record of a backup.
+type backupHeader struct {
+  Format string `json:"format"`
+  Table  string `json:"table"`
+}
+
+// backupRow is a row record of a backup.
+type backupRow struct {
+  // Timestamp is the latest write time of the regular columns of the row,
+  // in microseconds since the epoch, 0 if unknown.
+  Timestamp int64 `json:"ts,omitempty"`
+  // Row is the JSON document of the row, in the JSON format of the CQL
+  // types, without its null columns.
+  Row json.RawMessage `json:"row"`
+}
+
+// timestamped reports whether the column tag of a field of type t has a
+// write time that can be selected: it is a regular column, and not a
+// counter or a non frozen collection or UDT.
+func (tag *structTag) timestamped(t reflect.Type) bool {
+  if !tag.stored() || tag.partitionKey || tag.clusteringKey || tag.counter {
+    return false
+  }
+  return tag.frozen || tag.tuple || !isFreezable(t)
+}
+
+// Backup writes the rows of the column family of the entity type typ to w,
+// for a logical backup taken through CQL rather than with nodetool
+// snapshots. The table is read token range by token range, see
+// Query.TokenRange, so that no single query spans it. The options apply to
+// the queries, e.g. Table to back up another table of the same schema.
+//
+// The backup is a sequence of records, each prefixed by its length as an
+// unsigned varint: a header naming the table, then a record per row holding
+// its JSON document, in the JSON format of the CQL types, and the latest
+// write time of its columns. Restore replays it. Backup returns the number
+// of rows written. Counter tables cannot be backed up.
+func Backup(session Session, typ reflect.Type, w io.Writer,
+  opts ...Option) (int, error) {
+  return BackupContext(context.Background(), session, typ, w, opts...)
+}
+
+// BackupContext is like Backup but executes the queries with ctx.
+func BackupContext(ctx context.Context, session Session, typ reflect.Type,
+  w io.Writer, opts ...Option) (int, error) {
+
+  codec, err := getStructCodec(typ)
+  if err != nil {
+    return 0, err
+  }
+  if codec.hasCounters {
+    return 0, fmt.Errorf("datastore: cannot back up counter table %s",
+      codec.columnFamily)
+  }
+  q, err := NewQuery(typ, opts...)
+  if err != nil {
+    return 0, err
+  }
+  var cols, times []string
+  for _, tag := range codec.byIndex {
+    if !tag.stored() {
+      continue
+    }
+    cols = append(cols, tag.name)
+    if tag.timestamped(typ.FieldByIndex(tag.index).Type) {
+      times = append(times, "writetime("+tag.name+")")
+    }
+  }
+  q = q.Project(append(cols, times...)...).JSON()
+
+  // the table is recorded without its keyspace, the one of the session or
+  // of the options of the restore applying
+  header := backupHeader{Format: backupFormat, Table: codec.columnFamily}
+  if o := newOptions(ctx, opts); o.table != "" {
+    header.Table = o.table
+  }
+  bw := bufio.NewWriter(w)
+  if err := writeBackupRecord(bw, header); err != nil {
+    return 0, err
+  }
+  n := 0
+  for _, r := range tokenRanges(backupSplits) {
+    iter := q.TokenRange(r[0], r[1]).RunContext(ctx, session)
+    for {
+      var doc json.RawMessage
+      err := iter.Next(&doc)
+      if err == Done {
+        break
+      }
+      if err == nil {
+        var row backupRow
+        if row, err = backupRowOf(doc, times); err == nil {
+          err = writeBackupRecord(bw, row)
+        }
+      }
+      if err != nil {
+        iter.Close()
+        return n, err
+      }
+      n++
+    }
+    if err := iter.Close(); err != nil {
+      return n, err
+    }
+  }
+  return n, bw.Flush()
+}
+
+// backupRowOf returns the record of the row selected as the JSON document
+// doc, whose times columns are the write times of its columns.
+func backupRowOf(doc json.RawMessage, times []string) (backupRow, error) {
+  var values map[string]json.RawMessage
+  if err := json.Unmarshal(doc, &values); err != nil {
+    return backupRow{}, fmt.Errorf("datastore: decoding row %s: %v", doc,
+      err)
+  }
+  var row backupRow
+  for _, t := range times {
+    var ts *int64
+    if err := json.Unmarshal(values[t], &ts); err != nil {
+      return backupRow{}, fmt.Errorf("datastore: decoding %s: %v", t, err)
+    }
+    if ts != nil && *ts > row.Timestamp {
+      row.Timestamp = *ts
+    }
+    delete(values, t)
+  }
+  for col, v := range values {
+    // null columns are left out rather than restored as tombstones
+    if string(v) == "null" {
+      delete(values, col)
+    }
+  }
+  var err error
+  row.Row, err = json.Marshal(values)
+  return row, err
+}
+
+// writeBackupRecord writes the record rec, encoded in JSON and prefixed by
+// its length, to w.
+func writeBackupRecord(w io.Writer, rec interface{}) error {
+  b, err := json.Marshal(rec)
+  if err != nil {
+    return err
+  }
+  var size [binary.MaxVarintLen64]byte
+  n := binary.PutUvarint(size[:], uint64(len(b)))
+  if _, err := w.Write(size[:n]); err != nil {
+    return err
+  }
+  _, err = w.Write(b)
+  return err
+}
+
+// readBackupRecord reads the next record of r into rec. It returns io.EOF
+// at the end of the backup.
+func readBackupRecord(r *bufio.Reader, rec interface{}) error {
+  size, err := binary.ReadUvarint(r)
+  if err != nil {
+    if err == io.EOF {
+      return err
+    }
+    return fmt.Errorf("datastore: reading backup: %v", err)
+  }
+  if size > maxBackupRecord {
+    return fmt.Errorf("datastore: backup record of %d bytes", size)
+  }
+  b := make([]byte, size)
+  if _, err := io.ReadFull(r, b); err != nil {
+    return fmt.Errorf("datastore: reading backup: %v", err)
+  }
+  if err := json.Unmarshal(b, rec); err != nil {
+    return fmt.Errorf("datastore: decoding backup record: %v", err)
+  }
+  return nil
+}
+
+// Restore replays the backup written by Backup read from r, inserting its
+// rows into the table it was taken from, or the one given with the Table
+// option, and returns the number of rows restored. Each row is inserted
+// with INSERT JSON and USING TIMESTAMP its latest write time, so that the
+// rows written since the backup win over the restored ones. The other
+// options apply to the inserts; the restore stops at the first error.
+func Restore(session Session, r io.Reader, opts ...Option) (int, error) {
+  return RestoreContext(context.Background(), session, r, opts...)
+}
+
+// RestoreContext is like Restore but executes the inserts with ctx.
+func RestoreContext(ctx context.Context, session Session, r io.Reader,
+  opts ...Option) (int, error) {
+
+  br := bufio.NewReader(r)
+  var header backupHeader
+  if err := readBackupRecord(br, &header); err != nil {
+    if err == io.EOF {
+      err = errors.New("datastore: empty backup")
+    }
+    return 0, err
+  }
+  if header.Format != backupFormat {
+    return 0, fmt.Errorf("datastore: unknown backup format %q",
+      header.Format)
+  }
+  opts = opts[:len(opts):len(opts)]
+  n := 0
+  for {
+    var row backupRow
+    err := readBackupRecord(br, &row)
+    if err == io.EOF {
+      return n, nil
+    }
+    if err != nil {
+      return n, err
+    }
+    rowOpts := opts
+    if row.Timestamp > 0 {
+      rowOpts = append(opts, Timestamp(row.Timestamp))
+    }
+    if err := SaveJSONContext(ctx, session, header.Table, row.Row,
+      rowOpts...); err != nil {
+      return n, err
+    }
+    n++
+  }
+}
@@ -0,0 +1,154 @@
+package datastore
+
+import (
+  "context"
+  "fmt"
+
+  "github.com/gocql/gocql"
+)
+
+// Batch collects inserts, updates and deletes to execute them as a single
+// CQL batch, in one round trip. The statements are built when the batch is
+// run, from the entities and queries as they are then, so that they honor
+// the defaults of a Client and the settings of the context, e.g. the
+// Keyspace, Table and EntityCache options.
+type Batch struct {
+  typ  gocql.BatchType
+  opts []Option
+  // adds build the statements of the batch.
+  adds []batchAdd
+}
+
+// batchAdd builds a statement of a batch, and the rows it writes, see
+// EntityCache, with the options sets of the run.
+type batchAdd func(ctx context.Context, sets [][]Option) (*Statement,
+  cachedRows, error)
+
+// NewBatch returns an empty batch of the given type: gocql.LoggedBatch,
+// gocql.UnloggedBatch or gocql.CounterBatch. The options become the defaults
+// for running the batch and for the statements added to it.
+func NewBatch(typ gocql.BatchType, opts ...Option) *Batch {
+  return &Batch{typ: typ, opts: opts}
+}
+
+// Size returns the number of statements in the batch.
+func (b *Batch) Size() int {
+  return len(b.adds)
+}
+
+// Save adds the insert of src to the batch, src must be a struct pointer of
+// column family kind. The options apply to this statement only; only the
+// ones changing the statement itself, such as TTL, are honored. It fails
+// for the types with a version column, whose saves are conditional, see
+// ErrConcurrentModification, and for the types with unique columns, whose
+// values a batch does not claim, see UniqueTableDefs.
+func (b *Batch) Save(src interface{}, opts ...Option) error {
+  x, err := newLoadSaver(src)
+  if err != nil {
+    return err
+  }
+  err = checkBatchSave(x)
+  releaseLoadSaver(x)
+  if err != nil {
+    return err
+  }
+  b.adds = append(b.adds, func(ctx context.Context, sets [][]Option) (
+    *Statement, cachedRows, error) {
+
+    x, err := newLoadSaver(src)
+    if err != nil {
+      return nil, cachedRows{}, err
+    }
+    defer releaseLoadSaver(x)
+    o := newQueryOptions(ctx, b.opts, append(sets, opts)...)
+    cql, args, err := x.insertCQL(o)
+    if err != nil {
+      return nil, cachedRows{}, err
+    }
+    return newStatement(o, cql, args), entityRows(o, src), nil
+  })
+  return nil
+}
+
+// checkBatchSave checks that the entity adapted by ls can be saved by the
+// plain insert of a batch.
+func checkBatchSave(ls loadSaver) error {
+  if c := codecOf(ls); c != nil && c.version != "" {
+    return fmt.Errorf("datastore: %v has a version column, which the "+
+      "saves of a Batch do not check", c.typ)
+  }
+  if c := codecOf(ls); c != nil && len(c.uniques) > 0 {
+    return fmt.Errorf("datastore: %v has unique columns, which the saves "+
+      "of a Batch do not claim", c.typ)
+  }
+  return nil
+}
+
+// Update adds the update q to the batch.
+func (b *Batch) Update(q *UpdateQuery) error {
+  if q.err != nil {
+    return q.err
+  }
+  b.adds = append(b.adds, func(ctx context.Context, sets [][]Option) (
+    *Statement, cachedRows, error) {
+
+    o := newQueryOptions(ctx, b.opts, append(sets, q.opts)...)
+    cql, args, err := q.toCQL(o)
+    if err != nil {
+      return nil, cachedRows{}, err
+    }
+    return newStatement(o, cql, args),
+      filteredRows(o, q.codec, q.filter, q.params), nil
+  })
+  return nil
+}
+
+// Delete adds the delete q to the batch.
+func (b *Batch) Delete(q *DeleteQuery) error {
+  if q.err != nil {
+    return q.err
+  }
+  b.adds = append(b.adds, func(ctx context.Context, sets [][]Option) (
+    *Statement, cachedRows, error) {
+
+    o := newQueryOptions(ctx, b.opts, append(sets, q.opts)...)
+    cql, args, err := q.toCQL(o)
+    if err != nil {
+      return nil, cachedRows{}, err
+    }
+    return newStatement(o, cql, args),
+      filteredRows(o, q.codec, q.filter, q.params), nil
+  })
+  return nil
+}
+
+// Run executes the batch. The options override the ones the batch was
+// created with.
+func (b *Batch) Run(session Session, opts ...Option) error {
+  return b.RunContext(context.Background(), session, opts...)
+}
+
+// RunContext is like Run but executes the batch with ctx.
+func (b *Batch) RunContext(ctx context.Context, session Session,
+  opts ...Option) error {
+
+  o := newQueryOptions(ctx, b.opts, opts)
+  batch := &BatchStatement{
+    Type:       b.typ,
+    Statements: make([]*Statement, len(b.adds)),
+    opts:       o,
+  }
+  rows := make([]cachedRows, len(b.adds))
+  for i, add := range b.adds {
+    var err error
+    batch.Statements[i], rows[i], err = add(ctx, [][]Option{opts})
+    if err != nil {
+      return err
+    }
+  }
+  err := execBatch(ctx, session, batch)
+  if cerr := invalidate(ctx, o, rows...); err == nil {
+    err = cerr
+  }
+  return err
+}
@@ -0,0 +1,39 @@
+package datastore_test
+
+import (
+  "context"
+  "reflect"
+  "strings"
+  "testing"
+
+  "github.com/droot/datastore"
+  "github.com/droot/datastore/memstore"
+  "github.com/gocql/gocql"
+)
+
+func TestClientBatchDefaults(t *testing.T) {
+  store := memstore.New()
+  if err := store.RegisterTable("tenant.tweets",
+    reflect.TypeOf(tweet{})); err != nil {
+    t.Fatal(err)
+  }
+  s := &recordingSession{Session: store}
+  client := datastore.NewClient(s).With(datastore.Keyspace("tenant"))
+  b := datastore.NewBatch(gocql.LoggedBatch)
+  if err := b.Save(&tweet{ID: "a", Text: "hello"}); err != nil {
+    t.Fatal(err)
+  }
+  ctx := context.Background()
+  if err := client.Batch(ctx, b); err != nil {
+    t.Fatal(err)
+  }
+  if cql := s.batches[0].Statements[0].CQL; !strings.Contains(cql,
+    "tenant.tweets") {
+    t.Errorf("got %q, want an insert into tenant.tweets", cql)
+  }
+  var got tweet
+  err := client.Get(ctx, &got, []interface{}{"a"})
+  if err != nil || got.Text != "hello" {
+    t.Errorf("got %+v, %v, want the saved tweet", got, err)
+  }
+}
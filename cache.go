@@ -0,0 +1,541 @@
+package datastore
+
+import (
+  "bytes"
+  "container/list"
+  "context"
+  "encoding/gob"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "reflect"
+  "sync"
+  "time"
+
+  "github.com/gocql/gocql"
+)
+
+// Cache is the store of the entity cache, see EntityCache. It maps keys to
+// opaque values and is safe for concurrent use. Its operations map to the
+// GET, SET and DEL commands of Redis, so that a cache shared by the
+// instances of an application is a thin adapter around a Redis client, the
+// expiration of the entries being up to the store:
+//
+//   func (c redisCache) Get(ctx context.Context, key string) ([]byte, bool,
+//     error) {
+//     b, err := c.client.Get(ctx, key).Bytes()
+//     if err == redis.Nil {
+//       return nil, false, nil
+//     }
+//     return b, err == nil, err
+//   }
+//
+// NewLRUCache returns an in-memory store.
+type Cache interface {
+  // Get returns the value of key, ok being false if there is none.
+  Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+  // Set sets the value of key.
+  Set(ctx context.Context, key string, value []byte) error
+  // Delete deletes keys, the ones with no value being ignored.
+  Delete(ctx context.Context, keys ...string) error
+}
+
+// EntityCache makes the operation use the read-through entity cache stored
+// in c, typically given to NewClient for all the operations of a client. A
+// nil c disables the cache.
+//
+// Get, and Query.First when the query filters every primary key column by
+// equality, serve the row from the cache if present, and cache the row read
+// from Cassandra otherwise; see Query.NoCache and Query.RefreshCache to
+// bypass it. The entities are cached as the columns of their rows, loaded
+// like rows read from Cassandra: the AfterLoad hook runs on each load.
+//
+// SaveEntity, DeleteEntity, the updates and deletes, and the batches holding
+// them invalidate the rows they write, identified by their primary keys:
+// the updates and deletes filtering every primary key column by equality or
+// IN. The other writes, e.g. deleting a partition or SaveJSON, and the
+// writes of other clients are not seen by the cache, whose entries should
+// then expire. The entries are keyed by table, qualified by the keyspace
+// given with the Keyspace option only: the sessions sharing a store must
+// use the same keyspace.
+//
+// The cache is best effort: a read failing to use it falls back to
+// Cassandra, while a write failing to invalidate it returns the error, the
+// write itself having been executed. A read racing with a write may cache
+// the row as it was before the write, until it expires or is written again.
+func EntityCache(c Cache) Option {
+  return func(o *options) {
+    o.cache = c
+  }
+}
+
+// cachedRow is the value of a row in the entity cache.
+type cachedRow struct {
+  // Selection is the selection the row was read with, see getColumnStr,
+  // which tells the entries of older versions of the entity type apart.
+  Selection string
+  Columns   []string
+  // Values are the gob encoded values of the columns, nil for nulls.
+  Values [][]byte
+}
+
+// rowCacheKey returns the cache key of the row of table whose primary key
+// columns hold key.
+func rowCacheKey(table string, key []interface{}) (string, error) {
+  b, err := json.Marshal(key)
+  if err != nil {
+    return "", err
+  }
+  return "datastore/" + table + "/" + string(b), nil
+}
+
+// pinnedKeys returns the primary keys of the rows of codec the filters
+// restrict the statement to, resolving the named markers with params, with
+// IN filters if in is set. It reports false if the filters do not restrict
+// every primary key column by equality, or IN, alone.
+func pinnedKeys(codec *structCodec, filters []filter,
+  params map[string]interface{}, in bool) ([][]interface{}, bool) {
+
+  if codec.typ == nil || len(codec.partitionKeys) == 0 {
+    return nil, false
+  }
+  keys := codec.keyColumns()
+  values := make([][]interface{}, len(keys))
+  for _, f := range filters {
+    i := -1
+    for j, k := range keys {
+      if !f.token && k == f.FieldName {
+        i = j
+      }
+    }
+    if i < 0 || values[i] != nil {
+      return nil, false
+    }
+    v := f.Value
+    if p, ok := v.(param); ok {
+      if v, ok = params[p.name]; !ok {
+        return nil, false
+      }
+    }
+    switch {
+    case f.Op == Equal:
+      values[i] = []interface{}{v}
+    case f.Op == In && in:
+      rv := reflect.ValueOf(v)
+      if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+        return nil, false
+      }
+      values[i] = make([]interface{}, rv.Len())
+      for j := range values[i] {
+        values[i][j] = rv.Index(j).Interface()
+      }
+    default:
+      return nil, false
+    }
+  }
+  rows := [][]interface{}{nil}
+  for i, vs := range values {
+    if vs == nil {
+      return nil, false
+    }
+    next := make([][]interface{}, 0, len(rows)*len(vs))
+    for _, row := range rows {
+      for _, v := range vs {
+        next = append(next,
+          append(row[:i:i], codec.keyValue(keys[i], v)))
+      }
+    }
+    rows = next
+  }
+  return rows, true
+}
+
+// keyValue returns v, a value of the key column col, in the type of its
+// field, so that the cache keys of a row do not depend on how its key was
+// given, e.g. as an int or as a UUID string.
+func (codec *structCodec) keyValue(col string, v interface{}) interface{} {
+  tag := codec.byIndex[codec.byName[col].index]
+  t := codec.typ.FieldByIndex(tag.index).Type
+  if t.Kind() == reflect.Ptr {
+    t = t.Elem()
+  }
+  rv := reflect.ValueOf(v)
+  for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+    rv = rv.Elem()
+  }
+  if !rv.IsValid() {
+    return v
+  }
+  switch x := rv.Interface().(type) {
+  case time.Time:
+    return x.UTC()
+  case string:
+    if t == reflect.TypeOf(gocql.UUID{}) {
+      if u, err := gocql.ParseUUID(x); err == nil {
+        return u
+      }
+    }
+  }
+  if isNumber(rv.Kind()) && isNumber(t.Kind()) {
+    return rv.Convert(t).Interface()
+  }
+  return rv.Interface()
+}
+
+// isNumber reports whether k is an integer or floating point kind.
+func isNumber(k reflect.Kind) bool {
+  return reflect.Int <= k && k <= reflect.Float64
+}
+
+// cachedRows are rows of a table, identified by their primary keys, whose
+// cache entries are invalidated by a write.
+type cachedRows struct {
+  table string
+  keys  [][]interface{}
+}
+
+// filteredRows returns the rows of codec in the table of o the filters
+// restrict a write to, see pinnedKeys.
+func filteredRows(o *options, codec *structCodec, filters []filter,
+  params map[string]interface{}) cachedRows {
+
+  keys, ok := pinnedKeys(codec, filters, params, true)
+  if !ok {
+    return cachedRows{}
+  }
+  return cachedRows{o.tableOf(codec), keys}
+}
+
+// entityRows returns the row of the entity src in the table of o.
+func entityRows(o *options, src interface{}) cachedRows {
+  cls, err := newStructCLS(src)
+  if err != nil || len(cls.codec.partitionKeys) == 0 {
+    if err == nil {
+      releaseLoadSaver(cls)
+    }
+    return cachedRows{}
+  }
+  defer releaseLoadSaver(cls)
+  cols, vals := cls.keyArgs()
+  key := make([]interface{}, len(vals))
+  for i, v := range vals {
+    key[i] = cls.codec.keyValue(cols[i], v)
+  }
+  return cachedRows{o.tableOf(cls.codec), [][]interface{}{key}}
+}
+
+// invalidate deletes the entries of rows from the cache of o, if any.
+func invalidate(ctx context.Context, o *options, rows ...cachedRows) error {
+  if o.cache == nil {
+    return nil
+  }
+  var keys []string
+  for _, r := range rows {
+    for _, key := range r.keys {
+      // the keys that cannot be encoded cannot have been cached either
+      if k, err := rowCacheKey(r.table, key); err == nil {
+        keys = append(keys, k)
+      }
+    }
+  }
+  if len(keys) == 0 {
+    return nil
+  }
+  if err := o.cache.Delete(ctx, keys...); err != nil {
+    return fmt.Errorf("datastore: invalidating the entity cache: %v", err)
+  }
+  return nil
+}
+
+// cacheKey returns the cache key of the row the query reads into dst, and
+// false if it is not served by the cache of o: the query must select the
+// columns of the entity type of dst, from the row of a primary key.
+func (q *Query) cacheKey(o *options, dst interface{}) (string, bool) {
+  if o.cache == nil || q.cacheMode == cacheBypass || q.raw != nil ||
+    q.limit == 0 || len(q.projection) > 0 || len(q.order) > 0 ||
+    len(q.groupBy) > 0 || q.json || q.distinct || q.start != nil ||
+    q.pageState != nil || q.err != nil {
+    return "", false
+  }
+  switch dst.(type) {
+  case ColumnLoadSaver, EntityCodec:
+    return "", false
+  }
+  if t := reflect.TypeOf(dst); t == nil || t.Kind() != reflect.Ptr ||
+    t.Elem() != q.codec.typ {
+    return "", false
+  }
+  keys, ok := pinnedKeys(q.codec, q.filter, q.params, false)
+  if !ok {
+    return "", false
+  }
+  key, err := rowCacheKey(o.tableOf(q.codec), keys[0])
+  return key, err == nil
+}
+
+// firstCached loads the row of q into dst from the cache of o, under key,
+// or from Cassandra, caching it. It returns Done if there is no row.
+func (q *Query) firstCached(ctx context.Context, session Session,
+  o *options, key string, dst interface{}, opts []Option) error {
+
+  selection := q.codec.getColumnStr()
+  if q.cacheMode != cacheRefresh && loadCached(ctx, o.cache, key,
+    selection, dst) {
+    return nil
+  }
+  iter := q.RunContext(ctx, session, opts...)
+  iter.started(dst)
+  rec := &recordIter{}
+  if iter.iter != nil {
+    rec.Iter, iter.iter = iter.iter, rec
+  }
+  if err := iter.Next(dst); err != nil {
+    iter.Close()
+    return err
+  }
+  if err := iter.Close(); err != nil {
+    return err
+  }
+  if rec.row != nil && rec.err == nil {
+    rec.row.Selection = selection
+    var buf bytes.Buffer
+    if gob.NewEncoder(&buf).Encode(rec.row) == nil {
+      // a read does not fail for the cache
+      o.cache.Set(ctx, key, buf.Bytes())
+    }
+  }
+  return nil
+}
+
+// loadCached loads the row cached under key into dst, and reports whether
+// it was, the row having been read with selection.
+func loadCached(ctx context.Context, c Cache, key, selection string,
+  dst interface{}) bool {
+
+  b, ok, err := c.Get(ctx, key)
+  if err != nil || !ok {
+    return false
+  }
+  var row cachedRow
+  if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&row); err != nil ||
+    row.Selection != selection || len(row.Values) != len(row.Columns) {
+    return false
+  }
+  return LoadEntity(dst, &cachedIter{row: &row}) == nil
+}
+
+// cacheDest returns the value the destination dest of a column is scanned
+// into.
+func cacheDest(dest interface{}) (reflect.Value, bool) {
+  switch d := dest.(type) {
+  case udtValue:
+    return d.v, true
+  case nullUDT:
+    return d.v, true
+  }
+  v := reflect.ValueOf(dest)
+  if v.Kind() != reflect.Ptr || v.IsNil() {
+    return reflect.Value{}, false
+  }
+  return v.Elem(), true
+}
+
+// recordIter records the first row scanned from Iter, before the entity
+// it is loaded into is notified, see AfterLoader.
+type recordIter struct {
+  Iter
+  columns []string
+  row     *cachedRow
+  err     error
+}
+
+func (r *recordIter) RowData() (gocql.RowData, error) {
+  rowData, err := r.Iter.RowData()
+  r.columns = rowData.Columns
+  return rowData, err
+}
+
+func (r *recordIter) Warnings() []string {
+  return iterWarnings(r.Iter)
+}
+
+func (r *recordIter) Scan(dest ...interface{}) bool {
+  if !r.Iter.Scan(dest...) {
+    return false
+  }
+  if r.row != nil || r.err != nil {
+    return true
+  }
+  if len(r.columns) != len(dest) {
+    r.err = errors.New("datastore: columns and destinations differ")
+    return true
+  }
+  row := &cachedRow{Columns: r.columns, Values: make([][]byte, len(dest))}
+  for i, d := range dest {
+    v, ok := cacheDest(d)
+    if !ok {
+      r.err = fmt.Errorf("datastore: cannot cache %T", d)
+      return true
+    }
+    switch v.Kind() {
+    case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+      if v.IsNil() {
+        continue
+      }
+    }
+    var buf bytes.Buffer
+    if r.err = gob.NewEncoder(&buf).EncodeValue(v); r.err != nil {
+      return true
+    }
+    row.Values[i] = buf.Bytes()
+  }
+  r.row = row
+  return true
+}
+
+// cachedIter iterates over a cached row.
+type cachedIter struct {
+  row     *cachedRow
+  scanned bool
+  err     error
+}
+
+func (c *cachedIter) RowData() (gocql.RowData, error) {
+  values := make([]interface{}, len(c.row.Columns))
+  for i := range values {
+    values[i] = new(interface{})
+  }
+  return gocql.RowData{Columns: c.row.Columns, Values: values}, nil
+}
+
+func (c *cachedIter) Scan(dest ...interface{}) bool {
+  if c.scanned || c.err != nil {
+    return false
+  }
+  c.scanned = true
+  for i, d := range dest {
+    if _, ok := d.(*interface{}); ok {
+      // a column the entity does not load
+      continue
+    }
+    v, ok := cacheDest(d)
+    if !ok {
+      c.err = fmt.Errorf("datastore: cannot load %T from the cache", d)
+      return false
+    }
+    if c.row.Values[i] == nil {
+      v.Set(reflect.Zero(v.Type()))
+      continue
+    }
+    dec := gob.NewDecoder(bytes.NewReader(c.row.Values[i]))
+    if c.err = dec.DecodeValue(v); c.err != nil {
+      return false
+    }
+  }
+  return true
+}
+
+func (c *cachedIter) PageState() []byte {
+  return nil
+}
+
+func (c *cachedIter) Close() error {
+  return c.err
+}
+
+// LRUCache is an in-memory Cache holding a bounded number of entries, the
+// least recently used ones being evicted first.
+type LRUCache struct {
+  size int
+  ttl  time.Duration
+
+  mu      sync.Mutex
+  entries map[string]*list.Element
+  // lru holds the *lruEntry values, the most recently used first.
+  lru *list.List
+}
+
+type lruEntry struct {
+  key     string
+  value   []byte
+  expires time.Time
+}
+
+// NewLRUCache returns a cache holding up to size entries, any number of them
+// if size is not positive, each expiring ttl after being set, never if ttl
+// is 0.
+func NewLRUCache(size int, ttl time.Duration) *LRUCache {
+  return &LRUCache{
+    size:    size,
+    ttl:     ttl,
+    entries: make(map[string]*list.Element),
+    lru:     list.New(),
+  }
+}
+
+// Get returns the value of key.
+func (c *LRUCache) Get(ctx context.Context, key string) ([]byte, bool,
+  error) {
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  el, ok := c.entries[key]
+  if !ok {
+    return nil, false, nil
+  }
+  e := el.Value.(*lruEntry)
+  if !e.expires.IsZero() && time.Now().After(e.expires) {
+    c.lru.Remove(el)
+    delete(c.entries, key)
+    return nil, false, nil
+  }
+  c.lru.MoveToFront(el)
+  return e.value, true, nil
+}
+
+// Set sets the value of key, evicting the least recently used entry if the
+// cache is full.
+func (c *LRUCache) Set(ctx context.Context, key string, value []byte) error {
+  e := &lruEntry{key: key, value: value}
+  if c.ttl > 0 {
+    e.expires = time.Now().Add(c.ttl)
+  }
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  if el, ok := c.entries[key]; ok {
+    el.Value = e
+    c.lru.MoveToFront(el)
+    return nil
+  }
+  c.entries[key] = c.lru.PushFront(e)
+  for c.size > 0 && c.lru.Len() > c.size {
+    el := c.lru.Back()
+    if el == nil {
+      break
+    }
+    c.lru.Remove(el)
+    delete(c.entries, el.Value.(*lruEntry).key)
+  }
+  return nil
+}
+
+// Delete deletes keys.
+func (c *LRUCache) Delete(ctx context.Context, keys ...string) error {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  for _, key := range keys {
+    if el, ok := c.entries[key]; ok {
+      c.lru.Remove(el)
+      delete(c.entries, key)
+    }
+  }
+  return nil
+}
+
+// Len returns the number of entries of the cache, the expired ones
+// included until they are evicted.
+func (c *LRUCache) Len() int {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  return c.lru.Len()
+}
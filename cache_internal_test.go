@@ -0,0 +1,51 @@
+package datastore
+
+import (
+  "reflect"
+  "testing"
+)
+
+func TestPinnedKeys(t *testing.T) {
+  type post struct {
+    ColumnFamily string `cql:"posts"`
+    Timeline     string `cql:"timeline,pk"`
+    Seq          int    `cql:"seq,ck"`
+  }
+  codec, err := getStructCodec(reflect.TypeOf(post{}))
+  if err != nil {
+    t.Fatal(err)
+  }
+  eq := func(col string, v interface{}) filter {
+    return filter{FieldName: col, Op: Equal, Value: v}
+  }
+  in := func(col string, v interface{}) filter {
+    return filter{FieldName: col, Op: In, Value: v}
+  }
+  tests := []struct {
+    filters []filter
+    in      bool
+    want    [][]interface{}
+  }{
+    {[]filter{eq("timeline", "me"), eq("seq", 1)}, false,
+      [][]interface{}{{"me", 1}}},
+    // the key is converted to the type of the field
+    {[]filter{eq("timeline", "me"), eq("seq", int64(1))}, false,
+      [][]interface{}{{"me", 1}}},
+    {[]filter{in("timeline", []string{"me", "you"}), in("seq", []int{1, 2})},
+      true, [][]interface{}{{"me", 1}, {"me", 2}, {"you", 1}, {"you", 2}}},
+    {[]filter{in("timeline", []string{"me"}), eq("seq", 1)}, false, nil},
+    {[]filter{eq("timeline", "me")}, false, nil},
+    {[]filter{eq("timeline", "me"), {FieldName: "seq", Op: GreaterThan,
+      Value: 1}}, false, nil},
+    {[]filter{eq("timeline", "me"), eq("seq", 1), eq("seq", 2)}, false, nil},
+    {[]filter{eq("timeline", "me"), eq("seq", param{name: "seq"})}, false,
+      [][]interface{}{{"me", 2}}},
+  }
+  params := map[string]interface{}{"seq": 2}
+  for i, test := range tests {
+    got, ok := pinnedKeys(codec, test.filters, params, test.in)
+    if ok != (test.want != nil) || !reflect.DeepEqual(got, test.want) {
+      t.Errorf("%d: got %v %v, want %v", i, got, ok, test.want)
+    }
+  }
+}
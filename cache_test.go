@@ -0,0 +1,197 @@
+package datastore_test
+
+import (
+  "context"
+  "reflect"
+  "strings"
+  "testing"
+  "time"
+
+  "github.com/droot/datastore"
+)
+
+func TestLRUCacheEviction(t *testing.T) {
+  ctx := context.Background()
+  c := datastore.NewLRUCache(2, 0)
+  c.Set(ctx, "a", []byte("a"))
+  c.Set(ctx, "b", []byte("b"))
+  // a becomes the most recently used entry, b is evicted
+  if _, ok, _ := c.Get(ctx, "a"); !ok {
+    t.Fatal("a was evicted")
+  }
+  c.Set(ctx, "c", []byte("c"))
+  if _, ok, _ := c.Get(ctx, "b"); ok {
+    t.Error("b was not evicted")
+  }
+  for _, key := range []string{"a", "c"} {
+    if v, ok, _ := c.Get(ctx, key); !ok || string(v) != key {
+      t.Errorf("got %q %v for %s", v, ok, key)
+    }
+  }
+  if c.Len() != 2 {
+    t.Errorf("got %d entries, want 2", c.Len())
+  }
+  c.Delete(ctx, "a", "missing")
+  if _, ok, _ := c.Get(ctx, "a"); ok || c.Len() != 1 {
+    t.Error("a was not deleted")
+  }
+}
+
+func TestLRUCacheUnbounded(t *testing.T) {
+  ctx := context.Background()
+  for _, size := range []int{0, -1} {
+    c := datastore.NewLRUCache(size, 0)
+    for _, key := range []string{"a", "b", "c"} {
+      if err := c.Set(ctx, key, []byte(key)); err != nil {
+        t.Fatal(err)
+      }
+    }
+    if c.Len() != 3 {
+      t.Errorf("size %d: got %d entries, want 3", size, c.Len())
+    }
+  }
+}
+
+func TestLRUCacheExpiry(t *testing.T) {
+  ctx := context.Background()
+  c := datastore.NewLRUCache(2, time.Millisecond)
+  c.Set(ctx, "a", []byte("a"))
+  time.Sleep(2 * time.Millisecond)
+  if _, ok, _ := c.Get(ctx, "a"); ok || c.Len() != 0 {
+    t.Error("a did not expire")
+  }
+}
+
+type timelinePost struct {
+  ColumnFamily string `cql:"timeline"`
+  Timeline     string `cql:"timeline,pk"`
+  Seq          int    `cql:"seq,ck"`
+  Text         string `cql:"text"`
+}
+
+// reads returns the number of selects s executed.
+func (s *recordingSession) reads() int {
+  n := 0
+  for _, stmt := range s.stmts {
+    if strings.HasPrefix(stmt.CQL, "SELECT") {
+      n++
+    }
+  }
+  return n
+}
+
+// getText returns the text of the post seq of timeline, read with the
+// cache c.
+func getText(t *testing.T, s datastore.Session, c datastore.Cache,
+  timeline string, seq int) string {
+
+  t.Helper()
+  var p timelinePost
+  err := datastore.GetContext(context.Background(), s, &p,
+    []interface{}{timeline, seq}, datastore.EntityCache(c))
+  if err != nil {
+    t.Fatal(err)
+  }
+  return p.Text
+}
+
+func TestEntityCacheInvalidation(t *testing.T) {
+  typ := reflect.TypeOf(timelinePost{})
+  s := newRecordingSession(t, typ)
+  c := datastore.NewLRUCache(10, 0)
+  posts := []*timelinePost{{Timeline: "me", Seq: 1}, {Timeline: "me",
+    Seq: 2}, {Timeline: "you", Seq: 1}}
+  for _, p := range posts {
+    p.Text = "a"
+    if err := datastore.SaveEntity(s, p); err != nil {
+      t.Fatal(err)
+    }
+    getText(t, s, c, p.Timeline, p.Seq)
+  }
+  if n := s.reads(); n != 3 {
+    t.Fatalf("got %d reads, want 3", n)
+  }
+
+  // a write without the cache is not seen
+  err := datastore.SaveEntity(s, &timelinePost{Timeline: "me", Seq: 1,
+    Text: "b"})
+  if err != nil {
+    t.Fatal(err)
+  }
+  if text := getText(t, s, c, "me", 1); text != "a" || s.reads() != 3 {
+    t.Fatalf("got %q after %d reads, want the cached row", text, s.reads())
+  }
+
+  // a write with the cache invalidates the row
+  err = datastore.SaveEntity(s, &timelinePost{Timeline: "me", Seq: 1,
+    Text: "c"}, datastore.EntityCache(c))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if text := getText(t, s, c, "me", 1); text != "c" || s.reads() != 4 {
+    t.Errorf("got %q after %d reads, want c read again", text, s.reads())
+  }
+
+  uq, err := datastore.NewUpdateQuery(typ, datastore.EntityCache(c))
+  if err != nil {
+    t.Fatal(err)
+  }
+  // the update pins the row by its primary key, and invalidates it
+  err = uq.Filter("timeline =", "you").Filter("seq =", 1).
+    Update("text", "d").Run(s)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if text := getText(t, s, c, "you", 1); text != "d" {
+    t.Errorf("got %q, want d", text)
+  }
+
+  dq, err := datastore.NewDeleteQuery(typ, datastore.EntityCache(c))
+  if err != nil {
+    t.Fatal(err)
+  }
+  // the delete of a partition does not pin rows, which stay cached
+  if err := dq.Filter("timeline =", "me").Run(s); err != nil {
+    t.Fatal(err)
+  }
+  if text := getText(t, s, c, "me", 2); text != "a" {
+    t.Errorf("got %q, want the cached row", text)
+  }
+}
+
+func TestEntityCacheQueries(t *testing.T) {
+  typ := reflect.TypeOf(timelinePost{})
+  s := newRecordingSession(t, typ)
+  c := datastore.NewLRUCache(10, 0)
+  err := datastore.SaveEntity(s, &timelinePost{Timeline: "me", Seq: 1,
+    Text: "a"})
+  if err != nil {
+    t.Fatal(err)
+  }
+  q, err := datastore.NewQuery(typ, datastore.EntityCache(c))
+  if err != nil {
+    t.Fatal(err)
+  }
+  tests := []struct {
+    q     *datastore.Query
+    reads int
+  }{
+    // the primary key is pinned: the second read is served by the cache
+    {q.Filter("timeline =", "me").Filter("seq =", 1), 1},
+    // the partition key alone does not pin the row
+    {q.Filter("timeline =", "me"), 2},
+    {q.Filter("timeline =", "me").Filter("seq =", 1).NoCache(), 2},
+  }
+  for i, test := range tests {
+    s.stmts = nil
+    for j := 0; j < 2; j++ {
+      var p timelinePost
+      if err := test.q.First(s, &p); err != nil || p.Text != "a" {
+        t.Fatalf("%d: got %+v %v", i, p, err)
+      }
+    }
+    if n := s.reads(); n != test.reads {
+      t.Errorf("%d: got %d reads, want %d", i, n, test.reads)
+    }
+  }
+}
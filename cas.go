@@ -0,0 +1,172 @@
+package datastore
+
+import (
+  "context"
+  "errors"
+  "reflect"
+
+  "github.com/gocql/gocql"
+)
+
+// CASResult is the outcome of a conditional statement, a lightweight
+// transaction, returned by SaveEntityCAS and the ExecCAS methods of the
+// queries.
+type CASResult struct {
+  Applied bool
+  // Existing is the row found instead if the statement was not applied, a
+  // pointer to a new entity of the type of the statement holding the
+  // columns Cassandra returned: all of them for an insert, the ones the
+  // conditions involve otherwise. It is nil if the statement was applied,
+  // if there is no row, or when its table is queried by name.
+  Existing interface{}
+}
+
+// casInto makes a conditional statement record its outcome into res, the
+// existing row being decoded into a new value of typ, a pointer type, see
+// options.scanCAS.
+func casInto(res *CASResult, typ reflect.Type) Option {
+  return func(o *options) {
+    o.cas, o.casType = res, typ
+  }
+}
+
+// scanCAS reads the result of the conditional statement of iter like
+// scanCAS, recording it into the CASResult of o, if any, rather than
+// loading the existing row into ls.
+func (o *options) scanCAS(iter Iter, ls loadSaver) (bool, error) {
+  if o.cas == nil {
+    return scanCAS(iter, ls)
+  }
+  var existing reflect.Value
+  ls = nil
+  if o.casType != nil && o.casType.Kind() == reflect.Ptr {
+    existing = reflect.New(o.casType.Elem())
+    x, err := newLoadSaver(existing.Interface())
+    if err != nil {
+      iter.Close()
+      return false, err
+    }
+    defer releaseLoadSaver(x)
+    ls = x
+  }
+  cols := &columnsIter{Iter: iter}
+  applied, err := scanCAS(cols, ls)
+  if err != nil {
+    return false, err
+  }
+  o.cas.Applied = applied
+  // a statement not applied for lack of a row returns [applied] only
+  if !applied && ls != nil && len(cols.columns) > 1 {
+    o.cas.Existing = existing.Interface()
+  }
+  return applied, nil
+}
+
+// columnsIter records the columns of Iter.
+type columnsIter struct {
+  Iter
+  columns []string
+}
+
+func (it *columnsIter) RowData() (gocql.RowData, error) {
+  rowData, err := it.Iter.RowData()
+  it.columns = rowData.Columns
+  return rowData, err
+}
+
+// casOutcome returns the outcome res of a conditional statement whose
+// execution returned err.
+func casOutcome(res *CASResult, err error) (*CASResult, error) {
+  if err == ErrNotApplied || err == ErrConcurrentModification {
+    return res, nil
+  }
+  if err != nil {
+    return nil, err
+  }
+  return res, nil
+}
+
+// withCAS returns opts followed by the option recording the outcome of a
+// conditional statement into res.
+func withCAS(opts []Option, res *CASResult, typ reflect.Type) []Option {
+  return append(opts[:len(opts):len(opts)], casInto(res, typ))
+}
+
+// SaveEntityCAS saves the entity src like SaveEntity with the IfNotExists
+// option, or conditioned on its version if versioned, and returns whether
+// it was applied along with the existing row otherwise. Unlike SaveEntity,
+// it leaves src alone when not applied.
+func SaveEntityCAS(session Session, src interface{},
+  opts ...Option) (*CASResult, error) {
+  return SaveEntityCASContext(context.Background(), session, src, opts...)
+}
+
+// SaveEntityCASContext is like SaveEntityCAS but executes the insert with
+// ctx.
+func SaveEntityCASContext(ctx context.Context, session Session,
+  src interface{}, opts ...Option) (*CASResult, error) {
+
+  res := &CASResult{}
+  opts = append(opts[:len(opts):len(opts)], IfNotExists(),
+    casInto(res, reflect.TypeOf(src)))
+  return casOutcome(res, SaveEntityContext(ctx, session, src, opts...))
+}
+
+// ExecCAS executes a conditional update like RunCAS, returning the current
+// values of the columns involved in the conditions, if not applied, in a
+// new entity rather than into a destination.
+func (q *UpdateQuery) ExecCAS(session Session,
+  opts ...Option) (*CASResult, error) {
+  return q.ExecCASContext(context.Background(), session, opts...)
+}
+
+// ExecCASContext is like ExecCAS but executes the update with ctx.
+func (q *UpdateQuery) ExecCASContext(ctx context.Context, session Session,
+  opts ...Option) (*CASResult, error) {
+
+  if !q.isConditional() {
+    return nil, errors.New("datastore: ExecCAS on an unconditional update")
+  }
+  var existing interface{}
+  if q.codec.typ != nil {
+    existing = reflect.New(q.codec.typ).Interface()
+  }
+  applied, loaded, err := q.runCAS(ctx, session, existing, opts)
+  if err != nil {
+    return nil, err
+  }
+  res := &CASResult{Applied: applied}
+  if loaded {
+    res.Existing = existing
+  }
+  return res, nil
+}
+
+// ExecCAS executes a conditional delete and returns whether it was applied
+// along with the current values of the columns involved in the conditions
+// otherwise.
+func (q *DeleteQuery) ExecCAS(session Session,
+  opts ...Option) (*CASResult, error) {
+  return q.ExecCASContext(context.Background(), session, opts...)
+}
+
+// ExecCASContext is like ExecCAS but executes the delete with ctx.
+func (q *DeleteQuery) ExecCASContext(ctx context.Context, session Session,
+  opts ...Option) (*CASResult, error) {
+
+  if !q.isConditional() {
+    return nil, errors.New("datastore: ExecCAS on an unconditional delete")
+  }
+  res := &CASResult{}
+  err := q.RunContext(ctx, session, withCAS(opts, res, q.codec.ptrType())...)
+  return casOutcome(res, err)
+}
+
+// ptrType returns the pointer type of the entity type of the codec, nil
+// for the tables queried by name.
+func (c *structCodec) ptrType() reflect.Type {
+  if c.typ == nil {
+    return nil
+  }
+  return reflect.PtrTo(c.typ)
+}
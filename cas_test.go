@@ -0,0 +1,47 @@
+package datastore_test
+
+import (
+  "reflect"
+  "testing"
+
+  "github.com/droot/datastore"
+)
+
+func TestUpdateExecCAS(t *testing.T) {
+  s := newRecordingSession(t, reflect.TypeOf(tweet{}))
+  if err := datastore.SaveEntity(s, &tweet{ID: "a", Text: "a"}); err != nil {
+    t.Fatal(err)
+  }
+  q, err := datastore.NewUpdateQuery(reflect.TypeOf(tweet{}))
+  if err != nil {
+    t.Fatal(err)
+  }
+  tests := []struct {
+    q        *datastore.UpdateQuery
+    applied  bool
+    existing interface{}
+  }{
+    {q.Filter("id =", "a").Update("text", "b").If("text", "a"), true, nil},
+    {q.Filter("id =", "a").Update("text", "c").If("text", "a"), false,
+      &tweet{ID: "a", Text: "b"}},
+    {q.Filter("id =", "z").Update("text", "c").IfExists(), false, nil},
+  }
+  for i, test := range tests {
+    res, err := test.q.ExecCAS(s)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if res.Applied != test.applied ||
+      !reflect.DeepEqual(res.Existing, test.existing) {
+      t.Errorf("%d: got %v %#v, want %v %#v", i, res.Applied, res.Existing,
+        test.applied, test.existing)
+    }
+  }
+
+  // RunCAS loads the same values into dst
+  var dst tweet
+  applied, err := tests[1].q.RunCAS(s, &dst)
+  if err != nil || applied || dst.Text != "b" {
+    t.Errorf("got %v %v %+v, want false <nil> text b", applied, err, dst)
+  }
+}
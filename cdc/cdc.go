@@ -0,0 +1,332 @@
+// Package cdc captures the changes made to datastore entities and delivers
+// them to consumers, e.g. to feed caches or search indexes.
+//
+// The change data capture of Cassandra writes commit log segments on the
+// nodes, which cannot be read through CQL. The package follows a change
+// table convention instead: Save and Delete write an entity along with a
+// Record of the change in the same logged batch, and a Consumer tails the
+// records, loading the changed entities into their registered types:
+//
+//   err := cdc.Save(ctx, session, user)
+//
+//   consumer, err := cdc.NewConsumer(session, reflect.TypeOf(User{}))
+//   for change := range consumer.Changes(ctx, lastID) {
+//     index(change.Entity)
+//   }
+//   if err := consumer.Err(); err != nil {
+//     ...
+//   }
+//
+// The change table is created with datastore.CreateTable(session,
+// reflect.TypeOf(cdc.Record{})). Writes made without the package, such as
+// update queries, are not captured.
+package cdc
+
+import (
+  "context"
+  "encoding/json"
+  "fmt"
+  "reflect"
+  "time"
+
+  "github.com/droot/datastore"
+  "github.com/gocql/gocql"
+)
+
+// Record is a change recorded in the change table, partitioned by hour.
+type Record struct {
+  ColumnFamily string `cql:"datastore_changes"`
+  // Bucket is the hour of the change, formatted as 2006010215 in UTC.
+  Bucket string     `cql:"bucket,pk"`
+  ID     gocql.UUID `cql:"id,ck,type=timeuuid"`
+  Table  string     `cql:"tbl"`
+  Op     Op         `cql:"op"`
+  // Key is the JSON array of the primary key values of the entity.
+  Key string `cql:"key"`
+}
+
+// Op is the kind of a change.
+type Op string
+
+const (
+  OpSave   Op = "save"
+  OpDelete Op = "delete"
+)
+
+// bucketOf returns the bucket of the changes made at t.
+func bucketOf(t time.Time) string {
+  return t.UTC().Format("2006010215")
+}
+
+// newRecord returns the record of the change op of the entity src.
+func newRecord(src interface{}, op Op) (*Record, error) {
+  def, err := datastore.GetTableDef(reflect.TypeOf(src).Elem())
+  if err != nil {
+    return nil, err
+  }
+  _, key, err := datastore.KeyOf(src)
+  if err != nil {
+    return nil, err
+  }
+  doc, err := json.Marshal(key)
+  if err != nil {
+    return nil, fmt.Errorf("cdc: encoding the key of %T: %v", src, err)
+  }
+  id := gocql.TimeUUID()
+  return &Record{
+    Bucket: bucketOf(id.Time()),
+    ID:     id,
+    Table:  def.Name,
+    Op:     op,
+    Key:    string(doc),
+  }, nil
+}
+
+// Save saves src, a struct pointer of column family kind, like
+// datastore.SaveEntity and records the change. The options apply to the
+// insert of src, as with datastore.Batch.Save, and the types Batch.Save
+// rejects are rejected likewise.
+func Save(ctx context.Context, session datastore.Session, src interface{},
+  opts ...datastore.Option) error {
+
+  rec, err := newRecord(src, OpSave)
+  if err != nil {
+    return err
+  }
+  b := datastore.NewBatch(gocql.LoggedBatch)
+  if err := b.Save(src, opts...); err != nil {
+    return err
+  }
+  if err := b.Save(rec); err != nil {
+    return err
+  }
+  return b.RunContext(ctx, session)
+}
+
+// Delete deletes the row of src like datastore.DeleteEntity and records the
+// change.
+func Delete(ctx context.Context, session datastore.Session,
+  src interface{}, opts ...datastore.Option) error {
+
+  rec, err := newRecord(src, OpDelete)
+  if err != nil {
+    return err
+  }
+  cols, key, err := datastore.KeyOf(src)
+  if err != nil {
+    return err
+  }
+  q, err := datastore.NewDeleteQuery(reflect.TypeOf(src).Elem(), opts...)
+  if err != nil {
+    return err
+  }
+  for i, col := range cols {
+    q = q.Filter(col+" =", key[i])
+  }
+  b := datastore.NewBatch(gocql.LoggedBatch)
+  if err := b.Delete(q); err != nil {
+    return err
+  }
+  if err := b.Save(rec); err != nil {
+    return err
+  }
+  return b.RunContext(ctx, session)
+}
+
+// Change is a change of an entity delivered by a Consumer.
+type Change struct {
+  // ID is the time uuid of the change, the position to resume from.
+  ID    gocql.UUID
+  Op    Op
+  Table string
+  // Key holds the primary key values of the entity, in the order
+  // datastore.Get takes them.
+  Key []interface{}
+  // Entity is the current entity, a pointer to its registered type, loaded
+  // when the change is delivered. It is nil for deletes and for entities
+  // deleted since.
+  Entity interface{}
+}
+
+// Consumer tails the change table and delivers the changes of the entities
+// of its registered types.
+type Consumer struct {
+  session datastore.Session
+  types   map[string]reflect.Type
+  // Interval is the time between two reads of the change table once the
+  // consumer caught up, a second by default.
+  Interval time.Duration
+  // Lag is the age changes reach before being delivered, leaving the writes
+  // of clients with late clocks the time to land, 5 seconds by default.
+  Lag time.Duration
+  // Retention is the age of the oldest changes delivered, a week by
+  // default: the positions older than that start at the changes of the
+  // last week rather than read every hourly bucket since then.
+  Retention time.Duration
+
+  err error
+}
+
+// NewConsumer returns a Consumer of the changes of the entity types types.
+// The changes of other tables are skipped.
+func NewConsumer(session datastore.Session,
+  types ...reflect.Type) (*Consumer, error) {
+
+  c := &Consumer{
+    session:  session,
+    types:    make(map[string]reflect.Type, len(types)),
+    Interval:  time.Second,
+    Lag:       5 * time.Second,
+    Retention: 7 * 24 * time.Hour,
+  }
+  for _, typ := range types {
+    def, err := datastore.GetTableDef(typ)
+    if err != nil {
+      return nil, err
+    }
+    c.types[def.Name] = typ
+  }
+  return c, nil
+}
+
+// Changes delivers the changes recorded after the position after, in order,
+// until ctx is done or an error occurs. The position is the ID of the last
+// change processed, or gocql.MinTimeUUID(t) to start at the time t, no
+// earlier than Retention ago. The channel is closed once done; Err then
+// returns the error met, if any, e.g. for a position that is not a time
+// uuid.
+func (c *Consumer) Changes(ctx context.Context,
+  after gocql.UUID) <-chan Change {
+
+  ch := make(chan Change)
+  go func() {
+    c.err = c.tail(ctx, after, ch)
+    close(ch)
+  }()
+  return ch
+}
+
+// Err returns the error that stopped the delivery of the changes, once
+// their channel is closed. It returns nil if ctx was done.
+func (c *Consumer) Err() error {
+  return c.err
+}
+
+// tail delivers the changes recorded after pos on ch.
+func (c *Consumer) tail(ctx context.Context, pos gocql.UUID,
+  ch chan<- Change) error {
+
+  if pos.Version() != 1 {
+    return fmt.Errorf("cdc: position %v is not a time uuid", pos)
+  }
+  if oldest := time.Now().Add(-c.Retention); pos.Time().Before(oldest) {
+    pos = gocql.MinTimeUUID(oldest)
+  }
+  q, err := datastore.NewQuery(reflect.TypeOf(Record{}))
+  if err != nil {
+    return err
+  }
+  for {
+    end := time.Now().Add(-c.Lag)
+    bucket := pos.Time().UTC().Truncate(time.Hour)
+    iter := q.Filter("bucket =", bucketOf(bucket)).Filter("id >", pos).
+      Filter("id <=", gocql.MaxTimeUUID(end)).RunContext(ctx, c.session)
+    var rec Record
+    for err = iter.Next(&rec); err == nil; err = iter.Next(&rec) {
+      change, ok, err := c.change(ctx, &rec)
+      if err != nil {
+        iter.Close()
+        return err
+      }
+      if ok {
+        select {
+        case ch <- change:
+        case <-ctx.Done():
+          iter.Close()
+          return nil
+        }
+      }
+      pos = rec.ID
+    }
+    if err != datastore.Done {
+      iter.Close()
+      return c.stopped(ctx, err)
+    }
+    if err := iter.Close(); err != nil {
+      return c.stopped(ctx, err)
+    }
+    if next := bucket.Add(time.Hour); !next.After(end) {
+      // the bucket is complete, move on to the next one
+      pos = gocql.MinTimeUUID(next)
+      continue
+    }
+    select {
+    case <-time.After(c.Interval):
+    case <-ctx.Done():
+      return nil
+    }
+  }
+}
+
+// stopped returns the error err that stopped the tail, nil if caused by
+// ctx being done.
+func (c *Consumer) stopped(ctx context.Context, err error) error {
+  if ctx.Err() != nil {
+    return nil
+  }
+  return err
+}
+
+// change returns the change recorded by rec, reporting whether it is of a
+// registered type.
+func (c *Consumer) change(ctx context.Context, rec *Record) (Change,
+  bool, error) {
+
+  typ, ok := c.types[rec.Table]
+  if !ok {
+    return Change{}, false, nil
+  }
+  key, err := decodeKey(typ, rec.Key)
+  if err != nil {
+    return Change{}, false, err
+  }
+  change := Change{ID: rec.ID, Op: rec.Op, Table: rec.Table, Key: key}
+  if rec.Op != OpSave {
+    return change, true, nil
+  }
+  dst := reflect.New(typ).Interface()
+  switch err := datastore.GetContext(ctx, c.session, dst, key); err {
+  case nil:
+    change.Entity = dst
+  case datastore.ErrNoSuchEntity:
+  default:
+    return Change{}, false, err
+  }
+  return change, true, nil
+}
+
+// decodeKey decodes the JSON array doc of the primary key values of an
+// entity of type typ.
+func decodeKey(typ reflect.Type, doc string) ([]interface{}, error) {
+  _, zero, err := datastore.KeyOf(reflect.New(typ).Interface())
+  if err != nil {
+    return nil, err
+  }
+  var raw []json.RawMessage
+  if err := json.Unmarshal([]byte(doc), &raw); err != nil {
+    return nil, fmt.Errorf("cdc: decoding key %s of %v: %v", doc, typ, err)
+  }
+  if len(raw) != len(zero) {
+    return nil, fmt.Errorf("cdc: key %s of %v has %d values, want %d", doc,
+      typ, len(raw), len(zero))
+  }
+  key := make([]interface{}, len(raw))
+  for i, r := range raw {
+    v := reflect.New(reflect.TypeOf(zero[i]))
+    if err := json.Unmarshal(r, v.Interface()); err != nil {
+      return nil, fmt.Errorf("cdc: decoding key %s of %v: %v", doc, typ, err)
+    }
+    key[i] = v.Elem().Interface()
+  }
+  return key, nil
+}
@@ -0,0 +1,87 @@
+package cdc_test
+
+import (
+  "context"
+  "reflect"
+  "sync/atomic"
+  "testing"
+  "time"
+
+  "github.com/droot/datastore"
+  "github.com/droot/datastore/cdc"
+  "github.com/droot/datastore/memstore"
+  "github.com/gocql/gocql"
+)
+
+type user struct {
+  ColumnFamily string `cql:"users"`
+  ID           string `cql:"id,pk"`
+  Name         string `cql:"name"`
+}
+
+// countingSession counts the statements it executes.
+type countingSession struct {
+  datastore.Session
+  n int64
+}
+
+func (s *countingSession) Iter(ctx context.Context,
+  stmt *datastore.Statement) datastore.Iter {
+
+  atomic.AddInt64(&s.n, 1)
+  return s.Session.Iter(ctx, stmt)
+}
+
+func newStore(t *testing.T) *memstore.Store {
+  t.Helper()
+  s := memstore.New()
+  for _, typ := range []reflect.Type{reflect.TypeOf(user{}),
+    reflect.TypeOf(cdc.Record{})} {
+    if err := s.Register(typ); err != nil {
+      t.Fatal(err)
+    }
+  }
+  return s
+}
+
+func TestChangesNotTimeUUID(t *testing.T) {
+  c, err := cdc.NewConsumer(newStore(t), reflect.TypeOf(user{}))
+  if err != nil {
+    t.Fatal(err)
+  }
+  for range c.Changes(context.Background(), gocql.UUID{}) {
+    t.Error("got a change")
+  }
+  if c.Err() == nil {
+    t.Error("got no error for the zero uuid")
+  }
+}
+
+func TestChangesRetention(t *testing.T) {
+  store := newStore(t)
+  ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+  defer cancel()
+  if err := cdc.Save(ctx, store, &user{ID: "a", Name: "A"}); err != nil {
+    t.Fatal(err)
+  }
+  s := &countingSession{Session: store}
+  c, err := cdc.NewConsumer(s, reflect.TypeOf(user{}))
+  if err != nil {
+    t.Fatal(err)
+  }
+  c.Lag, c.Interval, c.Retention = 0, time.Millisecond, 2*time.Hour
+  change, ok := <-c.Changes(ctx, gocql.MinTimeUUID(
+    time.Now().AddDate(0, -1, 0)))
+  if !ok {
+    t.Fatalf("got no change: %v", c.Err())
+  }
+  cancel()
+  if u, _ := change.Entity.(*user); u == nil || u.Name != "A" {
+    t.Errorf("got %+v, want the saved user", change.Entity)
+  }
+  // the hourly buckets of the retention, the load of the entity and the
+  // polls until the cancellation, rather than a read per hour of the month
+  if n := atomic.LoadInt64(&s.n); n > 10 {
+    t.Errorf("got %d statements, want at most 10", n)
+  }
+}
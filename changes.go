@@ -0,0 +1,172 @@
+package datastore
+
+import (
+  "context"
+  "encoding/json"
+  "fmt"
+)
+
+// Snapshot records the values of the columns of an entity, typically once
+// loaded, to tell the columns changed since then. See OnlyChanged:
+//
+//   err := datastore.Get(session, &tw, id)
+//   snap, err := datastore.TakeSnapshot(&tw)
+//   tw.Text = "edited"
+//   // UPDATE tweet SET text = ? WHERE id = ?
+//   err = datastore.SaveEntity(session, &tw, datastore.OnlyChanged(snap))
+type Snapshot struct {
+  codec *structCodec
+  // values are the encoded values of the columns by field index, nil for
+  // the ones that cannot be encoded, which are deemed changed.
+  values [][]byte
+}
+
+// TakeSnapshot returns the snapshot of the columns of the entity src, a
+// struct pointer of column family kind.
+func TakeSnapshot(src interface{}) (*Snapshot, error) {
+  cls, err := newStructCLS(src)
+  if err != nil {
+    return nil, err
+  }
+  defer releaseLoadSaver(cls)
+  s := &Snapshot{codec: cls.codec}
+  s.take(cls)
+  return s, nil
+}
+
+// take records the columns of the entity adapted by cls.
+func (s *Snapshot) take(cls *structCLS) {
+  s.values = make([][]byte, len(cls.codec.byIndex))
+  for i, tag := range cls.codec.byIndex {
+    if tag.stored() {
+      s.values[i] = cls.columnState(i)
+    }
+  }
+}
+
+// columnState returns the value of the i'th column encoded in the JSON
+// format of the CQL types, which compares the contents of collections and
+// pointers rather than their addresses, nil if it cannot be encoded.
+func (cls *structCLS) columnState(i int) []byte {
+  b, err := json.Marshal(jsonValue(cls.field(i), cls.codec.byIndex[i].tuple))
+  if err != nil {
+    return nil
+  }
+  return b
+}
+
+// changed reports whether the i'th column of the entity adapted by cls
+// changed since the snapshot.
+func (s *Snapshot) changed(cls *structCLS, i int) bool {
+  if s.values[i] == nil {
+    return true
+  }
+  return string(cls.columnState(i)) != string(s.values[i])
+}
+
+// Changed returns the columns of the entity src changed since the snapshot,
+// in field order. src must be of the type of the snapshot.
+func (s *Snapshot) Changed(src interface{}) ([]string, error) {
+  cls, err := newStructCLS(src)
+  if err != nil {
+    return nil, err
+  }
+  defer releaseLoadSaver(cls)
+  if cls.codec != s.codec {
+    return nil, fmt.Errorf("datastore: snapshot of %v, got %T", s.codec.typ,
+      src)
+  }
+  var cols []string
+  for i, tag := range cls.codec.byIndex {
+    if tag.stored() && s.changed(cls, i) {
+      cols = append(cols, tag.name)
+    }
+  }
+  return cols, nil
+}
+
+// OnlyChanged makes SaveEntity write the columns of the entity changed
+// since the snapshot s with an UPDATE rather than insert all of them,
+// nothing being written if none changed. The columns set by saves, see
+// AutoTime, are written along with the changed ones. Once saved, the
+// snapshot records the saved entity, so that it tracks the next changes.
+// The row is expected to exist: unlike an insert, an update does not keep
+// a row whose columns are all null.
+//
+// The entity is inserted whole if its primary key or a unique column
+// changed, or if its type has a version column, and with the IfNotExists
+// option.
+func OnlyChanged(s *Snapshot) Option {
+  return func(o *options) {
+    o.changes = s
+  }
+}
+
+// changesCQL returns the UPDATE statement writing the columns of the entity
+// changed since the snapshot of o, and its bound values, "" if none
+// changed. It reports false if the entity is to be inserted instead. The
+// entity is prepared first, see prepare.
+func (cls *structCLS) changesCQL(o *options) (string, []interface{}, bool,
+  error) {
+
+  s := o.changes
+  if s == nil || s.codec != cls.codec || o.ifNotExists ||
+    cls.codec.version != "" || cls.codec.hasCounters {
+    return "", nil, false, nil
+  }
+  changed := false
+  for i, v := range cls.codec.byIndex {
+    // the columns set by saves change on every save
+    if !v.stored() || v.autoTime != "" || !s.changed(cls, i) {
+      continue
+    }
+    if v.partitionKey || v.clusteringKey || v.unique {
+      return "", nil, false, nil
+    }
+    changed = true
+  }
+  if !changed {
+    return "", nil, true, nil
+  }
+  if err := cls.prepare(); err != nil {
+    return "", nil, true, err
+  }
+  q := &UpdateQuery{codec: cls.codec}
+  for i, v := range cls.codec.byIndex {
+    if !v.stored() {
+      continue
+    }
+    changed := s.changed(cls, i)
+    switch {
+    case changed && (v.partitionKey || v.clusteringKey || v.unique):
+      return "", nil, false, nil
+    case v.partitionKey || v.clusteringKey:
+      q.filter = append(q.filter,
+        filter{FieldName: v.name, Op: Equal, Value: cls.fieldValue(i)})
+    case changed:
+      q.updates = append(q.updates,
+        update{FieldName: v.name, Op: assign, Value: cls.fieldValue(i)})
+    }
+  }
+  cql, args, err := q.toCQL(o)
+  return cql, args, true, err
+}
+
+// saveChanges saves the columns of the entity adapted by cls changed since
+// the snapshot of o, see OnlyChanged. It reports false if the entity is to
+// be inserted instead.
+func saveChanges(ctx context.Context, session Session, cls *structCLS,
+  o *options) (bool, error) {
+
+  cql, args, ok, err := cls.changesCQL(o)
+  if !ok || err != nil {
+    return ok, err
+  }
+  if cql != "" {
+    if err := exec(ctx, session, newStatement(o, cql, args)); err != nil {
+      return true, err
+    }
+  }
+  o.changes.take(cls)
+  return true, nil
+}
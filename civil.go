@@ -0,0 +1,122 @@
+package datastore
+
+import (
+  "fmt"
+  "reflect"
+  "time"
+
+  "github.com/gocql/gocql"
+)
+
+// Date is a calendar date, stored in a CQL date column. Unlike a time.Time
+// with the date tag option, it carries no time of day nor location, so it
+// loads back as the same date in every time zone.
+type Date struct {
+  Year  int
+  Month time.Month
+  Day   int
+}
+
+// DateOf returns the date t falls on in its location.
+func DateOf(t time.Time) Date {
+  y, m, d := t.Date()
+  return Date{Year: y, Month: m, Day: d}
+}
+
+// In returns the time of midnight at the start of d in loc.
+func (d Date) In(loc *time.Location) time.Time {
+  return time.Date(d.Year, d.Month, d.Day, 0, 0, 0, 0, loc)
+}
+
+// String returns d in the yyyy-mm-dd format.
+func (d Date) String() string {
+  return fmt.Sprintf("%04d-%02d-%02d", d.Year, d.Month, d.Day)
+}
+
+func (d Date) MarshalCQL(info gocql.TypeInfo) ([]byte, error) {
+  return gocql.Marshal(info, d.In(time.UTC))
+}
+
+func (d *Date) UnmarshalCQL(info gocql.TypeInfo, data []byte) error {
+  if data == nil {
+    *d = Date{}
+    return nil
+  }
+  var t time.Time
+  if err := gocql.Unmarshal(info, data, &t); err != nil {
+    return err
+  }
+  *d = DateOf(t.UTC())
+  return nil
+}
+
+// TimeOfDay is a time of day with nanosecond precision, stored in a CQL
+// time column.
+type TimeOfDay struct {
+  Hour       int
+  Minute     int
+  Second     int
+  Nanosecond int
+}
+
+// TimeOfDayOf returns the time of day of t in its location.
+func TimeOfDayOf(t time.Time) TimeOfDay {
+  return TimeOfDay{Hour: t.Hour(), Minute: t.Minute(), Second: t.Second(),
+    Nanosecond: t.Nanosecond()}
+}
+
+// Duration returns the time elapsed since midnight at t.
+func (t TimeOfDay) Duration() time.Duration {
+  return time.Duration(t.Hour)*time.Hour +
+    time.Duration(t.Minute)*time.Minute +
+    time.Duration(t.Second)*time.Second +
+    time.Duration(t.Nanosecond)
+}
+
+// String returns t in the hh:mm:ss.nnnnnnnnn format.
+func (t TimeOfDay) String() string {
+  return fmt.Sprintf("%02d:%02d:%02d.%09d", t.Hour, t.Minute, t.Second,
+    t.Nanosecond)
+}
+
+func (t TimeOfDay) MarshalCQL(info gocql.TypeInfo) ([]byte, error) {
+  return gocql.Marshal(info, t.Duration())
+}
+
+func (t *TimeOfDay) UnmarshalCQL(info gocql.TypeInfo, data []byte) error {
+  if data == nil {
+    *t = TimeOfDay{}
+    return nil
+  }
+  var d time.Duration
+  if err := gocql.Unmarshal(info, data, &d); err != nil {
+    return err
+  }
+  *t = TimeOfDayOf(time.Time{}.Add(d))
+  return nil
+}
+
+// checkCivilOpt checks that the field f can be stored in a column of type
+// cqlType if it is date or time. Date columns hold a Date, a time.Time or a
+// string in the yyyy-mm-dd format, time columns a TimeOfDay or a
+// time.Duration since midnight.
+func checkCivilOpt(cqlType string, f reflect.StructField) error {
+  t := f.Type
+  if t.Kind() == reflect.Ptr {
+    t = t.Elem()
+  }
+  switch cqlType {
+  case "date":
+    if t == typeOfDate || t == typeOfTime || t.Kind() == reflect.String {
+      return nil
+    }
+  case "time":
+    if t == typeOfTOD || t.Kind() == reflect.Int64 {
+      return nil
+    }
+  default:
+    return nil
+  }
+  return fmt.Errorf("datastore: %s column for %v field %s", cqlType, f.Type,
+    f.Name)
+}
@@ -0,0 +1,101 @@
+package datastore
+
+import (
+  "context"
+)
+
+// Client executes the operations of the package on a session with default
+// options, e.g. the consistency, keyspace, retry policy or logger of an
+// application, given once rather than on every call:
+//
+//   client := datastore.NewClient(session, datastore.Consistency(
+//     gocql.LocalQuorum), datastore.Log(logger))
+//   err := client.Save(ctx, &tweet)
+//
+// The defaults apply to the statements executed through the client and are
+// overridden by the consistency and timeout of the context, see
+// WithConsistency, by the options a query is created with and by the
+// options of each call. They apply to the statements of a Batch too, which
+// are built when the batch is run.
+type Client struct {
+  session Session
+  opts    []Option
+}
+
+// NewClient returns a Client executing statements with session, opts being
+// the defaults of the operations.
+func NewClient(session Session, opts ...Option) *Client {
+  return &Client{session: session, opts: opts}
+}
+
+// Session returns the session of the client.
+func (c *Client) Session() Session {
+  return c.session
+}
+
+// With returns a derivative client whose defaults are the ones of c
+// followed by opts.
+func (c *Client) With(opts ...Option) *Client {
+  return &Client{session: c.session,
+    opts: append(c.opts[:len(c.opts):len(c.opts)], opts...)}
+}
+
+// Observe returns a derivative client notifying observers of the
+// statements it executes, e.g. to collect metrics, see ObserveSession.
+func (c *Client) Observe(observers ...Observer) *Client {
+  return &Client{session: ObserveSession(c.session, observers...),
+    opts: c.opts}
+}
+
+// ctx returns ctx carrying the defaults of the client.
+func (c *Client) ctx(ctx context.Context) context.Context {
+  return withDefaults(ctx, c.opts)
+}
+
+// Save saves src like SaveEntity.
+func (c *Client) Save(ctx context.Context, src interface{},
+  opts ...Option) error {
+  return SaveEntityContext(c.ctx(ctx), c.session, src, opts...)
+}
+
+// Get loads the row whose primary key columns equal keyValues into dst,
+// like Get.
+func (c *Client) Get(ctx context.Context, dst interface{},
+  keyValues []interface{}, opts ...Option) error {
+  return GetContext(c.ctx(ctx), c.session, dst, keyValues, opts...)
+}
+
+// Delete deletes the row of the entity src like DeleteEntity.
+func (c *Client) Delete(ctx context.Context, src interface{},
+  opts ...Option) error {
+  return DeleteEntityContext(c.ctx(ctx), c.session, src, opts...)
+}
+
+// Query runs q and returns an iterator over its results, like Query.Run.
+func (c *Client) Query(ctx context.Context, q *Query,
+  opts ...Option) *Iterator {
+  return q.RunContext(c.ctx(ctx), c.session, opts...)
+}
+
+// GetAll runs q and loads all its results into dst, like Query.GetAll.
+func (c *Client) GetAll(ctx context.Context, q *Query, dst interface{},
+  opts ...Option) (int, error) {
+  return q.GetAllContext(c.ctx(ctx), c.session, dst, opts...)
+}
+
+// Update executes the update q, like UpdateQuery.Run.
+func (c *Client) Update(ctx context.Context, q *UpdateQuery,
+  opts ...Option) error {
+  return q.RunContext(c.ctx(ctx), c.session, opts...)
+}
+
+// DeleteWhere executes the delete q, like DeleteQuery.Run.
+func (c *Client) DeleteWhere(ctx context.Context, q *DeleteQuery,
+  opts ...Option) error {
+  return q.RunContext(c.ctx(ctx), c.session, opts...)
+}
+
+// Batch executes the batch b, like Batch.Run.
+func (c *Client) Batch(ctx context.Context, b *Batch) error {
+  return b.RunContext(c.ctx(ctx), c.session)
+}
@@ -0,0 +1,442 @@
+// Command datastore-gen generates reflection-free codecs for datastore
+// entity types.
+//
+// For each struct type named with -type, it emits the ColumnDest and
+// SaveColumns methods implementing datastore.EntityCodec, following the cql
+// struct tags of the fields, so that loads and saves of the entities skip
+// reflection. It is meant to be run by go generate from the package
+// declaring the types:
+//
+//   //go:generate datastore-gen -type Tweet,User
+//
+// The output goes to <type>_datastore.go, named after the first type, unless
+// -output is given. Nested structs declared in the package are stored in
+// user-defined types, like with the reflection based codec; use the udt tag
+// option for the structs of other packages. Tuple, version and unique
+// columns are not supported: implement datastore.ColumnLoadSaver for
+// entities having tuples, and leave the others to the reflection based
+// codec.
+package main
+
+import (
+  "bytes"
+  "flag"
+  "fmt"
+  "go/ast"
+  "go/format"
+  "go/parser"
+  "go/token"
+  "log"
+  "os"
+  "path/filepath"
+  "reflect"
+  "strconv"
+  "strings"
+)
+
+var (
+  typeNames = flag.String("type", "",
+    "comma-separated list of entity type names; required")
+  output = flag.String("output", "",
+    "output file name; default <dir>/<type>_datastore.go")
+)
+
+func usage() {
+  fmt.Fprintf(os.Stderr, "Usage: datastore-gen -type T [directory]\n")
+  flag.PrintDefaults()
+}
+
+func main() {
+  log.SetFlags(0)
+  log.SetPrefix("datastore-gen: ")
+  flag.Usage = usage
+  flag.Parse()
+  if *typeNames == "" {
+    flag.Usage()
+    os.Exit(2)
+  }
+  dir := "."
+  if flag.NArg() > 0 {
+    dir = flag.Arg(0)
+  }
+  g, err := parsePackage(dir)
+  if err != nil {
+    log.Fatal(err)
+  }
+  types := strings.Split(*typeNames, ",")
+  src, err := g.generate(types, strings.Join(os.Args[1:], " "))
+  if err != nil {
+    log.Fatal(err)
+  }
+  name := *output
+  if name == "" {
+    name = filepath.Join(dir, strings.ToLower(types[0])+"_datastore.go")
+  }
+  if err := os.WriteFile(name, src, 0644); err != nil {
+    log.Fatal(err)
+  }
+}
+
+// generator holds the declarations of the package the codecs are generated
+// for.
+type generator struct {
+  pkg string
+  // types gives the type expressions of the package's type declarations
+  // by name.
+  types map[string]ast.Expr
+  // unmarshalers lists the types of the package with an UnmarshalCQL
+  // method, which are not stored in user-defined types.
+  unmarshalers map[string]bool
+}
+
+// parsePackage parses the non-test Go files of dir, leaving out generated
+// ones.
+func parsePackage(dir string) (*generator, error) {
+  files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+  if err != nil {
+    return nil, err
+  }
+  g := &generator{
+    types:        make(map[string]ast.Expr),
+    unmarshalers: make(map[string]bool),
+  }
+  fset := token.NewFileSet()
+  for _, name := range files {
+    if strings.HasSuffix(name, "_test.go") {
+      continue
+    }
+    f, err := parser.ParseFile(fset, name, nil, parser.ParseComments)
+    if err != nil {
+      return nil, err
+    }
+    if ast.IsGenerated(f) {
+      continue
+    }
+    g.pkg = f.Name.Name
+    for _, decl := range f.Decls {
+      g.addDecl(decl)
+    }
+  }
+  if g.pkg == "" {
+    return nil, fmt.Errorf("no Go files in %s", dir)
+  }
+  return g, nil
+}
+
+// addDecl records the type declaration or UnmarshalCQL method decl.
+func (g *generator) addDecl(decl ast.Decl) {
+  switch d := decl.(type) {
+  case *ast.GenDecl:
+    for _, spec := range d.Specs {
+      if ts, ok := spec.(*ast.TypeSpec); ok {
+        g.types[ts.Name.Name] = ts.Type
+      }
+    }
+  case *ast.FuncDecl:
+    if d.Recv == nil || d.Name.Name != "UnmarshalCQL" {
+      return
+    }
+    recv := d.Recv.List[0].Type
+    if star, ok := recv.(*ast.StarExpr); ok {
+      recv = star.X
+    }
+    if id, ok := recv.(*ast.Ident); ok {
+      g.unmarshalers[id.Name] = true
+    }
+  }
+}
+
+// column is a column mapped by an entity field.
+type column struct {
+  name string
+  // field is the selector of the field from the receiver, e.g. x.Name.
+  field     string
+  udt       bool
+  nilable   bool
+  omitEmpty bool
+  // loadOnly is set for the columns not written by inserts: counters and
+  // write time or TTL selections.
+  loadOnly bool
+}
+
+// generate returns the formatted source of the codecs of the types, args
+// being the command line recorded in the header.
+func (g *generator) generate(types []string, args string) ([]byte, error) {
+  var buf bytes.Buffer
+  fmt.Fprintf(&buf, "// Code generated by \"datastore-gen %s\"; "+
+    "DO NOT EDIT.\n\n", args)
+  fmt.Fprintf(&buf, "package %s\n\n", g.pkg)
+  fmt.Fprintf(&buf, "import \"github.com/droot/datastore\"\n")
+  for _, name := range types {
+    st, ok := g.types[name].(*ast.StructType)
+    if !ok {
+      return nil, fmt.Errorf("no struct type %s in package %s", name, g.pkg)
+    }
+    cols, err := g.columns(st, "x")
+    if err != nil {
+      return nil, fmt.Errorf("%s: %v", name, err)
+    }
+    writeCodec(&buf, name, cols)
+  }
+  src, err := format.Source(buf.Bytes())
+  if err != nil {
+    return nil, fmt.Errorf("formatting output: %v", err)
+  }
+  return src, nil
+}
+
+// columns returns the columns mapped by the fields of st, reached from the
+// receiver through the selector path. The fields of untagged embedded
+// structs are promoted.
+func (g *generator) columns(st *ast.StructType, path string) ([]column,
+  error) {
+
+  var cols []column
+  for _, f := range st.Fields.List {
+    tag := ""
+    if f.Tag != nil {
+      s, err := strconv.Unquote(f.Tag.Value)
+      if err != nil {
+        return nil, err
+      }
+      tag = reflect.StructTag(s).Get("cql")
+    }
+    name, opts := tag, ""
+    if i := strings.Index(tag, ","); i != -1 {
+      name, opts = tag[:i], tag[i+1:]
+    }
+    names := make([]string, len(f.Names))
+    for i, id := range f.Names {
+      names[i] = id.Name
+    }
+    if len(f.Names) == 0 {
+      // embedded field, named after its type
+      typeName, err := embeddedName(f.Type)
+      if err != nil {
+        return nil, err
+      }
+      if name == "" && g.isStruct(f.Type) {
+        st := g.types[typeName].(*ast.StructType)
+        embedded, err := g.columns(st, path+"."+typeName)
+        if err != nil {
+          return nil, err
+        }
+        cols = append(cols, embedded...)
+        continue
+      }
+      names = []string{typeName}
+    }
+    for _, fieldName := range names {
+      if fieldName == "ColumnFamily" || name == "-" || isRef(opts) {
+        continue
+      }
+      col := column{name: name, field: path + "." + fieldName}
+      if col.name == "" {
+        col.name = fieldName
+      }
+      if err := g.applyOpts(&col, f.Type, opts); err != nil {
+        return nil, fmt.Errorf("field %s: %v", fieldName, err)
+      }
+      cols = append(cols, col)
+    }
+  }
+  return cols, nil
+}
+
+// applyOpts sets up col for a field of type typ with the tag options opts.
+func (g *generator) applyOpts(col *column, typ ast.Expr, opts string) error {
+  tuple := false
+  for _, opt := range splitTagOpts(opts) {
+    switch {
+    case opt == "omitempty":
+      col.omitEmpty = true
+    case opt == "counter":
+      col.loadOnly = true
+    case opt == "tuple":
+      tuple = true
+    case opt == "version", opt == "unique":
+      // the saves of generated codecs neither check versions nor claim
+      // unique values
+      return fmt.Errorf("%s columns are not supported", opt)
+    case strings.HasPrefix(opt, "udt="):
+      col.udt = true
+    case strings.HasPrefix(opt, "writetime="), strings.HasPrefix(opt, "ttl="):
+      i := strings.Index(opt, "=")
+      col.name = fmt.Sprintf("%s(%s)", opt[:i], strings.TrimSpace(opt[i+1:]))
+      col.loadOnly = true
+    }
+  }
+  if sel, ok := typ.(*ast.SelectorExpr); ok && sel.Sel.Name == "Tuple" {
+    tuple = true
+  }
+  if tuple {
+    return fmt.Errorf("tuple columns are not supported")
+  }
+  elem := typ
+  if star, ok := typ.(*ast.StarExpr); ok {
+    elem = star.X
+  }
+  if g.isStruct(elem) {
+    col.udt = true
+  }
+  col.nilable = g.isNilable(typ)
+  return nil
+}
+
+// isRef reports whether the tag options opts declare a related entity
+// field, which is not a column.
+func isRef(opts string) bool {
+  for _, opt := range splitTagOpts(opts) {
+    if strings.HasPrefix(opt, "ref=") {
+      return true
+    }
+  }
+  return false
+}
+
+// isStruct reports whether typ names a struct type of the package stored in
+// a user-defined type, i.e. without custom unmarshaling.
+func (g *generator) isStruct(typ ast.Expr) bool {
+  id, ok := typ.(*ast.Ident)
+  if !ok || g.unmarshalers[id.Name] {
+    return false
+  }
+  _, ok = g.types[id.Name].(*ast.StructType)
+  return ok
+}
+
+// isNilable reports whether the values of type typ compare to nil, looking
+// through the type declarations of the package.
+func (g *generator) isNilable(typ ast.Expr) bool {
+  for i := 0; i < 10; i++ {
+    switch t := typ.(type) {
+    case *ast.StarExpr, *ast.MapType, *ast.FuncType, *ast.InterfaceType,
+      *ast.ChanType:
+      return true
+    case *ast.ArrayType:
+      return t.Len == nil
+    case *ast.Ident:
+      underlying, ok := g.types[t.Name]
+      if !ok {
+        return t.Name == "any" || t.Name == "error"
+      }
+      typ = underlying
+    default:
+      return false
+    }
+  }
+  return false
+}
+
+// embeddedName returns the field name of the embedded field of type typ.
+func embeddedName(typ ast.Expr) (string, error) {
+  switch t := typ.(type) {
+  case *ast.Ident:
+    return t.Name, nil
+  case *ast.SelectorExpr:
+    return t.Sel.Name, nil
+  case *ast.StarExpr:
+    name, err := embeddedName(t.X)
+    if err != nil {
+      return "", err
+    }
+    return "", fmt.Errorf("embedded pointer field %s not supported", name)
+  }
+  return "", fmt.Errorf("unsupported embedded field")
+}
+
+// splitTagOpts splits tag options on the commas that are not part of a
+// parameterized CQL type such as map<text,int>. A regexp option extends to
+// the end of the tag.
+func splitTagOpts(opts string) []string {
+  var res []string
+  depth, start := 0, 0
+  for i, r := range opts {
+    if i == start && depth == 0 &&
+      strings.HasPrefix(strings.TrimSpace(opts[i:]), "regexp=") {
+      break
+    }
+    switch r {
+    case '<':
+      depth++
+    case '>':
+      depth--
+    case ',':
+      if depth == 0 {
+        res = append(res, strings.TrimSpace(opts[start:i]))
+        start = i + 1
+      }
+    }
+  }
+  return append(res, strings.TrimSpace(opts[start:]))
+}
+
+// writeCodec writes the EntityCodec methods of the type name mapping the
+// columns cols.
+func writeCodec(buf *bytes.Buffer, name string, cols []column) {
+  fmt.Fprintf(buf, "\nvar _ datastore.EntityCodec = (*%s)(nil)\n", name)
+
+  fmt.Fprintf(buf, "\n// ColumnDest implements datastore.EntityCodec.\n")
+  fmt.Fprintf(buf, "func (x *%s) ColumnDest(name string) interface{} {\n",
+    name)
+  fmt.Fprintf(buf, "switch name {\n")
+  for _, col := range cols {
+    fmt.Fprintf(buf, "case %q:\nreturn %s\n", col.name, col.ref())
+  }
+  fmt.Fprintf(buf, "}\nreturn nil\n}\n")
+
+  var saved, omitEmpty []column
+  for _, col := range cols {
+    switch {
+    case col.loadOnly:
+    case col.omitEmpty:
+      omitEmpty = append(omitEmpty, col)
+    default:
+      saved = append(saved, col)
+    }
+  }
+  fmt.Fprintf(buf, "\n// SaveColumns implements datastore.EntityCodec.\n")
+  fmt.Fprintf(buf, "func (x *%s) SaveColumns() ([]string, []interface{}) "+
+    "{\n", name)
+  fmt.Fprintf(buf, "names := make([]string, 0, %d)\n", len(saved)+
+    len(omitEmpty))
+  fmt.Fprintf(buf, "vals := make([]interface{}, 0, %d)\n", len(saved)+
+    len(omitEmpty))
+  if len(saved) > 0 {
+    fmt.Fprintf(buf, "names = append(names")
+    for _, col := range saved {
+      fmt.Fprintf(buf, ", %q", col.name)
+    }
+    fmt.Fprintf(buf, ")\nvals = append(vals")
+    for _, col := range saved {
+      fmt.Fprintf(buf, ", %s", col.value())
+    }
+    fmt.Fprintf(buf, ")\n")
+  }
+  for _, col := range omitEmpty {
+    cond := fmt.Sprintf("!datastore.IsZero(%s)", col.field)
+    if col.nilable {
+      cond = col.field + " != nil"
+    }
+    fmt.Fprintf(buf, "if %s {\n", cond)
+    fmt.Fprintf(buf, "names = append(names, %q)\n", col.name)
+    fmt.Fprintf(buf, "vals = append(vals, %s)\n}\n", col.value())
+  }
+  fmt.Fprintf(buf, "return names, vals\n}\n")
+}
+
+// ref returns the expression of the scan destination of the column.
+func (col column) ref() string {
+  if col.udt {
+    return "datastore.UDT(&" + col.field + ")"
+  }
+  return "&" + col.field
+}
+
+// value returns the expression of the value of the column to insert.
+func (col column) value() string {
+  if col.udt {
+    return col.ref()
+  }
+  return col.field
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+// generateSrc returns the codecs generated for the types of the package
+// of the source src.
+func generateSrc(t *testing.T, src string, types ...string) (string,
+  error) {
+
+  t.Helper()
+  dir := t.TempDir()
+  if err := os.WriteFile(filepath.Join(dir, "types.go"), []byte(src),
+    0644); err != nil {
+    t.Fatal(err)
+  }
+  g, err := parsePackage(dir)
+  if err != nil {
+    t.Fatal(err)
+  }
+  out, err := g.generate(types, "-type "+strings.Join(types, ","))
+  return string(out), err
+}
+
+func TestGenerate(t *testing.T) {
+  src, err := generateSrc(t, `package p
+
+type Tweet struct {
+  ColumnFamily string `+"`cql:\"tweet\"`"+`
+  ID           string `+"`cql:\"id,pk\"`"+`
+  Text         string `+"`cql:\"text,omitempty\"`"+`
+}
+`, "Tweet")
+  if err != nil {
+    t.Fatal(err)
+  }
+  for _, want := range []string{"func (x *Tweet) ColumnDest(",
+    "func (x *Tweet) SaveColumns()", `case "text":`} {
+    if !strings.Contains(src, want) {
+      t.Errorf("output lacks %q:\n%s", want, src)
+    }
+  }
+}
+
+func TestGenerateUnsupported(t *testing.T) {
+  for _, opts := range []string{"version", "unique"} {
+    _, err := generateSrc(t, `package p
+
+type Account struct {
+  ColumnFamily string `+"`cql:\"accounts\"`"+`
+  ID           string `+"`cql:\"id,pk\"`"+`
+  Field        int64  `+"`cql:\"field,"+opts+"\"`"+`
+}
+`, "Account")
+    if err == nil || !strings.Contains(err.Error(), "not supported") {
+      t.Errorf("%s: got error %v, want not supported", opts, err)
+    }
+  }
+}
@@ -0,0 +1,82 @@
+package datastore
+
+import (
+  "fmt"
+  "reflect"
+
+  "github.com/gocql/gocql"
+)
+
+// EntityCodec can be implemented by entities to map their fields to columns
+// without reflection, typically with the code generated by the
+// datastore-gen command. Loads and saves prefer it to the reflection based
+// codec, the struct tags still giving the column family and the schema. A
+// ColumnLoadSaver implementation takes precedence over it.
+type EntityCodec interface {
+  // ColumnDest returns the pointer to scan the column name into, nil for
+  // the columns the entity does not load.
+  ColumnDest(name string) interface{}
+  // SaveColumns returns the names of the columns to insert and their
+  // values, in the same order.
+  SaveColumns() ([]string, []interface{})
+}
+
+// UDT adapts p, a pointer to a nested struct field or to a pointer to one,
+// to the marshaling of user-defined type columns, both as a scan
+// destination and as a value. It is used by the generated codecs.
+func UDT(p interface{}) interface{} {
+  return udtOf(reflect.ValueOf(p).Elem())
+}
+
+// IsZero reports whether v is the zero value of its type. It is used by the
+// generated codecs to leave out omitempty columns.
+func IsZero[T comparable](v T) bool {
+  var zero T
+  return v == zero
+}
+
+// codecCLS adapts an EntityCodec to a loadSaver.
+type codecCLS struct {
+  p     EntityCodec
+  codec *structCodec
+}
+
+func newCodecCLS(p EntityCodec) (*codecCLS, error) {
+  t := reflect.TypeOf(p)
+  if t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+    return nil, fmt.Errorf("%w: %T is not a struct pointer", ErrInvalidEntity,
+      p)
+  }
+  codec, err := getStructCodec(t.Elem())
+  if err != nil {
+    return nil, err
+  }
+  return &codecCLS{p, codec}, nil
+}
+
+func (cls *codecCLS) setDests(rowData *gocql.RowData) {
+  for i, col := range rowData.Columns {
+    if dest := cls.p.ColumnDest(col); dest != nil {
+      rowData.Values[i] = dest
+    }
+  }
+}
+
+func (cls *codecCLS) loaded(rowData *gocql.RowData) error {
+  return afterLoad(cls.p)
+}
+
+func (cls *codecCLS) insertCQL(o *options) (string, []interface{}, error) {
+  if cls.codec.hasCounters {
+    return "", nil, fmt.Errorf("datastore: cannot insert into counter "+
+      "table %s, use UpdateQuery.Increment", cls.codec.columnFamily)
+  }
+  if err := cls.codec.prepareSave(reflect.ValueOf(cls.p).Elem()); err != nil {
+    return "", nil, err
+  }
+  names, vals := cls.p.SaveColumns()
+  if len(names) == 0 {
+    return "", nil, fmt.Errorf("datastore: %T saved no columns", cls.p)
+  }
+  return insertColumnsCQL(o.tableOf(cls.codec), names, vals, o)
+}
@@ -0,0 +1,127 @@
+package datastore
+
+import (
+  "fmt"
+  "reflect"
+  "strings"
+
+  "github.com/gocql/gocql"
+)
+
+// Column is a column name and value.
+type Column struct {
+  Name  string
+  Value interface{}
+}
+
+// ColumnLoadSaver can be implemented by entities to convert themselves to
+// and from a list of columns, bypassing the reflection based codec. The
+// column family is given by the Entity interface if implemented, or else by
+// the ColumnFamily tag of the underlying struct.
+type ColumnLoadSaver interface {
+  // Load loads the columns of a row. The values have the Go types gocql
+  // decodes the column types to by default, e.g. string for text and
+  // []string for list<text>.
+  Load([]Column) error
+  // Save returns the columns to insert.
+  Save() ([]Column, error)
+}
+
+// customCLS adapts a ColumnLoadSaver to a loadSaver.
+type customCLS struct {
+  p            ColumnLoadSaver
+  columnFamily string
+}
+
+func newCustomCLS(p ColumnLoadSaver) (*customCLS, error) {
+  if e, ok := p.(Entity); ok {
+    return &customCLS{p, e.ColumnFamily()}, nil
+  }
+  t := reflect.TypeOf(p)
+  if t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+    return nil, fmt.Errorf("datastore: %T must implement Entity", p)
+  }
+  codec, err := getStructCodec(t.Elem())
+  if err != nil {
+    return nil, err
+  }
+  return &customCLS{p, codec.columnFamily}, nil
+}
+
+// setDests keeps the default destinations allocated by gocql for every
+// column.
+func (cls *customCLS) setDests(rowData *gocql.RowData) {}
+
+func (cls *customCLS) loaded(rowData *gocql.RowData) error {
+  cols := make([]Column, 0, len(rowData.Columns))
+  for i, name := range rowData.Columns {
+    if name == "[applied]" {
+      continue
+    }
+    cols = append(cols, Column{
+      Name:  name,
+      Value: reflect.ValueOf(rowData.Values[i]).Elem().Interface(),
+    })
+  }
+  if err := cls.p.Load(cols); err != nil {
+    return err
+  }
+  return afterLoad(cls.p)
+}
+
+// table returns the table the entity is saved into.
+func (cls *customCLS) table(o *options) string {
+  return o.qualify(cls.columnFamily)
+}
+
+func (cls *customCLS) insertCQL(o *options) (string, []interface{}, error) {
+  if err := beforeSave(cls.p); err != nil {
+    return "", nil, err
+  }
+  if err := validate(cls.p); err != nil {
+    return "", nil, err
+  }
+  cols, err := cls.p.Save()
+  if err != nil {
+    return "", nil, err
+  }
+  if len(cols) == 0 {
+    return "", nil, fmt.Errorf("datastore: %T saved no columns", cls.p)
+  }
+  names := make([]string, len(cols))
+  vals := make([]interface{}, len(cols))
+  for i, col := range cols {
+    names[i], vals[i] = col.Name, col.Value
+  }
+  return insertColumnsCQL(cls.table(o), names, vals, o)
+}
+
+// insertColumnsCQL returns the INSERT statement into table of the columns
+// names with the values vals, and its bound values.
+func insertColumnsCQL(table string, names []string, vals []interface{},
+  o *options) (string, []interface{}, error) {
+
+  if o.insertJSON {
+    jsonVals := make([]interface{}, len(vals))
+    for i := range vals {
+      jsonVals[i] = jsonValue(reflect.ValueOf(vals[i]), false)
+    }
+    doc, err := jsonObject(names, jsonVals)
+    if err != nil {
+      return "", nil, err
+    }
+    cql, args, err := insertJSONCQL(table, o)
+    if err != nil {
+      return "", nil, err
+    }
+    return cql, append([]interface{}{string(doc)}, args...), nil
+  }
+  qqs := strings.TrimSuffix(strings.Repeat("?,", len(names)), ",")
+  queryStr := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table,
+    strings.Join(names, ","), qqs)
+  suffix, suffixArgs, err := insertSuffix(o)
+  if err != nil {
+    return "", nil, err
+  }
+  return queryStr + suffix, append(vals, suffixArgs...), nil
+}
@@ -0,0 +1,120 @@
+package datastore
+
+import (
+  "context"
+  "reflect"
+  "strings"
+  "sync"
+
+  "github.com/gocql/gocql"
+)
+
+// tableConsistency holds the consistency levels of the statements on a
+// table, see RegisterConsistency.
+type tableConsistency struct {
+  read, write gocql.Consistency
+}
+
+// tableKey identifies a table by its lower-cased keyspace and name, the
+// keyspace being empty for the keyspace of the session.
+type tableKey struct {
+  keyspace, table string
+}
+
+// newTableKey returns the key of the table named name in a statement,
+// qualified by its keyspace or not.
+func newTableKey(name string) tableKey {
+  var k tableKey
+  if i := strings.LastIndexByte(name, '.'); i >= 0 {
+    k.keyspace, name = strings.Trim(name[:i], `"`), name[i+1:]
+  }
+  k.table = strings.Trim(name, `"`)
+  k.keyspace, k.table = strings.ToLower(k.keyspace), strings.ToLower(k.table)
+  return k
+}
+
+// tableConsistencies maps the tableKey of tables to their
+// *tableConsistency.
+var tableConsistencies sync.Map
+
+// RegisterConsistency sets the consistency levels of the statements on the
+// column family of the entity type typ: read for the selects, write for
+// the other statements. They apply to the statements that are not given a
+// level by an option, by the context or by a query, but override the
+// defaults of a Client, e.g. to read and write counters at ONE and payments
+// at QUORUM whatever the default. A batch executed without a level takes
+// the write level of the table of its first statement with one.
+//
+// The Table option registers the levels of another table of the entity
+// type. The levels registered with the Keyspace option apply to the
+// statements qualified by the keyspace, i.e. of the operations given the
+// same option, the others to the statements executed in the keyspace of the
+// session. The levels are resolved when a statement is built. Entity types
+// are typically registered at start-up, registering a type again replacing
+// its levels.
+func RegisterConsistency(typ reflect.Type, read, write gocql.Consistency,
+  opts ...Option) error {
+
+  codec, err := getStructCodec(typ)
+  if err != nil {
+    return err
+  }
+  table := newOptions(context.Background(), opts).tableOf(codec)
+  tableConsistencies.Store(newTableKey(table),
+    &tableConsistency{read: read, write: write})
+  return nil
+}
+
+// consistencyOf returns the registered consistency level of the statement
+// op on table, as described by describeCQL, reporting whether the table has
+// one.
+func consistencyOf(op, table string) (gocql.Consistency, bool) {
+  if table == "" {
+    return 0, false
+  }
+  c, ok := tableConsistencies.Load(newTableKey(table))
+  if !ok {
+    return 0, false
+  }
+  if op == "select" {
+    return c.(*tableConsistency).read, true
+  }
+  return c.(*tableConsistency).write, true
+}
+
+// withTableConsistency returns the options o of the statement op on table
+// set to the registered consistency level of the table, if any and not set
+// otherwise.
+func withTableConsistency(o *options, op, table string) *options {
+  if o.hasConsistency && !o.defaultConsistency {
+    return o
+  }
+  c, ok := consistencyOf(op, table)
+  if !ok {
+    return o
+  }
+  xo := *o
+  xo.consistency, xo.hasConsistency, xo.defaultConsistency = c, true, false
+  return &xo
+}
+
+// withBatchConsistency returns batch executed at the registered write
+// consistency level of the table of its first statement with one, if not
+// set otherwise.
+func withBatchConsistency(batch *BatchStatement) *BatchStatement {
+  o := batch.options()
+  if o.hasConsistency && !o.defaultConsistency {
+    return batch
+  }
+  for _, stmt := range batch.Statements {
+    op, table := stmt.describe()
+    if c, ok := consistencyOf(op, table); ok {
+      x, xo := *batch, *o
+      xo.consistency, xo.hasConsistency, xo.defaultConsistency = c, true,
+        false
+      x.opts = &xo
+      return &x
+    }
+  }
+  return batch
+}
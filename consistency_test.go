@@ -0,0 +1,52 @@
+package datastore_test
+
+import (
+  "reflect"
+  "testing"
+
+  "github.com/droot/datastore"
+  "github.com/gocql/gocql"
+)
+
+// ledgerEntry has registered consistency levels.
+type ledgerEntry struct {
+  ColumnFamily string `cql:"ledger"`
+  ID           string `cql:"id,pk"`
+}
+
+func TestRegisterConsistency(t *testing.T) {
+  typ := reflect.TypeOf(ledgerEntry{})
+  err := datastore.RegisterConsistency(typ, gocql.One, gocql.All)
+  if err != nil {
+    t.Fatal(err)
+  }
+  err = datastore.RegisterConsistency(typ, gocql.LocalOne, gocql.Quorum,
+    datastore.Keyspace("archive"))
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  s := newRecordingSession(t, typ)
+  if err := datastore.SaveEntity(s, &ledgerEntry{ID: "a"}); err != nil {
+    t.Fatal(err)
+  }
+  var e ledgerEntry
+  if err := datastore.Get(s, &e, "a"); err != nil {
+    t.Fatal(err)
+  }
+  // the store has no archive keyspace, the statement is recorded anyway
+  datastore.SaveEntity(s, &e, datastore.Keyspace("archive"))
+  err = datastore.SaveEntity(s, &e, datastore.Consistency(gocql.Two))
+  if err != nil {
+    t.Fatal(err)
+  }
+  want := []gocql.Consistency{gocql.All, gocql.One, gocql.Quorum, gocql.Two}
+  if len(s.stmts) != len(want) {
+    t.Fatalf("got %d statements, want %d", len(s.stmts), len(want))
+  }
+  for i, stmt := range s.stmts {
+    if c, ok := stmt.Consistency(); !ok || c != want[i] {
+      t.Errorf("%s: got %v %v, want %v", stmt.CQL, c, ok, want[i])
+    }
+  }
+}
@@ -0,0 +1,42 @@
+package datastore
+
+import (
+  "context"
+  "time"
+
+  "github.com/gocql/gocql"
+)
+
+// ctxKey is the type of the context keys used by this package.
+type ctxKey int
+
+const (
+  consistencyKey ctxKey = iota
+  timeoutKey
+  defaultsKey
+)
+
+// WithConsistency returns a copy of ctx carrying consistency level c. Every
+// operation executed with the returned context uses c instead of the session
+// default, e.g. to force strong reads right after a write.
+func WithConsistency(ctx context.Context, c gocql.Consistency) context.Context {
+  return context.WithValue(ctx, consistencyKey, c)
+}
+
+// WithTimeout returns a copy of ctx carrying timeout d. Every operation
+// executed with the returned context is cancelled if it does not complete
+// within d. Unlike context.WithTimeout, the clock starts per operation, not
+// when the context is created.
+func WithTimeout(ctx context.Context, d time.Duration) context.Context {
+  return context.WithValue(ctx, timeoutKey, d)
+}
+
+// withDefaults returns a copy of ctx carrying the default options opts of
+// a Client, applied before the other settings of the operations executed
+// with it.
+func withDefaults(ctx context.Context, opts []Option) context.Context {
+  if len(opts) == 0 {
+    return ctx
+  }
+  return context.WithValue(ctx, defaultsKey, opts)
+}
@@ -0,0 +1,279 @@
+package datastore
+
+import (
+  "context"
+  "encoding"
+  "encoding/csv"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "io"
+  "reflect"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// The values of these types are converted to and from CSV fields with their
+// text encoding, see ExportCSV.
+var (
+  typeOfTextMarshaler = reflect.TypeOf(
+    (*encoding.TextMarshaler)(nil)).Elem()
+  typeOfTextUnmarshaler = reflect.TypeOf(
+    (*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// csvColumns returns the indices of the columns of codec exported to CSV.
+func csvColumns(codec *structCodec) []int {
+  var cols []int
+  for i, tag := range codec.byIndex {
+    if tag.stored() && !tag.counter {
+      cols = append(cols, i)
+    }
+  }
+  return cols
+}
+
+// ExportCSV writes the entities of type typ selected by q to w in CSV, q
+// being nil to export the whole column family. The first record names the
+// columns. Null values and zero dates are written as empty fields. Strings,
+// numbers and booleans are written as is, timestamps in RFC 3339 format,
+// dates and times of day as with their String methods, blobs in hex
+// prefixed by 0x, values implementing encoding.TextMarshaler with it, and
+// collections, UDTs and tuples in JSON. Counter columns are left out.
+func ExportCSV(session Session, typ reflect.Type, w io.Writer,
+  q *Query) error {
+  return ExportCSVContext(context.Background(), session, typ, w, q)
+}
+
+// ExportCSVContext is like ExportCSV but executes the query with ctx.
+func ExportCSVContext(ctx context.Context, session Session,
+  typ reflect.Type, w io.Writer, q *Query) error {
+
+  codec, err := getStructCodec(typ)
+  if err != nil {
+    return err
+  }
+  if q == nil {
+    if q, err = NewQuery(typ); err != nil {
+      return err
+    }
+  }
+  cols := csvColumns(codec)
+  record := make([]string, len(cols))
+  for i, c := range cols {
+    record[i] = codec.byIndex[c].name
+  }
+  cw := csv.NewWriter(w)
+  if err := cw.Write(record); err != nil {
+    return err
+  }
+  iter := q.RunContext(ctx, session)
+  for {
+    e := reflect.New(typ)
+    err := iter.Next(e.Interface())
+    if err == Done {
+      break
+    }
+    if err != nil {
+      iter.Close()
+      return err
+    }
+    for i, c := range cols {
+      v := e.Elem().FieldByIndex(codec.byIndex[c].index)
+      if record[i], err = csvField(v); err != nil {
+        iter.Close()
+        return fmt.Errorf("datastore: column %s: %v", codec.byIndex[c].name,
+          err)
+      }
+    }
+    if err := cw.Write(record); err != nil {
+      iter.Close()
+      return err
+    }
+  }
+  if err := iter.Close(); err != nil {
+    return err
+  }
+  cw.Flush()
+  return cw.Error()
+}
+
+// csvField returns the CSV field of the value v, see ExportCSV.
+func csvField(v reflect.Value) (string, error) {
+  for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+    if v.IsNil() {
+      return "", nil
+    }
+    v = v.Elem()
+  }
+  switch x := v.Interface().(type) {
+  case time.Time:
+    return x.Format(time.RFC3339Nano), nil
+  case Date:
+    if x == (Date{}) {
+      // the zero date is not a valid one
+      return "", nil
+    }
+    return x.String(), nil
+  case TimeOfDay:
+    return x.String(), nil
+  }
+  if v.Type().Implements(typeOfTextMarshaler) {
+    b, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+    return string(b), err
+  }
+  switch v.Kind() {
+  case reflect.String:
+    return v.String(), nil
+  case reflect.Bool:
+    return strconv.FormatBool(v.Bool()), nil
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+    reflect.Int64:
+    return strconv.FormatInt(v.Int(), 10), nil
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+    reflect.Uint64:
+    return strconv.FormatUint(v.Uint(), 10), nil
+  case reflect.Float32, reflect.Float64:
+    return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()), nil
+  case reflect.Slice:
+    if v.IsNil() {
+      return "", nil
+    }
+    if v.Type().Elem().Kind() == reflect.Uint8 {
+      return "0x" + hex.EncodeToString(v.Bytes()), nil
+    }
+  case reflect.Map:
+    if v.IsNil() {
+      return "", nil
+    }
+  }
+  b, err := json.Marshal(v.Interface())
+  return string(b), err
+}
+
+// ImportCSV saves the entities of type typ read from r in CSV, in the format
+// written by ExportCSV, and returns the number of entities saved. The first
+// record names the columns, the ones left out keep their zero values, as do
+// the empty fields. The entities are saved one by one with SaveEntity and
+// the options; the import stops at the first error, which gives the line of
+// the record.
+func ImportCSV(session Session, typ reflect.Type, r io.Reader,
+  opts ...Option) (int, error) {
+  return ImportCSVContext(context.Background(), session, typ, r, opts...)
+}
+
+// ImportCSVContext is like ImportCSV but executes the inserts with ctx.
+func ImportCSVContext(ctx context.Context, session Session,
+  typ reflect.Type, r io.Reader, opts ...Option) (int, error) {
+
+  codec, err := getStructCodec(typ)
+  if err != nil {
+    return 0, err
+  }
+  cr := csv.NewReader(r)
+  header, err := cr.Read()
+  if err == io.EOF {
+    return 0, nil
+  }
+  if err != nil {
+    return 0, fmt.Errorf("datastore: reading CSV header: %v", err)
+  }
+  cols := make([]int, len(header))
+  for i, name := range header {
+    f, ok := codec.byName[strings.TrimSpace(name)]
+    if !ok || !codec.byIndex[f.index].stored() ||
+      codec.byIndex[f.index].counter {
+      return 0, fmt.Errorf("datastore: unknown CSV column %q in %v", name,
+        typ)
+    }
+    cols[i] = f.index
+  }
+  n := 0
+  for {
+    record, err := cr.Read()
+    if err == io.EOF {
+      return n, nil
+    }
+    if err != nil {
+      return n, fmt.Errorf("datastore: reading CSV: %v", err)
+    }
+    line, _ := cr.FieldPos(0)
+    e := reflect.New(typ)
+    for i, field := range record {
+      tag := codec.byIndex[cols[i]]
+      v := e.Elem().FieldByIndex(tag.index)
+      if err := parseCSVField(v, field); err != nil {
+        return n, fmt.Errorf("datastore: CSV line %d, column %s: %v", line,
+          tag.name, err)
+      }
+    }
+    if err := SaveEntityContext(ctx, session, e.Interface(),
+      opts...); err != nil {
+      return n, fmt.Errorf("datastore: CSV line %d: %w", line,
+        err)
+    }
+    n++
+  }
+}
+
+// parseCSVField sets v to the value of the CSV field s, see ExportCSV.
+func parseCSVField(v reflect.Value, s string) error {
+  if s == "" {
+    return nil
+  }
+  if v.Kind() == reflect.Ptr {
+    v.Set(reflect.New(v.Type().Elem()))
+    v = v.Elem()
+  }
+  switch v.Interface().(type) {
+  case time.Time:
+    t, err := time.Parse(time.RFC3339Nano, s)
+    v.Set(reflect.ValueOf(t))
+    return err
+  case Date:
+    t, err := time.Parse("2006-01-02", s)
+    v.Set(reflect.ValueOf(DateOf(t)))
+    return err
+  case TimeOfDay:
+    t, err := time.Parse("15:04:05.999999999", s)
+    v.Set(reflect.ValueOf(TimeOfDayOf(t)))
+    return err
+  }
+  if p := v.Addr(); p.Type().Implements(typeOfTextUnmarshaler) {
+    return p.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+  }
+  switch v.Kind() {
+  case reflect.String:
+    v.SetString(s)
+    return nil
+  case reflect.Bool:
+    b, err := strconv.ParseBool(s)
+    v.SetBool(b)
+    return err
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+    reflect.Int64:
+    i, err := strconv.ParseInt(s, 10, v.Type().Bits())
+    v.SetInt(i)
+    return err
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+    reflect.Uint64:
+    u, err := strconv.ParseUint(s, 10, v.Type().Bits())
+    v.SetUint(u)
+    return err
+  case reflect.Float32, reflect.Float64:
+    f, err := strconv.ParseFloat(s, v.Type().Bits())
+    v.SetFloat(f)
+    return err
+  case reflect.Slice:
+    if v.Type().Elem().Kind() == reflect.Uint8 {
+      if !strings.HasPrefix(s, "0x") {
+        return fmt.Errorf("blob %q not prefixed by 0x", s)
+      }
+      b, err := hex.DecodeString(s[2:])
+      v.SetBytes(b)
+      return err
+    }
+  }
+  return json.Unmarshal([]byte(s), v.Addr().Interface())
+}
@@ -0,0 +1,151 @@
+package datastore
+
+import (
+  "crypto/hmac"
+  "crypto/rand"
+  "crypto/sha256"
+  "encoding/base64"
+  "encoding/binary"
+  "errors"
+  "fmt"
+  "hash/fnv"
+  "reflect"
+  "sync/atomic"
+)
+
+// Cursor is an opaque position in the results of a query, to resume them
+// later with Query.Start, e.g. as the pagination token of a REST API. It
+// records the paging state of Cassandra along with a hash of the query
+// statement, so that it only resumes the query it was taken from, and is
+// signed with the key set by SetCursorKey, so that clients cannot forge
+// one.
+type Cursor struct {
+  // hash is the hash of the statement of the query, see queryHash.
+  hash uint64
+  // pageState is the paging state positioned after the page of the
+  // cursor, nil at the end of the results if done is set.
+  pageState []byte
+  done      bool
+}
+
+// cursorVersion is the version of the encoding of cursors.
+const cursorVersion = 2
+
+// cursorMACSize is the size of the truncated HMAC-SHA256 signing cursors.
+const cursorMACSize = 16
+
+// cursorKey holds the key set with SetCursorKey.
+var cursorKey atomic.Value
+
+func init() {
+  key := make([]byte, 32)
+  if _, err := rand.Read(key); err != nil {
+    panic(err)
+  }
+  cursorKey.Store(key)
+}
+
+// SetCursorKey sets the key signing the cursors, see Cursor.String. It
+// defaults to a random key, so that the cursors of a process are only
+// accepted by the same process: set the same secret key of at least 32
+// bytes in every instance of a service for them to accept the cursors of
+// each other, and across restarts.
+func SetCursorKey(key []byte) {
+  cursorKey.Store(append([]byte(nil), key...))
+}
+
+// cursorMAC returns the signature of the encoded cursor b.
+func cursorMAC(b []byte) []byte {
+  mac := hmac.New(sha256.New, cursorKey.Load().([]byte))
+  mac.Write(b)
+  return mac.Sum(nil)[:cursorMACSize]
+}
+
+// String returns the cursor signed and encoded in base64, URL safe.
+func (c Cursor) String() string {
+  b := make([]byte, 10, 10+len(c.pageState)+cursorMACSize)
+  b[0] = cursorVersion
+  if c.done {
+    b[1] = 1
+  }
+  binary.BigEndian.PutUint64(b[2:], c.hash)
+  b = append(b, c.pageState...)
+  return base64.RawURLEncoding.EncodeToString(append(b, cursorMAC(b)...))
+}
+
+// DecodeCursor decodes a cursor from its String encoding. It fails with
+// ErrInvalidCursor if the cursor was not signed with the key of
+// SetCursorKey.
+func DecodeCursor(s string) (Cursor, error) {
+  b, err := base64.RawURLEncoding.DecodeString(s)
+  if err != nil || len(b) < 10+cursorMACSize {
+    return Cursor{}, ErrInvalidCursor
+  }
+  b, sig := b[:len(b)-cursorMACSize], b[len(b)-cursorMACSize:]
+  if !hmac.Equal(sig, cursorMAC(b)) || b[0] != cursorVersion || b[1] > 1 {
+    return Cursor{}, ErrInvalidCursor
+  }
+  c := Cursor{
+    hash: binary.BigEndian.Uint64(b[2:]),
+    done: b[1] == 1,
+  }
+  if len(b) > 10 {
+    c.pageState = b[10:]
+  }
+  return c, nil
+}
+
+// queryHash returns the hash identifying the query of statement cql bound
+// to args. The statement and the type and Go syntax of every argument are
+// hashed prefixed by their length, so that distinct queries hash distinct
+// inputs.
+func queryHash(cql string, args []interface{}) uint64 {
+  h := fnv.New64a()
+  write := func(s string) {
+    var n [binary.MaxVarintLen64]byte
+    h.Write(n[:binary.PutUvarint(n[:], uint64(len(s)))])
+    h.Write([]byte(s))
+  }
+  write(cql)
+  for _, arg := range args {
+    // pointers are hashed by value rather than address
+    v := reflect.ValueOf(arg)
+    for v.Kind() == reflect.Ptr && !v.IsNil() {
+      v = v.Elem()
+    }
+    write(fmt.Sprintf("%T", arg))
+    if v.IsValid() {
+      arg = v.Interface()
+    }
+    write(fmt.Sprintf("%#v", arg))
+  }
+  return h.Sum64()
+}
+
+// Cursor returns the cursor positioned after the current page of results,
+// like PageState. It fails for queries sorted client-side, whose results
+// are not paged.
+func (t *Iterator) Cursor() (Cursor, error) {
+  if t.err == Done {
+    return Cursor{hash: t.hash, done: true}, nil
+  }
+  if t.err != nil {
+    return Cursor{}, t.err
+  }
+  if t.sort {
+    return Cursor{}, errors.New("datastore: no cursor for a query " +
+      "sorted client-side")
+  }
+  state := t.PageState()
+  return Cursor{hash: t.hash, pageState: state, done: len(state) == 0}, nil
+}
+
+// Start returns a derivative query resuming the results at the cursor c,
+// taken from the iterator of the same query. Running it with a cursor of
+// another query fails with ErrInvalidCursor.
+func (q *Query) Start(c Cursor) *Query {
+  q = q.clone()
+  q.start = &c
+  q.pageState = c.pageState
+  return q
+}
@@ -0,0 +1,52 @@
+package datastore
+
+import (
+  "bytes"
+  "errors"
+  "testing"
+)
+
+func TestCursorSignature(t *testing.T) {
+  defer SetCursorKey(cursorKey.Load().([]byte))
+  SetCursorKey([]byte("secret"))
+  c := Cursor{hash: 42, pageState: []byte("state")}
+  got, err := DecodeCursor(c.String())
+  if err != nil {
+    t.Fatal(err)
+  }
+  if got.hash != c.hash || !bytes.Equal(got.pageState, c.pageState) ||
+    got.done {
+    t.Errorf("got %+v, want %+v", got, c)
+  }
+
+  // a cursor signed with another key
+  s := c.String()
+  SetCursorKey([]byte("other secret"))
+  if _, err := DecodeCursor(s); !errors.Is(err, ErrInvalidCursor) {
+    t.Errorf("got %v, want ErrInvalidCursor", err)
+  }
+  if _, err := DecodeCursor("AgA"); !errors.Is(err, ErrInvalidCursor) {
+    t.Errorf("got %v, want ErrInvalidCursor", err)
+  }
+}
+
+func TestQueryHash(t *testing.T) {
+  one, two, otherOne := 1, 2, 1
+  tests := []struct {
+    a, b []interface{}
+  }{
+    {[]interface{}{"a b"}, []interface{}{"a", "b"}},
+    {[]interface{}{"1"}, []interface{}{1}},
+    {[]interface{}{[]string{"a", "b"}}, []interface{}{"[a b]"}},
+    {[]interface{}{&one}, []interface{}{&two}},
+  }
+  for _, test := range tests {
+    if queryHash("SELECT", test.a) == queryHash("SELECT", test.b) {
+      t.Errorf("%v and %v hash the same", test.a, test.b)
+    }
+  }
+  if queryHash("SELECT", []interface{}{&one}) !=
+    queryHash("SELECT", []interface{}{&otherOne}) {
+    t.Error("pointers to equal values hash differently")
+  }
+}
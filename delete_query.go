@@ -0,0 +1,224 @@
+package datastore
+
+import (
+  "context"
+  "fmt"
+  "reflect"
+)
+
+// NewDeleteQuery creates a new DeleteQuery given an entity type. The options
+// become the defaults for every run of the query.
+func NewDeleteQuery(typ reflect.Type, opts ...Option) (*DeleteQuery, error) {
+  codec, err := getStructCodec(typ)
+  if err != nil {
+    return nil, err
+  }
+  return &DeleteQuery{
+    codec: codec,
+    opts:  opts,
+  }, nil
+}
+
+// DeleteQuery represents a CQL DELETE statement.
+type DeleteQuery struct {
+  filter []filter
+  // conditions are the IF clause conditions, AND'ed together.
+  conditions []filter
+  ifExists   bool
+  codec      *structCodec
+  opts   []Option
+  // params are the values bound to the named markers, see Param.
+  params map[string]interface{}
+
+  err error
+}
+
+func (q *DeleteQuery) clone() *DeleteQuery {
+  x := *q
+  if len(q.filter) > 0 {
+    x.filter = make([]filter, len(q.filter))
+    copy(x.filter, q.filter)
+  }
+  if len(q.conditions) > 0 {
+    x.conditions = make([]filter, len(q.conditions))
+    copy(x.conditions, q.conditions)
+  }
+  return &x
+}
+
+// Filter returns a derivative query with a field-based filter, see
+// Query.Filter. The rows matching all the filters are deleted.
+func (q *DeleteQuery) Filter(filterStr string, value interface{}) *DeleteQuery {
+  q = q.clone()
+  f, err := parseFilter(filterStr, value)
+  if err != nil {
+    q.err = err
+    return q
+  }
+  q.filter = append(q.filter, f)
+  return q
+}
+
+// FilterField returns a derivative query with a filter comparing the field
+// fieldName to value with the operator op, see Query.FilterField.
+func (q *DeleteQuery) FilterField(fieldName string, op Operator,
+  value interface{}) *DeleteQuery {
+
+  q = q.clone()
+  f, err := newFilter(fieldName, op, value)
+  if err != nil {
+    q.err = err
+    return q
+  }
+  q.filter = append(q.filter, f)
+  return q
+}
+
+// If returns a derivative query that only applies if the column fieldName
+// currently holds value. Multiple conditions are AND'ed together.
+// Conditional deletes are lightweight transactions, see ExecCAS.
+func (q *DeleteQuery) If(fieldName string, value interface{}) *DeleteQuery {
+  q = q.clone()
+  q.conditions = append(q.conditions,
+    filter{FieldName: fieldName, Op: Equal, Value: value})
+  return q
+}
+
+// IfExists returns a derivative query that only applies if the row exists.
+func (q *DeleteQuery) IfExists() *DeleteQuery {
+  q = q.clone()
+  q.ifExists = true
+  return q
+}
+
+// isConditional reports whether the delete is a lightweight transaction.
+func (q *DeleteQuery) isConditional() bool {
+  return q.ifExists || len(q.conditions) > 0
+}
+
+// toCQL returns the statement of the query and the values bound to its
+// markers.
+func (q *DeleteQuery) toCQL(o *options) (string, []interface{}, error) {
+  cql, args, err := q.buildCQL(o)
+  if err != nil {
+    return "", nil, err
+  }
+  if args, err = bindParams(args, q.params); err != nil {
+    return "", nil, err
+  }
+  return cql, args, nil
+}
+
+// buildCQL builds the statement of the query, whose named markers are left
+// unbound.
+func (q *DeleteQuery) buildCQL(o *options) (cql string, args []interface{},
+  err error) {
+
+  if q.err != nil {
+    return "", nil, q.err
+  }
+  using, args := o.timestampClause()
+  cql = fmt.Sprintf("DELETE FROM %s%s", o.tableOf(q.codec), using)
+
+  whereClause, whereArgs, err := getWhereClause(q.codec, q.filter)
+  if err != nil {
+    return "", whereArgs, err
+  }
+  cql = cql + whereClause
+  args = append(args, whereArgs...)
+
+  ifClause, ifArgs, err := getIfClause(q.codec, q.conditions, q.ifExists)
+  if err != nil {
+    return "", nil, err
+  }
+  cql = cql + ifClause
+  args = append(args, ifArgs...)
+  return cql, args, nil
+}
+
+// CQL returns the CQL statement of the query.
+func (q *DeleteQuery) CQL() (string, error) {
+  cql, _, err := q.Statement()
+  return cql, err
+}
+
+// Statement returns the CQL statement of the query along with the values
+// bound to its markers.
+func (q *DeleteQuery) Statement() (string, []interface{}, error) {
+  return q.toCQL(newOptions(context.Background(), q.opts))
+}
+
+// Run executes the delete. The options override the ones the query was
+// created with. A conditional delete that is not applied returns
+// ErrNotApplied.
+func (q *DeleteQuery) Run(session Session, opts ...Option) error {
+  return q.RunContext(context.Background(), session, opts...)
+}
+
+// RunContext is like Run but executes the delete with ctx.
+func (q *DeleteQuery) RunContext(ctx context.Context, session Session,
+  opts ...Option) error {
+
+  o := newQueryOptions(ctx, q.opts, opts)
+  cql, args, err := q.toCQL(o)
+  if err != nil {
+    return err
+  }
+  stmt := newStatement(o, cql, args)
+  if q.isConditional() {
+    iter, cancel := run(ctx, session, stmt)
+    defer cancel()
+    applied, cerr := o.scanCAS(iter, nil)
+    if err = cerr; err == nil && !applied {
+      err = ErrNotApplied
+    }
+  } else {
+    err = exec(ctx, session, stmt)
+  }
+  rows := filteredRows(o, q.codec, q.filter, q.params)
+  if cerr := invalidate(ctx, o, rows); err == nil {
+    err = cerr
+  }
+  return err
+}
+
+// DeleteEntity deletes the row of the entity src, a struct pointer of column
+// family kind, identified by the values of its pk and ck tagged fields.
+func DeleteEntity(session Session, src interface{}, opts ...Option) error {
+  return DeleteEntityContext(context.Background(), session, src, opts...)
+}
+
+// DeleteEntityContext is like DeleteEntity but executes the delete with ctx.
+func DeleteEntityContext(ctx context.Context, session Session,
+  src interface{}, opts ...Option) error {
+
+  q, err := entityDeleteQuery(src, opts)
+  if err != nil {
+    return err
+  }
+  if cls, err := newStructCLS(src); err == nil {
+    defer releaseLoadSaver(cls)
+    if len(cls.codec.uniques) > 0 {
+      return deleteUnique(ctx, session, cls, q)
+    }
+  }
+  return q.RunContext(ctx, session)
+}
+
+// entityDeleteQuery returns the query deleting the row of the entity src.
+func entityDeleteQuery(src interface{}, opts []Option) (*DeleteQuery, error) {
+  cls, err := newStructCLS(src)
+  if err != nil {
+    return nil, err
+  }
+  defer releaseLoadSaver(cls)
+  if len(cls.codec.partitionKeys) == 0 {
+    return nil, fmt.Errorf("datastore: no partition key column in %v",
+      cls.codec.typ)
+  }
+  q := &DeleteQuery{codec: cls.codec, opts: opts}
+  for _, k := range cls.codec.keyColumns() {
+    q = q.Filter(k+" =", cls.field(cls.codec.byName[k].index).Interface())
+  }
+  return q, nil
+}
@@ -1,8 +1,11 @@
 package datastore
 
 import (
+  "context"
   "fmt"
   "reflect"
+  "regexp"
+  "strconv"
   "strings"
   "sync"
 
@@ -15,21 +18,195 @@ type Entity interface {
   ColumnFamily() string
 }
 
+// BeforeSaver is implemented by entities that need to run code before being
+// saved, e.g. to compute derived columns or validate their fields. An error
+// aborts the save.
+type BeforeSaver interface {
+  BeforeSave() error
+}
+
+// AfterLoader is implemented by entities that need to run code after being
+// loaded, e.g. to decode fields. An error is returned by the load.
+type AfterLoader interface {
+  AfterLoad() error
+}
+
 // structTag is the parsed `cql:"name,options"` tag of a struct field.
 // if a field has no tag, or the tag has an empty name, then the structTag's
 // name is just the field name. A "-" name means that the datastore ignores
 // that field.
+//
+// The options are comma separated:
+//   pk         the column is (part of) the partition key
+//   ck         the column is a clustering column
+//   desc       the clustering column is sorted in descending order
+//   list, set  the slice field is stored in a CQL list (default) or set
+//   udt=N      the struct field is stored in the user-defined type N
+//   counter    the column is a counter, only updated with Increment
+//   version    the integer column is the version of the row, incremented
+//              by every save, see ErrConcurrentModification
+//   autocreatetime, autoupdatetime
+//              the time.Time column is set to the current time by saves,
+//              if zero or always respectively, see AutoTime
+//   type=T     the CQL type of the column, e.g. type=timeuuid
+//   unique     the values of the column are unique, see UniqueTableDefs
+//   ref=C      the field is not a column but the entity whose primary key
+//              is held by the columns C, separated by +, see LoadRelated
+//   required, maxlen=N, regexp=R
+//              validation rules checked by saves, see ValidationError;
+//              regexp must be the last option, R extending to the end of
+//              the tag
 type structTag struct {
   name string
   opts string
+  // index is the index path of the field in the struct, several indexes
+  // for the fields of embedded structs.
+  index []int
+
+  partitionKey  bool
+  clusteringKey bool
+  descending    bool
+  // collection is the CQL collection kind of a slice field, "list" or "set".
+  collection string
+  // udt is the name of the user-defined type of a nested struct field.
+  udt     string
+  counter bool
+  // omitEmpty is set for columns left out of inserts when the field holds
+  // the zero value of its type.
+  omitEmpty bool
+  // function is set for fields loading the write time or TTL of a column,
+  // "writetime" or "ttl", named after the selection, e.g. writetime(col).
+  function string
+  // indexed is set for columns with a secondary index, named indexName or
+  // after the table and column when empty.
+  indexed   bool
+  indexName string
+  // frozen is set for collection and UDT columns stored frozen, written
+  // and read as a whole.
+  frozen bool
+  // tuple is set for struct, array and Tuple fields stored in tuple
+  // columns.
+  tuple   bool
+  // version is set for the version column of optimistic locking.
+  version bool
+  // autoTime is "create" or "update" for the columns set by saves.
+  autoTime string
+  // unique is set for the columns with unique values.
+  unique bool
+  // ref lists the columns holding the key of a related entity field.
+  ref []string
+  // required, maxLen and pattern are the validation rules of the column.
+  required bool
+  maxLen   int
+  pattern  *regexp.Regexp
+  cqlType  string
+}
+
+// parseTagOpts parses the options part of a cql struct tag into tag.
+func parseTagOpts(tag *structTag) error {
+  for _, opt := range splitTagOpts(tag.opts) {
+    switch {
+    case opt == "":
+    case opt == "pk":
+      tag.partitionKey = true
+    case opt == "ck":
+      tag.clusteringKey = true
+    case opt == "desc":
+      tag.descending = true
+    case opt == "counter":
+      tag.counter = true
+    case opt == "omitempty":
+      tag.omitEmpty = true
+    case opt == "version":
+      tag.version = true
+    case opt == "unique":
+      tag.unique = true
+    case strings.HasPrefix(opt, "ref="):
+      tag.ref = strings.Split(strings.TrimPrefix(opt, "ref="), "+")
+    case opt == "required":
+      tag.required = true
+    case strings.HasPrefix(opt, "maxlen="):
+      n, err := strconv.Atoi(strings.TrimPrefix(opt, "maxlen="))
+      if err != nil || n <= 0 {
+        return fmt.Errorf("datastore: invalid option %q in tag of %s",
+          opt, tag.name)
+      }
+      tag.maxLen = n
+    case strings.HasPrefix(opt, "regexp="):
+      re, err := regexp.Compile(strings.TrimPrefix(opt, "regexp="))
+      if err != nil {
+        return fmt.Errorf("datastore: invalid option %q in tag of %s: %v",
+          opt, tag.name, err)
+      }
+      tag.pattern = re
+    case opt == "autocreatetime":
+      tag.autoTime = "create"
+    case opt == "autoupdatetime":
+      tag.autoTime = "update"
+    case opt == "frozen":
+      tag.frozen = true
+    case opt == "tuple":
+      tag.tuple = true
+    case opt == "date", opt == "time":
+      tag.cqlType = opt
+    case opt == "list", opt == "set":
+      tag.collection = opt
+    case strings.HasPrefix(opt, "udt="):
+      tag.udt = strings.TrimPrefix(opt, "udt=")
+    case strings.HasPrefix(opt, "writetime="), strings.HasPrefix(opt, "ttl="):
+      i := strings.Index(opt, "=")
+      tag.function = opt[:i]
+      tag.name = fmt.Sprintf("%s(%s)", opt[:i], strings.TrimSpace(opt[i+1:]))
+    case opt == "index":
+      tag.indexed = true
+    case strings.HasPrefix(opt, "index="):
+      tag.indexed = true
+      tag.indexName = strings.TrimPrefix(opt, "index=")
+    case strings.HasPrefix(opt, "type="):
+      tag.cqlType = strings.TrimPrefix(opt, "type=")
+    default:
+      return fmt.Errorf("datastore: unknown option %q in tag of %s",
+        opt, tag.name)
+    }
+  }
+  return nil
+}
+
+// splitTagOpts splits tag options on the commas that are not part of a
+// parameterized CQL type such as map<text,int>. A regexp option extends to
+// the end of the tag.
+func splitTagOpts(opts string) []string {
+  var res []string
+  depth, start := 0, 0
+  for i, r := range opts {
+    if i == start && depth == 0 &&
+      strings.HasPrefix(strings.TrimSpace(opts[i:]), "regexp=") {
+      break
+    }
+    switch r {
+    case '<':
+      depth++
+    case '>':
+      depth--
+    case ',':
+      if depth == 0 {
+        res = append(res, strings.TrimSpace(opts[start:i]))
+        start = i + 1
+      }
+    }
+  }
+  return append(res, strings.TrimSpace(opts[start:]))
 }
 
 // structCodec describes how to convert a struct to and from a sequence of
 // column values.
 type structCodec struct {
+  // typ is the struct type this codec describes.
+  typ reflect.Type
   // column family name this struct represent
   columnFamily string
-  // byIndex gives the structTag for the i'th field.
+  // byIndex gives the structTag for the i'th column, in field order with
+  // the fields of embedded structs in place of the embedded field.
   byIndex []structTag
   // byName gives the field codec for the structTag with the given name.
   byName map[string]fieldCodec
@@ -37,23 +214,53 @@ type structCodec struct {
   // nrDBCols gives number of columns being stored in DB. Columns with "-" tag
   // are ignored.
   nrDBCols int
+  // hasCounters is set for counter tables, which cannot be inserted into.
+  hasCounters bool
+  // version is the version column of optimistic locking, if any.
+  version string
+  // uniques are the indices of the columns with unique values.
+  uniques []int
+  // relations are the related entity fields by field name, see
+  // LoadRelated.
+  relations map[string]*relation
+  // autoTimes are the indices of the columns set by saves, see AutoTime.
+  autoTimes []int
+  // partitionKeys and clusteringKeys are the names of the key columns, in
+  // field order.
+  partitionKeys  []string
+  clusteringKeys []string
+
+  // insertStmts caches the INSERT statements of the type by omitted columns
+  // and suffix, see insertStmt.
+  insertStmts sync.Map
 }
 
-// fieldCodec is a struct field's index
+// fieldCodec is a column's index in structCodec.byIndex.
 type fieldCodec struct {
   index int
 }
 
 // structCodecs collects the structCodecs that have already been calculated.
+// The complete ones are published to readyCodecs, which every operation
+// reads without locking.
 var (
   structCodecsMutex sync.Mutex
   structCodecs      = make(map[reflect.Type]*structCodec)
+  readyCodecs       sync.Map // map[reflect.Type]*structCodec
 )
 
 func getStructCodec(t reflect.Type) (*structCodec, error) {
+  if c, ok := readyCodecs.Load(t); ok {
+    return c.(*structCodec), nil
+  }
   structCodecsMutex.Lock()
   defer structCodecsMutex.Unlock()
-  return getStructCodecLocked(t)
+  c, err := getStructCodecLocked(t)
+  if err != nil {
+    return nil, invalidEntity(err)
+  }
+  readyCodecs.Store(t, c)
+  return c, nil
 }
 
 func getStructCodecLocked(t reflect.Type) (ret *structCodec, err error) {
@@ -62,8 +269,8 @@ func getStructCodecLocked(t reflect.Type) (ret *structCodec, err error) {
     return c, nil
   }
   c = &structCodec{
-    byIndex: make([]structTag, t.NumField()),
-    byName:  make(map[string]fieldCodec),
+    typ:    t,
+    byName: make(map[string]fieldCodec),
   }
 
   structCodecs[t] = c
@@ -73,11 +280,50 @@ func getStructCodecLocked(t reflect.Type) (ret *structCodec, err error) {
     }
   }()
 
-  nrDBCols := 0
+  if err := c.addFields(t, nil); err != nil {
+    return nil, err
+  }
+  for _, tag := range c.byIndex {
+    if tag.function == "" {
+      continue
+    }
+    col := strings.TrimSuffix(tag.name[len(tag.function)+1:], ")")
+    if f, ok := c.byName[col]; !ok || !c.byIndex[f.index].stored() {
+      return nil, fmt.Errorf("datastore: %s of unknown column %s in %v",
+        tag.function, col, t)
+    }
+  }
+  for name, rel := range c.relations {
+    for _, col := range rel.columns {
+      if f, ok := c.byName[col]; !ok || !c.byIndex[f.index].stored() {
+        return nil, fmt.Errorf("datastore: reference %s of unknown column "+
+          "%s in %v", name, col, t)
+      }
+    }
+  }
+  if f, ok := c.byName[uniqueValue]; ok && len(c.uniques) > 0 &&
+    (c.byIndex[f.index].partitionKey || c.byIndex[f.index].clusteringKey) {
+    return nil, fmt.Errorf("datastore: key column %s of %v conflicts with "+
+      "the unique lookup tables", uniqueValue, t)
+  }
+  if c.columnFamily == "" {
+    // column family is not defined for this entity type
+    return nil,
+      fmt.Errorf("datastore: ColumnFamily field missing in %v", t)
+  }
+  return c, nil
+}
+
+// addFields adds the fields of the struct type t, reached from the codec's
+// type through the field index path index, to the codec. The fields of
+// untagged embedded structs are promoted: they become columns of the outer
+// type, which lets entities share common fields such as audit timestamps.
+func (c *structCodec) addFields(t reflect.Type, index []int) error {
   // iterate over each struct field
-  for i := range c.byIndex {
+  for i := 0; i < t.NumField(); i++ {
 
     f := t.Field(i)
+    fieldIndex := append(append([]int(nil), index...), i)
     name, opts := f.Tag.Get("cql"), ""
 
     if ii := strings.Index(name, ","); ii != -1 {
@@ -85,60 +331,259 @@ func getStructCodecLocked(t reflect.Type) (ret *structCodec, err error) {
       name, opts = name[:ii], name[ii+1:]
     }
 
-    if name == "" {
-      if !f.Anonymous {
-        // if no name has been assigned, use the struct field name
-        name = f.Name
+    if name == "" && f.Anonymous {
+      ft := f.Type
+      if ft.Kind() == reflect.Ptr && ft.Elem().Kind() == reflect.Struct {
+        return fmt.Errorf("datastore: embedded pointer field %s not "+
+          "supported, embed %v instead", f.Name, ft.Elem())
+      }
+      if ft.Kind() == reflect.Struct && isUDT(ft) {
+        if err := c.addFields(ft, fieldIndex); err != nil {
+          return err
+        }
+        continue
       }
     }
+    if name == "" {
+      // if no name has been assigned, use the struct field name
+      name = f.Name
+    }
 
     if f.Name == "ColumnFamily" {
       if name == "" || name == "-" {
-        return nil, fmt.Errorf("datastore: name %s not allowed", name)
+        return fmt.Errorf("datastore: name %s not allowed", name)
       }
       c.columnFamily = name
       name = "-" // ignore this columnFamily for DB storage
     }
+    tag := structTag{
+      name:  name,
+      opts:  opts,
+      index: fieldIndex,
+    }
+    if err := parseTagOpts(&tag); err != nil {
+      return err
+    }
+    if tag.ref != nil {
+      if err := c.addRelation(&tag, f); err != nil {
+        return err
+      }
+      continue
+    }
+    if err := checkFunctionField(&tag, f); err != nil {
+      return err
+    }
+    if err := checkTupleField(&tag, f); err != nil {
+      return err
+    }
+    name = tag.name
+    if _, ok := c.byName[name]; ok && name != "-" {
+      return fmt.Errorf("datastore: duplicate column %s in %v", name, c.typ)
+    }
     // TODO (sunil): Check if the name is valid or not
-    c.byName[name] = fieldCodec{index: i}
-    c.byIndex[i] = structTag{
-      name: name,
-      opts: opts,
+    c.byName[name] = fieldCodec{index: len(c.byIndex)}
+    if f.Type.Kind() == reflect.Ptr && (tag.partitionKey || tag.clusteringKey) {
+      // key columns cannot be null
+      return fmt.Errorf("datastore: key field %s cannot be a pointer", f.Name)
+    }
+    if tag.omitEmpty && (tag.partitionKey || tag.clusteringKey) {
+      return fmt.Errorf("datastore: omitempty option on key field %s",
+        f.Name)
+    }
+    if err := checkCivilOpt(tag.cqlType, f); err != nil {
+      return err
+    }
+    if tag.collection != "" && !isCollection(f.Type) {
+      return fmt.Errorf("datastore: %s option on non slice field %s",
+        tag.collection, f.Name)
+    }
+    if tag.counter {
+      if k := f.Type.Kind(); k != reflect.Int64 && k != reflect.Int {
+        return fmt.Errorf("datastore: counter field %s must be an int64",
+          f.Name)
+      }
+      c.hasCounters = true
+    }
+    if tag.frozen && tag.cqlType == "" && !isFreezable(f.Type) {
+      return fmt.Errorf("datastore: frozen option on field %s of non "+
+        "collection type", f.Name)
+    }
+    if err := c.checkVersionField(&tag, f); err != nil {
+      return err
+    }
+    if err := checkAutoTimeField(&tag, f); err != nil {
+      return err
+    }
+    if err := checkValidatedField(&tag, f); err != nil {
+      return err
+    }
+    if err := checkUniqueField(&tag, f); err != nil {
+      return err
+    }
+    if tag.indexed && tag.counter {
+      return fmt.Errorf("datastore: index option on counter field %s",
+        f.Name)
+    }
+    if isUDTField(f.Type) && !tag.tuple {
+      if tag.udt == "" {
+        ut := f.Type
+        if ut.Kind() == reflect.Ptr {
+          ut = ut.Elem()
+        }
+        tag.udt = strings.ToLower(ut.Name())
+      }
+    } else if tag.udt != "" {
+      return fmt.Errorf("datastore: udt option on non struct field %s",
+        f.Name)
+    }
+    c.byIndex = append(c.byIndex, tag)
+    if tag.autoTime != "" {
+      c.autoTimes = append(c.autoTimes, len(c.byIndex)-1)
+    }
+    if tag.unique {
+      c.uniques = append(c.uniques, len(c.byIndex)-1)
     }
 
-    if f.Name != "ColumnFamily" && name != "-" {
-      nrDBCols += 1
+    if tag.stored() {
+      c.nrDBCols++
+      switch {
+      case tag.partitionKey:
+        c.partitionKeys = append(c.partitionKeys, name)
+      case tag.clusteringKey:
+        c.clusteringKeys = append(c.clusteringKeys, name)
+      }
     }
   }
-  if c.columnFamily == "" {
-    // column family is not defined for this entity type
-    return nil,
-      fmt.Errorf("datastore: ColumnFamily field missing in %v", t)
+  return nil
+}
+
+// hasColumn reports whether name is a column of the type. Any name is
+// accepted for the tables queried by name, see NewTableQuery.
+func (codec *structCodec) hasColumn(name string) bool {
+  if codec.typ == nil {
+    return name != ""
   }
-  c.nrDBCols = nrDBCols
-  return c, nil
+  _, ok := codec.byName[name]
+  return ok
+}
+
+// stored reports whether the tag maps a column written by inserts, as
+// opposed to ignored fields and function selections.
+func (tag *structTag) stored() bool {
+  return tag.name != "-" && tag.function == ""
+}
+
+// checkFunctionField checks the field f loading the write time or TTL
+// selection of tag. Write times are microseconds since the epoch, TTLs
+// seconds, null for columns without TTL.
+func checkFunctionField(tag *structTag, f reflect.StructField) error {
+  if tag.function == "" {
+    return nil
+  }
+  if tag.partitionKey || tag.clusteringKey || tag.omitEmpty || tag.indexed {
+    return fmt.Errorf("datastore: %s field %s cannot have key, omitempty "+
+      "or index options", tag.function, f.Name)
+  }
+  t := f.Type
+  if t.Kind() == reflect.Ptr {
+    t = t.Elem()
+  }
+  switch t.Kind() {
+  case reflect.Int, reflect.Int64:
+    return nil
+  case reflect.Int32:
+    if tag.function == "ttl" {
+      return nil
+    }
+  }
+  return fmt.Errorf("datastore: %s field %s must be an integer",
+    tag.function, f.Name)
+}
+
+// keyColumns returns the primary key columns, partition keys first.
+func (codec *structCodec) keyColumns() []string {
+  keys := make([]string, 0,
+    len(codec.partitionKeys)+len(codec.clusteringKeys))
+  keys = append(keys, codec.partitionKeys...)
+  return append(keys, codec.clusteringKeys...)
+}
+
+// isCollection reports whether values of type t are stored in a CQL list or
+// set column. Byte slices are stored as blobs.
+func isCollection(t reflect.Type) bool {
+  if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+    return false
+  }
+  return t.Elem().Kind() != reflect.Uint8
+}
+
+// isFreezable reports whether values of type t are stored in collection or
+// UDT columns, which may be frozen.
+func isFreezable(t reflect.Type) bool {
+  if t.Kind() == reflect.Ptr {
+    t = t.Elem()
+  }
+  return isCollection(t) || t.Kind() == reflect.Map || isUDT(t)
+}
+
+// loadSaver is implemented by the adapters loading and saving entities,
+// either by reflection (structCLS) or through the entity's own
+// ColumnLoadSaver (customCLS) or EntityCodec (codecCLS) implementation.
+type loadSaver interface {
+  // setDests replaces the default scan destinations of rowData by the
+  // entity's own, for the columns the entity has.
+  setDests(rowData *gocql.RowData)
+  // loaded completes the load of a row scanned into rowData.
+  loaded(rowData *gocql.RowData) error
+  // insertCQL returns the INSERT statement saving the entity and its bound
+  // values.
+  insertCQL(o *options) (string, []interface{}, error)
 }
 
 // structCLS adapt a struct to be a ColumnLoadSaver.
 type structCLS struct {
   v     reflect.Value
   codec *structCodec
+  // prepared is set once the entity is prepared to be saved.
+  prepared bool
 }
 
-func (cls *structCLS) Load(iter *gocql.Iter) error {
-  rowData, err := iter.RowData()
-  if err != nil {
-    return err
-  }
+func (cls *structCLS) setDests(rowData *gocql.RowData) {
   for i, col := range rowData.Columns {
     f, ok := cls.codec.byName[col]
-    if ok {
-      rowData.Values[i] = cls.v.Field(f.index).Addr().Interface()
+    if ok && col != "-" {
+      // gocql unmarshals list and set columns into slices and map columns
+      // into maps, replacing the previous contents of the field.
+      rowData.Values[i] = cls.fieldDest(f.index)
+      continue
+    }
+    // gocql scans the elements of tuple columns separately
+    if name, j, ok := tupleElem(col); ok {
+      f, ok := cls.codec.byName[name]
+      if ok && cls.codec.byIndex[f.index].tuple {
+        if dest := tupleElemDest(cls.field(f.index), j); dest != nil {
+          rowData.Values[i] = dest
+        }
+      }
     }
-    // TODO (sunil): Check what to do with slice values
   }
+}
+
+func (cls *structCLS) loaded(rowData *gocql.RowData) error {
+  cls.loadTuples(rowData)
+  return afterLoad(cls.v.Addr().Interface())
+}
+
+// loadRow loads the next row of iter into ls. It returns Done if the results
+// are exhausted.
+func loadRow(ls loadSaver, iter Iter) error {
+  rowData, err := iter.RowData()
+  if err != nil {
+    return err
+  }
+  ls.setDests(&rowData)
   if iter.Scan(rowData.Values...) {
-    return nil
+    return ls.loaded(&rowData)
   }
   err = iter.Close()
   if err != nil {
@@ -148,71 +593,319 @@ func (cls *structCLS) Load(iter *gocql.Iter) error {
   return Done
 }
 
+// beforeSave calls the BeforeSave hook of the entity p, if any.
+func beforeSave(p interface{}) error {
+  if s, ok := p.(BeforeSaver); ok {
+    return s.BeforeSave()
+  }
+  return nil
+}
+
+// afterLoad calls the AfterLoad hook of the entity p, if any.
+func afterLoad(p interface{}) error {
+  if l, ok := p.(AfterLoader); ok {
+    return l.AfterLoad()
+  }
+  return nil
+}
+
+// field returns the struct field of the i'th column.
+func (cls *structCLS) field(i int) reflect.Value {
+  return cls.v.FieldByIndex(cls.codec.byIndex[i].index)
+}
+
+// fieldValue returns the value of the i'th column to bind to a statement.
+func (cls *structCLS) fieldValue(i int) interface{} {
+  if cls.codec.byIndex[i].udt != "" {
+    return udtOf(cls.field(i))
+  }
+  return cls.field(i).Interface()
+}
+
+// fieldDest returns the destination to scan the i'th column into.
+func (cls *structCLS) fieldDest(i int) interface{} {
+  if cls.codec.byIndex[i].udt != "" {
+    return udtOf(cls.field(i))
+  }
+  // Pointer fields are scanned through a pointer to the pointer, which gocql
+  // sets to nil for null columns.
+  return cls.field(i).Addr().Interface()
+}
+
+// getColumnStr returns the selection of the columns and functions the
+// fields of the type load.
 func (codec *structCodec) getColumnStr() string {
-  cols := make([]string, codec.nrDBCols)
-  i := 0
+  cols := make([]string, 0, len(codec.byIndex))
   for _, v := range codec.byIndex {
     if v.name == "-" {
       continue
     }
-    cols[i] = v.name
-    i++
+    cols = append(cols, v.name)
   }
   return strings.Join(cols, ",")
 }
 
-func (cls *structCLS) save(session *gocql.Session) error {
-  qqs := make([]string, cls.codec.nrDBCols)
-  vals := make([]interface{}, cls.codec.nrDBCols)
-  i := 0
-  for _, v := range cls.codec.byIndex {
-    if v.name == "-" {
+// insertCQL returns the INSERT statement saving the entity and its bound
+// values. The entity is prepared first, see prepare.
+func (cls *structCLS) insertCQL(o *options) (string, []interface{}, error) {
+  if cls.codec.hasCounters {
+    return "", nil, fmt.Errorf("datastore: cannot insert into counter "+
+      "table %s, use UpdateQuery.Increment", cls.codec.columnFamily)
+  }
+  if err := cls.prepare(); err != nil {
+    return "", nil, err
+  }
+  if o.insertJSON {
+    return cls.insertJSON(o)
+  }
+  vals := make([]interface{}, 0, cls.codec.nrDBCols)
+  // the omitted columns of most saves fit in buf, kept off the heap
+  var buf [8]string
+  omitted := buf[:0]
+  for i, v := range cls.codec.byIndex {
+    if !v.stored() {
+      continue
+    }
+    if v.omitEmpty && cls.field(i).IsZero() {
+      // Not writing the column avoids a tombstone for an unset field.
+      omitted = append(omitted, v.name)
       continue
     }
-    qqs[i] = "?"
-    vals[i] = cls.v.Field(cls.codec.byName[v.name].index).Interface()
-    i++
+    vals = append(vals, cls.fieldValue(i))
+  }
+  suffix, suffixArgs, err := insertSuffix(o)
+  if err != nil {
+    return "", nil, err
+  }
+  stmt := cls.codec.insertStmt(o.tableOf(cls.codec), omitted, suffix)
+  return stmt, append(vals, suffixArgs...), nil
+}
+
+// insertStmt returns the INSERT statement into table of the columns of the
+// type but the omitted ones, followed by suffix. The statements are built
+// once per shape: saves of the same type then share the statement text, and
+// so the statement gocql prepared for it, instead of rebuilding it on every
+// save.
+func (codec *structCodec) insertStmt(table string, omitted []string,
+  suffix string) string {
+
+  key := table + "|" + strings.Join(omitted, ",") + "|" + suffix
+  if stmt, ok := codec.insertStmts.Load(key); ok {
+    return stmt.(string)
+  }
+  cols := make([]string, 0, codec.nrDBCols)
+  for _, tag := range codec.byIndex {
+    switch {
+    case !tag.stored():
+    case len(omitted) > 0 && omitted[0] == tag.name:
+      // omitted lists the columns in field order
+      omitted = omitted[1:]
+    default:
+      cols = append(cols, tag.name)
+    }
+  }
+  qqs := strings.TrimSuffix(strings.Repeat("?,", len(cols)), ",")
+  stmt := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)%s",
+    table, strings.Join(cols, ","), qqs, suffix)
+  codec.insertStmts.Store(key, stmt)
+  return stmt
+}
+
+// insertSuffix returns the clauses set by the options to append to an
+// INSERT statement, along with the values they bind.
+func insertSuffix(o *options) (string, []interface{}, error) {
+  var suffix string
+  if o.ifNotExists {
+    suffix = " IF NOT EXISTS"
+  }
+  using, usingArgs, err := o.usingClause()
+  return suffix + using, usingArgs, err
+}
+
+// saveEntity executes the insert of the entity adapted by ls.
+func saveEntity(ctx context.Context, session Session, ls loadSaver,
+  o *options) error {
+
+  cls, ok := ls.(*structCLS)
+  if !ok {
+    if c, ok := ls.(*codecCLS); ok && c.codec.version != "" {
+      return fmt.Errorf("datastore: %v has a version column, which the "+
+        "saves of an EntityCodec do not check", c.codec.typ)
+    }
+    if c, ok := ls.(*codecCLS); ok && len(c.codec.uniques) > 0 {
+      return fmt.Errorf("datastore: %v has unique columns, which the "+
+        "saves of an EntityCodec do not claim", c.codec.typ)
+    }
+    return saveRow(ctx, session, ls, o)
   }
-  // columnStr := strings.Join(cols, ",")
-  qqStr := strings.Join(qqs, ",")
-  queryStr := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
-    cls.codec.columnFamily, cls.codec.getColumnStr(), qqStr)
+  if o.changes != nil {
+    if saved, err := saveChanges(ctx, session, cls, o); saved {
+      return err
+    }
+  }
+  var err error
+  if len(cls.codec.uniques) > 0 {
+    err = saveUnique(ctx, session, cls, o)
+  } else {
+    err = saveRow(ctx, session, ls, o)
+  }
+  if err == nil && o.changes != nil && o.changes.codec == cls.codec {
+    o.changes.take(cls)
+  }
+  return err
+}
+
+// saveRow executes the insert of the entity adapted by ls, or its
+// conditional update if versioned.
+func saveRow(ctx context.Context, session Session, ls loadSaver,
+  o *options) error {
 
-  if err := session.Query(queryStr, vals...).Exec(); err != nil {
+  if cls, ok := ls.(*structCLS); ok && cls.codec.version != "" {
+    return saveVersioned(ctx, session, cls, o)
+  }
+  queryStr, vals, err := ls.insertCQL(o)
+  if err != nil {
+    return err
+  }
+  stmt := newStatement(o, queryStr, vals)
+  if o.ifNotExists {
+    iter, cancel := run(ctx, session, stmt)
+    defer cancel()
+    applied, err := o.scanCAS(iter, ls)
+    if err != nil {
+      return err
+    }
+    if !applied {
+      return ErrNotApplied
+    }
+    return nil
+  }
+  if err := exec(ctx, session, stmt); err != nil {
     return err
   }
   return nil
 }
 
-// newStructCLS returns structCLS (column load saver struct).
+// scanCAS reads the result of a conditional statement. If the statement was
+// not applied and ls is not nil, the current values of the row are loaded
+// into the entity.
+func scanCAS(iter Iter, ls loadSaver) (applied bool, err error) {
+  rowData, err := iter.RowData()
+  if err != nil {
+    iter.Close()
+    return false, err
+  }
+  if ls != nil {
+    ls.setDests(&rowData)
+  }
+  for i, col := range rowData.Columns {
+    if col == "[applied]" {
+      rowData.Values[i] = &applied
+    }
+  }
+  iter.Scan(rowData.Values...)
+  if err := iter.Close(); err != nil {
+    return false, err
+  }
+  if !applied && ls != nil {
+    return false, ls.loaded(&rowData)
+  }
+  return applied, nil
+}
+
+// structCLSPool recycles the structCLS of the loads and saves, which are
+// short-lived, to spare an allocation per entity on hot paths.
+var structCLSPool = sync.Pool{
+  New: func() interface{} { return new(structCLS) },
+}
+
+// newStructCLS returns structCLS (column load saver struct). It should be
+// handed back with releaseLoadSaver once the operation is done.
 func newStructCLS(p interface{}) (*structCLS, error) {
   v := reflect.ValueOf(p)
   if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
-    return nil, fmt.Errorf("invalid entity type")
+    return nil, fmt.Errorf("%w: %T is not a struct pointer", ErrInvalidEntity,
+      p)
   }
   v = v.Elem()
   codec, err := getStructCodec(v.Type())
   if err != nil {
     return nil, err
   }
-  return &structCLS{v, codec}, nil
+  cls := structCLSPool.Get().(*structCLS)
+  cls.v, cls.codec = v, codec
+  return cls, nil
+}
+
+// releaseLoadSaver recycles ls, which must not be used afterwards.
+func releaseLoadSaver(ls loadSaver) {
+  if cls, ok := ls.(*structCLS); ok {
+    // drop the references to the entity and its codec
+    *cls = structCLS{}
+    structCLSPool.Put(cls)
+  }
 }
 
-// LoadEntity loads the columns from iter to dst, dst must be a struct pointer.
-func LoadEntity(dst interface{}, iter *gocql.Iter) error {
-  x, err := newStructCLS(dst)
+// newLoadSaver returns the loadSaver adapting the entity p, a struct pointer
+// or a ColumnLoadSaver.
+func newLoadSaver(p interface{}) (loadSaver, error) {
+  switch c := p.(type) {
+  case ColumnLoadSaver:
+    return newCustomCLS(c)
+  case EntityCodec:
+    return newCodecCLS(c)
+  }
+  return newStructCLS(p)
+}
+
+// codecOf returns the codec of the entity adapted by ls, nil for a
+// ColumnLoadSaver.
+func codecOf(ls loadSaver) *structCodec {
+  switch c := ls.(type) {
+  case *structCLS:
+    return c.codec
+  case *codecCLS:
+    return c.codec
+  }
+  return nil
+}
+
+// LoadEntity loads the columns from iter to dst, dst must be a struct pointer
+// or a ColumnLoadSaver.
+func LoadEntity(dst interface{}, iter Iter) error {
+  x, err := newLoadSaver(dst)
   if err != nil {
     return err
   }
-  return x.Load(iter)
+  defer releaseLoadSaver(x)
+  return loadRow(x, iter)
 }
 
 // SaveEntity saves a given entity instance in datastore, src must be a struct
-// pointer of column family kind.
-func SaveEntity(session *gocql.Session, src interface{}) error {
-  x, err := newStructCLS(src)
+// pointer of column family kind or a ColumnLoadSaver.
+//
+// With the IfNotExists option, the insert only happens if no row with the
+// same primary key exists; otherwise ErrNotApplied is returned and the
+// existing row is loaded into src.
+func SaveEntity(session Session, src interface{}, opts ...Option) error {
+  return SaveEntityContext(context.Background(), session, src, opts...)
+}
+
+// SaveEntityContext is like SaveEntity but executes the insert with ctx.
+func SaveEntityContext(ctx context.Context, session Session,
+  src interface{}, opts ...Option) error {
+
+  x, err := newLoadSaver(src)
   if err != nil {
     return err
   }
-  return x.save(session)
+  defer releaseLoadSaver(x)
+  o := newOptions(ctx, opts)
+  err = saveEntity(ctx, session, x, o)
+  if o.cache != nil {
+    if cerr := invalidate(ctx, o, entityRows(o, src)); err == nil {
+      err = cerr
+    }
+  }
+  return err
 }
@@ -0,0 +1,125 @@
+package datastore
+
+import (
+  "errors"
+  "fmt"
+  "strings"
+
+  "github.com/gocql/gocql"
+)
+
+// The errors of the package, to match with errors.Is, which sees through the
+// context they may be wrapped with.
+var (
+  // Done is returned when a query iteration has completed.
+  Done = errors.New("datastore: query has no more results")
+  // ErrNoSuchEntity is returned when no row matches the requested key, or
+  // no row the query of First.
+  ErrNoSuchEntity = errors.New("datastore: no such entity")
+  // ErrInvalidEntity is matched by the errors returned for values that are
+  // not entities, or whose type cannot be mapped to a column family, e.g.
+  // for lack of a ColumnFamily field or because of an invalid cql tag.
+  ErrInvalidEntity = errors.New("datastore: invalid entity")
+  // ErrNotApplied is returned when a conditional write was not applied
+  // because its condition did not hold.
+  ErrNotApplied = errors.New("datastore: conditional write not applied")
+  // ErrInvalidCursor is returned when running a query started at a cursor
+  // that was not taken from the same query, or decoding a malformed cursor.
+  ErrInvalidCursor = errors.New("datastore: invalid cursor")
+)
+
+// entityError is an error about an entity type, matching ErrInvalidEntity.
+type entityError struct {
+  err error
+}
+
+// invalidEntity returns the non-nil error err as matching
+// ErrInvalidEntity.
+func invalidEntity(err error) error {
+  return entityError{err}
+}
+
+func (e entityError) Error() string {
+  return e.err.Error()
+}
+
+func (e entityError) Is(target error) bool {
+  return target == ErrInvalidEntity
+}
+
+func (e entityError) Unwrap() error {
+  return e.err
+}
+
+// Error is returned when the session fails to execute a statement, e.g. on
+// a timeout or an invalid query, wrapping the error of gocql. Use
+// errors.As to inspect it and errors.Is to match the underlying error.
+type Error struct {
+  // Op is the kind of statement, as in Execution.
+  Op string
+  // Table is the column family of the statement, empty for batches.
+  Table string
+  // CQL is the statement, without its values. The statements of a batch
+  // are joined by semicolons.
+  CQL string
+  Err error
+}
+
+func (e *Error) Error() string {
+  if e.Table == "" {
+    return fmt.Sprintf("datastore: %s: %v", e.Op, e.Err)
+  }
+  return fmt.Sprintf("datastore: %s %s: %v", e.Op, e.Table, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+  return e.Err
+}
+
+// statementError wraps the non-nil error err met executing cql into an
+// *Error.
+func statementError(cql string, err error) error {
+  if err == nil {
+    return nil
+  }
+  if _, ok := err.(*Error); ok {
+    return err
+  }
+  op, table := describeCQL(cql)
+  return &Error{Op: op, Table: table, CQL: cql, Err: err}
+}
+
+// batchError wraps the non-nil error err met executing batch into an
+// *Error.
+func batchError(batch *BatchStatement, err error) error {
+  if err == nil {
+    return nil
+  }
+  if _, ok := err.(*Error); ok {
+    return err
+  }
+  cqls := make([]string, len(batch.Statements))
+  for i, stmt := range batch.Statements {
+    cqls[i] = stmt.CQL
+  }
+  return &Error{Op: "batch", CQL: strings.Join(cqls, "; "), Err: err}
+}
+
+// errorIter wraps the errors of the iterator of a statement into *Error.
+type errorIter struct {
+  Iter
+  cql string
+}
+
+func (it errorIter) RowData() (gocql.RowData, error) {
+  rd, err := it.Iter.RowData()
+  return rd, statementError(it.cql, err)
+}
+
+func (it errorIter) Close() error {
+  return statementError(it.cql, it.Iter.Close())
+}
+
+func (it errorIter) Warnings() []string {
+  return iterWarnings(it.Iter)
+}
@@ -0,0 +1,53 @@
+package datastore_test
+
+import (
+  "context"
+  "errors"
+  "reflect"
+  "testing"
+
+  "github.com/droot/datastore"
+  "github.com/gocql/gocql"
+)
+
+// errorSession fails every statement with err.
+type errorSession struct {
+  datastore.Session
+  err error
+}
+
+type errIter struct {
+  datastore.Iter
+  err error
+}
+
+func (it errIter) Close() error {
+  return it.err
+}
+
+func (s errorSession) Iter(ctx context.Context,
+  stmt *datastore.Statement) datastore.Iter {
+  return errIter{s.Session.Iter(ctx, stmt), s.err}
+}
+
+func TestSessionErrorWrapping(t *testing.T) {
+  timeout := &gocql.RequestErrWriteTimeout{}
+  s := errorSession{newRecordingSession(t, reflect.TypeOf(tweet{})),
+    timeout}
+  err := datastore.SaveEntity(s, &tweet{ID: "a"})
+  var dsErr *datastore.Error
+  if !errors.As(err, &dsErr) || dsErr.Op != "insert" ||
+    dsErr.Table != "tweets" {
+    t.Fatalf("got %v, want an *Error", err)
+  }
+  var got *gocql.RequestErrWriteTimeout
+  if !errors.As(err, &got) || got != timeout {
+    t.Errorf("got %v, want the write timeout", err)
+  }
+
+  s.err = gocql.ErrNotFound
+  err = datastore.SaveEntity(s, &tweet{ID: "a"})
+  if err == gocql.ErrNotFound || !errors.Is(err, gocql.ErrNotFound) {
+    t.Errorf("got %v, want ErrNotFound wrapped", err)
+  }
+}
@@ -0,0 +1,58 @@
+package datastore
+
+import (
+  "context"
+  "errors"
+  "reflect"
+)
+
+// TableSizeEstimate is an approximate size of a column family as reported by
+// the coordinator node's system.size_estimates table.
+type TableSizeEstimate struct {
+  // Partitions is the estimated number of partitions (rows for tables
+  // without clustering columns).
+  Partitions int64
+  // Bytes is the estimated size of the data in bytes.
+  Bytes int64
+  // Ranges is the number of token ranges the estimate was summed over.
+  Ranges int
+}
+
+const sizeEstimatesCQL = "SELECT partitions_count, mean_partition_size " +
+  "FROM system.size_estimates WHERE keyspace_name = ? AND table_name = ?"
+
+// EstimateTableSize returns approximate partition count and size in bytes of
+// the column family represented by typ, without scanning the table. The
+// estimates are refreshed periodically by Cassandra and only cover the token
+// ranges owned by the node serving the query, so they are meant for
+// dashboards and capacity planning, not for exact counts. It fails if the
+// session is not connected to a keyspace.
+func EstimateTableSize(session Session, typ reflect.Type) (
+  *TableSizeEstimate, error) {
+
+  codec, err := getStructCodec(typ)
+  if err != nil {
+    return nil, err
+  }
+  keyspace := session.Keyspace()
+  if keyspace == "" {
+    return nil, errors.New("datastore: no keyspace to estimate the size " +
+      "of " + codec.columnFamily + " in")
+  }
+  stmt := newStatement(&options{}, sizeEstimatesCQL,
+    []interface{}{keyspace, codec.columnFamily})
+  iter, cancel := run(context.Background(), session, stmt)
+  defer cancel()
+
+  est := &TableSizeEstimate{}
+  var partitions, meanSize int64
+  for iter.Scan(&partitions, &meanSize) {
+    est.Partitions += partitions
+    est.Bytes += partitions * meanSize
+    est.Ranges++
+  }
+  if err := iter.Close(); err != nil {
+    return nil, err
+  }
+  return est, nil
+}
@@ -0,0 +1,25 @@
+package datastore_test
+
+import (
+  "reflect"
+  "testing"
+
+  "github.com/droot/datastore"
+)
+
+// noKeyspaceSession is not connected to a keyspace.
+type noKeyspaceSession struct {
+  datastore.Session
+}
+
+func (noKeyspaceSession) Keyspace() string {
+  return ""
+}
+
+func TestEstimateTableSizeNoKeyspace(t *testing.T) {
+  s := noKeyspaceSession{newRecordingSession(t, reflect.TypeOf(tweet{}))}
+  est, err := datastore.EstimateTableSize(s, reflect.TypeOf(tweet{}))
+  if err == nil {
+    t.Errorf("got %+v, want an error", est)
+  }
+}
@@ -24,8 +24,9 @@ func main() {
   cluster := gocql.NewCluster("10.0.0.10")
   cluster.Keyspace = "example"
   cluster.Consistency = gocql.Quorum
-  session, _ := cluster.CreateSession()
-  defer session.Close()
+  cqlSession, _ := cluster.CreateSession()
+  defer cqlSession.Close()
+  session := datastore.NewSession(cqlSession)
 
   tw := &Tweet{
     Timeline: "me",
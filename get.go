@@ -0,0 +1,78 @@
+package datastore
+
+import (
+  "context"
+  "fmt"
+  "reflect"
+  "strings"
+)
+
+// keyQuery returns a query of typ filtered on its primary key columns, the
+// pk tagged columns followed by the ck tagged ones, equal to keyValues. All
+// the partition key columns must be given, followed by any prefix of the
+// clustering columns.
+func keyQuery(typ reflect.Type, keyValues []interface{},
+  opts []Option) (*Query, error) {
+
+  q, err := NewQuery(typ, opts...)
+  if err != nil {
+    return nil, err
+  }
+  keys := q.codec.keyColumns()
+  nrPKs := len(q.codec.partitionKeys)
+  if nrPKs == 0 {
+    return nil, fmt.Errorf("datastore: no partition key column in %v", typ)
+  }
+  if len(keyValues) < nrPKs || len(keyValues) > len(keys) {
+    return nil, fmt.Errorf("datastore: %d key values given for key (%s)",
+      len(keyValues), strings.Join(keys, ", "))
+  }
+  for i, v := range keyValues {
+    q = q.Filter(keys[i]+" =", v)
+  }
+  return q, nil
+}
+
+// KeyOf returns the primary key columns of the entity src, a struct pointer
+// of column family kind, in the order Get takes their values, along with the
+// values src holds.
+func KeyOf(src interface{}) (columns []string, values []interface{},
+  err error) {
+
+  cls, err := newStructCLS(src)
+  if err != nil {
+    return nil, nil, err
+  }
+  defer releaseLoadSaver(cls)
+  columns, values = cls.keyArgs()
+  return columns, values, nil
+}
+
+// Get loads into dst, a struct pointer, the row whose primary key columns
+// equal keyValues, in the order the pk and ck tagged fields are declared.
+// Trailing clustering columns may be omitted, in which case the first
+// matching row is loaded. ErrNoSuchEntity is returned if no row matches.
+func Get(session Session, dst interface{}, keyValues ...interface{}) error {
+  return GetContext(context.Background(), session, dst, keyValues)
+}
+
+// GetContext is like Get but executes the read with ctx and opts.
+func GetContext(ctx context.Context, session Session, dst interface{},
+  keyValues []interface{}, opts ...Option) error {
+
+  v := reflect.ValueOf(dst)
+  if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+    return fmt.Errorf("datastore: dst must be a struct pointer, got %T", dst)
+  }
+  q, err := keyQuery(v.Elem().Type(), keyValues, opts)
+  if err != nil {
+    return err
+  }
+  if err := getOne(ctx, session, q, dst); err != nil {
+    if err == Done {
+      return ErrNoSuchEntity
+    }
+    return err
+  }
+  return nil
+}
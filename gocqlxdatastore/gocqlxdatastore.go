@@ -0,0 +1,117 @@
+// Package gocqlxdatastore lets code mixing the datastore package and gocqlx
+// share a single mapping of its entities. The statements of datastore
+// queries run as gocqlx queries, bound by name, and the rows of gocqlx
+// queries load into datastore entities through their cql tags:
+//
+//   q, err := datastore.NewQuery(typeOfUser)
+//   q = q.Filter("id =", datastore.Param("id"))
+//   qx, err := gocqlxdatastore.Query(session, q,
+//     map[string]interface{}{"id": id})
+//
+//   var user User
+//   err = gocqlxdatastore.Get(qx, &user)
+package gocqlxdatastore
+
+import (
+  "context"
+  "fmt"
+  "reflect"
+
+  "github.com/droot/datastore"
+  "github.com/scylladb/gocqlx/v2"
+)
+
+// Builder is implemented by the datastore queries, Query, UpdateQuery and
+// DeleteQuery, see datastore.Query.NamedStatement.
+type Builder interface {
+  NamedStatement() (stmt string, names []string,
+    values map[string]interface{}, err error)
+}
+
+// Query returns the gocqlx query of the statement of q, built with session.
+// Its markers are bound by name to values, along with the values given to
+// q: its literal values and the ones bound to its params with Bind. values
+// may be nil once all the params of q are bound. The query can be bound
+// again with BindMap or BindStruct, the values of the literal markers being
+// named ?1, ?2 and so on by position.
+func Query(session gocqlx.Session, q Builder,
+  values map[string]interface{}) (*gocqlx.Queryx, error) {
+  return QueryContext(context.Background(), session, q, values)
+}
+
+// QueryContext is like Query but the query is executed with ctx.
+func QueryContext(ctx context.Context, session gocqlx.Session, q Builder,
+  values map[string]interface{}) (*gocqlx.Queryx, error) {
+
+  stmt, names, bound, err := q.NamedStatement()
+  if err != nil {
+    return nil, err
+  }
+  for name, v := range values {
+    bound[name] = v
+  }
+  qx := session.ContextQuery(ctx, stmt, names).BindMap(bound)
+  if err := qx.Err(); err != nil {
+    return nil, fmt.Errorf("gocqlxdatastore: %v", err)
+  }
+  return qx, nil
+}
+
+// Get loads the first row selected by qx into dst, a struct pointer of
+// column family kind or a ColumnLoadSaver, like datastore.LoadEntity. It
+// returns datastore.ErrNoSuchEntity if no row is selected.
+func Get(qx *gocqlx.Queryx, dst interface{}) error {
+  if err := qx.Err(); err != nil {
+    return err
+  }
+  iter := qx.Iter()
+  err := datastore.LoadEntity(dst, iter.Iter)
+  if err == datastore.Done {
+    return datastore.ErrNoSuchEntity
+  }
+  if err != nil {
+    iter.Iter.Close()
+    return err
+  }
+  return iter.Iter.Close()
+}
+
+// Select loads the rows selected by qx into dst, a pointer to a slice of
+// structs or struct pointers of column family kind, appending them. It
+// returns the number of rows loaded.
+func Select(qx *gocqlx.Queryx, dst interface{}) (int, error) {
+  dv := reflect.ValueOf(dst)
+  if dv.Kind() != reflect.Ptr || dv.IsNil() ||
+    dv.Elem().Kind() != reflect.Slice {
+    return 0, fmt.Errorf("gocqlxdatastore: dst must be a slice pointer, "+
+      "got %T", dst)
+  }
+  if err := qx.Err(); err != nil {
+    return 0, err
+  }
+  sv := dv.Elem()
+  elemType, isPtr := sv.Type().Elem(), false
+  if elemType.Kind() == reflect.Ptr {
+    elemType, isPtr = elemType.Elem(), true
+  }
+  iter := qx.Iter().Iter
+  n := 0
+  for {
+    elem := reflect.New(elemType)
+    err := datastore.LoadEntity(elem.Interface(), iter)
+    if err == datastore.Done {
+      // the iterator was closed at the end of the rows
+      return n, nil
+    }
+    if err != nil {
+      iter.Close()
+      return n, err
+    }
+    if isPtr {
+      sv.Set(reflect.Append(sv, elem))
+    } else {
+      sv.Set(reflect.Append(sv, elem.Elem()))
+    }
+    n++
+  }
+}
@@ -0,0 +1,109 @@
+package datastore
+
+import (
+  "context"
+  "fmt"
+  "reflect"
+  "strings"
+)
+
+// indexDef returns the secondary index on the column of tag. Unless named
+// with the index= option, it gets Cassandra's default name,
+// <table>_<column>_idx.
+func indexDef(codec *structCodec, tag structTag) IndexDef {
+  name := tag.indexName
+  if name == "" {
+    name = fmt.Sprintf("%s_%s_idx", codec.columnFamily, tag.name)
+  }
+  return IndexDef{Name: name, Column: tag.name}
+}
+
+// columnIndex returns the secondary index on column of the entity type typ,
+// in the table of o. The default name of the index is the one of the table
+// given with the Table option, if any.
+func columnIndex(typ reflect.Type, column string, o *options) (string,
+  IndexDef, error) {
+
+  codec, err := getStructCodec(typ)
+  if err != nil {
+    return "", IndexDef{}, err
+  }
+  f, ok := codec.byName[column]
+  if !ok || !codec.byIndex[f.index].stored() {
+    return "", IndexDef{}, fmt.Errorf("datastore: no column %s in %v",
+      column, typ)
+  }
+  idx := indexDef(codec, codec.byIndex[f.index])
+  if o.table != "" && codec.byIndex[f.index].indexName == "" {
+    table := o.table[strings.LastIndex(o.table, ".")+1:]
+    idx.Name = fmt.Sprintf("%s_%s_idx", table, column)
+  }
+  return o.tableOf(codec), idx, nil
+}
+
+// CreateIndexCQL returns the CREATE INDEX IF NOT EXISTS statement of the
+// secondary index on column of the entity type typ. Indexes on collection
+// columns index their values. The index is created on the table given with
+// the Table and Keyspace options, in its keyspace.
+func CreateIndexCQL(typ reflect.Type, column string,
+  opts ...Option) (string, error) {
+
+  table, idx, err := columnIndex(typ, column,
+    newOptions(context.Background(), opts))
+  if err != nil {
+    return "", err
+  }
+  return fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)", idx.Name,
+    table, idx.Column), nil
+}
+
+// CreateIndex creates the secondary index on column of the entity type typ
+// if it does not exist yet, see CreateIndexCQL.
+func CreateIndex(session Session, typ reflect.Type, column string,
+  opts ...Option) error {
+
+  cql, err := CreateIndexCQL(typ, column, opts...)
+  if err != nil {
+    return err
+  }
+  stmt := newStatement(&options{}, cql, nil)
+  return exec(context.Background(), session, stmt)
+}
+
+// DropIndex drops the secondary index on column of the entity type typ if it
+// exists, honoring the Table and Keyspace options like CreateIndexCQL.
+func DropIndex(session Session, typ reflect.Type, column string,
+  opts ...Option) error {
+
+  o := newOptions(context.Background(), opts)
+  _, idx, err := columnIndex(typ, column, o)
+  if err != nil {
+    return err
+  }
+  name := idx.Name
+  if o.keyspace != "" {
+    name = o.keyspace + "." + name
+  }
+  cql := fmt.Sprintf("DROP INDEX IF EXISTS %s", name)
+  stmt := newStatement(&options{}, cql, nil)
+  return exec(context.Background(), session, stmt)
+}
+
+// EnsureIndexes creates the secondary indexes declared with the index tag
+// option on the fields of typ that do not exist yet, honoring the Table and
+// Keyspace options like CreateIndexCQL:
+//
+//   Email string   `cql:"email,index"`
+//   Tags  []string `cql:"tags,set,index=user_tags"`
+func EnsureIndexes(session Session, typ reflect.Type, opts ...Option) error {
+  def, err := GetTableDef(typ)
+  if err != nil {
+    return err
+  }
+  for _, idx := range def.Indexes {
+    if err := CreateIndex(session, typ, idx.Column, opts...); err != nil {
+      return err
+    }
+  }
+  return nil
+}
@@ -0,0 +1,215 @@
+package datastore
+
+import (
+  "bytes"
+  "context"
+  "encoding/hex"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "math/big"
+  "net"
+  "reflect"
+  "strings"
+  "time"
+
+  "github.com/gocql/gocql"
+)
+
+// InsertJSON makes SaveEntity insert the entity as a JSON document, with
+// INSERT INTO cf JSON ?, instead of binding a value per column. Columns left
+// out of the document, such as omitempty ones, keep their current values.
+func InsertJSON() Option {
+  return func(o *options) {
+    o.insertJSON = true
+  }
+}
+
+// SaveJSON inserts the row described by doc, a JSON object mapping column
+// names to values, into the column family columnFamily. Values are in the
+// format of the column types' JSON encoding, e.g. strings for uuids and
+// timestamps. Columns missing from doc keep their current values.
+func SaveJSON(session Session, columnFamily string, doc []byte,
+  opts ...Option) error {
+  return SaveJSONContext(context.Background(), session, columnFamily, doc,
+    opts...)
+}
+
+// SaveJSONContext is like SaveJSON but executes the insert with ctx.
+func SaveJSONContext(ctx context.Context, session Session,
+  columnFamily string, doc []byte, opts ...Option) error {
+
+  if columnFamily == "" {
+    return errors.New("datastore: empty table name")
+  }
+  if !json.Valid(doc) {
+    return errors.New("datastore: invalid JSON document")
+  }
+  o := newOptions(ctx, opts)
+  cql, args, err := insertJSONCQL(o.qualify(columnFamily), o)
+  if err != nil {
+    return err
+  }
+  stmt := newStatement(o, cql, append([]interface{}{string(doc)}, args...))
+  if o.ifNotExists {
+    iter, cancel := run(ctx, session, stmt)
+    defer cancel()
+    applied, err := scanCAS(iter, nil)
+    if err == nil && !applied {
+      err = ErrNotApplied
+    }
+    return err
+  }
+  return exec(ctx, session, stmt)
+}
+
+// insertJSONCQL returns the INSERT JSON statement of columnFamily, whose
+// first marker is bound to the document, along with the values bound by the
+// options.
+func insertJSONCQL(columnFamily string, o *options) (string, []interface{},
+  error) {
+
+  suffix, args, err := insertSuffix(o)
+  if err != nil {
+    return "", nil, err
+  }
+  return fmt.Sprintf("INSERT INTO %s JSON ? DEFAULT UNSET%s", columnFamily,
+    suffix), args, nil
+}
+
+// jsonObject encodes the columns names and their values vals as a JSON
+// object, in order.
+func jsonObject(names []string, vals []interface{}) ([]byte, error) {
+  var buf bytes.Buffer
+  buf.WriteByte('{')
+  for i, name := range names {
+    if i > 0 {
+      buf.WriteByte(',')
+    }
+    key, _ := json.Marshal(name)
+    val, err := json.Marshal(vals[i])
+    if err != nil {
+      return nil, fmt.Errorf("datastore: column %s: %v", name, err)
+    }
+    buf.Write(key)
+    buf.WriteByte(':')
+    buf.Write(val)
+  }
+  buf.WriteByte('}')
+  return buf.Bytes(), nil
+}
+
+// jsonValue returns the value v encodes to in the JSON format of the CQL
+// types, tuple being set for tuple columns.
+func jsonValue(v reflect.Value, tuple bool) interface{} {
+  if !v.IsValid() {
+    return nil
+  }
+  switch v.Kind() {
+  case reflect.Ptr, reflect.Interface:
+    if v.IsNil() {
+      return nil
+    }
+    return jsonValue(v.Elem(), tuple)
+  }
+  switch x := v.Interface().(type) {
+  case udtValue:
+    return jsonUDT(x.v)
+  case nullUDT:
+    return jsonValue(x.v, false)
+  case time.Time:
+    return x.UTC().Format("2006-01-02 15:04:05.000-0700")
+  case gocql.UUID, Date, TimeOfDay, net.IP:
+    return fmt.Sprint(x)
+  case big.Int:
+    return json.Number(x.String())
+  case []byte:
+    return "0x" + hex.EncodeToString(x)
+  }
+  switch v.Kind() {
+  case reflect.Slice, reflect.Array:
+    if v.Kind() == reflect.Slice && v.IsNil() {
+      return nil
+    }
+    elems := make([]interface{}, v.Len())
+    for i := range elems {
+      elems[i] = jsonValue(v.Index(i), false)
+    }
+    return elems
+  case reflect.Map:
+    if v.IsNil() {
+      return nil
+    }
+    m := make(map[string]interface{}, v.Len())
+    iter := v.MapRange()
+    for iter.Next() {
+      key := jsonValue(iter.Key(), false)
+      s, ok := key.(string)
+      if !ok {
+        b, _ := json.Marshal(key)
+        s = string(b)
+      }
+      m[s] = jsonValue(iter.Value(), false)
+    }
+    return m
+  case reflect.Struct:
+    if tuple {
+      elems := make([]interface{}, v.NumField())
+      for i := range elems {
+        elems[i] = jsonValue(v.Field(i), false)
+      }
+      return elems
+    }
+    if isUDT(v.Type()) {
+      return jsonUDT(v)
+    }
+  }
+  return v.Interface()
+}
+
+// jsonUDT returns the fields of the UDT value v by name, named as mapped by
+// udtValue.
+func jsonUDT(v reflect.Value) map[string]interface{} {
+  t := v.Type()
+  m := make(map[string]interface{}, t.NumField())
+  for i := 0; i < t.NumField(); i++ {
+    f := t.Field(i)
+    name := f.Tag.Get("cql")
+    if j := strings.Index(name, ","); j != -1 {
+      name = name[:j]
+    }
+    if name == "-" || f.PkgPath != "" {
+      continue
+    }
+    if name == "" {
+      name = strings.ToLower(f.Name)
+    }
+    m[name] = jsonValue(v.Field(i), false)
+  }
+  return m
+}
+
+// insertJSON returns the INSERT JSON statement saving the entity and its
+// bound values.
+func (cls *structCLS) insertJSON(o *options) (string, []interface{},
+  error) {
+
+  var names []string
+  var vals []interface{}
+  for i, v := range cls.codec.byIndex {
+    if !v.stored() || v.omitEmpty && cls.field(i).IsZero() {
+      continue
+    }
+    names = append(names, v.name)
+    vals = append(vals, jsonValue(cls.field(i), v.tuple))
+  }
+  doc, err := jsonObject(names, vals)
+  if err != nil {
+    return "", nil, err
+  }
+  cql, args, err := insertJSONCQL(o.tableOf(cls.codec), o)
+  if err != nil {
+    return "", nil, err
+  }
+  return cql, append([]interface{}{string(doc)}, args...), nil
+}
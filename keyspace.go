@@ -0,0 +1,93 @@
+package datastore
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "sort"
+  "strings"
+)
+
+// Replication is the replication strategy of a keyspace, see SimpleStrategy
+// and NetworkTopologyStrategy.
+type Replication struct {
+  // Class is the replication strategy class.
+  Class string
+  // Factor is the replication factor of SimpleStrategy.
+  Factor int
+  // DataCenters gives the replication factor per data center of
+  // NetworkTopologyStrategy.
+  DataCenters map[string]int
+}
+
+// SimpleStrategy replicates the data rf times in the cluster, regardless of
+// data centers. It suits test and single data center clusters.
+func SimpleStrategy(rf int) Replication {
+  return Replication{Class: "SimpleStrategy", Factor: rf}
+}
+
+// NetworkTopologyStrategy replicates the data in each data center of dcs as
+// many times as the replication factor given for it.
+func NetworkTopologyStrategy(dcs map[string]int) Replication {
+  return Replication{Class: "NetworkTopologyStrategy", DataCenters: dcs}
+}
+
+// String returns the replication map of r in CQL.
+func (r Replication) String() string {
+  opts := []string{fmt.Sprintf("'class': '%s'", r.Class)}
+  if r.Class == "SimpleStrategy" {
+    opts = append(opts, fmt.Sprintf("'replication_factor': %d", r.Factor))
+  }
+  dcs := make([]string, 0, len(r.DataCenters))
+  for dc := range r.DataCenters {
+    dcs = append(dcs, dc)
+  }
+  sort.Strings(dcs)
+  for _, dc := range dcs {
+    opts = append(opts, fmt.Sprintf("'%s': %d", dc, r.DataCenters[dc]))
+  }
+  return "{" + strings.Join(opts, ", ") + "}"
+}
+
+// CreateKeyspaceCQL returns the CREATE KEYSPACE IF NOT EXISTS statement of
+// the keyspace name replicated with r.
+func CreateKeyspaceCQL(name string, r Replication) (string, error) {
+  if name == "" {
+    return "", errors.New("datastore: empty keyspace name")
+  }
+  switch {
+  case r.Class == "SimpleStrategy" && r.Factor <= 0:
+    return "", fmt.Errorf("datastore: invalid replication factor %d",
+      r.Factor)
+  case r.Class == "NetworkTopologyStrategy" && len(r.DataCenters) == 0:
+    return "", errors.New("datastore: no data center to replicate to")
+  case r.Class == "":
+    return "", errors.New("datastore: no replication strategy")
+  }
+  return fmt.Sprintf("CREATE KEYSPACE IF NOT EXISTS %s WITH replication = %s",
+    name, r), nil
+}
+
+// CreateKeyspace creates the keyspace name replicated with r if it does not
+// exist yet, e.g. to provision the keyspace of tests:
+//
+//   err := datastore.CreateKeyspace(session, "example",
+//     datastore.SimpleStrategy(1))
+func CreateKeyspace(session Session, name string, r Replication) error {
+  cql, err := CreateKeyspaceCQL(name, r)
+  if err != nil {
+    return err
+  }
+  stmt := newStatement(&options{}, cql, nil)
+  return exec(context.Background(), session, stmt)
+}
+
+// DropKeyspace drops the keyspace name and all its tables if it exists.
+func DropKeyspace(session Session, name string) error {
+  if name == "" {
+    return errors.New("datastore: empty keyspace name")
+  }
+  cql := fmt.Sprintf("DROP KEYSPACE IF EXISTS %s", name)
+  stmt := newStatement(&options{}, cql, nil)
+  return exec(context.Background(), session, stmt)
+}
@@ -0,0 +1,81 @@
+package datastore
+
+import (
+  "context"
+  "log"
+  "sync/atomic"
+)
+
+// Logger is called with every statement executed by the package once it
+// is finished, with its CQL text, bound value count, duration and error.
+type Logger interface {
+  LogStatement(ctx context.Context, e *Execution)
+}
+
+// LoggerFunc adapts a function to a Logger.
+type LoggerFunc func(ctx context.Context, e *Execution)
+
+func (f LoggerFunc) LogStatement(ctx context.Context, e *Execution) {
+  f(ctx, e)
+}
+
+// NewStdLogger returns a Logger writing a line per statement to l.
+func NewStdLogger(l *log.Logger) Logger {
+  return LoggerFunc(func(ctx context.Context, e *Execution) {
+    l.Printf("datastore: %s [%d values] %d rows in %v, err: %v", e.CQL,
+      e.Args, e.Rows, e.Duration, e.Err)
+  })
+}
+
+// globalLogger holds the logger set with SetLogger.
+var globalLogger atomic.Value
+
+type loggerHolder struct {
+  l Logger
+}
+
+// SetLogger sets the logger of the statements executed without the Log
+// option, nil to log none.
+func SetLogger(l Logger) {
+  globalLogger.Store(loggerHolder{l})
+}
+
+// Log sets the logger of the operation, overriding the one set with
+// SetLogger. Given to v2.NewClient, it logs the statements of the client.
+func Log(l Logger) Option {
+  return func(o *options) {
+    o.logger = l
+  }
+}
+
+// getLogger returns the logger of the operation, if any.
+func (o *options) getLogger() Logger {
+  if o.logger != nil {
+    return o.logger
+  }
+  h, _ := globalLogger.Load().(loggerHolder)
+  return h.l
+}
+
+// logObserver adapts a Logger to an Observer.
+type logObserver struct {
+  l Logger
+}
+
+func (o logObserver) Start(ctx context.Context,
+  e *Execution) context.Context {
+  return ctx
+}
+
+func (o logObserver) Finish(ctx context.Context, e *Execution) {
+  o.l.LogStatement(ctx, e)
+}
+
+// logged returns session, logging the statements to the logger of o if
+// any.
+func logged(session Session, o *options) Session {
+  if l := o.getLogger(); l != nil {
+    return ObserveSession(session, logObserver{l})
+  }
+  return session
+}
@@ -0,0 +1,861 @@
+// Package memstore provides an in-memory datastore.Session for unit tests.
+//
+// A Store interprets the statements generated by the datastore package
+// against tables held in memory, so code using the package can be tested
+// without a Cassandra cluster:
+//
+//   store := memstore.New()
+//   if err := store.Register(reflect.TypeOf(User{})); err != nil {
+//     ...
+//   }
+//   err := datastore.SaveEntity(store, &User{ID: "u1"})
+//
+// The Store is not a Cassandra emulator: TTLs and write timestamps are
+// ignored, WRITETIME and TTL selections load as null, user-defined types are
+// not supported and the restrictions Cassandra puts on queries, such as
+// filtering on non-key columns without ALLOW FILTERING, are not enforced.
+// Partitions are spread over the token ring by a hash other than Cassandra's,
+// so rows come back in a different, but likewise stable, partition order.
+package memstore
+
+import (
+  "context"
+  "encoding/json"
+  "fmt"
+  "hash/fnv"
+  "reflect"
+  "sort"
+  "strings"
+  "sync"
+
+  "github.com/droot/datastore"
+  "github.com/gocql/gocql"
+)
+
+// Keyspace is the keyspace reported by a Store.
+const Keyspace = "memstore"
+
+// row maps column names to values.
+type row map[string]interface{}
+
+type table struct {
+  def *datastore.TableDef
+  // keys lists the primary key columns, partition key first.
+  keys []string
+  // desc marks the clustering columns sorted in descending order.
+  desc map[string]bool
+  rows map[string]row
+}
+
+// Store is an in-memory datastore.Session. It is safe for concurrent use.
+type Store struct {
+  mu     sync.Mutex
+  tables map[string]*table
+}
+
+// New returns an empty store without tables.
+func New() *Store {
+  return &Store{tables: map[string]*table{}}
+}
+
+// Register creates the tables of the entity types types, derived with
+// datastore.GetTableDef, along with the lookup tables of their unique
+// columns. Statements on tables that were not registered fail.
+func (s *Store) Register(types ...reflect.Type) error {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  for _, typ := range types {
+    def, err := datastore.GetTableDef(typ)
+    if err != nil {
+      return err
+    }
+    lookups, err := datastore.UniqueTableDefs(typ)
+    if err != nil {
+      return err
+    }
+    s.register(def)
+    for _, lookup := range lookups {
+      s.register(lookup)
+    }
+  }
+  return nil
+}
+
+// RegisterTable creates the table name with the columns of the entity type
+// typ, and the lookup tables of its unique columns, for the entities saved
+// with the datastore.Table option. The name may
+// be qualified by a keyspace, see the datastore.Keyspace option; tables
+// registered without keyspace serve every keyspace.
+func (s *Store) RegisterTable(name string, typ reflect.Type) error {
+  def, err := datastore.GetTableDef(typ)
+  if err != nil {
+    return err
+  }
+  def.Name = name
+  lookups, err := datastore.UniqueTableDefs(typ, datastore.Table(name))
+  if err != nil {
+    return err
+  }
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  s.register(def)
+  for _, lookup := range lookups {
+    s.register(lookup)
+  }
+  return nil
+}
+
+// register creates the table def unless it exists.
+func (s *Store) register(def *datastore.TableDef) {
+  name := strings.ToLower(def.Name)
+  if _, ok := s.tables[name]; ok {
+    return
+  }
+  t := &table{def: def, desc: map[string]bool{}, rows: map[string]row{}}
+  for _, col := range def.Columns {
+    if col.PartitionKey {
+      t.keys = append(t.keys, col.Name)
+    }
+  }
+  for _, col := range def.Columns {
+    if col.ClusteringKey {
+      t.keys = append(t.keys, col.Name)
+      t.desc[col.Name] = col.Descending
+    }
+  }
+  s.tables[name] = t
+}
+
+// Reset removes all rows, keeping the registered tables.
+func (s *Store) Reset() {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  for _, t := range s.tables {
+    t.rows = map[string]row{}
+  }
+}
+
+// Keyspace returns Keyspace.
+func (s *Store) Keyspace() string {
+  return Keyspace
+}
+
+// Iter executes stmt.
+func (s *Store) Iter(ctx context.Context,
+  stmt *datastore.Statement) datastore.Iter {
+
+  if err := ctx.Err(); err != nil {
+    return &memIter{err: err}
+  }
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  it, err := s.execute(stmt)
+  if err != nil {
+    return &memIter{err: err}
+  }
+  return it
+}
+
+// ExecBatch executes the statements of batch in order. A failing statement
+// stops the batch but does not roll back the statements executed before it.
+func (s *Store) ExecBatch(ctx context.Context,
+  batch *datastore.BatchStatement) error {
+
+  if err := ctx.Err(); err != nil {
+    return err
+  }
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  for _, stmt := range batch.Statements {
+    if _, err := s.execute(stmt); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+func (s *Store) execute(stmt *datastore.Statement) (*memIter, error) {
+  st, err := parse(stmt.CQL)
+  if err != nil {
+    return nil, err
+  }
+  t, ok := s.tables[st.table]
+  if i := strings.LastIndexByte(st.table, '.'); !ok && i >= 0 {
+    // tables registered without keyspace serve every keyspace
+    t, ok = s.tables[st.table[i+1:]]
+  }
+  if !ok {
+    return nil, fmt.Errorf("memstore: unknown table %q", st.table)
+  }
+  if st.nargs > len(stmt.Args) {
+    return nil, fmt.Errorf("memstore: %d values bound to %q, want %d",
+      len(stmt.Args), stmt.CQL, st.nargs)
+  }
+  args := make([]interface{}, len(stmt.Args))
+  for i, arg := range stmt.Args {
+    args[i] = normalize(arg)
+  }
+  if err := t.checkColumns(st); err != nil {
+    return nil, err
+  }
+  switch st.kind {
+  case "SELECT":
+    return t.selectRows(st, args)
+  case "INSERT":
+    return t.insert(st, args)
+  case "UPDATE":
+    return t.update(st, args)
+  case "DELETE":
+    return t.delete(st, args)
+  }
+  return nil, fmt.Errorf("memstore: unsupported statement %q", stmt.CQL)
+}
+
+// checkColumns checks that the columns st refers to exist in t.
+func (t *table) checkColumns(st *statement) error {
+  names := append([]string(nil), st.columns...)
+  for _, a := range st.aggregates {
+    if a.column != "*" {
+      names = append(names, a.column)
+    }
+  }
+  for _, conds := range [][]cond{st.where, st.ifConds} {
+    for _, c := range conds {
+      if c.token != nil {
+        names = append(names, c.token...)
+        continue
+      }
+      names = append(names, c.column)
+    }
+  }
+  for _, a := range st.sets {
+    names = append(names, a.column)
+  }
+  for _, o := range st.order {
+    names = append(names, o.column)
+  }
+  names = append(names, st.groupBy...)
+  for _, name := range names {
+    if i := strings.IndexByte(name, '('); i >= 0 {
+      name = strings.TrimSuffix(name[i+1:], ")")
+    }
+    if !t.hasColumn(name) {
+      return fmt.Errorf("memstore: unknown column %q in table %q",
+        name, t.def.Name)
+    }
+  }
+  return nil
+}
+
+func (t *table) hasColumn(name string) bool {
+  for _, col := range t.def.Columns {
+    if strings.EqualFold(col.Name, name) {
+      return true
+    }
+  }
+  return false
+}
+
+func (t *table) columnNames() []string {
+  names := make([]string, len(t.def.Columns))
+  for i, col := range t.def.Columns {
+    names[i] = col.Name
+  }
+  return names
+}
+
+// encodeKey returns the primary key of r as a map key.
+func (t *table) encodeKey(r row) string {
+  parts := make([]string, len(t.keys))
+  for i, k := range t.keys {
+    parts[i] = fmt.Sprintf("%#v", r[k])
+  }
+  return strings.Join(parts, "\x00")
+}
+
+// keyOf returns the primary key fixed by the equality conditions of where.
+func (t *table) keyOf(where []cond, args []interface{}) (row, error) {
+  key := row{}
+  for _, c := range where {
+    if c.op != "=" {
+      return nil, fmt.Errorf("memstore: write with %s condition on %q",
+        c.op, c.column)
+    }
+    key[c.column] = args[c.args[0]]
+  }
+  for _, k := range t.keys {
+    if _, ok := key[k]; !ok {
+      return nil, fmt.Errorf("memstore: missing key column %q", k)
+    }
+  }
+  return key, nil
+}
+
+// token returns the token of the values of columns in r. It is not the one
+// Cassandra computes, but likewise spreads partitions over the int64 range.
+func token(r row, columns []string) int64 {
+  h := fnv.New64a()
+  for _, col := range columns {
+    fmt.Fprintf(h, "%#v\x00", r[col])
+  }
+  // murmur3's finalizer, fnv alone barely spreads short keys
+  x := h.Sum64()
+  x ^= x >> 33
+  x *= 0xff51afd7ed558ccd
+  x ^= x >> 33
+  x *= 0xc4ceb9fe1a85ec53
+  x ^= x >> 33
+  return int64(x)
+}
+
+// matches reports whether r satisfies all conds.
+func matches(r row, conds []cond, args []interface{}) bool {
+  for _, c := range conds {
+    v := r[c.column]
+    if c.token != nil {
+      v = token(r, c.token)
+    }
+    vals := make([]interface{}, len(c.args))
+    for i, arg := range c.args {
+      vals[i] = args[arg]
+    }
+    if c.list {
+      vals = listValues(args[c.args[0]])
+    }
+    switch c.op {
+    case "LIKE":
+      if !like(v, vals[0]) {
+        return false
+      }
+      continue
+    case "CONTAINS", "CONTAINS KEY":
+      if !containsValue(v, vals[0], c.op == "CONTAINS KEY") {
+        return false
+      }
+      continue
+    }
+    ok := false
+    for _, arg := range vals {
+      n, comparable := compare(v, arg)
+      if !comparable {
+        continue
+      }
+      switch c.op {
+      case "=", "IN":
+        ok = n == 0
+      case "!=":
+        ok = n != 0
+      case "<":
+        ok = n < 0
+      case "<=":
+        ok = n <= 0
+      case ">":
+        ok = n > 0
+      case ">=":
+        ok = n >= 0
+      }
+      if ok {
+        break
+      }
+    }
+    if !ok {
+      return false
+    }
+  }
+  return true
+}
+
+// like reports whether v matches the LIKE pattern, in which % matches any
+// sequence of characters.
+func like(v, pattern interface{}) bool {
+  s, ok := v.(string)
+  p, pok := pattern.(string)
+  if !ok || !pok {
+    return false
+  }
+  parts := strings.Split(p, "%")
+  if !strings.HasPrefix(s, parts[0]) {
+    return false
+  }
+  s = s[len(parts[0]):]
+  for i, part := range parts[1:] {
+    if i == len(parts)-2 {
+      return strings.HasSuffix(s, part)
+    }
+    j := strings.Index(s, part)
+    if j < 0 {
+      return false
+    }
+    s = s[j+len(part):]
+  }
+  return s == ""
+}
+
+// containsValue reports whether the collection v holds the element x, or
+// the key x if key is set.
+func containsValue(v, x interface{}, key bool) bool {
+  rv := reflect.ValueOf(v)
+  switch {
+  case rv.Kind() == reflect.Map:
+    iter := rv.MapRange()
+    for iter.Next() {
+      e := iter.Value()
+      if key {
+        e = iter.Key()
+      }
+      if c, ok := compare(normalize(e.Interface()), x); ok && c == 0 {
+        return true
+      }
+    }
+  case !key:
+    for _, e := range listValues(v) {
+      if c, ok := compare(e, x); ok && c == 0 {
+        return true
+      }
+    }
+  }
+  return false
+}
+
+// partitionKey returns the partition key columns.
+func (t *table) partitionKey() []string {
+  return t.keys[:len(t.keys)-len(t.desc)]
+}
+
+// sorted returns the rows of t matching where ordered like Cassandra does:
+// by token of the partition key, then by clustering columns.
+func (t *table) sorted(where []cond, args []interface{}) []row {
+  var rows []row
+  for _, r := range t.rows {
+    if matches(r, where, args) {
+      rows = append(rows, r)
+    }
+  }
+  pks := t.partitionKey()
+  sort.SliceStable(rows, func(i, j int) bool {
+    ti, tj := token(rows[i], pks), token(rows[j], pks)
+    if ti != tj {
+      return ti < tj
+    }
+    for _, k := range t.keys {
+      c, _ := compare(rows[i][k], rows[j][k])
+      if c == 0 {
+        continue
+      }
+      if t.desc[k] {
+        return c > 0
+      }
+      return c < 0
+    }
+    return false
+  })
+  return rows
+}
+
+// perPartition keeps the first n rows of each partition.
+func (t *table) perPartition(rows []row, n int) []row {
+  counts := map[string]int{}
+  var res []row
+  for _, r := range rows {
+    key := make([]interface{}, 0, len(t.keys))
+    for _, k := range t.partitionKey() {
+      key = append(key, r[k])
+    }
+    pk := fmt.Sprintf("%#v", key)
+    if counts[pk] < n {
+      res = append(res, r)
+    }
+    counts[pk]++
+  }
+  return res
+}
+
+func (t *table) selectRows(st *statement, args []interface{}) (
+  *memIter, error) {
+
+  it, err := t.selectValues(st, args)
+  if err != nil || !st.json {
+    return it, err
+  }
+  return jsonRows(it)
+}
+
+// jsonRows returns the rows of it as JSON documents, in the single [json]
+// column of SELECT JSON results.
+func jsonRows(it *memIter) (*memIter, error) {
+  res := &memIter{columns: []string{"[json]"}}
+  for _, values := range it.rows {
+    var b strings.Builder
+    b.WriteByte('{')
+    for i, col := range it.columns {
+      key, _ := json.Marshal(col)
+      val, err := json.Marshal(values[i])
+      if err != nil {
+        return nil, err
+      }
+      if i > 0 {
+        b.WriteString(", ")
+      }
+      fmt.Fprintf(&b, "%s: %s", key, val)
+    }
+    b.WriteByte('}')
+    res.rows = append(res.rows, []interface{}{b.String()})
+  }
+  return res, nil
+}
+
+func (t *table) selectValues(st *statement, args []interface{}) (
+  *memIter, error) {
+
+  rows := t.sorted(st.where, args)
+  if len(st.order) > 0 {
+    sort.SliceStable(rows, func(i, j int) bool {
+      for _, o := range st.order {
+        c, _ := compare(rows[i][o.column], rows[j][o.column])
+        if c == 0 {
+          continue
+        }
+        if o.desc {
+          return c > 0
+        }
+        return c < 0
+      }
+      return false
+    })
+  }
+  if st.perPartition >= 0 {
+    rows = t.perPartition(rows, st.perPartition)
+  }
+  if len(st.aggregates) > 0 {
+    it, err := aggregateRows(st, rows)
+    if err == nil && st.limit >= 0 && len(it.rows) > st.limit {
+      it.rows = it.rows[:st.limit]
+    }
+    return it, err
+  }
+  if st.limit >= 0 && len(rows) > st.limit {
+    rows = rows[:st.limit]
+  }
+  columns := st.columns
+  if columns == nil {
+    columns = t.columnNames()
+  }
+  it := &memIter{columns: columns}
+  seen := map[string]bool{}
+  for _, r := range rows {
+    values := make([]interface{}, len(columns))
+    for i, col := range columns {
+      values[i] = r[col]
+    }
+    if st.distinct {
+      k := fmt.Sprintf("%#v", values)
+      if seen[k] {
+        continue
+      }
+      seen[k] = true
+    }
+    it.rows = append(it.rows, values)
+  }
+  return it, nil
+}
+
+// aggregateRows computes the aggregates of st over rows, per group of rows
+// when grouped.
+func aggregateRows(st *statement, rows []row) (*memIter, error) {
+  it := &memIter{columns: append([]string(nil), st.columns...)}
+  for _, a := range st.aggregates {
+    name := a.alias
+    if name == "" {
+      name = a.fn + "(" + a.column + ")"
+    }
+    it.columns = append(it.columns, name)
+  }
+  groups := [][]row{rows}
+  if len(st.groupBy) > 0 {
+    groups = nil
+    index := map[string]int{}
+    for _, r := range rows {
+      key := make([]interface{}, len(st.groupBy))
+      for i, col := range st.groupBy {
+        key[i] = r[col]
+      }
+      k := fmt.Sprintf("%#v", key)
+      i, ok := index[k]
+      if !ok {
+        i = len(groups)
+        index[k] = i
+        groups = append(groups, nil)
+      }
+      groups[i] = append(groups[i], r)
+    }
+  }
+  for _, g := range groups {
+    values := make([]interface{}, 0, len(it.columns))
+    for _, col := range st.columns {
+      var v interface{}
+      if len(g) > 0 {
+        v = g[0][col]
+      }
+      values = append(values, v)
+    }
+    for _, a := range st.aggregates {
+      v, err := aggregateValue(a, g)
+      if err != nil {
+        return nil, err
+      }
+      values = append(values, v)
+    }
+    it.rows = append(it.rows, values)
+  }
+  return it, nil
+}
+
+// aggregateValue computes the aggregate a over rows.
+func aggregateValue(a aggregate, rows []row) (interface{}, error) {
+  var res interface{}
+  var n int64
+  for _, r := range rows {
+    v := r[a.column]
+    if a.column == "*" {
+      v = true
+    }
+    if v == nil {
+      continue
+    }
+    n++
+    switch a.fn {
+    case "min", "max":
+      c, ok := compare(v, res)
+      if res == nil || ok && (c < 0) == (a.fn == "min") && c != 0 {
+        res = v
+      }
+    case "sum", "avg":
+      var err error
+      if res, err = combine(res, "+", v); err != nil {
+        return nil, err
+      }
+    case "count":
+    default:
+      return nil, fmt.Errorf("memstore: unknown aggregate %s", a.fn)
+    }
+  }
+  switch a.fn {
+  case "count":
+    res = n
+  case "avg":
+    switch x := res.(type) {
+    case int64:
+      res = x / n
+    case float64:
+      res = x / float64(n)
+    }
+  }
+  return res, nil
+}
+
+// casResult returns the result of a conditional write: the [applied]
+// column, followed by the current values of the row if it was not applied.
+func (t *table) casResult(applied bool, current row) *memIter {
+  columns := []string{"[applied]"}
+  values := []interface{}{applied}
+  if !applied && current != nil {
+    for _, col := range t.columnNames() {
+      columns = append(columns, col)
+      values = append(values, current[col])
+    }
+  }
+  return &memIter{columns: columns, rows: [][]interface{}{values}}
+}
+
+func (t *table) insert(st *statement, args []interface{}) (*memIter, error) {
+  r := row{}
+  for i, col := range st.columns {
+    r[col] = args[st.values[i]]
+  }
+  if st.json {
+    var err error
+    if r, err = t.jsonRow(args[st.values[0]], st.defaultNull); err != nil {
+      return nil, err
+    }
+  }
+  for _, k := range t.keys {
+    if r[k] == nil {
+      return nil, fmt.Errorf("memstore: missing key column %q", k)
+    }
+  }
+  key := t.encodeKey(r)
+  current, exists := t.rows[key]
+  if st.ifNotExists && exists {
+    return t.casResult(false, current), nil
+  }
+  if !exists {
+    current = row{}
+    t.rows[key] = current
+  }
+  for col, v := range r {
+    current[col] = v
+  }
+  if st.ifNotExists {
+    return t.casResult(true, nil), nil
+  }
+  return &memIter{}, nil
+}
+
+// jsonRow decodes the row of an INSERT JSON statement from doc, converting
+// the values to the column types.
+func (t *table) jsonRow(doc interface{}, defaultNull bool) (row, error) {
+  s, ok := doc.(string)
+  if !ok {
+    return nil, fmt.Errorf("memstore: JSON document of type %T", doc)
+  }
+  var m map[string]interface{}
+  dec := json.NewDecoder(strings.NewReader(s))
+  dec.UseNumber()
+  if err := dec.Decode(&m); err != nil {
+    return nil, fmt.Errorf("memstore: invalid JSON document: %v", err)
+  }
+  r := row{}
+  for _, col := range t.def.Columns {
+    v, ok := m[strings.ToLower(col.Name)]
+    if !ok {
+      if defaultNull {
+        r[col.Name] = nil
+      }
+      continue
+    }
+    delete(m, strings.ToLower(col.Name))
+    x, err := fromJSON(col.Type, v)
+    if err != nil {
+      return nil, fmt.Errorf("memstore: column %s: %v", col.Name, err)
+    }
+    r[col.Name] = x
+  }
+  for name := range m {
+    return nil, fmt.Errorf("memstore: unknown column %q in table %q", name,
+      t.def.Name)
+  }
+  return r, nil
+}
+
+// columnType returns the CQL type of the column name, "" if unknown.
+func (t *table) columnType(name string) string {
+  for _, col := range t.def.Columns {
+    if col.Name == name {
+      return col.Type
+    }
+  }
+  return ""
+}
+
+func (t *table) update(st *statement, args []interface{}) (*memIter, error) {
+  key, err := t.keyOf(st.where, args)
+  if err != nil {
+    return nil, err
+  }
+  current, exists := t.rows[t.encodeKey(key)]
+  conditional := st.ifExists || len(st.ifConds) > 0
+  if st.ifExists && !exists {
+    return t.casResult(false, nil), nil
+  }
+  if len(st.ifConds) > 0 && (!exists || !matches(current, st.ifConds, args)) {
+    return t.casResult(false, current), nil
+  }
+
+  updated := row{}
+  for col, v := range current {
+    updated[col] = v
+  }
+  for col, v := range key {
+    updated[col] = v
+  }
+  for _, a := range st.sets {
+    v := args[a.arg]
+    if a.op != "=" {
+      if v, err = combine(updated[a.column], a.op, v); err != nil {
+        return nil, err
+      }
+      if strings.HasPrefix(t.columnType(a.column), "set<") {
+        v = setOf(v)
+      }
+    }
+    updated[a.column] = v
+  }
+  t.rows[t.encodeKey(key)] = updated
+  if conditional {
+    return t.casResult(true, nil), nil
+  }
+  return &memIter{}, nil
+}
+
+func (t *table) delete(st *statement, args []interface{}) (*memIter,
+  error) {
+
+  if st.ifExists || len(st.ifConds) > 0 {
+    // conditional deletes apply to a single row
+    key, err := t.keyOf(st.where, args)
+    if err != nil {
+      return nil, err
+    }
+    current, exists := t.rows[t.encodeKey(key)]
+    if !exists || !matches(current, st.ifConds, args) {
+      return t.casResult(false, current), nil
+    }
+    delete(t.rows, t.encodeKey(key))
+    return t.casResult(true, nil), nil
+  }
+  for k, r := range t.rows {
+    if matches(r, st.where, args) {
+      delete(t.rows, k)
+    }
+  }
+  return &memIter{}, nil
+}
+
+// memIter iterates over the rows of a result computed upfront.
+type memIter struct {
+  columns []string
+  rows    [][]interface{}
+  pos     int
+  err     error
+}
+
+func (it *memIter) RowData() (gocql.RowData, error) {
+  if it.err != nil {
+    return gocql.RowData{}, it.err
+  }
+  rd := gocql.RowData{Columns: it.columns,
+    Values: make([]interface{}, len(it.columns))}
+  for i := range rd.Values {
+    rd.Values[i] = new(interface{})
+  }
+  return rd, nil
+}
+
+func (it *memIter) Scan(dest ...interface{}) bool {
+  if it.err != nil || it.pos >= len(it.rows) {
+    return false
+  }
+  values := it.rows[it.pos]
+  if len(dest) != len(values) {
+    it.err = fmt.Errorf("memstore: scanning %d columns into %d destinations",
+      len(values), len(dest))
+    return false
+  }
+  for i, v := range values {
+    if err := assign(dest[i], v); err != nil {
+      it.err = err
+      return false
+    }
+  }
+  it.pos++
+  return true
+}
+
+// PageState returns nil, results are never paged.
+func (it *memIter) PageState() []byte {
+  return nil
+}
+
+func (it *memIter) Close() error {
+  return it.err
+}
@@ -0,0 +1,80 @@
+package memstore_test
+
+import (
+  "reflect"
+  "testing"
+
+  "github.com/droot/datastore"
+  "github.com/droot/datastore/memstore"
+)
+
+type post struct {
+  ColumnFamily string            `cql:"posts"`
+  Timeline     string            `cql:"timeline,pk"`
+  Seq          int               `cql:"seq,ck"`
+  Text         string            `cql:"text"`
+  Tags         []string          `cql:"tags"`
+  Attrs        map[string]string `cql:"attrs"`
+}
+
+func TestRoundTrip(t *testing.T) {
+  s := memstore.New()
+  typ := reflect.TypeOf(post{})
+  if err := s.Register(typ); err != nil {
+    t.Fatal(err)
+  }
+  posts := []*post{
+    {Timeline: "me", Seq: 1, Text: "a", Tags: []string{"x"}},
+    {Timeline: "me", Seq: 2, Text: "b", Attrs: map[string]string{"k": "v"}},
+    {Timeline: "you", Seq: 1, Text: "c"},
+  }
+  for _, p := range posts {
+    if err := datastore.SaveEntity(s, p); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  var got post
+  if err := datastore.Get(s, &got, "me", 2); err != nil {
+    t.Fatal(err)
+  }
+  if !reflect.DeepEqual(&got, posts[1]) {
+    t.Errorf("got %+v, want %+v", got, posts[1])
+  }
+
+  q, err := datastore.NewQuery(typ)
+  if err != nil {
+    t.Fatal(err)
+  }
+  var timeline []post
+  _, err = q.Filter("timeline =", "me").Order("-seq").GetAll(s, &timeline)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(timeline) != 2 || timeline[0].Seq != 2 || timeline[1].Seq != 1 ||
+    !reflect.DeepEqual(timeline[1].Tags, []string{"x"}) {
+    t.Errorf("got %+v", timeline)
+  }
+
+  uq, err := datastore.NewUpdateQuery(typ)
+  if err != nil {
+    t.Fatal(err)
+  }
+  err = uq.Filter("timeline =", "me").Filter("seq =", 1).
+    Update("text", "edited").Run(s)
+  if err != nil {
+    t.Fatal(err)
+  }
+  err = datastore.Get(s, &got, "me", 1)
+  if err != nil || got.Text != "edited" {
+    t.Errorf("got %+v %v, want the edited post", got, err)
+  }
+
+  if err := datastore.DeleteEntity(s, posts[2]); err != nil {
+    t.Fatal(err)
+  }
+  err = datastore.Get(s, &got, "you", 1)
+  if err != datastore.ErrNoSuchEntity {
+    t.Errorf("got %v, want ErrNoSuchEntity", err)
+  }
+}
@@ -0,0 +1,426 @@
+package memstore
+
+import (
+  "fmt"
+  "strconv"
+  "strings"
+  "unicode"
+)
+
+// The parser understands the subset of CQL generated by the datastore
+// package. Bind markers are numbered in the order they appear, which is the
+// order the datastore package binds its values in.
+
+type operator string
+
+// cond is a condition of a WHERE or IF clause.
+type cond struct {
+  column string
+  // token lists the partition key columns of a condition on their token,
+  // which has no column.
+  token []string
+  op     operator
+  // args are the indexes of the bound values, several for IN.
+  args []int
+  // list is set for IN conditions whose single value is the list of values
+  // to match.
+  list bool
+}
+
+// assignment is an assignment of the SET clause of an UPDATE.
+type assignment struct {
+  column string
+  // op is "=" for plain assignments, "+" or "-" for relative ones and
+  // "prepend" for the elements prepended to a list.
+  op  string
+  arg int
+}
+
+// aggregate is an aggregate function applied to a column, or to * for
+// count(*).
+type aggregate struct {
+  fn     string
+  column string
+  alias  string
+}
+
+// aggregateFuncs are the aggregate functions selections may apply.
+var aggregateFuncs = map[string]bool{
+  "count": true, "min": true, "max": true, "sum": true, "avg": true,
+}
+
+type orderBy struct {
+  column string
+  desc   bool
+}
+
+// statement is a parsed CQL statement.
+type statement struct {
+  kind    string // SELECT, INSERT, UPDATE or DELETE
+  table   string
+  columns []string
+  // aggregates are the aggregate functions selected, computed per group of
+  // rows with the values of the columns of the first row of the group.
+  aggregates []aggregate
+  distinct   bool
+  // json is set for SELECT JSON and INSERT JSON statements, defaultNull for
+  // the latter setting the columns missing from the document to null.
+  json        bool
+  defaultNull bool
+  // values are the bind marker indexes of an INSERT, aligned with columns.
+  values  []int
+  sets    []assignment
+  where   []cond
+  groupBy []string
+  order   []orderBy
+  limit   int
+  // perPartition is the PER PARTITION LIMIT, -1 if none.
+  perPartition int
+  ifNotExists  bool
+  ifExists     bool
+  ifConds      []cond
+  // nargs is the number of bind markers.
+  nargs int
+}
+
+type parser struct {
+  toks []string
+  pos  int
+  // nargs is the number of bind markers seen so far.
+  nargs int
+  // err is the first syntax error met, after which the parser reads no
+  // more tokens.
+  err error
+}
+
+func tokenize(cql string) []string {
+  var toks []string
+  for i := 0; i < len(cql); {
+    c := rune(cql[i])
+    switch {
+    case unicode.IsSpace(c):
+      i++
+    case c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c) || c == '.':
+      j := i
+      for j < len(cql) && (cql[j] == '_' || cql[j] == '.' ||
+        unicode.IsLetter(rune(cql[j])) || unicode.IsDigit(rune(cql[j]))) {
+        j++
+      }
+      toks = append(toks, cql[i:j])
+      i = j
+    case c == '[':
+      j := strings.IndexByte(cql[i:], ']')
+      if j < 0 {
+        j = len(cql) - i - 1
+      }
+      toks = append(toks, cql[i:i+j+1])
+      i += j + 1
+    case strings.HasPrefix(cql[i:], "<=") || strings.HasPrefix(cql[i:], ">=") ||
+      strings.HasPrefix(cql[i:], "!="):
+      toks = append(toks, cql[i:i+2])
+      i += 2
+    default:
+      toks = append(toks, string(c))
+      i++
+    }
+  }
+  return toks
+}
+
+func parse(cql string) (*statement, error) {
+  p := &parser{toks: tokenize(cql)}
+  st := &statement{limit: -1, perPartition: -1}
+  switch kw := strings.ToUpper(p.next()); kw {
+  case "SELECT":
+    p.parseSelect(st)
+  case "INSERT":
+    p.parseInsert(st)
+  case "UPDATE":
+    p.parseUpdate(st)
+  case "DELETE":
+    p.parseDelete(st)
+  case "":
+  default:
+    p.fail("unknown statement %s", kw)
+  }
+  if p.err == nil && p.pos != len(p.toks) {
+    p.fail("unexpected %s", p.toks[p.pos])
+  }
+  if p.err != nil {
+    return nil, fmt.Errorf("memstore: unsupported statement %q: %v", cql,
+      p.err)
+  }
+  st.kind = strings.ToUpper(p.toks[0])
+  st.nargs = p.nargs
+  return st, nil
+}
+
+// fail records the syntax error described by format and args, unless one
+// was met already.
+func (p *parser) fail(format string, args ...interface{}) {
+  if p.err == nil {
+    p.err = fmt.Errorf(format, args...)
+  }
+}
+
+// peek returns the next token, "" at the end of the statement or after an
+// error.
+func (p *parser) peek() string {
+  if p.err == nil && p.pos < len(p.toks) {
+    return p.toks[p.pos]
+  }
+  return ""
+}
+
+func (p *parser) next() string {
+  t := p.peek()
+  if t == "" {
+    p.fail("unexpected end of statement")
+    return ""
+  }
+  p.pos++
+  return t
+}
+
+// accept consumes the keywords kws if they come next.
+func (p *parser) accept(kws ...string) bool {
+  if p.err != nil {
+    return false
+  }
+  for i, kw := range kws {
+    if p.pos+i >= len(p.toks) || !strings.EqualFold(p.toks[p.pos+i], kw) {
+      return false
+    }
+  }
+  p.pos += len(kws)
+  return true
+}
+
+func (p *parser) expect(kws ...string) {
+  if !p.accept(kws...) {
+    p.fail("expected %s, got %q", strings.Join(kws, " "), p.peek())
+  }
+}
+
+// marker parses a positional (?) or named (:name) bind marker.
+func (p *parser) marker() int {
+  if p.accept(":") {
+    p.next()
+  } else {
+    p.expect("?")
+  }
+  p.nargs++
+  return p.nargs - 1
+}
+
+// isMarker reports whether a bind marker comes next.
+func (p *parser) isMarker() bool {
+  return p.peek() == "?" || p.peek() == ":"
+}
+
+// identList parses a comma separated list of identifiers.
+func (p *parser) identList() []string {
+  var ids []string
+  for {
+    ids = append(ids, strings.ToLower(p.next()))
+    if !p.accept(",") {
+      return ids
+    }
+  }
+}
+
+func (p *parser) parseSelect(st *statement) {
+  st.json = p.accept("JSON")
+  st.distinct = p.accept("DISTINCT")
+  if !p.accept("*") {
+    for {
+      col := strings.ToLower(p.next())
+      if p.accept("(") {
+        arg := strings.ToLower(p.next())
+        p.expect(")")
+        if aggregateFuncs[col] {
+          a := aggregate{fn: col, column: arg}
+          if p.accept("AS") {
+            a.alias = strings.ToLower(p.next())
+          }
+          st.aggregates = append(st.aggregates, a)
+          if !p.accept(",") {
+            break
+          }
+          continue
+        }
+        // writetime(col) or ttl(col), which load as null
+        col += "(" + arg + ")"
+      }
+      st.columns = append(st.columns, col)
+      if !p.accept(",") {
+        break
+      }
+    }
+  }
+  p.expect("FROM")
+  st.table = strings.ToLower(p.next())
+  if p.accept("WHERE") {
+    st.where = p.conds()
+  }
+  if p.accept("GROUP", "BY") {
+    st.groupBy = p.identList()
+  }
+  if p.accept("ORDER", "BY") {
+    for {
+      o := orderBy{column: strings.ToLower(p.next())}
+      if p.accept("DESC") {
+        o.desc = true
+      } else {
+        p.accept("ASC")
+      }
+      st.order = append(st.order, o)
+      if !p.accept(",") {
+        break
+      }
+    }
+  }
+  if p.accept("PER", "PARTITION", "LIMIT") {
+    st.perPartition = p.number()
+  }
+  if p.accept("LIMIT") {
+    st.limit = p.number()
+  }
+  p.accept("ALLOW", "FILTERING")
+}
+
+// number parses an integer literal.
+func (p *parser) number() int {
+  t := p.next()
+  n, err := strconv.Atoi(t)
+  if err != nil && p.err == nil {
+    p.fail("expected a number, got %q", t)
+  }
+  return n
+}
+
+func (p *parser) parseInsert(st *statement) {
+  p.expect("INTO")
+  st.table = strings.ToLower(p.next())
+  if p.accept("JSON") {
+    st.json = true
+    st.values = []int{p.marker()}
+    if p.accept("DEFAULT") {
+      st.defaultNull = p.accept("NULL")
+      p.accept("UNSET")
+    }
+    st.ifNotExists = p.accept("IF", "NOT", "EXISTS")
+    p.using()
+    return
+  }
+  p.expect("(")
+  st.columns = p.identList()
+  p.expect(")")
+  p.expect("VALUES")
+  p.expect("(")
+  for {
+    st.values = append(st.values, p.marker())
+    if !p.accept(",") {
+      break
+    }
+  }
+  p.expect(")")
+  st.ifNotExists = p.accept("IF", "NOT", "EXISTS")
+  p.using()
+}
+
+func (p *parser) parseUpdate(st *statement) {
+  st.table = strings.ToLower(p.next())
+  p.using()
+  p.expect("SET")
+  for {
+    a := assignment{column: strings.ToLower(p.next()), op: "="}
+    p.expect("=")
+    if !p.isMarker() {
+      p.next() // the column itself
+      a.op = p.next()
+      a.arg = p.marker()
+    } else if a.arg = p.marker(); p.accept("+") {
+      p.next() // the column itself
+      a.op = "prepend"
+    }
+    st.sets = append(st.sets, a)
+    if !p.accept(",") {
+      break
+    }
+  }
+  p.expect("WHERE")
+  st.where = p.conds()
+  p.ifClause(st)
+}
+
+func (p *parser) parseDelete(st *statement) {
+  p.expect("FROM")
+  st.table = strings.ToLower(p.next())
+  p.using()
+  p.expect("WHERE")
+  st.where = p.conds()
+  p.ifClause(st)
+}
+
+// using skips a USING clause, the TTL and timestamp of writes are ignored.
+func (p *parser) using() {
+  if !p.accept("USING") {
+    return
+  }
+  for {
+    p.next() // TTL or TIMESTAMP
+    p.marker()
+    if !p.accept("AND") {
+      return
+    }
+  }
+}
+
+func (p *parser) ifClause(st *statement) {
+  if !p.accept("IF") {
+    return
+  }
+  if p.accept("EXISTS") {
+    st.ifExists = true
+    return
+  }
+  st.ifConds = p.conds()
+}
+
+func (p *parser) conds() []cond {
+  var conds []cond
+  for {
+    c := cond{column: strings.ToLower(p.next())}
+    if c.column == "token" && p.accept("(") {
+      c.column, c.token = "", p.identList()
+      p.expect(")")
+    }
+    c.op = operator(strings.ToUpper(p.next()))
+    if c.op == "CONTAINS" && p.accept("KEY") {
+      c.op = "CONTAINS KEY"
+    }
+    switch c.op {
+    case "IN":
+      if p.isMarker() {
+        // a single marker bound to the list of values
+        c.args, c.list = []int{p.marker()}, true
+        break
+      }
+      p.expect("(")
+      for p.err == nil && p.peek() != ")" {
+        c.args = append(c.args, p.marker())
+        p.accept(",")
+      }
+      p.expect(")")
+    case "=", "<", "<=", ">", ">=", "!=", "LIKE", "CONTAINS", "CONTAINS KEY":
+      c.args = []int{p.marker()}
+    default:
+      p.fail("unsupported operator %s", c.op)
+    }
+    conds = append(conds, c)
+    if !p.accept("AND") {
+      return conds
+    }
+  }
+}
@@ -0,0 +1,125 @@
+package memstore
+
+import (
+  "reflect"
+  "testing"
+)
+
+// eq returns the condition column = ? on the arg'th marker.
+func eq(column string, arg int) cond {
+  return cond{column: column, op: "=", args: []int{arg}}
+}
+
+func TestParse(t *testing.T) {
+  tests := []struct {
+    cql  string
+    want statement
+  }{
+    {"SELECT id,seq,name FROM users WHERE id = ? AND seq > ? LIMIT 1",
+      statement{kind: "SELECT", table: "users",
+        columns: []string{"id", "seq", "name"},
+        where: []cond{eq("id", 0),
+          {column: "seq", op: ">", args: []int{1}}},
+        limit: 1, perPartition: -1, nargs: 2}},
+    {"SELECT * FROM analytics.users WHERE id IN (?, ?) ALLOW FILTERING",
+      statement{kind: "SELECT", table: "analytics.users",
+        where: []cond{{column: "id", op: "IN", args: []int{0, 1}}},
+        limit: -1, perPartition: -1, nargs: 2}},
+    {"SELECT id FROM users WHERE id = :id AND seq IN :seqs",
+      statement{kind: "SELECT", table: "users", columns: []string{"id"},
+        where: []cond{eq("id", 0),
+          {column: "seq", op: "IN", args: []int{1}, list: true}},
+        limit: -1, perPartition: -1, nargs: 2}},
+    {"SELECT DISTINCT id FROM users",
+      statement{kind: "SELECT", table: "users", columns: []string{"id"},
+        distinct: true, limit: -1, perPartition: -1}},
+    {"SELECT JSON id,writetime(name),ttl(name) FROM wt WHERE " +
+      "token(id) > ? AND token(id) <= ?",
+      statement{kind: "SELECT", table: "wt", json: true,
+        columns: []string{"id", "writetime(name)", "ttl(name)"},
+        where: []cond{{token: []string{"id"}, op: ">", args: []int{0}},
+          {token: []string{"id"}, op: "<=", args: []int{1}}},
+        limit: -1, perPartition: -1, nargs: 2}},
+    {"SELECT id,max(seq) AS top,count(*) FROM users GROUP BY id",
+      statement{kind: "SELECT", table: "users", columns: []string{"id"},
+        aggregates: []aggregate{{fn: "max", column: "seq", alias: "top"},
+          {fn: "count", column: "*"}},
+        groupBy: []string{"id"}, limit: -1, perPartition: -1}},
+    {"SELECT id FROM users WHERE id = ? ORDER BY seq DESC, name " +
+      "PER PARTITION LIMIT 2 LIMIT 10",
+      statement{kind: "SELECT", table: "users", columns: []string{"id"},
+        where: []cond{eq("id", 0)},
+        order: []orderBy{{column: "seq", desc: true}, {column: "name"}},
+        limit: 10, perPartition: 2, nargs: 1}},
+    {"SELECT id FROM docs WHERE tags CONTAINS ? AND attrs CONTAINS KEY ? " +
+      "AND name LIKE ?",
+      statement{kind: "SELECT", table: "docs", columns: []string{"id"},
+        where: []cond{{column: "tags", op: "CONTAINS", args: []int{0}},
+          {column: "attrs", op: "CONTAINS KEY", args: []int{1}},
+          {column: "name", op: "LIKE", args: []int{2}}},
+        limit: -1, perPartition: -1, nargs: 3}},
+    {"INSERT INTO users (id,name) VALUES (?,?) IF NOT EXISTS " +
+      "USING TTL ? AND TIMESTAMP ?",
+      statement{kind: "INSERT", table: "users",
+        columns: []string{"id", "name"}, values: []int{0, 1},
+        ifNotExists: true, limit: -1, perPartition: -1, nargs: 4}},
+    {"INSERT INTO users JSON ? DEFAULT UNSET USING TTL ?",
+      statement{kind: "INSERT", table: "users", json: true,
+        values: []int{0}, limit: -1, perPartition: -1, nargs: 2}},
+    {"INSERT INTO users JSON ? DEFAULT NULL",
+      statement{kind: "INSERT", table: "users", json: true,
+        defaultNull: true, values: []int{0}, limit: -1, perPartition: -1,
+        nargs: 1}},
+    {"UPDATE colls USING TTL ? SET l = ? + l, s = s + ?, m = m - ?, " +
+      "n = :n WHERE id = ? IF EXISTS",
+      statement{kind: "UPDATE", table: "colls",
+        sets: []assignment{{column: "l", op: "prepend", arg: 1},
+          {column: "s", op: "+", arg: 2}, {column: "m", op: "-", arg: 3},
+          {column: "n", op: "=", arg: 4}},
+        where: []cond{eq("id", 5)}, ifExists: true, limit: -1,
+        perPartition: -1, nargs: 6}},
+    {"UPDATE vers SET version = ? WHERE id = ? IF version = ?",
+      statement{kind: "UPDATE", table: "vers",
+        sets:  []assignment{{column: "version", op: "=", arg: 0}},
+        where: []cond{eq("id", 1)}, ifConds: []cond{eq("version", 2)},
+        limit: -1, perPartition: -1, nargs: 3}},
+    {"DELETE FROM users USING TIMESTAMP ? WHERE id = ? AND seq = ? " +
+      "IF name = ?",
+      statement{kind: "DELETE", table: "users",
+        where:   []cond{eq("id", 1), eq("seq", 2)},
+        ifConds: []cond{eq("name", 3)}, limit: -1, perPartition: -1,
+        nargs: 4}},
+    {"DELETE FROM accts_unique_email WHERE value = ? IF EXISTS",
+      statement{kind: "DELETE", table: "accts_unique_email",
+        where: []cond{eq("value", 0)}, ifExists: true, limit: -1,
+        perPartition: -1, nargs: 1}},
+  }
+  for _, test := range tests {
+    got, err := parse(test.cql)
+    if err != nil {
+      t.Errorf("%s: %v", test.cql, err)
+      continue
+    }
+    if !reflect.DeepEqual(*got, test.want) {
+      t.Errorf("%s:\ngot  %+v\nwant %+v", test.cql, *got, test.want)
+    }
+  }
+}
+
+func TestParseErrors(t *testing.T) {
+  for _, cql := range []string{
+    "",
+    "TRUNCATE users",
+    "SELECT id FROM",
+    "SELECT id FROM users WHERE id",
+    "SELECT id FROM users WHERE id IN (?,",
+    "SELECT id FROM users WHERE id ~ ?",
+    "SELECT id FROM users LIMIT ten",
+    "INSERT INTO users (id) VALUES (?) extra",
+    "UPDATE users SET name ? WHERE id = ?",
+  } {
+    if _, err := parse(cql); err == nil {
+      t.Errorf("%q: got no error", cql)
+    }
+  }
+}
@@ -0,0 +1,420 @@
+package memstore
+
+import (
+  "bytes"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "reflect"
+  "sort"
+  "strings"
+  "time"
+
+  "github.com/gocql/gocql"
+)
+
+// normalize converts a bound value to the representation stored in tables:
+// integers become int64, floats float64 and the other values are deep
+// copied so that later changes by the caller do not leak into the store.
+func normalize(v interface{}) interface{} {
+  if v == nil {
+    return nil
+  }
+  rv := reflect.ValueOf(v)
+  switch rv.Kind() {
+  case reflect.Ptr:
+    if rv.IsNil() {
+      return nil
+    }
+    return normalize(rv.Elem().Interface())
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    return rv.Int()
+  case reflect.Float32, reflect.Float64:
+    return rv.Float()
+  }
+  return copyValue(rv).Interface()
+}
+
+// listValues returns the normalized elements of the slice v.
+func listValues(v interface{}) []interface{} {
+  rv := reflect.ValueOf(v)
+  if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+    return nil
+  }
+  vals := make([]interface{}, rv.Len())
+  for i := range vals {
+    vals[i] = normalize(rv.Index(i).Interface())
+  }
+  return vals
+}
+
+// copyValue deep copies slices and maps.
+func copyValue(v reflect.Value) reflect.Value {
+  switch v.Kind() {
+  case reflect.Slice:
+    if v.IsNil() {
+      return v
+    }
+    c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+    for i := 0; i < v.Len(); i++ {
+      c.Index(i).Set(copyValue(v.Index(i)))
+    }
+    return c
+  case reflect.Map:
+    if v.IsNil() {
+      return v
+    }
+    c := reflect.MakeMapWithSize(v.Type(), v.Len())
+    for _, k := range v.MapKeys() {
+      c.SetMapIndex(k, copyValue(v.MapIndex(k)))
+    }
+    return c
+  }
+  return v
+}
+
+// assign copies the stored value v into the scan destination dest.
+func assign(dest interface{}, v interface{}) error {
+  dv := reflect.ValueOf(dest)
+  if dv.Kind() != reflect.Ptr || dv.IsNil() {
+    return fmt.Errorf("memstore: cannot scan into %T", dest)
+  }
+  return assignValue(dv.Elem(), v)
+}
+
+func assignValue(dv reflect.Value, v interface{}) error {
+  if v == nil {
+    dv.Set(reflect.Zero(dv.Type()))
+    return nil
+  }
+  rv := copyValue(reflect.ValueOf(v))
+  switch {
+  case dv.Kind() == reflect.Interface || rv.Type().AssignableTo(dv.Type()):
+    dv.Set(rv)
+  case dv.Kind() == reflect.Ptr:
+    p := reflect.New(dv.Type().Elem())
+    if err := assignValue(p.Elem(), v); err != nil {
+      return err
+    }
+    dv.Set(p)
+  case rv.Type().ConvertibleTo(dv.Type()) && rv.Kind() != reflect.String:
+    dv.Set(rv.Convert(dv.Type()))
+  case rv.Kind() == reflect.String && dv.Kind() == reflect.Slice &&
+    dv.Type().Elem().Kind() == reflect.Uint8:
+    // text columns scan into byte slices
+    dv.Set(reflect.ValueOf([]byte(rv.String())).Convert(dv.Type()))
+  case dv.Kind() == reflect.Map && rv.Kind() == reflect.Map:
+    m := reflect.MakeMapWithSize(dv.Type(), rv.Len())
+    iter := rv.MapRange()
+    for iter.Next() {
+      k := reflect.New(dv.Type().Key()).Elem()
+      e := reflect.New(dv.Type().Elem()).Elem()
+      if err := assignValue(k, iter.Key().Interface()); err != nil {
+        return err
+      }
+      if err := assignValue(e, iter.Value().Interface()); err != nil {
+        return err
+      }
+      m.SetMapIndex(k, e)
+    }
+    dv.Set(m)
+  case dv.Kind() == reflect.Slice && rv.Kind() == reflect.Slice:
+    s := reflect.MakeSlice(dv.Type(), rv.Len(), rv.Len())
+    for i := 0; i < rv.Len(); i++ {
+      if err := assignValue(s.Index(i), rv.Index(i).Interface()); err != nil {
+        return err
+      }
+    }
+    dv.Set(s)
+  default:
+    return fmt.Errorf("memstore: cannot scan %T into %v", v, dv.Type())
+  }
+  return nil
+}
+
+// compare compares two stored values. ok is false if they are not
+// comparable.
+func compare(a, b interface{}) (c int, ok bool) {
+  switch x := a.(type) {
+  case int64:
+    switch y := b.(type) {
+    case int64:
+      return cmpInt(x, y), true
+    case float64:
+      return cmpFloat(float64(x), y), true
+    }
+  case float64:
+    switch y := b.(type) {
+    case int64:
+      return cmpFloat(x, float64(y)), true
+    case float64:
+      return cmpFloat(x, y), true
+    }
+  case string:
+    if y, ok := b.(string); ok {
+      return strings.Compare(x, y), true
+    }
+  case bool:
+    if y, ok := b.(bool); ok {
+      if x == y {
+        return 0, true
+      }
+      if !x {
+        return -1, true
+      }
+      return 1, true
+    }
+  case time.Time:
+    if y, ok := b.(time.Time); ok {
+      switch {
+      case x.Before(y):
+        return -1, true
+      case x.After(y):
+        return 1, true
+      }
+      return 0, true
+    }
+  case gocql.UUID:
+    if y, ok := b.(gocql.UUID); ok {
+      if x.Version() == 1 && y.Version() == 1 {
+        if c := compare2Times(x.Time(), y.Time()); c != 0 {
+          return c, true
+        }
+      }
+      return bytes.Compare(x[:], y[:]), true
+    }
+  case []byte:
+    if y, ok := b.([]byte); ok {
+      return bytes.Compare(x, y), true
+    }
+  }
+  if reflect.DeepEqual(a, b) {
+    return 0, true
+  }
+  return 0, false
+}
+
+func compare2Times(x, y time.Time) int {
+  c, _ := compare(x, y)
+  return c
+}
+
+func cmpInt(x, y int64) int {
+  switch {
+  case x < y:
+    return -1
+  case x > y:
+    return 1
+  }
+  return 0
+}
+
+func cmpFloat(x, y float64) int {
+  switch {
+  case x < y:
+    return -1
+  case x > y:
+    return 1
+  }
+  return 0
+}
+
+// combine applies a relative assignment, "+", "-" or "prepend", of delta
+// to the stored value v: counters are added to, lists appended, prepended
+// to or removed from, sets and maps merged or removed from.
+func combine(v interface{}, op string, delta interface{}) (interface{}, error) {
+  if v == nil {
+    if op == "+" || op == "prepend" {
+      return delta, nil
+    }
+    v = reflect.Zero(reflect.TypeOf(delta)).Interface()
+  }
+  if x, ok := v.(int64); ok {
+    if y, ok := delta.(int64); ok {
+      if op == "+" {
+        return x + y, nil
+      }
+      return x - y, nil
+    }
+  }
+  if x, ok := v.(float64); ok {
+    if y, ok := delta.(float64); ok {
+      if op == "+" {
+        return x + y, nil
+      }
+      return x - y, nil
+    }
+  }
+  rv, rd := reflect.ValueOf(v), reflect.ValueOf(delta)
+  switch {
+  case rv.Kind() == reflect.Slice && rd.Kind() == reflect.Slice &&
+    op == "prepend":
+    return reflect.AppendSlice(rd.Convert(rv.Type()), rv).Interface(), nil
+  case rv.Kind() == reflect.Map && rd.Kind() == reflect.Slice && op == "-":
+    res := copyValue(rv)
+    for i := 0; i < rd.Len(); i++ {
+      res.SetMapIndex(rd.Index(i).Convert(rv.Type().Key()), reflect.Value{})
+    }
+    return res.Interface(), nil
+  case rv.Kind() == reflect.Slice && rd.Kind() == reflect.Slice:
+    if op == "+" {
+      return reflect.AppendSlice(copyValue(rv), rd.Convert(rv.Type())).Interface(), nil
+    }
+    res := reflect.MakeSlice(rv.Type(), 0, rv.Len())
+    for i := 0; i < rv.Len(); i++ {
+      removed := false
+      for j := 0; j < rd.Len(); j++ {
+        if c, ok := compare(normalize(rv.Index(i).Interface()),
+          normalize(rd.Index(j).Interface())); ok && c == 0 {
+          removed = true
+        }
+      }
+      if !removed {
+        res = reflect.Append(res, rv.Index(i))
+      }
+    }
+    return res.Interface(), nil
+  case rv.Kind() == reflect.Map && rd.Kind() == reflect.Map && op == "+":
+    res := copyValue(rv)
+    for _, k := range rd.MapKeys() {
+      res.SetMapIndex(k, rd.MapIndex(k))
+    }
+    return res.Interface(), nil
+  }
+  return nil, fmt.Errorf("memstore: cannot apply %s to %T", op, v)
+}
+
+// setOf returns the elements of the slice v sorted, without duplicates, as
+// Cassandra stores sets.
+func setOf(v interface{}) interface{} {
+  rv := reflect.ValueOf(v)
+  if rv.Kind() != reflect.Slice {
+    return v
+  }
+  res := copyValue(rv)
+  sort.SliceStable(res.Interface(), func(i, j int) bool {
+    c, _ := compare(normalize(res.Index(i).Interface()),
+      normalize(res.Index(j).Interface()))
+    return c < 0
+  })
+  n := 0
+  for i := 0; i < res.Len(); i++ {
+    if n > 0 {
+      c, ok := compare(normalize(res.Index(n-1).Interface()),
+        normalize(res.Index(i).Interface()))
+      if ok && c == 0 {
+        continue
+      }
+    }
+    res.Index(n).Set(res.Index(i))
+    n++
+  }
+  return res.Slice(0, n).Interface()
+}
+
+// fromJSON converts the value v decoded from JSON to the type of the CQL
+// type cqlType it stands for.
+func fromJSON(cqlType string, v interface{}) (interface{}, error) {
+  if v == nil {
+    return nil, nil
+  }
+  cqlType = strings.TrimSpace(cqlType)
+  if strings.HasPrefix(cqlType, "frozen<") {
+    cqlType = cqlType[len("frozen<") : len(cqlType)-1]
+  }
+  base, params := cqlType, ""
+  if i := strings.IndexByte(cqlType, '<'); i >= 0 {
+    base, params = cqlType[:i], cqlType[i+1:len(cqlType)-1]
+  }
+  switch base {
+  case "bigint", "int", "smallint", "tinyint", "counter", "varint":
+    if n, ok := v.(json.Number); ok {
+      return n.Int64()
+    }
+  case "float", "double", "decimal":
+    if n, ok := v.(json.Number); ok {
+      return n.Float64()
+    }
+  case "uuid", "timeuuid":
+    if s, ok := v.(string); ok {
+      return gocql.ParseUUID(s)
+    }
+  case "timestamp":
+    if s, ok := v.(string); ok {
+      for _, layout := range []string{"2006-01-02 15:04:05.000-0700",
+        time.RFC3339Nano} {
+        if t, err := time.Parse(layout, s); err == nil {
+          return t, nil
+        }
+      }
+      return nil, fmt.Errorf("invalid timestamp %q", s)
+    }
+  case "blob":
+    if s, ok := v.(string); ok && strings.HasPrefix(s, "0x") {
+      return hex.DecodeString(s[2:])
+    }
+  case "list", "set":
+    if elems, ok := v.([]interface{}); ok {
+      res := make([]interface{}, len(elems))
+      for i, e := range elems {
+        x, err := fromJSON(params, e)
+        if err != nil {
+          return nil, err
+        }
+        res[i] = x
+      }
+      return res, nil
+    }
+  case "map":
+    kt, et := splitMapType(params)
+    if m, ok := v.(map[string]interface{}); ok {
+      res := make(map[interface{}]interface{}, len(m))
+      for k, e := range m {
+        var key interface{} = k
+        if kt != "text" && kt != "varchar" && kt != "ascii" {
+          // non text keys are quoted JSON values
+          dec := json.NewDecoder(strings.NewReader(k))
+          dec.UseNumber()
+          if err := dec.Decode(&key); err != nil {
+            key = k
+          }
+        }
+        kx, err := fromJSON(kt, key)
+        if err != nil {
+          return nil, err
+        }
+        ex, err := fromJSON(et, e)
+        if err != nil {
+          return nil, err
+        }
+        res[kx] = ex
+      }
+      return res, nil
+    }
+  default:
+    return v, nil
+  }
+  if n, ok := v.(json.Number); ok {
+    return n.String(), nil
+  }
+  return v, nil
+}
+
+// splitMapType splits the parameters of a map type into the key and value
+// types.
+func splitMapType(params string) (string, string) {
+  depth := 0
+  for i, r := range params {
+    switch r {
+    case '<':
+      depth++
+    case '>':
+      depth--
+    case ',':
+      if depth == 0 {
+        return strings.TrimSpace(params[:i]), strings.TrimSpace(params[i+1:])
+      }
+    }
+  }
+  return params, ""
+}
@@ -0,0 +1,183 @@
+// Package migrations evolves the schema of the tables backing datastore
+// entities.
+//
+// A Migrator compares the tables derived from the registered entity types
+// with the live table metadata found in system_schema, and emits the
+// statements creating missing user-defined types and tables and adding
+// missing columns. Applied statements are recorded in a version-tracking
+// table so that every deployment knows which migrations already ran.
+// Columns are never dropped or altered, nor are the fields of existing
+// types, those changes must be done by hand.
+package migrations
+
+import (
+  "context"
+  "fmt"
+  "reflect"
+  "strings"
+  "time"
+
+  "github.com/droot/datastore"
+)
+
+// VersionTable is the name of the table recording the applied migrations.
+const VersionTable = "datastore_migrations"
+
+// Migrator migrates the tables of a set of entity types in the keyspace of a
+// session.
+type Migrator struct {
+  session  datastore.Session
+  keyspace string
+  types    []reflect.Type
+}
+
+// New returns a Migrator working on the keyspace session is connected to.
+func New(session datastore.Session) *Migrator {
+  return &Migrator{
+    session:  session,
+    keyspace: session.Keyspace(),
+  }
+}
+
+// Register adds entity types whose tables are managed by m.
+func (m *Migrator) Register(types ...reflect.Type) {
+  m.types = append(m.types, types...)
+}
+
+// Plan returns the statements bringing the keyspace in line with the
+// registered entity types, without applying them. The user-defined types
+// of the columns are created before the tables and columns using them.
+func (m *Migrator) Plan() ([]string, error) {
+  var stmts []string
+  planned := make(map[string]bool)
+  for _, typ := range m.types {
+    def, err := datastore.GetTableDef(typ)
+    if err != nil {
+      return nil, err
+    }
+    types, err := datastore.GetTypeDefs(typ)
+    if err != nil {
+      return nil, err
+    }
+    for _, t := range types {
+      if planned[t.Name] {
+        continue
+      }
+      planned[t.Name] = true
+      live, err := m.liveType(t.Name)
+      if err != nil {
+        return nil, err
+      }
+      if !live {
+        stmts = append(stmts, datastore.TypeCQL(t))
+      }
+    }
+    live, err := m.liveColumns(def.Name)
+    if err != nil {
+      return nil, err
+    }
+    if len(live) == 0 {
+      stmt, err := datastore.CreateTableCQL(typ)
+      if err != nil {
+        return nil, err
+      }
+      stmts = append(stmts, stmt)
+      continue
+    }
+    for _, col := range def.Columns {
+      if live[strings.ToLower(col.Name)] {
+        continue
+      }
+      if col.PartitionKey || col.ClusteringKey {
+        return nil, fmt.Errorf("migrations: cannot add key column %s to %s",
+          col.Name, def.Name)
+      }
+      stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s ADD %s %s",
+        def.Name, col.Name, col.Type))
+    }
+  }
+  return stmts, nil
+}
+
+// Apply applies the statements returned by Plan, recording each of them in
+// the version table. It returns the statements applied.
+func (m *Migrator) Apply() ([]string, error) {
+  if err := m.exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s "+
+    "(version int PRIMARY KEY, statement text, applied_at timestamp)",
+    VersionTable)); err != nil {
+    return nil, err
+  }
+  version, err := m.Version()
+  if err != nil {
+    return nil, err
+  }
+  stmts, err := m.Plan()
+  if err != nil {
+    return nil, err
+  }
+  for i, stmt := range stmts {
+    if err := m.exec(stmt); err != nil {
+      return stmts[:i], fmt.Errorf("migrations: %q: %v", stmt, err)
+    }
+    version++
+    if err := m.exec(fmt.Sprintf("INSERT INTO %s "+
+      "(version, statement, applied_at) VALUES (?, ?, ?)", VersionTable),
+      version, stmt, time.Now()); err != nil {
+      return stmts[:i+1], err
+    }
+  }
+  return stmts, nil
+}
+
+// Version returns the number of migrations applied so far, 0 if none or if
+// the version table does not exist yet.
+func (m *Migrator) Version() (int, error) {
+  live, err := m.liveColumns(VersionTable)
+  if err != nil || len(live) == 0 {
+    return 0, err
+  }
+  var version int
+  iter := m.iter(fmt.Sprintf("SELECT version FROM %s", VersionTable))
+  for v := 0; iter.Scan(&v); {
+    if v > version {
+      version = v
+    }
+  }
+  return version, iter.Close()
+}
+
+// liveColumns returns the set of columns of table as found in system_schema.
+// The set is empty if the table does not exist. Unquoted identifiers are case
+// insensitive, so names are lowercased the way Cassandra stores them.
+func (m *Migrator) liveColumns(table string) (map[string]bool, error) {
+  cols := make(map[string]bool)
+  iter := m.iter("SELECT column_name FROM system_schema.columns "+
+    "WHERE keyspace_name = ? AND table_name = ?", m.keyspace,
+    strings.ToLower(table))
+  for name := ""; iter.Scan(&name); {
+    cols[name] = true
+  }
+  return cols, iter.Close()
+}
+
+// liveType reports whether the user-defined type name exists, as found in
+// system_schema.
+func (m *Migrator) liveType(name string) (bool, error) {
+  iter := m.iter("SELECT type_name FROM system_schema.types "+
+    "WHERE keyspace_name = ? AND type_name = ?", m.keyspace,
+    strings.ToLower(name))
+  var found string
+  ok := iter.Scan(&found)
+  return ok, iter.Close()
+}
+
+// iter executes the statement cql with args.
+func (m *Migrator) iter(cql string, args ...interface{}) datastore.Iter {
+  return m.session.Iter(context.Background(),
+    &datastore.Statement{CQL: cql, Args: args})
+}
+
+// exec executes the statement cql with args, which yields no rows.
+func (m *Migrator) exec(cql string, args ...interface{}) error {
+  return m.iter(cql, args...).Close()
+}
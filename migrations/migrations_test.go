@@ -0,0 +1,139 @@
+package migrations
+
+import (
+  "context"
+  "reflect"
+  "strings"
+  "testing"
+
+  "github.com/droot/datastore"
+  "github.com/gocql/gocql"
+)
+
+// schemaSession serves the system_schema queries from its live tables and
+// types, recording the other statements.
+type schemaSession struct {
+  // columns maps the live tables to their columns.
+  columns map[string][]string
+  types   map[string]bool
+  stmts   []string
+}
+
+// rowsIter iterates over rows of a single column.
+type rowsIter struct {
+  rows []string
+}
+
+func (it *rowsIter) RowData() (gocql.RowData, error) {
+  return gocql.RowData{}, nil
+}
+
+func (it *rowsIter) Scan(dest ...interface{}) bool {
+  if len(it.rows) == 0 {
+    return false
+  }
+  *dest[0].(*string), it.rows = it.rows[0], it.rows[1:]
+  return true
+}
+
+func (it *rowsIter) PageState() []byte {
+  return nil
+}
+
+func (it *rowsIter) Close() error {
+  return nil
+}
+
+func (s *schemaSession) Keyspace() string {
+  return "app"
+}
+
+func (s *schemaSession) Iter(ctx context.Context,
+  stmt *datastore.Statement) datastore.Iter {
+
+  switch {
+  case strings.Contains(stmt.CQL, "system_schema.columns"):
+    return &rowsIter{rows: s.columns[stmt.Args[1].(string)]}
+  case strings.Contains(stmt.CQL, "system_schema.types"):
+    if name := stmt.Args[1].(string); s.types[name] {
+      return &rowsIter{rows: []string{name}}
+    }
+    return &rowsIter{}
+  }
+  s.stmts = append(s.stmts, stmt.CQL)
+  return &rowsIter{}
+}
+
+func (s *schemaSession) ExecBatch(ctx context.Context,
+  batch *datastore.BatchStatement) error {
+  return nil
+}
+
+type geo struct {
+  Lat float64 `cql:"lat"`
+  Lon float64 `cql:"lon"`
+}
+
+type place struct {
+  Name string `cql:"name"`
+  At   geo    `cql:"at"`
+}
+
+type venue struct {
+  ColumnFamily string `cql:"venues"`
+  ID           string `cql:"id,pk"`
+  Main         place  `cql:"main"`
+}
+
+type store struct {
+  ColumnFamily string `cql:"stores"`
+  ID           string `cql:"id,pk"`
+  Location     geo    `cql:"location"`
+  Rating       int    `cql:"rating"`
+}
+
+func TestPlan(t *testing.T) {
+  s := &schemaSession{
+    columns: map[string][]string{"stores": {"id", "location"}},
+    types:   map[string]bool{"geo": true},
+  }
+  m := New(s)
+  m.Register(reflect.TypeOf(venue{}), reflect.TypeOf(store{}))
+  got, err := m.Plan()
+  if err != nil {
+    t.Fatal(err)
+  }
+  want := []string{
+    "CREATE TYPE IF NOT EXISTS place (name text, at frozen<geo>)",
+    "CREATE TABLE IF NOT EXISTS venues (id text, main place, " +
+      "PRIMARY KEY ((id)))",
+    "ALTER TABLE stores ADD rating bigint",
+  }
+  if !reflect.DeepEqual(got, want) {
+    t.Errorf("got %q, want %q", got, want)
+  }
+}
+
+func TestApply(t *testing.T) {
+  s := &schemaSession{}
+  m := New(s)
+  m.Register(reflect.TypeOf(venue{}))
+  applied, err := m.Apply()
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(applied) != 3 || !strings.HasPrefix(applied[0], "CREATE TYPE") ||
+    !strings.HasPrefix(applied[2], "CREATE TABLE") {
+    t.Fatalf("got %q, want the types before the table", applied)
+  }
+  // the version table, then each statement followed by its record
+  if len(s.stmts) != 1+2*len(applied) ||
+    !strings.Contains(s.stmts[0], VersionTable) {
+    t.Fatalf("got %q", s.stmts)
+  }
+  for i, stmt := range applied {
+    if s.stmts[1+2*i] != stmt {
+      t.Errorf("got %q, want %q", s.stmts[1+2*i], stmt)
+    }
+  }
+}
@@ -0,0 +1,373 @@
+package datastore
+
+import (
+  "context"
+  "fmt"
+  "reflect"
+  "sync"
+
+  "github.com/gocql/gocql"
+)
+
+// KeyStatus is the outcome of reading a single key in a multi-key read.
+type KeyStatus int
+
+const (
+  // KeyFound means a row was found and loaded for the key.
+  KeyFound KeyStatus = iota
+  // KeyMissing means no row matched the key.
+  KeyMissing
+  // KeyErrored means reading the key failed, see KeyResult.Err.
+  KeyErrored
+)
+
+func (s KeyStatus) String() string {
+  switch s {
+  case KeyFound:
+    return "found"
+  case KeyMissing:
+    return "missing"
+  case KeyErrored:
+    return "errored"
+  }
+  return fmt.Sprintf("KeyStatus(%d)", int(s))
+}
+
+// KeyResult reports what happened to one of the keys requested from GetMulti.
+type KeyResult struct {
+  Key    interface{}
+  Status KeyStatus
+  // Err is the error encountered while reading the key, if Status is
+  // KeyErrored.
+  Err error
+}
+
+// KeyResults are the outcomes of the keys requested from GetMulti, aligned
+// with them.
+type KeyResults []KeyResult
+
+// Err returns nil if every key was found, or else a MultiError aligned with
+// the keys, holding ErrNoSuchEntity for the missing ones.
+func (rs KeyResults) Err() error {
+  errs := make(MultiError, len(rs))
+  failed := false
+  for i, r := range rs {
+    switch r.Status {
+    case KeyMissing:
+      errs[i] = ErrNoSuchEntity
+    case KeyErrored:
+      errs[i] = r.Err
+    default:
+      continue
+    }
+    failed = true
+  }
+  if failed {
+    return errs
+  }
+  return nil
+}
+
+// GetMulti loads, for every key in keys, the row whose keyField column equals
+// that key into the corresponding element of dst. dst must be a []S or []*S
+// where S is an entity struct type, and must have the same length as keys.
+// keyField must be the primary key of S, a single column: the rows are
+// looked up in batches of 100 keys, with an IN query per batch.
+//
+// The returned slice is aligned with keys and tells for each key whether it
+// was found, missing or errored; its Err method returns them as a
+// MultiError. The error is only non-nil when the arguments themselves are
+// invalid.
+func GetMulti(session Session, dst interface{}, keyField string,
+  keys []interface{}, opts ...Option) (KeyResults, error) {
+  return GetMultiContext(context.Background(), session, dst, keyField, keys,
+    opts...)
+}
+
+// GetMultiContext is like GetMulti but executes the reads with ctx.
+func GetMultiContext(ctx context.Context, session Session,
+  dst interface{}, keyField string, keys []interface{},
+  opts ...Option) (KeyResults, error) {
+
+  v := reflect.ValueOf(dst)
+  if v.Kind() != reflect.Slice {
+    return nil, fmt.Errorf("datastore: dst must be a slice, got %T", dst)
+  }
+  if v.Len() != len(keys) {
+    return nil, fmt.Errorf("datastore: dst has length %d, want %d",
+      v.Len(), len(keys))
+  }
+  elemType, isPtr := v.Type().Elem(), false
+  if elemType.Kind() == reflect.Ptr {
+    elemType, isPtr = elemType.Elem(), true
+  }
+  if elemType.Kind() != reflect.Struct {
+    return nil, fmt.Errorf("%w type %v", ErrInvalidEntity, elemType)
+  }
+  q, err := NewQuery(elemType, opts...)
+  if err != nil {
+    return nil, err
+  }
+  codec := q.codec
+  if pk := codec.keyColumns(); len(pk) != 1 || pk[0] != keyField {
+    return nil, fmt.Errorf("datastore: GetMulti by %s, which is not the "+
+      "primary key of %v", keyField, elemType)
+  }
+  keyTypes := []reflect.Type{elemType.FieldByIndex(
+    codec.byIndex[codec.byName[keyField].index].index).Type}
+
+  // the keys, by index and distinct
+  results := make(KeyResults, len(keys))
+  refs := make([]string, len(keys))
+  var distinct [][]reflect.Value
+  seen := make(map[string]bool)
+  for i, key := range keys {
+    results[i].Key = key
+    // Go converts integers to strings, but they are no string keys
+    kv := reflect.ValueOf(key)
+    if !kv.IsValid() || !kv.Type().ConvertibleTo(keyTypes[0]) ||
+      (kv.Kind() == reflect.String) != (keyTypes[0].Kind() == reflect.String) {
+      results[i].Status = KeyErrored
+      results[i].Err = fmt.Errorf("datastore: key %v of type %T is not a "+
+        "%v", key, key, keyTypes[0])
+      continue
+    }
+    vals := []reflect.Value{kv.Convert(keyTypes[0])}
+    refs[i] = keyString(vals)
+    if !seen[refs[i]] {
+      seen[refs[i]] = true
+      distinct = append(distinct, vals)
+    }
+  }
+
+  found := make(map[string]reflect.Value, len(distinct))
+  failed := make(map[string]error)
+  for start := 0; start < len(distinct); start += relatedBatch {
+    batch := distinct[start:]
+    if len(batch) > relatedBatch {
+      batch = batch[:relatedBatch]
+    }
+    rows := reflect.New(reflect.SliceOf(elemType))
+    _, err := q.Filter(keyField+" in", inValues(batch, 0, keyTypes[0])).
+      GetAllContext(ctx, session, rows.Interface())
+    for _, vals := range batch {
+      if err != nil {
+        failed[keyString(vals)] = err
+      }
+    }
+    for i := 0; err == nil && i < rows.Elem().Len(); i++ {
+      row := rows.Elem().Index(i)
+      vals, err := relatedKey(codec, row, []string{keyField}, keyTypes)
+      if err == nil && vals != nil {
+        found[keyString(vals)] = row
+      }
+    }
+  }
+
+  for i := range keys {
+    if results[i].Status == KeyErrored {
+      continue
+    }
+    row, ok := found[refs[i]]
+    switch {
+    case failed[refs[i]] != nil:
+      results[i].Status, results[i].Err = KeyErrored, failed[refs[i]]
+    case !ok:
+      results[i].Status = KeyMissing
+    case isPtr:
+      elem := reflect.New(elemType)
+      elem.Elem().Set(row)
+      v.Index(i).Set(elem)
+    default:
+      v.Index(i).Set(row)
+    }
+  }
+  return results, nil
+}
+
+// getOne loads the first result of q into dst. It returns Done if the query
+// yields no results.
+func getOne(ctx context.Context, session Session, q *Query,
+  dst interface{}) error {
+
+  q = q.Limit(1)
+  o := newQueryOptions(ctx, q.opts)
+  if key, ok := q.cacheKey(o, dst); ok {
+    return q.firstCached(ctx, session, o, key, dst, nil)
+  }
+  iter := q.RunContext(ctx, session)
+  if err := iter.Next(dst); err != nil {
+    iter.Close()
+    return err
+  }
+  return iter.Close()
+}
+
+// MultiError is returned by operations on multiple entities. It is aligned
+// with the entities passed to the operation: the i'th error is the error for
+// the i'th entity, nil if it succeeded.
+type MultiError []error
+
+func (m MultiError) Error() string {
+  s, n := "", 0
+  for _, e := range m {
+    if e != nil {
+      if n == 0 {
+        s = e.Error()
+      }
+      n++
+    }
+  }
+  switch n {
+  case 0:
+    return "(0 errors)"
+  case 1:
+    return s
+  case 2:
+    return s + " (and 1 other error)"
+  }
+  return fmt.Sprintf("%s (and %d other errors)", s, n-1)
+}
+
+// multiConcurrency is the maximum number of statements SaveMulti and
+// DeleteMulti execute concurrently.
+const multiConcurrency = 16
+
+// run calls f for every index of m concurrently, recording its errors in m,
+// and reports whether any failed.
+func (m MultiError) run(f func(i int) error) bool {
+  var wg sync.WaitGroup
+  sem := make(chan struct{}, multiConcurrency)
+  for i := range m {
+    wg.Add(1)
+    sem <- struct{}{}
+    go func(i int) {
+      defer func() { <-sem; wg.Done() }()
+      m[i] = f(i)
+    }(i)
+  }
+  wg.Wait()
+  for _, err := range m {
+    if err != nil {
+      return true
+    }
+  }
+  return false
+}
+
+// SaveMulti saves the entities srcs, each being a struct pointer of column
+// family kind or a ColumnLoadSaver. The inserts of entities of the same type
+// share a prepared statement and run concurrently, or are sent in a single
+// unlogged batch with the UnloggedBatch option, but for the entities a
+// batch cannot save, see Batch.Save, which are saved like SaveEntity. If
+// any insert fails, a MultiError aligned with srcs is returned.
+func SaveMulti(session Session, srcs []interface{},
+  opts ...Option) error {
+  return SaveMultiContext(context.Background(), session, srcs, opts...)
+}
+
+// SaveMultiContext is like SaveMulti but executes the inserts with ctx.
+func SaveMultiContext(ctx context.Context, session Session,
+  srcs []interface{}, opts ...Option) error {
+
+  o := newOptions(ctx, opts)
+  errs := make(MultiError, len(srcs))
+  failed := false
+  if o.unloggedBatch {
+    b := NewBatch(gocql.UnloggedBatch, opts...)
+    var added, alone []int
+    for i, src := range srcs {
+      if !batchable(src) {
+        alone = append(alone, i)
+      } else if errs[i] = b.Save(src); errs[i] != nil {
+        failed = true
+      } else {
+        added = append(added, i)
+      }
+    }
+    if len(added) > 0 {
+      if err := b.RunContext(ctx, session); err != nil {
+        for _, i := range added {
+          errs[i], failed = err, true
+        }
+      }
+    }
+    aloneErrs := make(MultiError, len(alone))
+    if aloneErrs.run(func(j int) error {
+      return SaveEntityContext(ctx, session, srcs[alone[j]], opts...)
+    }) {
+      failed = true
+      for j, i := range alone {
+        errs[i] = aloneErrs[j]
+      }
+    }
+  } else {
+    failed = errs.run(func(i int) error {
+      return SaveEntityContext(ctx, session, srcs[i], opts...)
+    })
+  }
+  if failed {
+    return errs
+  }
+  return nil
+}
+
+// batchable reports whether the entity src can be saved in a batch.
+func batchable(src interface{}) bool {
+  x, err := newLoadSaver(src)
+  if err != nil {
+    // the batch reports the error
+    return true
+  }
+  defer releaseLoadSaver(x)
+  return checkBatchSave(x) == nil
+}
+
+// DeleteMulti deletes the rows of the entities srcs, each being a struct
+// pointer of column family kind, like DeleteEntity. The deletes run
+// concurrently, or are sent in a single unlogged batch with the
+// UnloggedBatch option, which does not release the values of unique
+// columns. If any delete fails, a MultiError aligned with srcs is returned.
+func DeleteMulti(session Session, srcs []interface{},
+  opts ...Option) error {
+  return DeleteMultiContext(context.Background(), session, srcs, opts...)
+}
+
+// DeleteMultiContext is like DeleteMulti but executes the deletes with ctx.
+func DeleteMultiContext(ctx context.Context, session Session,
+  srcs []interface{}, opts ...Option) error {
+
+  o := newOptions(ctx, opts)
+  errs := make(MultiError, len(srcs))
+  failed := false
+  if o.unloggedBatch {
+    b := NewBatch(gocql.UnloggedBatch, opts...)
+    var added []int
+    for i, src := range srcs {
+      q, err := entityDeleteQuery(src, nil)
+      if err == nil {
+        err = b.Delete(q)
+      }
+      if errs[i] = err; err != nil {
+        failed = true
+      } else {
+        added = append(added, i)
+      }
+    }
+    if len(added) > 0 {
+      if err := b.RunContext(ctx, session); err != nil {
+        for _, i := range added {
+          errs[i], failed = err, true
+        }
+      }
+    }
+  } else {
+    failed = errs.run(func(i int) error {
+      return DeleteEntityContext(ctx, session, srcs[i], opts...)
+    })
+  }
+  if failed {
+    return errs
+  }
+  return nil
+}
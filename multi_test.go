@@ -0,0 +1,55 @@
+package datastore_test
+
+import (
+  "errors"
+  "reflect"
+  "strings"
+  "testing"
+
+  "github.com/droot/datastore"
+)
+
+func TestGetMulti(t *testing.T) {
+  s := newRecordingSession(t, reflect.TypeOf(tweet{}))
+  for _, id := range []string{"a", "b"} {
+    if err := datastore.SaveEntity(s, &tweet{ID: id, Text: id}); err != nil {
+      t.Fatal(err)
+    }
+  }
+  s.stmts = nil
+  keys := []interface{}{"b", "missing", "a", 1}
+  dst := make([]*tweet, len(keys))
+  results, err := datastore.GetMulti(s, dst, "id", keys)
+  if err != nil {
+    t.Fatal(err)
+  }
+  want := []datastore.KeyStatus{datastore.KeyFound, datastore.KeyMissing,
+    datastore.KeyFound, datastore.KeyErrored}
+  for i, r := range results {
+    if r.Status != want[i] || r.Key != keys[i] {
+      t.Errorf("key %v: got %v, want %v", keys[i], r.Status, want[i])
+    }
+  }
+  if dst[0] == nil || dst[0].Text != "b" || dst[1] != nil ||
+    dst[2] == nil || dst[2].Text != "a" {
+    t.Errorf("got %+v", dst)
+  }
+  if len(s.stmts) != 1 || !strings.Contains(s.stmts[0].CQL, " IN ") {
+    t.Errorf("got %d statements, want a single IN query", len(s.stmts))
+  }
+  var errs datastore.MultiError
+  if !errors.As(results.Err(), &errs) ||
+    errs[1] != datastore.ErrNoSuchEntity {
+    t.Errorf("got %v, want ErrNoSuchEntity for the missing key",
+      results.Err())
+  }
+}
+
+func TestGetMultiPartialKey(t *testing.T) {
+  s := newRecordingSession(t, reflect.TypeOf(timelinePost{}))
+  dst := make([]timelinePost, 1)
+  _, err := datastore.GetMulti(s, dst, "timeline", []interface{}{"me"})
+  if err == nil || !strings.Contains(err.Error(), "primary key") {
+    t.Errorf("got %v, want a primary key error", err)
+  }
+}
@@ -0,0 +1,170 @@
+package datastore
+
+import (
+  "context"
+  "strings"
+  "time"
+)
+
+// Execution describes the execution of a statement, or of a batch, by a
+// session returned by ObserveSession.
+type Execution struct {
+  // Op is the kind of statement in lower case: select, insert, update,
+  // delete, batch, or the first keyword of other statements.
+  Op string
+  // Table is the column family the statement reads or writes, empty for
+  // batches and statements the package did not generate.
+  Table string
+  // CQL is the statement, without its values. The statements of a batch
+  // are joined by semicolons.
+  CQL string
+  // Args is the number of values bound to the statement.
+  Args  int
+  Start time.Time
+
+  // Duration is the time taken to execute the statement, until its
+  // iterator was closed. It is set once the execution is finished, like
+  // Rows and Err.
+  Duration time.Duration
+  // Rows is the number of rows read.
+  Rows int
+  Err  error
+}
+
+// Observer is notified of the executions of a session returned by
+// ObserveSession, e.g. to trace or measure them.
+type Observer interface {
+  // Start is called before e is executed. The context it returns is the one
+  // the statement is executed and Finish called with.
+  Start(ctx context.Context, e *Execution) context.Context
+  // Finish is called once e is finished.
+  Finish(ctx context.Context, e *Execution)
+}
+
+// ObserveSession returns a Session executing statements with s and
+// notifying the observers of each execution. Observers are called in order
+// on start and in reverse order on finish.
+func ObserveSession(s Session, observers ...Observer) Session {
+  return observedSession{s: s, observers: observers}
+}
+
+type observedSession struct {
+  s         Session
+  observers []Observer
+}
+
+func (o observedSession) Keyspace() string {
+  return o.s.Keyspace()
+}
+
+// start notifies the observers of e and returns the contexts they returned,
+// the last one being the one to execute e with.
+func (o observedSession) start(ctx context.Context,
+  e *Execution) []context.Context {
+
+  ctxs := make([]context.Context, len(o.observers)+1)
+  ctxs[0] = ctx
+  for i, obs := range o.observers {
+    ctxs[i+1] = obs.Start(ctxs[i], e)
+  }
+  return ctxs
+}
+
+func (o observedSession) finish(ctxs []context.Context, e *Execution) {
+  e.Duration = time.Since(e.Start)
+  for i := len(o.observers) - 1; i >= 0; i-- {
+    o.observers[i].Finish(ctxs[i+1], e)
+  }
+}
+
+func (o observedSession) Iter(ctx context.Context, stmt *Statement) Iter {
+  op, table := stmt.describe()
+  e := &Execution{Op: op, Table: table, CQL: stmt.CQL, Args: len(stmt.Args),
+    Start: time.Now()}
+  ctxs := o.start(ctx, e)
+  return &observedIter{Iter: o.s.Iter(ctxs[len(ctxs)-1], stmt), o: o,
+    ctxs: ctxs, e: e}
+}
+
+func (o observedSession) ExecBatch(ctx context.Context,
+  batch *BatchStatement) error {
+
+  cqls := make([]string, len(batch.Statements))
+  args := 0
+  for i, stmt := range batch.Statements {
+    cqls[i] = stmt.CQL
+    args += len(stmt.Args)
+  }
+  e := &Execution{Op: "batch", CQL: strings.Join(cqls, "; "), Args: args,
+    Start: time.Now()}
+  ctxs := o.start(ctx, e)
+  e.Err = o.s.ExecBatch(ctxs[len(ctxs)-1], batch)
+  o.finish(ctxs, e)
+  return e.Err
+}
+
+// observedIter counts the rows read and finishes the execution on Close.
+type observedIter struct {
+  Iter
+  o      observedSession
+  ctxs   []context.Context
+  e      *Execution
+  closed bool
+}
+
+func (it *observedIter) Scan(dest ...interface{}) bool {
+  if !it.Iter.Scan(dest...) {
+    return false
+  }
+  it.e.Rows++
+  return true
+}
+
+func (it *observedIter) Warnings() []string {
+  return iterWarnings(it.Iter)
+}
+
+func (it *observedIter) Close() error {
+  err := it.Iter.Close()
+  if !it.closed {
+    it.closed = true
+    it.e.Err = err
+    it.o.finish(it.ctxs, it.e)
+  }
+  return err
+}
+
+// describeCQL returns the kind of statement cql is and the table it
+// applies to, as far as the statements generated by the package go.
+func describeCQL(cql string) (op, table string) {
+  words := strings.Fields(cql)
+  if len(words) == 0 {
+    return "", ""
+  }
+  op = strings.ToLower(words[0])
+  var after string
+  switch op {
+  case "select", "delete":
+    after = "from"
+  case "insert":
+    after = "into"
+  case "update":
+    return op, tableName(words, 1)
+  default:
+    return op, ""
+  }
+  for i, w := range words {
+    if strings.EqualFold(w, after) {
+      return op, tableName(words, i+1)
+    }
+  }
+  return op, ""
+}
+
+// tableName returns words[i] if it names a table.
+func tableName(words []string, i int) string {
+  if i >= len(words) {
+    return ""
+  }
+  return strings.TrimSuffix(strings.SplitN(words[i], "(", 2)[0], ";")
+}
@@ -0,0 +1,270 @@
+package datastore
+
+import (
+  "context"
+  "fmt"
+  "reflect"
+  "strings"
+  "time"
+
+  "github.com/gocql/gocql"
+)
+
+// Option configures how an operation is executed. Options can be passed to
+// the query constructors, where they become the query's defaults, and to the
+// functions executing statements, where they override those defaults.
+// Options given explicitly take precedence over the defaults carried by the
+// context.
+type Option func(*options)
+
+// options is the resolved set of Options for a single operation.
+type options struct {
+  consistency    gocql.Consistency
+  hasConsistency bool
+  // defaultConsistency is set if the consistency is a default of the
+  // Client, which the one of the table overrides, see RegisterConsistency.
+  defaultConsistency bool
+  timeout        time.Duration
+  // ttl is only honored by writes, which fail with ttlErr if it is
+  // invalid.
+  ttl    time.Duration
+  ttlErr error
+  // timestamp is the write timestamp in microseconds, only honored by
+  // writes.
+  timestamp    int64
+  hasTimestamp bool
+  tracer       gocql.Tracer
+  retryPolicy  gocql.RetryPolicy
+  speculative  gocql.SpeculativeExecutionPolicy
+  // table overrides the column family of the entity type, keyspace the
+  // keyspace of the session.
+  table    string
+  keyspace string
+  // ifNotExists and insertJSON are only honored by inserts.
+  ifNotExists bool
+  insertJSON  bool
+  // unloggedBatch is only honored by SaveMulti.
+  unloggedBatch bool
+  logger        Logger
+  // idempotent is only meaningful if hasIdempotent is set, selects being
+  // idempotent by default.
+  idempotent    bool
+  hasIdempotent bool
+  // cache is the store of the entity cache, see EntityCache.
+  cache Cache
+  // changes is only honored by SaveEntity, see OnlyChanged.
+  changes *Snapshot
+  // cas records the outcome of conditional statements, whose existing row
+  // is decoded into a new value of casType, see CASResult.
+  cas     *CASResult
+  casType reflect.Type
+}
+
+// Consistency sets the consistency level of the operation.
+func Consistency(c gocql.Consistency) Option {
+  return func(o *options) {
+    o.consistency, o.hasConsistency = c, true
+    o.defaultConsistency = false
+  }
+}
+
+// maxTTL is the largest TTL Cassandra accepts, 20 years.
+const maxTTL = 630720000 * time.Second
+
+// TTL makes a write expire after d, which must be a positive whole number
+// of seconds up to 20 years: writes fail otherwise. It is ignored by reads.
+func TTL(d time.Duration) Option {
+  return func(o *options) {
+    o.ttl, o.ttlErr = d, nil
+    if d <= 0 || d%time.Second != 0 || d > maxTTL {
+      o.ttlErr = fmt.Errorf("datastore: invalid TTL %v, not a positive "+
+        "whole number of seconds up to %v", d, maxTTL)
+    }
+  }
+}
+
+// Timestamp sets the write timestamp, in microseconds since the epoch, with
+// a USING TIMESTAMP clause. Replaying writes with their original timestamps
+// makes them idempotent, and backfilled rows do not overwrite newer ones.
+// It is ignored by reads and cannot be used with conditional writes.
+func Timestamp(ts int64) Option {
+  return func(o *options) {
+    o.timestamp, o.hasTimestamp = ts, true
+  }
+}
+
+// Trace enables tracing of the operation, reporting to tracer.
+func Trace(tracer gocql.Tracer) Option {
+  return func(o *options) {
+    o.tracer = tracer
+  }
+}
+
+// Retry sets the retry policy of the operation. Given to a query
+// constructor, it applies to every run of the query; see also
+// SetReadRetryPolicy.
+func Retry(policy gocql.RetryPolicy) Option {
+  return func(o *options) {
+    o.retryPolicy = policy
+  }
+}
+
+// Timeout bounds the time the operation takes, overriding the timeout of
+// the context, see WithTimeout: the statement is cancelled if it does not
+// complete within d, retries included. The timeout of the session still
+// bounds each request to a node. The clock of a query starts when it is
+// run and stops when its iterator is closed.
+func Timeout(d time.Duration) Option {
+  return func(o *options) {
+    o.timeout = d
+  }
+}
+
+// Idempotent marks the statements of the operation as idempotent or not,
+// i.e. safe to execute more than once, which enables gocql's speculative
+// execution for them. Selects are idempotent unless marked otherwise; mark
+// writes that only assign values, but not counter updates nor conditional
+// writes.
+func Idempotent(v bool) Option {
+  return func(o *options) {
+    o.idempotent, o.hasIdempotent = v, true
+  }
+}
+
+// SpeculativeExecution sets the speculative execution policy of the
+// operation: once the delay of the policy elapses without a response, the
+// statement is sent to another node as well, the first response winning,
+// to tame the latency of slow nodes. gocql only executes the idempotent
+// statements speculatively, see Idempotent. Given to NewClient, it applies
+// to every idempotent statement of the client:
+//
+//   client := datastore.NewClient(session, datastore.SpeculativeExecution(
+//     &gocql.SimpleSpeculativeExecution{NumAttempts: 2,
+//       TimeoutDelay: 50 * time.Millisecond}))
+func SpeculativeExecution(p gocql.SpeculativeExecutionPolicy) Option {
+  return func(o *options) {
+    o.speculative = p
+  }
+}
+
+// Table makes the operation target the table name instead of the column
+// family of the entity type, e.g. one of the per-tenant or per-time-bucket
+// tables sharing the columns of the type.
+func Table(name string) Option {
+  return func(o *options) {
+    o.table = name
+  }
+}
+
+// Keyspace makes the operation target the tables of the keyspace name
+// instead of the keyspace of the session, qualifying the table names of the
+// statements, so that one session can address several keyspaces.
+func Keyspace(name string) Option {
+  return func(o *options) {
+    o.keyspace = name
+  }
+}
+
+// tableOf returns the table the operation targets for the entity type of
+// codec, qualified by its keyspace if set.
+func (o *options) tableOf(codec *structCodec) string {
+  return o.qualify(codec.columnFamily)
+}
+
+// qualify returns the name of the table the operation targets given its
+// default name table.
+func (o *options) qualify(table string) string {
+  if o.table != "" {
+    table = o.table
+  }
+  if o.keyspace != "" {
+    table = o.keyspace + "." + table
+  }
+  return table
+}
+
+// IfNotExists makes an insert a lightweight transaction that only creates
+// the row if it does not exist yet. It is ignored by other operations.
+func IfNotExists() Option {
+  return func(o *options) {
+    o.ifNotExists = true
+  }
+}
+
+// UnloggedBatch makes SaveMulti send all of its inserts in a single unlogged
+// batch. It is ignored by other operations.
+func UnloggedBatch() Option {
+  return func(o *options) {
+    o.unloggedBatch = true
+  }
+}
+
+// newOptions resolves the options of an operation executed with ctx. Later
+// option sets override earlier ones, which override the settings carried by
+// ctx, which override the defaults of the Client.
+func newOptions(ctx context.Context, sets ...[]Option) *options {
+  return newQueryOptions(ctx, nil, sets...)
+}
+
+// newQueryOptions is like newOptions for the operations of a query, batch
+// or entity created with the options base: the settings carried by ctx
+// override base, and are overridden by the option sets of the call.
+func newQueryOptions(ctx context.Context, base []Option,
+  sets ...[]Option) *options {
+
+  o := &options{}
+  if defaults, ok := ctx.Value(defaultsKey).([]Option); ok {
+    for _, opt := range defaults {
+      opt(o)
+    }
+    o.defaultConsistency = o.hasConsistency
+  }
+  for _, opt := range base {
+    opt(o)
+  }
+  if c, ok := ctx.Value(consistencyKey).(gocql.Consistency); ok {
+    o.consistency, o.hasConsistency = c, true
+    o.defaultConsistency = false
+  }
+  if d, ok := ctx.Value(timeoutKey).(time.Duration); ok {
+    o.timeout = d
+  }
+  for _, set := range sets {
+    for _, opt := range set {
+      opt(o)
+    }
+  }
+  return o
+}
+
+// usingClause returns the USING clause of a write statement along with its
+// bound values. The TTL and the timestamp are bound rather than inlined so
+// that writes with different values share the same prepared statement.
+func (o *options) usingClause() (string, []interface{}, error) {
+  if o.ttlErr != nil {
+    return "", nil, o.ttlErr
+  }
+  var clauses []string
+  var args []interface{}
+  if o.ttl > 0 {
+    clauses = append(clauses, "TTL ?")
+    args = append(args, int32(o.ttl/time.Second))
+  }
+  if o.hasTimestamp {
+    clauses = append(clauses, "TIMESTAMP ?")
+    args = append(args, o.timestamp)
+  }
+  if len(clauses) == 0 {
+    return "", nil, nil
+  }
+  return " USING " + strings.Join(clauses, " AND "), args, nil
+}
+
+// timestampClause returns the USING TIMESTAMP clause of a delete statement
+// along with its bound value.
+func (o *options) timestampClause() (string, []interface{}) {
+  if !o.hasTimestamp {
+    return "", nil
+  }
+  return " USING TIMESTAMP ?", []interface{}{o.timestamp}
+}
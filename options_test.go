@@ -0,0 +1,66 @@
+package datastore
+
+import (
+  "context"
+  "testing"
+  "time"
+
+  "github.com/gocql/gocql"
+)
+
+func TestOptionsPrecedence(t *testing.T) {
+  ctx := withDefaults(context.Background(),
+    []Option{Consistency(gocql.One), Timeout(time.Second)})
+  base := []Option{Consistency(gocql.Quorum), Timeout(2 * time.Second)}
+  tests := []struct {
+    name        string
+    ctx         context.Context
+    call        []Option
+    consistency gocql.Consistency
+    timeout     time.Duration
+  }{
+    {"query", ctx, nil, gocql.Quorum, 2 * time.Second},
+    {"context", WithTimeout(WithConsistency(ctx, gocql.All), 3*time.Second),
+      nil, gocql.All, 3 * time.Second},
+    {"call", WithTimeout(WithConsistency(ctx, gocql.All), 3*time.Second),
+      []Option{Consistency(gocql.LocalOne), Timeout(4 * time.Second)},
+      gocql.LocalOne, 4 * time.Second},
+  }
+  for _, test := range tests {
+    o := newQueryOptions(test.ctx, base, test.call)
+    if o.consistency != test.consistency || o.timeout != test.timeout {
+      t.Errorf("%s: got %v, %v, want %v, %v", test.name, o.consistency,
+        o.timeout, test.consistency, test.timeout)
+    }
+    if o.defaultConsistency {
+      t.Errorf("%s: got a default consistency", test.name)
+    }
+  }
+}
+
+func TestTTL(t *testing.T) {
+  tests := []struct {
+    ttl   time.Duration
+    using string
+    valid bool
+  }{
+    {time.Hour, " USING TTL ?", true},
+    {maxTTL, " USING TTL ?", true},
+    {0, "", false},
+    {-time.Second, "", false},
+    {500 * time.Millisecond, "", false},
+    {1500 * time.Millisecond, "", false},
+    {maxTTL + time.Second, "", false},
+  }
+  for _, test := range tests {
+    o := newOptions(context.Background(), []Option{TTL(test.ttl)})
+    using, args, err := o.usingClause()
+    if (err == nil) != test.valid || using != test.using {
+      t.Errorf("%v: got %q %v %v", test.ttl, using, args, err)
+      continue
+    }
+    if test.valid && args[0] != int32(test.ttl/time.Second) {
+      t.Errorf("%v: got %v", test.ttl, args)
+    }
+  }
+}
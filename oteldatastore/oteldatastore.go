@@ -0,0 +1,89 @@
+// Package oteldatastore traces the statements executed by the datastore
+// package with OpenTelemetry. Every statement, or batch, gets a client span
+// carrying its kind, table, CQL text without the values, row count and
+// error:
+//
+//   session := oteldatastore.NewSession(datastore.NewSession(cqlSession))
+//   client := v2.NewClientWithSession(session)
+package oteldatastore
+
+import (
+  "context"
+  "fmt"
+
+  "github.com/droot/datastore"
+  "go.opentelemetry.io/otel"
+  "go.opentelemetry.io/otel/attribute"
+  "go.opentelemetry.io/otel/codes"
+  "go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/droot/datastore/oteldatastore"
+
+type config struct {
+  tp trace.TracerProvider
+}
+
+// Option configures the tracing.
+type Option func(*config)
+
+// WithTracerProvider sets the tracer provider the spans are created with,
+// the global one by default.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+  return func(c *config) {
+    c.tp = tp
+  }
+}
+
+type observer struct {
+  tracer trace.Tracer
+}
+
+// NewObserver returns an observer recording a span per execution, see
+// datastore.ObserveSession.
+func NewObserver(opts ...Option) datastore.Observer {
+  c := &config{}
+  for _, opt := range opts {
+    opt(c)
+  }
+  if c.tp == nil {
+    c.tp = otel.GetTracerProvider()
+  }
+  return observer{tracer: c.tp.Tracer(instrumentationName)}
+}
+
+// NewSession returns a Session executing statements with s and tracing
+// them.
+func NewSession(s datastore.Session, opts ...Option) datastore.Session {
+  return datastore.ObserveSession(s, NewObserver(opts...))
+}
+
+func (o observer) Start(ctx context.Context,
+  e *datastore.Execution) context.Context {
+
+  name := e.Op
+  if e.Table != "" {
+    name = fmt.Sprintf("%s %s", e.Op, e.Table)
+  }
+  attrs := []attribute.KeyValue{
+    attribute.String("db.system", "cassandra"),
+    attribute.String("db.operation", e.Op),
+    attribute.String("db.statement", e.CQL),
+  }
+  if e.Table != "" {
+    attrs = append(attrs, attribute.String("db.cassandra.table", e.Table))
+  }
+  ctx, _ = o.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient),
+    trace.WithTimestamp(e.Start), trace.WithAttributes(attrs...))
+  return ctx
+}
+
+func (o observer) Finish(ctx context.Context, e *datastore.Execution) {
+  span := trace.SpanFromContext(ctx)
+  span.SetAttributes(attribute.Int("db.cassandra.rows", e.Rows))
+  if e.Err != nil {
+    span.RecordError(e.Err)
+    span.SetStatus(codes.Error, e.Err.Error())
+  }
+  span.End(trace.WithTimestamp(e.Start.Add(e.Duration)))
+}
@@ -0,0 +1,172 @@
+package datastore
+
+import (
+  "context"
+  "fmt"
+  "strconv"
+)
+
+// param is a named bind marker, see Param.
+type param struct {
+  name string
+}
+
+// Param returns a named bind marker to pass to Filter, Update and the like
+// in place of a value: the statement gets the :name marker instead of ?,
+// and the value is given when running the query with Bind. A query can then
+// be built once and bound to new values on each use, and the statements it
+// logs name their values. For an IN filter, the value bound is the slice of
+// values to match.
+func Param(name string) interface{} {
+  return param{name}
+}
+
+// marker returns the bind marker of the value v.
+func marker(v interface{}) string {
+  if p, ok := v.(param); ok {
+    return ":" + p.name
+  }
+  return "?"
+}
+
+// validParamName reports whether name can be used in a bind marker.
+func validParamName(name string) bool {
+  for i, r := range name {
+    switch {
+    case r == '_', 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z':
+    case '0' <= r && r <= '9' && i > 0:
+    default:
+      return false
+    }
+  }
+  return name != ""
+}
+
+// bindParams returns args with the params replaced by their values in
+// bound.
+func bindParams(args []interface{}, bound map[string]interface{}) (
+  []interface{}, error) {
+
+  res := make([]interface{}, len(args))
+  for i, arg := range args {
+    p, ok := arg.(param)
+    if !ok {
+      res[i] = arg
+      continue
+    }
+    if !validParamName(p.name) {
+      return nil, fmt.Errorf("datastore: invalid parameter name %q", p.name)
+    }
+    v, ok := bound[p.name]
+    if !ok {
+      return nil, fmt.Errorf("datastore: no value bound to :%s", p.name)
+    }
+    res[i] = v
+  }
+  return res, nil
+}
+
+// bindValues returns a copy of bound with values added.
+func bindValues(bound, values map[string]interface{}) map[string]interface{} {
+  res := make(map[string]interface{}, len(bound)+len(values))
+  for k, v := range bound {
+    res[k] = v
+  }
+  for k, v := range values {
+    res[k] = v
+  }
+  return res
+}
+
+// Bind returns a derivative query with values bound to its named markers,
+// see Param.
+func (q *Query) Bind(values map[string]interface{}) *Query {
+  q = q.clone()
+  q.params = bindValues(q.params, values)
+  return q
+}
+
+// Bind returns a derivative query with values bound to its named markers,
+// see Param.
+func (q *UpdateQuery) Bind(values map[string]interface{}) *UpdateQuery {
+  q = q.clone()
+  q.params = bindValues(q.params, values)
+  return q
+}
+
+// Bind returns a derivative query with values bound to its named markers,
+// see Param.
+func (q *DeleteQuery) Bind(values map[string]interface{}) *DeleteQuery {
+  q = q.clone()
+  q.params = bindValues(q.params, values)
+  return q
+}
+
+// namedArgs returns the names of the markers of a statement whose values
+// are args, and the values bound to them, see Query.NamedStatement.
+func namedArgs(args []interface{}, bound map[string]interface{}) (
+  []string, map[string]interface{}, error) {
+
+  names := make([]string, len(args))
+  values := make(map[string]interface{}, len(args))
+  for i, arg := range args {
+    p, ok := arg.(param)
+    if !ok {
+      names[i] = "?" + strconv.Itoa(i+1)
+      values[names[i]] = arg
+      continue
+    }
+    if !validParamName(p.name) {
+      return nil, nil, fmt.Errorf("datastore: invalid parameter name %q",
+        p.name)
+    }
+    names[i] = p.name
+    if v, ok := bound[p.name]; ok {
+      values[p.name] = v
+    }
+  }
+  return names, values, nil
+}
+
+// NamedStatement returns the CQL statement of the query, the names of its
+// markers in order and the values bound to them, the form of named query
+// libraries such as gocqlx: the markers of the params are named after them,
+// the other ones ?1, ?2 and so on by position, which no param can be named.
+// Unlike Statement, it does not fail on params not bound yet, which are
+// left out of values.
+func (q *Query) NamedStatement() (stmt string, names []string,
+  values map[string]interface{}, err error) {
+
+  stmt, args, err := q.buildCQL()
+  if err != nil {
+    return "", nil, nil, err
+  }
+  names, values, err = namedArgs(args, q.params)
+  return stmt, names, values, err
+}
+
+// NamedStatement returns the CQL statement of the query, the names of its
+// markers and the values bound to them, see Query.NamedStatement.
+func (q *UpdateQuery) NamedStatement() (stmt string, names []string,
+  values map[string]interface{}, err error) {
+
+  stmt, args, err := q.buildCQL(newOptions(context.Background(), q.opts))
+  if err != nil {
+    return "", nil, nil, err
+  }
+  names, values, err = namedArgs(args, q.params)
+  return stmt, names, values, err
+}
+
+// NamedStatement returns the CQL statement of the query, the names of its
+// markers and the values bound to them, see Query.NamedStatement.
+func (q *DeleteQuery) NamedStatement() (stmt string, names []string,
+  values map[string]interface{}, err error) {
+
+  stmt, args, err := q.buildCQL(newOptions(context.Background(), q.opts))
+  if err != nil {
+    return "", nil, nil, err
+  }
+  names, values, err = namedArgs(args, q.params)
+  return stmt, names, values, err
+}
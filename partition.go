@@ -0,0 +1,267 @@
+package datastore
+
+import (
+  "context"
+  "fmt"
+  "reflect"
+  "strings"
+  "sync"
+)
+
+// partitionHolder describes a struct holding the rows of a partition in a
+// slice field, see GetPartition.
+type partitionHolder struct {
+  // rows is the index path of the slice field, whose elements are of the
+  // entity type elem, or pointers to it if ptr is set.
+  rows  []int
+  elem  reflect.Type
+  ptr   bool
+  codec *structCodec
+  // keys are the fields set to the partition key columns, by column.
+  keys map[string][]int
+}
+
+// partitionHolders caches the partitionHolders by type.
+var partitionHolders sync.Map
+
+// getPartitionHolder returns the description of the struct type t holding
+// the rows of a partition.
+func getPartitionHolder(t reflect.Type) (*partitionHolder, error) {
+  if h, ok := partitionHolders.Load(t); ok {
+    return h.(*partitionHolder), nil
+  }
+  h, err := newPartitionHolder(t)
+  if err != nil {
+    return nil, err
+  }
+  partitionHolders.Store(t, h)
+  return h, nil
+}
+
+func newPartitionHolder(t reflect.Type) (*partitionHolder, error) {
+  h := &partitionHolder{keys: make(map[string][]int)}
+  for i := 0; i < t.NumField(); i++ {
+    f := t.Field(i)
+    if f.PkgPath != "" || f.Type.Kind() != reflect.Slice {
+      continue
+    }
+    elem, ptr := f.Type.Elem(), false
+    if elem.Kind() == reflect.Ptr {
+      elem, ptr = elem.Elem(), true
+    }
+    if elem.Kind() != reflect.Struct {
+      continue
+    }
+    codec, err := getStructCodec(elem)
+    if err != nil {
+      // not a slice of entities
+      continue
+    }
+    if h.codec != nil {
+      return nil, fmt.Errorf("datastore: several entity slice fields in %v",
+        t)
+    }
+    h.rows, h.elem, h.ptr, h.codec = f.Index, elem, ptr, codec
+  }
+  if h.codec == nil {
+    return nil, fmt.Errorf("datastore: no entity slice field in %v", t)
+  }
+  if len(h.codec.partitionKeys) == 0 {
+    return nil, fmt.Errorf("datastore: no partition key column in %v",
+      h.elem)
+  }
+  for i := 0; i < t.NumField(); i++ {
+    f := t.Field(i)
+    name := strings.Split(f.Tag.Get("cql"), ",")[0]
+    if f.PkgPath != "" || name == "" || name == "-" {
+      continue
+    }
+    for _, k := range h.codec.partitionKeys {
+      if k != name {
+        continue
+      }
+      kt := h.elem.FieldByIndex(
+        h.codec.byIndex[h.codec.byName[k].index].index).Type
+      if !kt.ConvertibleTo(f.Type) {
+        return nil, fmt.Errorf("datastore: field %s of %v cannot hold "+
+          "column %s of type %v", f.Name, t, k, kt)
+      }
+      h.keys[k] = f.Index
+    }
+  }
+  return h, nil
+}
+
+// partitionKey returns the values of the partition key columns of the
+// entity e.
+func (h *partitionHolder) partitionKey(e reflect.Value) []reflect.Value {
+  vals := make([]reflect.Value, len(h.codec.partitionKeys))
+  for i, k := range h.codec.partitionKeys {
+    vals[i] = e.FieldByIndex(h.codec.byIndex[h.codec.byName[k].index].index)
+  }
+  return vals
+}
+
+// setKey sets the key fields of the holder v to the values of the
+// partition key columns of the entity e.
+func (h *partitionHolder) setKey(v, e reflect.Value) {
+  for i, k := range h.codec.partitionKeys {
+    if index, ok := h.keys[k]; ok {
+      f := v.FieldByIndex(index)
+      f.Set(h.partitionKey(e)[i].Convert(f.Type()))
+    }
+  }
+}
+
+// appendRow appends the entity e to the rows of the holder v.
+func (h *partitionHolder) appendRow(v, e reflect.Value) {
+  rows := v.FieldByIndex(h.rows)
+  if h.ptr {
+    rows.Set(reflect.Append(rows, e.Addr()))
+  } else {
+    rows.Set(reflect.Append(rows, e))
+  }
+}
+
+// GetPartition loads the rows of a partition into dst, a pointer to a struct
+// holding them in a field of type []S or []*S, where S is an entity type,
+// for one-to-many models whose children are the clustering rows of a
+// partition:
+//
+//   type Timeline struct {
+//     UserID  string  `cql:"user_id"`
+//     Entries []Tweet
+//   }
+//
+//   var tl Timeline
+//   err := datastore.GetPartition(session, &tl, userID)
+//
+// keyValues are the values of the partition key columns, possibly followed
+// by a prefix of the clustering columns as for Get. The rows replace the
+// contents of the slice field, in clustering order. The fields of dst whose
+// cql tag names a partition key column of S are set to its value.
+// ErrNoSuchEntity is returned if no row matches.
+func GetPartition(session Session, dst interface{},
+  keyValues ...interface{}) error {
+  return GetPartitionContext(context.Background(), session, dst, keyValues)
+}
+
+// GetPartitionContext is like GetPartition but executes the query with ctx
+// and opts.
+func GetPartitionContext(ctx context.Context, session Session,
+  dst interface{}, keyValues []interface{}, opts ...Option) error {
+
+  v := reflect.ValueOf(dst)
+  if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+    return fmt.Errorf("datastore: dst must be a struct pointer, got %T", dst)
+  }
+  v = v.Elem()
+  h, err := getPartitionHolder(v.Type())
+  if err != nil {
+    return err
+  }
+  q, err := keyQuery(h.elem, keyValues, opts)
+  if err != nil {
+    return err
+  }
+  rows := v.FieldByIndex(h.rows)
+  rows.Set(reflect.Zero(rows.Type()))
+  iter := q.RunContext(ctx, session)
+  for {
+    e := reflect.New(h.elem).Elem()
+    err := iter.Next(e.Addr().Interface())
+    if err == Done {
+      break
+    }
+    if err != nil {
+      iter.Close()
+      return err
+    }
+    if rows.Len() == 0 {
+      h.setKey(v, e)
+    }
+    h.appendRow(v, e)
+  }
+  if err := iter.Close(); err != nil {
+    return err
+  }
+  if rows.Len() == 0 {
+    return ErrNoSuchEntity
+  }
+  return nil
+}
+
+// GetPartitions runs the query and groups its rows by partition into
+// holders appended to dst, a pointer to a []H or []*H where H is a struct
+// holding the rows of a partition as described by GetPartition. The query
+// must be of the entity type of the rows, e.g. with an IN filter on the
+// partition key to load several partitions at once. The holders are
+// appended in the order their first row is read. It returns the number of
+// holders appended.
+func (q *Query) GetPartitions(session Session, dst interface{},
+  opts ...Option) (int, error) {
+  return q.GetPartitionsContext(context.Background(), session, dst, opts...)
+}
+
+// GetPartitionsContext is like GetPartitions but executes the query with
+// ctx.
+func (q *Query) GetPartitionsContext(ctx context.Context, session Session,
+  dst interface{}, opts ...Option) (int, error) {
+
+  dv := reflect.ValueOf(dst)
+  if dv.Kind() != reflect.Ptr || dv.IsNil() || dv.Elem().Kind() != reflect.Slice {
+    return 0, fmt.Errorf("datastore: dst must be a slice pointer, got %T", dst)
+  }
+  sv := dv.Elem()
+  ht, isPtr := sv.Type().Elem(), false
+  if ht.Kind() == reflect.Ptr {
+    ht, isPtr = ht.Elem(), true
+  }
+  if ht.Kind() != reflect.Struct {
+    return 0, fmt.Errorf("datastore: invalid partition type %v", ht)
+  }
+  h, err := getPartitionHolder(ht)
+  if err != nil {
+    return 0, err
+  }
+  if h.codec != q.codec {
+    return 0, fmt.Errorf("datastore: query of %v cannot load %v",
+      q.codec.typ, h.elem)
+  }
+  // the holders by partition key, IN queries ordered by clustering columns
+  // interleaving the rows of their partitions
+  holders := make(map[string]reflect.Value)
+  var order []reflect.Value
+  iter := q.RunContext(ctx, session, opts...)
+  for {
+    e := reflect.New(h.elem).Elem()
+    err := iter.Next(e.Addr().Interface())
+    if err == Done {
+      break
+    }
+    if err != nil {
+      iter.Close()
+      return 0, err
+    }
+    key := keyString(h.partitionKey(e))
+    holder, ok := holders[key]
+    if !ok {
+      holder = reflect.New(ht).Elem()
+      h.setKey(holder, e)
+      holders[key] = holder
+      order = append(order, holder)
+    }
+    h.appendRow(holder, e)
+  }
+  if err := iter.Close(); err != nil {
+    return 0, err
+  }
+  for _, holder := range order {
+    if isPtr {
+      sv.Set(reflect.Append(sv, holder.Addr()))
+    } else {
+      sv.Set(reflect.Append(sv, holder))
+    }
+  }
+  return len(order), nil
+}
@@ -0,0 +1,451 @@
+package datastore
+
+import (
+  "bytes"
+  "errors"
+  "fmt"
+  "reflect"
+  "strings"
+  "time"
+
+  "github.com/gocql/gocql"
+)
+
+// PostFilter returns a derivative query applying the filters Cassandra
+// cannot serve client-side, while iterating over the results, instead of
+// having the query rejected or running it with AllowFiltering. Those are the
+// filters on columns that are neither part of the primary key nor indexed,
+// the comparisons other than = and IN on partition key columns, and the
+// CONTAINS filters on clustering columns.
+//
+// The rows dropped by the post filters are still read from Cassandra, which
+// may be a lot of rows: PostFilters lists the filters applied client-side
+// and Iterator.Scanned counts the rows read. The limit of the query then
+// applies to the filtered rows, and the filtered columns must be selected.
+// Post-filtered queries cannot be aggregated.
+func (q *Query) PostFilter() *Query {
+  q = q.clone()
+  q.postFilter = true
+  return q
+}
+
+// PostFilters returns the columns of the filters the query applies
+// client-side, see PostFilter.
+func (q *Query) PostFilters() []string {
+  _, post := q.splitFilters()
+  cols := make([]string, len(post))
+  for i, f := range post {
+    cols[i] = f.FieldName
+  }
+  return cols
+}
+
+// splitFilters splits the filters of the query into the ones sent to
+// Cassandra and the ones applied client-side, see PostFilter.
+func (q *Query) splitFilters() (server, post []filter) {
+  if !q.postFilter || q.codec.typ == nil {
+    return q.filter, nil
+  }
+  for _, f := range q.filter {
+    if q.servable(f) {
+      server = append(server, f)
+    } else {
+      post = append(post, f)
+    }
+  }
+  return server, post
+}
+
+// servable reports whether Cassandra serves the filter f without ALLOW
+// FILTERING.
+func (q *Query) servable(f filter) bool {
+  if f.token {
+    return true
+  }
+  fc, ok := q.codec.byName[f.FieldName]
+  if !ok {
+    // left to getWhereClause to report
+    return true
+  }
+  tag := q.codec.byIndex[fc.index]
+  switch {
+  case tag.partitionKey:
+    return f.Op == Equal || f.Op == In
+  case tag.clusteringKey:
+    return f.Op != Like && f.Op != Contains && f.Op != ContainsKey
+  case tag.indexed:
+    return f.Op == Equal || f.Op == Like || f.Op == Contains ||
+      f.Op == ContainsKey
+  }
+  return false
+}
+
+// checkPostFilters checks that the post filters post can be applied to the
+// rows of the query.
+func (q *Query) checkPostFilters(post []filter) error {
+  if q.distinct || q.json || len(q.groupBy) > 0 {
+    return errors.New("datastore: post filters on a DISTINCT, GROUP BY " +
+      "or JSON query")
+  }
+  if len(q.projection) == 0 {
+    return nil
+  }
+  for _, f := range post {
+    if !inColumns(q.projection, f.FieldName) {
+      return fmt.Errorf("datastore: post filter column %s is not selected",
+        f.FieldName)
+    }
+  }
+  return nil
+}
+
+// bindPostFilters returns the post filters with their params replaced by
+// their values in bound.
+func bindPostFilters(post []filter, bound map[string]interface{}) ([]filter,
+  error) {
+
+  res := make([]filter, len(post))
+  for i, f := range post {
+    args, err := bindParams([]interface{}{f.Value}, bound)
+    if err != nil {
+      return nil, err
+    }
+    f.Value = args[0]
+    res[i] = f
+  }
+  return res, nil
+}
+
+// nextFiltered loads the next result matching the post filters of the
+// iterator into dst. Every row is loaded into a value of its own, copied to
+// dst if it matches, so that dst is left untouched by the dropped rows.
+func (t *Iterator) nextFiltered(dst interface{}) error {
+  if t.limit > 0 && t.returned >= t.limit {
+    return Done
+  }
+  dv := reflect.ValueOf(dst)
+  if dv.Kind() != reflect.Ptr || dv.IsNil() {
+    return fmt.Errorf("datastore: invalid destination %T", dst)
+  }
+  for {
+    row := reflect.New(dv.Type().Elem())
+    _, ok, err := loadMatching(row.Interface(), t.iter, t.post)
+    if err != nil {
+      return err
+    }
+    t.scanned++
+    if ok {
+      dv.Elem().Set(row.Elem())
+      t.returned++
+      return nil
+    }
+  }
+}
+
+// loadMatching loads the next row of iter into dst and reports whether it
+// matches the filters. The returned rowData holds the destinations the
+// columns were scanned into. It returns Done if the results are exhausted.
+func loadMatching(dst interface{}, iter Iter, filters []filter) (
+  gocql.RowData, bool, error) {
+
+  rowData, err := iter.RowData()
+  if err != nil {
+    return rowData, false, err
+  }
+  var ls loadSaver
+  if isScalarDest(dst) {
+    if len(rowData.Columns) != 1 {
+      return rowData, false, fmt.Errorf("datastore: cannot load %d "+
+        "columns into %T", len(rowData.Columns), dst)
+    }
+    rowData.Values[0] = dst
+  } else {
+    if ls, err = newLoadSaver(dst); err != nil {
+      return rowData, false, err
+    }
+    defer releaseLoadSaver(ls)
+    ls.setDests(&rowData)
+  }
+  if !iter.Scan(rowData.Values...) {
+    if err := iter.Close(); err != nil {
+      return rowData, false, err
+    }
+    return rowData, false, Done
+  }
+  for _, f := range filters {
+    ok, err := matchColumn(f, &rowData)
+    if err != nil || !ok {
+      return rowData, false, err
+    }
+  }
+  if ls != nil {
+    return rowData, true, ls.loaded(&rowData)
+  }
+  return rowData, true, nil
+}
+
+// columnValue returns the value of the column col scanned into rowData.
+func columnValue(rowData *gocql.RowData, col string) (reflect.Value,
+  error) {
+
+  for i, name := range rowData.Columns {
+    if name != col {
+      continue
+    }
+    switch x := rowData.Values[i].(type) {
+    case udtValue:
+      return x.v, nil
+    case nullUDT:
+      return x.v, nil
+    }
+    return reflect.ValueOf(rowData.Values[i]), nil
+  }
+  return reflect.Value{}, fmt.Errorf("datastore: column %s is not "+
+    "selected", col)
+}
+
+// matchColumn reports whether the column of the filter f in the scanned
+// row rowData matches f.
+func matchColumn(f filter, rowData *gocql.RowData) (bool, error) {
+  v, err := columnValue(rowData, f.FieldName)
+  if err != nil {
+    return false, err
+  }
+  ok, err := matchFilter(f, v)
+  if err != nil {
+    return false, fmt.Errorf("datastore: post filter on %s: %v",
+      f.FieldName, err)
+  }
+  return ok, nil
+}
+
+// matchFilter reports whether the value v matches the filter f. Null
+// values match no filter.
+func matchFilter(f filter, v reflect.Value) (bool, error) {
+  v = indirect(v)
+  if !v.IsValid() {
+    return false, nil
+  }
+  fv := indirect(reflect.ValueOf(f.Value))
+  switch f.Op {
+  case In:
+    for i := 0; i < fv.Len(); i++ {
+      if equalValues(v, indirect(fv.Index(i))) {
+        return true, nil
+      }
+    }
+    return false, nil
+  case Contains:
+    switch v.Kind() {
+    case reflect.Slice, reflect.Array:
+      for i := 0; i < v.Len(); i++ {
+        if equalValues(indirect(v.Index(i)), fv) {
+          return true, nil
+        }
+      }
+      return false, nil
+    case reflect.Map:
+      iter := v.MapRange()
+      for iter.Next() {
+        if equalValues(indirect(iter.Value()), fv) {
+          return true, nil
+        }
+      }
+      return false, nil
+    }
+    return false, fmt.Errorf("CONTAINS on a %v", v.Type())
+  case ContainsKey:
+    if v.Kind() != reflect.Map {
+      return false, fmt.Errorf("CONTAINS KEY on a %v", v.Type())
+    }
+    iter := v.MapRange()
+    for iter.Next() {
+      if equalValues(indirect(iter.Key()), fv) {
+        return true, nil
+      }
+    }
+    return false, nil
+  case Like:
+    if v.Kind() != reflect.String {
+      return false, fmt.Errorf("LIKE on a %v", v.Type())
+    }
+    return likeMatch(v.String(), fv.String()), nil
+  case Equal:
+    return equalValues(v, fv), nil
+  }
+  c, ok := compareValues(v, fv)
+  if !ok {
+    return false, fmt.Errorf("cannot compare a %v to a %v", v.Type(),
+      fv.Type())
+  }
+  switch f.Op {
+  case LessThan:
+    return c < 0, nil
+  case LessEq:
+    return c <= 0, nil
+  case GreaterEq:
+    return c >= 0, nil
+  case GreaterThan:
+    return c > 0, nil
+  }
+  return false, fmt.Errorf("invalid operator %v", f.Op)
+}
+
+// indirect follows the pointers and interfaces of v, returning the zero
+// Value for nil ones.
+func indirect(v reflect.Value) reflect.Value {
+  for v.IsValid() && (v.Kind() == reflect.Ptr ||
+    v.Kind() == reflect.Interface) {
+    if v.IsNil() {
+      return reflect.Value{}
+    }
+    v = v.Elem()
+  }
+  return v
+}
+
+// equalValues reports whether a and b hold the same value, comparing
+// numbers of different types by value.
+func equalValues(a, b reflect.Value) bool {
+  if !a.IsValid() || !b.IsValid() {
+    return a.IsValid() == b.IsValid()
+  }
+  if c, ok := compareValues(a, b); ok {
+    return c == 0
+  }
+  if b.Type().ConvertibleTo(a.Type()) {
+    b = b.Convert(a.Type())
+  }
+  return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+// compareValues compares the ordered values a and b like CQL does, numbers
+// by value, strings, blobs and UUIDs bytewise, timeuuids by time, times
+// chronologically and dates and times of day field by field. It reports
+// false if the values cannot be ordered.
+func compareValues(a, b reflect.Value) (int, bool) {
+  if n, ok := toFloat(a); ok {
+    m, ok := toFloat(b)
+    switch {
+    case !ok:
+      return 0, false
+    case n < m:
+      return -1, true
+    case n > m:
+      return 1, true
+    }
+    if x, ok := toInt(a); ok {
+      if y, ok := toInt(b); ok && x != y {
+        // beyond the precision of float64
+        if x < y {
+          return -1, true
+        }
+        return 1, true
+      }
+    }
+    return 0, true
+  }
+  if a.Type() == typeOfTime && b.Type() == typeOfTime {
+    return a.Interface().(time.Time).Compare(b.Interface().(time.Time)), true
+  }
+  if a.Type() == typeOfUUID && b.Type() == typeOfUUID {
+    x, y := a.Interface().(gocql.UUID), b.Interface().(gocql.UUID)
+    if x.Version() == 1 && y.Version() == 1 {
+      if c := x.Time().Compare(y.Time()); c != 0 {
+        return c, true
+      }
+    }
+    return bytes.Compare(x[:], y[:]), true
+  }
+  switch {
+  case a.Kind() == reflect.String && b.Kind() == reflect.String:
+    return strings.Compare(a.String(), b.String()), true
+  case a.Kind() == reflect.Bool && b.Kind() == reflect.Bool:
+    x, y := a.Bool(), b.Bool()
+    switch {
+    case x == y:
+      return 0, true
+    case y:
+      return -1, true
+    }
+    return 1, true
+  case isBytes(a) && isBytes(b):
+    return bytes.Compare(toBytes(a), toBytes(b)), true
+  case a.Kind() == reflect.Struct && a.Type() == b.Type() &&
+    (a.Type() == typeOfDate || a.Type() == typeOfTOD):
+    for i := 0; i < a.NumField(); i++ {
+      if c, _ := compareValues(a.Field(i), b.Field(i)); c != 0 {
+        return c, true
+      }
+    }
+    return 0, true
+  }
+  return 0, false
+}
+
+// toFloat returns the number v holds as a float64.
+func toFloat(v reflect.Value) (float64, bool) {
+  switch v.Kind() {
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+    reflect.Int64:
+    return float64(v.Int()), true
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+    reflect.Uint64:
+    return float64(v.Uint()), true
+  case reflect.Float32, reflect.Float64:
+    return v.Float(), true
+  }
+  return 0, false
+}
+
+// toInt returns the integer v holds as an int64.
+func toInt(v reflect.Value) (int64, bool) {
+  switch v.Kind() {
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+    reflect.Int64:
+    return v.Int(), true
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+    reflect.Uint64:
+    if u := v.Uint(); u <= 1<<63-1 {
+      return int64(u), true
+    }
+  }
+  return 0, false
+}
+
+// isBytes reports whether v is a byte slice or array.
+func isBytes(v reflect.Value) bool {
+  return (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) &&
+    v.Type().Elem().Kind() == reflect.Uint8
+}
+
+// toBytes returns the bytes of the byte slice or array v.
+func toBytes(v reflect.Value) []byte {
+  if v.Kind() == reflect.Slice {
+    return v.Bytes()
+  }
+  b := make([]byte, v.Len())
+  reflect.Copy(reflect.ValueOf(b), v)
+  return b
+}
+
+// likeMatch reports whether s matches the LIKE pattern, whose % match any
+// sequence of characters.
+func likeMatch(s, pattern string) bool {
+  parts := strings.Split(pattern, "%")
+  if !strings.HasPrefix(s, parts[0]) {
+    return false
+  }
+  s = s[len(parts[0]):]
+  for i, part := range parts[1:] {
+    if i == len(parts)-2 {
+      return strings.HasSuffix(s, part)
+    }
+    j := strings.Index(s, part)
+    if j < 0 {
+      return false
+    }
+    s = s[j+len(part):]
+  }
+  return s == ""
+}
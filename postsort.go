@@ -0,0 +1,164 @@
+package datastore
+
+import (
+  "errors"
+  "fmt"
+  "reflect"
+  "sort"
+)
+
+// PostSort returns a derivative query sorting the results client-side when
+// Cassandra cannot serve its order, i.e. when it orders by columns other
+// than the clustering columns. The results are then read in full and sorted
+// in memory before the first one is returned, the limit of the query
+// applying to the sorted results. At most maxRows rows are buffered: reading
+// more makes the iteration fail with ErrTooManyRows. The ordered columns
+// must be selected.
+func (q *Query) PostSort(maxRows int) *Query {
+  q = q.clone()
+  if maxRows <= 0 {
+    q.err = fmt.Errorf("datastore: invalid post sort row cap %d", maxRows)
+    return q
+  }
+  q.postSort = maxRows
+  return q
+}
+
+// ErrTooManyRows is returned by the iteration of a query sorted client-side
+// reading more rows than its cap, see Query.PostSort.
+var ErrTooManyRows = errors.New("datastore: too many rows to sort")
+
+// sortsClientSide reports whether the results are sorted client-side, see
+// PostSort.
+func (q *Query) sortsClientSide() bool {
+  if q.postSort == 0 || q.codec.typ == nil {
+    return false
+  }
+  for _, o := range q.order {
+    fc, ok := q.codec.byName[o.FieldName]
+    if ok && !q.codec.byIndex[fc.index].clusteringKey {
+      return true
+    }
+  }
+  return false
+}
+
+// checkPostSort checks that the results of the query can be sorted
+// client-side.
+func (q *Query) checkPostSort() error {
+  if q.distinct || q.json || len(q.groupBy) > 0 {
+    return errors.New("datastore: post sort of a DISTINCT, GROUP BY or " +
+      "JSON query")
+  }
+  for _, o := range q.order {
+    if !q.codec.hasColumn(o.FieldName) {
+      return fmt.Errorf("query : fieldname %s not found", o.FieldName)
+    }
+    if len(q.projection) > 0 && !inColumns(q.projection, o.FieldName) {
+      return fmt.Errorf("datastore: order column %s is not selected",
+        o.FieldName)
+    }
+  }
+  return nil
+}
+
+// sortedRow is a result buffered to be sorted, along with the values of the
+// columns it is sorted by.
+type sortedRow struct {
+  v    reflect.Value
+  keys []reflect.Value
+}
+
+// nextSorted loads the next result of the query sorted client-side into
+// dst, reading and sorting all the results first.
+func (t *Iterator) nextSorted(dst interface{}) error {
+  dv := reflect.ValueOf(dst)
+  if dv.Kind() != reflect.Ptr || dv.IsNil() {
+    return fmt.Errorf("datastore: invalid destination %T", dst)
+  }
+  if !t.buffered {
+    if err := t.sortRows(dv.Type().Elem()); err != nil {
+      t.err = err
+      return err
+    }
+    t.buffered = true
+  }
+  if len(t.rows) == 0 {
+    return Done
+  }
+  row := t.rows[0]
+  if row.Type() != dv.Type().Elem() {
+    return fmt.Errorf("datastore: cannot load a %v into %T", row.Type(), dst)
+  }
+  dv.Elem().Set(row)
+  t.rows = t.rows[1:]
+  t.returned++
+  return nil
+}
+
+// sortRows reads the results of the query into values of type typ and
+// sorts them by the order of the query.
+func (t *Iterator) sortRows(typ reflect.Type) error {
+  var rows []sortedRow
+  for {
+    row := reflect.New(typ)
+    rowData, ok, err := loadMatching(row.Interface(), t.iter, t.post)
+    if err == Done {
+      break
+    }
+    if err != nil {
+      return err
+    }
+    t.scanned++
+    if !ok {
+      continue
+    }
+    if len(rows) == t.q.postSort {
+      t.iter.Close()
+      return ErrTooManyRows
+    }
+    keys := make([]reflect.Value, len(t.q.order))
+    for i, o := range t.q.order {
+      if keys[i], err = columnValue(&rowData, o.FieldName); err != nil {
+        return err
+      }
+    }
+    rows = append(rows, sortedRow{row.Elem(), keys})
+  }
+  sort.SliceStable(rows, func(i, j int) bool {
+    for k, o := range t.q.order {
+      c := compareKeys(rows[i].keys[k], rows[j].keys[k])
+      if o.Direction == descending {
+        c = -c
+      }
+      if c != 0 {
+        return c < 0
+      }
+    }
+    return false
+  })
+  if t.limit > 0 && len(rows) > int(t.limit) {
+    rows = rows[:t.limit]
+  }
+  t.rows = make([]reflect.Value, len(rows))
+  for i, row := range rows {
+    t.rows[i] = row.v
+  }
+  return nil
+}
+
+// compareKeys compares the sort keys a and b, nulls first. Values that
+// cannot be ordered compare equal.
+func compareKeys(a, b reflect.Value) int {
+  a, b = indirect(a), indirect(b)
+  switch {
+  case !a.IsValid() && !b.IsValid():
+    return 0
+  case !a.IsValid():
+    return -1
+  case !b.IsValid():
+    return 1
+  }
+  c, _ := compareValues(a, b)
+  return c
+}
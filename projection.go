@@ -0,0 +1,83 @@
+package datastore
+
+import (
+  "context"
+  "reflect"
+)
+
+// narrowable reports whether the columns the query selects may be narrowed
+// to the ones of the entities its rows are loaded into, see Iterator.Next.
+func (q *Query) narrowable() bool {
+  return q.raw == nil && q.codec.typ != nil && len(q.projection) == 0 &&
+    !q.json && !q.distinct && len(q.groupBy) == 0
+}
+
+// projectionOf returns the columns the entity dst loads, if they are fewer
+// than the ones of the type of the query, nil otherwise.
+func (q *Query) projectionOf(dst interface{}) []string {
+  switch dst.(type) {
+  case ColumnLoadSaver, EntityCodec:
+    // their loads are not described by tags
+    return nil
+  }
+  t := reflect.TypeOf(dst)
+  if t == nil || t.Kind() != reflect.Ptr || t.Elem() == q.codec.typ ||
+    isScalarDest(dst) {
+    return nil
+  }
+  codec, err := getStructCodec(t.Elem())
+  if err != nil {
+    return nil
+  }
+  var cols []string
+  for _, tag := range codec.byIndex {
+    if tag.name == "-" {
+      continue
+    }
+    if !q.codec.hasColumn(tag.name) {
+      // a column of another table, which the load would fail on
+      return nil
+    }
+    cols = append(cols, tag.name)
+  }
+  all := 0
+  for _, tag := range q.codec.byIndex {
+    if tag.name != "-" {
+      all++
+    }
+  }
+  if len(cols) == 0 || len(cols) >= all {
+    return nil
+  }
+  return cols
+}
+
+// deferRun makes the iterator execute stmt, the statement of its query,
+// once the destination of the rows is known, see start: the statement
+// selects the columns the destination loads.
+func (t *Iterator) deferRun(ctx context.Context, session Session,
+  stmt *Statement) {
+
+  t.start = func(dst interface{}) {
+    if cols := t.q.projectionOf(dst); cols != nil {
+      cql, args, err := t.q.Project(cols...).toCQL()
+      if err != nil {
+        t.err = err
+        return
+      }
+      x := *stmt
+      x.CQL, x.Args = cql, args
+      stmt, t.cql = &x, cql
+    }
+    t.iter, t.cancel = run(ctx, session, stmt)
+  }
+}
+
+// started executes the statement of the iterator if it was deferred, for
+// rows loaded into dst, nil if unknown.
+func (t *Iterator) started(dst interface{}) {
+  if start := t.start; start != nil {
+    t.start = nil
+    start(dst)
+  }
+}
@@ -0,0 +1,90 @@
+// Package promdatastore exposes Prometheus metrics on the statements
+// executed by the datastore package, per column family and kind of
+// statement:
+//
+//   m := promdatastore.NewMetrics()
+//   prometheus.MustRegister(m)
+//   session := datastore.ObserveSession(datastore.NewSession(cqlSession), m)
+package promdatastore
+
+import (
+  "context"
+
+  "github.com/droot/datastore"
+  "github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics is a datastore.Observer collecting:
+//
+//   datastore_statements_total: executed statements
+//   datastore_statement_errors_total: failed statements
+//   datastore_statement_duration_seconds: histogram of execution times
+//   datastore_rows_total: rows read
+//
+// labelled by table and op, the kind of statement (select, insert, update,
+// delete or batch). Batches have an empty table.
+type Metrics struct {
+  statements *prometheus.CounterVec
+  errors     *prometheus.CounterVec
+  duration   *prometheus.HistogramVec
+  rows       *prometheus.CounterVec
+}
+
+var labels = []string{"table", "op"}
+
+// NewMetrics returns metrics to register with a Prometheus registry.
+func NewMetrics() *Metrics {
+  return &Metrics{
+    statements: prometheus.NewCounterVec(prometheus.CounterOpts{
+      Name: "datastore_statements_total",
+      Help: "Number of statements executed.",
+    }, labels),
+    errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+      Name: "datastore_statement_errors_total",
+      Help: "Number of statements that failed.",
+    }, labels),
+    duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+      Name:    "datastore_statement_duration_seconds",
+      Help:    "Time taken to execute statements and read their rows.",
+      Buckets: prometheus.ExponentialBuckets(0.0005, 2, 16),
+    }, labels),
+    rows: prometheus.NewCounterVec(prometheus.CounterOpts{
+      Name: "datastore_rows_total",
+      Help: "Number of rows read.",
+    }, labels),
+  }
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+  m.statements.Describe(ch)
+  m.errors.Describe(ch)
+  m.duration.Describe(ch)
+  m.rows.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+  m.statements.Collect(ch)
+  m.errors.Collect(ch)
+  m.duration.Collect(ch)
+  m.rows.Collect(ch)
+}
+
+// Start implements datastore.Observer.
+func (m *Metrics) Start(ctx context.Context,
+  e *datastore.Execution) context.Context {
+  return ctx
+}
+
+// Finish implements datastore.Observer.
+func (m *Metrics) Finish(ctx context.Context, e *datastore.Execution) {
+  m.statements.WithLabelValues(e.Table, e.Op).Inc()
+  if e.Err != nil {
+    m.errors.WithLabelValues(e.Table, e.Op).Inc()
+  }
+  m.duration.WithLabelValues(e.Table, e.Op).Observe(e.Duration.Seconds())
+  if e.Rows > 0 {
+    m.rows.WithLabelValues(e.Table, e.Op).Add(float64(e.Rows))
+  }
+}
@@ -1,30 +1,139 @@
 package datastore
 
 import (
+  "context"
   "errors"
   "fmt"
   "math"
   "reflect"
   "strings"
-
-  "github.com/gocql/gocql"
+  "sync"
 )
 
-type operator int
+// Operator is the comparison operator of a filter, see Query.FilterField.
+type Operator int
 
+// The filter operators, named after their CQL counterparts.
 const (
-  lessThan operator = iota
-  lessEq
-  equal
-  greaterEq
-  greaterThan
+  LessThan Operator = iota
+  LessEq
+  Equal
+  GreaterEq
+  GreaterThan
+  In
+  Like
+  Contains
+  ContainsKey
 )
 
 // filter is a conditional filter on query results.
 type filter struct {
   FieldName string
-  Op        operator
+  Op        Operator
   Value     interface{}
+  // token is set for filters on the token of the partition key, which
+  // have no FieldName.
+  token bool
+}
+
+// wordOperators are the operators spelled as words, following the field
+// name of a filter string, the longest suffixes first.
+var wordOperators = []struct {
+  suffix string
+  op     Operator
+}{
+  {" contains key", ContainsKey},
+  {" contains", Contains},
+  {" like", Like},
+  {" in", In},
+}
+
+// parseFilter parses a filter string of the form accepted by Query.Filter.
+func parseFilter(filterStr string, value interface{}) (filter, error) {
+  filterStr = strings.TrimSpace(filterStr)
+  if len(filterStr) < 1 {
+    return filter{}, errors.New("datastore: invalid filter: " + filterStr)
+  }
+  for _, w := range wordOperators {
+    n := len(filterStr) - len(w.suffix)
+    if n <= 0 || !strings.EqualFold(filterStr[n:], w.suffix) {
+      continue
+    }
+    return newFilter(strings.TrimSpace(filterStr[:n]), w.op, value)
+  }
+  f := filter{
+    FieldName: strings.TrimRight(filterStr, " ><=!"),
+    Value:     value,
+  }
+  switch op := strings.TrimSpace(filterStr[len(f.FieldName):]); op {
+  case "<=":
+    f.Op = LessEq
+  case ">=":
+    f.Op = GreaterEq
+  case "<":
+    f.Op = LessThan
+  case ">":
+    f.Op = GreaterThan
+  case "=":
+    f.Op = Equal
+  default:
+    return filter{},
+      fmt.Errorf("datastore: invalid operator %q in filter %q", op, filterStr)
+  }
+  return f, nil
+}
+
+// newFilter returns the filter comparing the column fieldName to value with
+// the operator op.
+func newFilter(fieldName string, op Operator, value interface{}) (filter,
+  error) {
+
+  if fieldName == "" {
+    return filter{}, errors.New("datastore: empty field name in filter")
+  }
+  switch op {
+  case In:
+    return parseInFilter(fieldName, value)
+  case Like:
+    return parseLikeFilter(fieldName, value)
+  }
+  if _, ok := filterOpMapping[op]; !ok {
+    return filter{}, fmt.Errorf("datastore: invalid operator %d in filter "+
+      "on %s", op, fieldName)
+  }
+  return filter{FieldName: fieldName, Op: op, Value: value}, nil
+}
+
+// parseInFilter returns an IN filter on fieldName. value must be a slice or
+// an array holding the values to match, at least one as CQL has no empty
+// lists.
+func parseInFilter(fieldName string, value interface{}) (filter, error) {
+  if _, ok := value.(param); ok {
+    return filter{FieldName: fieldName, Op: In, Value: value}, nil
+  }
+  v := reflect.ValueOf(value)
+  if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+    return filter{},
+      fmt.Errorf("datastore: IN filter on %q needs a slice, got %T",
+        fieldName, value)
+  }
+  if v.Len() == 0 {
+    return filter{},
+      fmt.Errorf("datastore: IN filter on %q needs values", fieldName)
+  }
+  return filter{FieldName: fieldName, Op: In, Value: value}, nil
+}
+
+// parseLikeFilter returns a LIKE filter on fieldName. value must be a string
+// pattern.
+func parseLikeFilter(fieldName string, value interface{}) (filter, error) {
+  switch value.(type) {
+  case string, param:
+    return filter{FieldName: fieldName, Op: Like, Value: value}, nil
+  }
+  return filter{}, fmt.Errorf(
+    "datastore: LIKE filter on %q needs a string pattern, got %T",
+    fieldName, value)
 }
 
 // getWhereClause is a helper function to get the Where clause related info to
@@ -37,13 +146,42 @@ func getWhereClause(codec *structCodec, filters []filter) (
   }
   conditions := make([]string, len(filters))
   for i, filter := range filters {
-    _, ok := codec.byName[filter.FieldName]
-    if !ok {
+    if filter.token {
+      if len(codec.partitionKeys) == 0 {
+        return cond, args, fmt.Errorf(
+          "datastore: no partition key column in %s", codec.columnFamily)
+      }
+      conditions[i] = fmt.Sprintf("token(%s) %s %s",
+        strings.Join(codec.partitionKeys, ", "), filterOpMapping[filter.Op],
+        marker(filter.Value))
+      args = append(args, filter.Value)
+      continue
+    }
+    if !codec.hasColumn(filter.FieldName) {
       return cond, args,
         fmt.Errorf("query : fieldname %s not found", filter.FieldName)
     }
-    conditions[i] = fmt.Sprintf("%s %s ?", filter.FieldName,
-      filterOpMapping[filter.Op])
+    if _, ok := filter.Value.(param); ok && filter.Op == In {
+      // the whole list is bound to the marker
+      conditions[i] = fmt.Sprintf("%s IN %s", filter.FieldName,
+        marker(filter.Value))
+      args = append(args, filter.Value)
+      continue
+    }
+    if filter.Op == In {
+      // flatten the values so that each one gets its own bind marker
+      v := reflect.ValueOf(filter.Value)
+      markers := make([]string, v.Len())
+      for j := range markers {
+        markers[j] = "?"
+        args = append(args, v.Index(j).Interface())
+      }
+      conditions[i] = fmt.Sprintf("%s IN (%s)", filter.FieldName,
+        strings.Join(markers, ", "))
+      continue
+    }
+    conditions[i] = fmt.Sprintf("%s %s %s", filter.FieldName,
+      filterOpMapping[filter.Op], marker(filter.Value))
     args = append(args, filter.Value)
   }
   cond = " WHERE " + strings.Join(conditions, " AND ")
@@ -63,8 +201,30 @@ type order struct {
   Direction sortDirection
 }
 
-// NewQuery creates a new Query given an entity type.
-func NewQuery(typ reflect.Type) (*Query, error) {
+var sortDirectionMapping = map[sortDirection]string{
+  ascending:  "ASC",
+  descending: "DESC",
+}
+
+// getOrderClause is a helper function to get the ORDER BY clause to construct
+// CQL query.
+func getOrderClause(codec *structCodec, orders []order) (string, error) {
+  if len(orders) <= 0 {
+    return "", nil
+  }
+  clauses := make([]string, len(orders))
+  for i, o := range orders {
+    if !codec.hasColumn(o.FieldName) {
+      return "", fmt.Errorf("query : fieldname %s not found", o.FieldName)
+    }
+    clauses[i] = o.FieldName + " " + sortDirectionMapping[o.Direction]
+  }
+  return " ORDER BY " + strings.Join(clauses, ", "), nil
+}
+
+// NewQuery creates a new Query given an entity type. The options become the
+// defaults for every run of the query.
+func NewQuery(typ reflect.Type, opts ...Option) (*Query, error) {
   codec, err := getStructCodec(typ)
   if err != nil {
     return nil, err
@@ -72,6 +232,59 @@ func NewQuery(typ reflect.Type) (*Query, error) {
   return &Query{
     limit: -1,
     codec: codec,
+    opts:  opts,
+    stmt:  &queryStmt{},
+    table: newOptions(context.Background(), opts).tableOf(codec),
+  }, nil
+}
+
+// NewTableQuery creates a new Query on the column family table, for tools
+// reading tables whose columns are not known at compile time: the query
+// selects all the columns unless projected otherwise, its filters and
+// orders are not checked against the columns, and its rows are typically
+// read with Iterator.NextMap. As the partition key is not known either,
+// Distinct needs a projection and TokenRange is not supported.
+func NewTableQuery(table string, opts ...Option) (*Query, error) {
+  if table == "" {
+    return nil, errors.New("datastore: empty table name")
+  }
+  codec := &structCodec{
+    columnFamily: table,
+    byName:       make(map[string]fieldCodec),
+  }
+  return &Query{
+    limit: -1,
+    codec: codec,
+    opts:  opts,
+    stmt:  &queryStmt{},
+  }, nil
+}
+
+// rawStmt is the hand-written statement of a query created with Raw.
+type rawStmt struct {
+  cql  string
+  args []interface{}
+}
+
+// Raw creates a new Query executing the hand-written statement cql with
+// args, for statements the query builder cannot express. The rows are
+// decoded like the ones of the other queries, into entities of type typ,
+// or read with Iterator.NextMap if typ is nil. The derivative queries
+// changing the statement, such as Filter or Limit, are invalid and so are
+// Count and Aggregate, the other ones apply.
+func Raw(typ reflect.Type, cql string, args ...interface{}) (*Query, error) {
+  codec := &structCodec{byName: make(map[string]fieldCodec)}
+  if typ != nil {
+    var err error
+    if codec, err = getStructCodec(typ); err != nil {
+      return nil, err
+    }
+  }
+  return &Query{
+    limit: -1,
+    codec: codec,
+    stmt:  &queryStmt{},
+    raw:   &rawStmt{cql: cql, args: args},
   }, nil
 }
 
@@ -82,12 +295,58 @@ type Query struct {
   projection []string
   codec      *structCodec
   limit      int32
+  cacheMode  cacheMode
+  opts       []Option
+  // pageSize is the number of rows fetched per round trip, 0 meaning the
+  // session default.
+  pageSize  int
+  pageState []byte
+  // start is the cursor the results resume at, see Start.
+  start *Cursor
+  // allowFiltering is set to let Cassandra scan rows to serve the filters.
+  allowFiltering bool
+  // postFilter is set to apply the filters Cassandra cannot serve
+  // client-side, see PostFilter.
+  postFilter bool
+  // postSort is the maximum number of rows sorted client-side when
+  // Cassandra cannot serve the order, 0 meaning never, see PostSort.
+  postSort int
+  // distinct is set to yield the distinct partition keys only.
+  distinct bool
+  // perPartitionLimit limits the number of rows per partition, 0 meaning
+  // unlimited.
+  perPartitionLimit int32
+  // groupBy are the primary key columns the rows are grouped by.
+  groupBy []string
+  // json is set to select the rows as JSON documents.
+  json bool
+  // table is the table read when overridden by the Table or Keyspace
+  // options, see tableName.
+  table string
+  // stmt caches the statement of the query, see toCQL.
+  stmt *queryStmt
+  // params are the values bound to the named markers, see Param.
+  params map[string]interface{}
+  // raw is set for the queries executing a hand-written statement.
+  raw *rawStmt
 
   err error
 }
 
+// queryStmt is the statement of a query, built on first use. Queries are
+// immutable once built, so a query run repeatedly, e.g. one page after the
+// other, executes the same statement text and reuses the statement gocql
+// prepared for it.
+type queryStmt struct {
+  once sync.Once
+  cql  string
+  args []interface{}
+  err  error
+}
+
 func (q *Query) clone() *Query {
   x := *q
+  x.stmt = &queryStmt{}
   // Copy the contents of the slice-typed fields
   if len(q.filter) > 0 {
     x.filter = make([]filter, len(q.filter))
@@ -102,39 +361,61 @@ func (q *Query) clone() *Query {
 
 // Filter returns a derivative query with a field-based filter.
 // The filterStr argument must be a field name followed by optional space,
-// followed by an operator, one of ">", "<", ">=", "<=", or "=".
-// Fields are compared against the provided value using the operator.
+// followed by an operator, one of ">", "<", ">=", "<=", "=", "in", "like",
+// "contains" or "contains key".
+// Fields are compared against the provided value using the operator; for
+// "in" the value must be a non-empty slice of the values to match, for
+// "like" a pattern such as "foo%", which needs a SASI index on the column. The
+// "contains" operator matches the list, set and map columns holding the
+// value, "contains key" the map columns holding the key; both need a
+// secondary index on the column, or AllowFiltering.
 // Multiple filters are AND'ed together.
 func (q *Query) Filter(filterStr string, value interface{}) *Query {
   q = q.clone()
-  filterStr = strings.TrimSpace(filterStr)
-  if len(filterStr) < 1 {
-    q.err = errors.New("datastore: invalid filter: " + filterStr)
+  f, err := parseFilter(filterStr, value)
+  if err != nil {
+    q.err = err
     return q
   }
-  f := filter{
-    FieldName: strings.TrimRight(filterStr, " ><=!"),
-    Value:     value,
-  }
-  switch op := strings.TrimSpace(filterStr[len(f.FieldName):]); op {
-  case "<=":
-    f.Op = lessEq
-  case ">=":
-    f.Op = greaterEq
-  case "<":
-    f.Op = lessThan
-  case ">":
-    f.Op = greaterThan
-  case "=":
-    f.Op = equal
-  default:
-    q.err = fmt.Errorf("datastore: invalid operator %q in filter %q", op, filterStr)
+  q.filter = append(q.filter, f)
+  return q
+}
+
+// FilterField returns a derivative query with a filter comparing the field
+// fieldName to value with the operator op. It is like Filter without
+// parsing the field name and the operator out of a string, e.g.
+// q.FilterField("id", datastore.Equal, v) is q.Filter("id =", v).
+func (q *Query) FilterField(fieldName string, op Operator,
+  value interface{}) *Query {
+
+  q = q.clone()
+  f, err := newFilter(fieldName, op, value)
+  if err != nil {
+    q.err = err
     return q
   }
   q.filter = append(q.filter, f)
   return q
 }
 
+// The bounds of the token ring of the Murmur3 partitioner, the default one.
+const (
+  MinToken int64 = math.MinInt64
+  MaxToken int64 = math.MaxInt64
+)
+
+// TokenRange returns a derivative query restricted to the partitions whose
+// partition key token is greater than start and less than or equal to end.
+// Walking the ring range by range, from MinToken to MaxToken, is the way to
+// read a whole table without relying on the coordinator to page over it.
+func (q *Query) TokenRange(start, end int64) *Query {
+  q = q.clone()
+  q.filter = append(q.filter,
+    filter{Op: GreaterThan, Value: start, token: true},
+    filter{Op: LessEq, Value: end, token: true})
+  return q
+}
+
 // Order returns a derivative query with a field-based sort order. Orders are
 // applied in the order they are added. The default order is ascending; to sort
 // in descending order prefix the fieldName with a minus sign (-).
@@ -167,6 +448,60 @@ func (q *Query) Project(fieldNames ...string) *Query {
   return q
 }
 
+// KeysOnly returns a derivative query that yields only the primary key
+// columns, for cheap lookups ahead of Get, GetMulti or DeleteMulti calls:
+// its results load into partially populated entities, or into key values
+// with Iterator.NextKey.
+func (q *Query) KeysOnly() *Query {
+  if len(q.codec.partitionKeys) == 0 {
+    q = q.clone()
+    q.err = fmt.Errorf("datastore: no partition key column in %s",
+      q.codec.columnFamily)
+    return q
+  }
+  return q.Project(q.codec.keyColumns()...)
+}
+
+// Keyspace returns a derivative query reading the table of the keyspace
+// name instead of the keyspace of the session. It is a shorthand for passing
+// the Keyspace option.
+func (q *Query) Keyspace(name string) *Query {
+  q = q.clone()
+  q.opts = append(q.opts[:len(q.opts):len(q.opts)], Keyspace(name))
+  q.table = newOptions(context.Background(), q.opts).tableOf(q.codec)
+  return q
+}
+
+// tableName returns the table the query reads.
+func (q *Query) tableName() string {
+  if q.table != "" {
+    return q.table
+  }
+  return q.codec.columnFamily
+}
+
+// JSON returns a derivative query selecting each row as a JSON document
+// mapping the selected column names to their values, e.g. to proxy rows
+// straight to an HTTP API. The documents are read with Iterator.Next into a
+// *string or a *json.RawMessage, or with GetAll into a slice of those.
+func (q *Query) JSON() *Query {
+  q = q.clone()
+  q.json = true
+  return q
+}
+
+// GroupBy returns a derivative query grouping the rows by the columns cols,
+// a prefix of the primary key, to compute aggregates per group server-side.
+// The query typically projects the grouping columns and aggregates, e.g.
+// Project("item", "max(amount) AS top").GroupBy("item"), whose results are
+// read with Iterator.NextMap or into entity fields named after the aliases.
+// GROUP BY needs Cassandra 3.10 or later.
+func (q *Query) GroupBy(cols ...string) *Query {
+  q = q.clone()
+  q.groupBy = append([]string(nil), cols...)
+  return q
+}
+
 // Limit returns a derivative query that has a limit on the number of results
 // returned. A negative value means unlimited.
 func (q *Query) Limit(limit int) *Query {
@@ -180,102 +515,650 @@ func (q *Query) Limit(limit int) *Query {
 
 }
 
-var filterOpMapping = map[operator]string{
-  lessEq:      "<=",
-  greaterEq:   ">=",
-  lessThan:    "<",
-  greaterThan: ">",
-  equal:       "=",
+// PerPartitionLimit returns a derivative query that yields at most limit rows
+// of each partition, e.g. the latest N rows per key of a table clustered by
+// time in descending order. A non-positive value means unlimited.
+func (q *Query) PerPartitionLimit(limit int) *Query {
+  q = q.clone()
+  if limit > math.MaxInt32 {
+    q.err = errors.New("datastore: query limit overflow")
+    return q
+  }
+  if limit < 0 {
+    limit = 0
+  }
+  q.perPartitionLimit = int32(limit)
+  return q
+}
+
+// Distinct returns a derivative query that yields each partition once,
+// loading only the partition key columns, to enumerate the partitions of a
+// table. Projections must be made of partition key columns.
+func (q *Query) Distinct() *Query {
+  q = q.clone()
+  q.distinct = true
+  return q
+}
+
+// PageSize returns a derivative query fetching n rows per round trip.
+// Iteration transparently fetches the following pages; to serve one page per
+// request, stop after n rows and resume later with PageState.
+func (q *Query) PageSize(n int) *Query {
+  q = q.clone()
+  q.pageSize = n
+  return q
+}
+
+// PageState returns a derivative query resuming the results where the
+// iterator that returned state, see Iterator.PageState, stopped.
+func (q *Query) PageState(state []byte) *Query {
+  q = q.clone()
+  q.pageState = state
+  return q
+}
+
+// AllowFiltering returns a derivative query that lets Cassandra scan rows to
+// serve filters on columns that are neither keys nor indexed. Such queries
+// may read the whole table, so this must be asked for explicitly.
+func (q *Query) AllowFiltering() *Query {
+  q = q.clone()
+  q.allowFiltering = true
+  return q
+}
+
+// cacheMode tells how a query may use the entity cache.
+type cacheMode int
+
+const (
+  // cacheDefault serves results from the cache when present.
+  cacheDefault cacheMode = iota
+  // cacheBypass neither reads from nor writes to the cache.
+  cacheBypass
+  // cacheRefresh skips cached results but stores the fresh ones.
+  cacheRefresh
+)
+
+// NoCache returns a derivative query that bypasses the entity cache, see
+// EntityCache, entirely. Use it on consistency-critical paths. Like Get,
+// only First by primary key consults the cache, so it has no effect on
+// other reads, nor if no cache is enabled.
+func (q *Query) NoCache() *Query {
+  q = q.clone()
+  q.cacheMode = cacheBypass
+  return q
+}
+
+// RefreshCache returns a derivative query that ignores the rows cached in
+// the entity cache, see EntityCache, but caches the row it reads from
+// Cassandra. Like NoCache, it only affects First by primary key.
+func (q *Query) RefreshCache() *Query {
+  q = q.clone()
+  q.cacheMode = cacheRefresh
+  return q
+}
+
+var filterOpMapping = map[Operator]string{
+  LessEq:      "<=",
+  GreaterEq:   ">=",
+  LessThan:    "<",
+  GreaterThan: ">",
+  Equal:       "=",
+  In:          "IN",
+  Like:        "LIKE",
+  Contains:    "CONTAINS",
+  ContainsKey: "CONTAINS KEY",
+}
+
+// String returns the CQL spelling of op, e.g. "<=".
+func (op Operator) String() string {
+  if s, ok := filterOpMapping[op]; ok {
+    return s
+  }
+  return fmt.Sprintf("Operator(%d)", int(op))
 }
 
 // toCQL returns CQL query statement corresponding to the query q.
 func (q *Query) toCQL() (string, []interface{}, error) {
+  if q.stmt == nil {
+    cql, args, err := q.buildCQL()
+    if err != nil {
+      return "", nil, err
+    }
+    if args, err = bindParams(args, q.params); err != nil {
+      return "", nil, err
+    }
+    return cql, args, nil
+  }
+  q.stmt.once.Do(func() {
+    q.stmt.cql, q.stmt.args, q.stmt.err = q.buildCQL()
+  })
+  if q.stmt.err != nil {
+    return "", nil, q.stmt.err
+  }
+  // The values are copied as the caller may modify them.
+  args, err := bindParams(q.stmt.args, q.params)
+  if err != nil {
+    return "", nil, err
+  }
+  return q.stmt.cql, args, nil
+}
+
+// buildCQL builds the statement of the query.
+func (q *Query) buildCQL() (string, []interface{}, error) {
+  if q.err != nil {
+    return "", nil, q.err
+  }
+  if q.raw != nil {
+    if len(q.filter) > 0 || len(q.order) > 0 || len(q.projection) > 0 ||
+      q.limit >= 0 || q.perPartitionLimit > 0 || len(q.groupBy) > 0 ||
+      q.distinct || q.json || q.allowFiltering {
+      return "", nil, errors.New("datastore: raw query cannot be altered")
+    }
+    return q.raw.cql, q.raw.args, nil
+  }
   codec := q.codec
 
   var columnStr string
-  if len(q.projection) > 0 {
+  switch {
+  case q.distinct:
+    cols, err := q.distinctColumns()
+    if err != nil {
+      return "", nil, err
+    }
+    columnStr = "DISTINCT " + cols
+  case len(q.projection) > 0:
     columnStr = strings.Join(q.projection, ",")
-  } else {
+  case codec.typ == nil:
+    columnStr = "*"
+  default:
     columnStr = codec.getColumnStr()
   }
 
-  cql := fmt.Sprintf("SELECT %s FROM %s", columnStr, codec.columnFamily)
+  if q.json {
+    columnStr = "JSON " + columnStr
+  }
+  cql := fmt.Sprintf("SELECT %s FROM %s", columnStr, q.tableName())
 
   var args []interface{}
 
-  whereClause, whereArgs, err := getWhereClause(q.codec, q.filter)
+  filters, post := q.splitFilters()
+  if len(post) > 0 {
+    if err := q.checkPostFilters(post); err != nil {
+      return "", nil, err
+    }
+  }
+  whereClause, whereArgs, err := getWhereClause(q.codec, filters)
   if err != nil {
     return "", whereArgs, err
   }
   cql = cql + whereClause
   args = append(args, whereArgs...)
 
-  if q.limit > 0 {
+  if len(q.groupBy) > 0 {
+    if err := q.checkGroupBy(); err != nil {
+      return "", nil, err
+    }
+    cql = cql + " GROUP BY " + strings.Join(q.groupBy, ",")
+  }
+
+  postSort := q.sortsClientSide()
+  if postSort {
+    if err := q.checkPostSort(); err != nil {
+      return "", nil, err
+    }
+  } else {
+    orderClause, err := getOrderClause(q.codec, q.order)
+    if err != nil {
+      return "", nil, err
+    }
+    cql = cql + orderClause
+  }
+
+  if q.perPartitionLimit > 0 {
+    if q.distinct {
+      return "", nil, errors.New(
+        "datastore: PER PARTITION LIMIT on a DISTINCT query")
+    }
+    cql = cql + fmt.Sprintf(" PER PARTITION LIMIT %d", q.perPartitionLimit)
+  }
+
+  if q.limit > 0 && len(post) == 0 && !postSort {
+    // the limit of post-filtered or sorted queries applies to the filtered
+    // and sorted rows
     cql = cql + fmt.Sprintf(" LIMIT %d", q.limit)
   }
 
-  if len(q.order) > 0 {
-    // TODO (sunil): implement order by clause
+  if q.allowFiltering {
+    cql = cql + " ALLOW FILTERING"
   }
 
   return cql, args, nil
 }
 
-// Run returns Iterator by executing the query.
-func (q *Query) Run(session *gocql.Session) *Iterator {
+// checkGroupBy checks that the grouping columns are a prefix of the primary
+// key, as Cassandra requires.
+func (q *Query) checkGroupBy() error {
+  if q.codec.typ == nil {
+    // the primary key of tables queried by name is not known
+    return nil
+  }
+  keys := q.codec.keyColumns()
+  if len(q.groupBy) > len(keys) {
+    return errors.New("datastore: GROUP BY on non primary key columns")
+  }
+  for i, col := range q.groupBy {
+    if col != keys[i] {
+      return fmt.Errorf("datastore: GROUP BY column %s is not the primary "+
+        "key column %s", col, keys[i])
+    }
+  }
+  return nil
+}
+
+// distinctColumns returns the columns selected by a DISTINCT query, the
+// partition key columns unless projected otherwise.
+func (q *Query) distinctColumns() (string, error) {
+  if len(q.projection) == 0 {
+    if len(q.codec.partitionKeys) == 0 {
+      return "", fmt.Errorf("datastore: no partition key column in %s",
+        q.codec.columnFamily)
+    }
+    return strings.Join(q.codec.partitionKeys, ","), nil
+  }
+  for _, col := range q.projection {
+    if q.codec.typ == nil {
+      // the partition key of tables queried by name is not known
+      break
+    }
+    tag, ok := q.codec.byName[col]
+    if !ok || !q.codec.byIndex[tag.index].partitionKey {
+      return "", fmt.Errorf("datastore: DISTINCT on non partition key "+
+        "column %s", col)
+    }
+  }
+  return strings.Join(q.projection, ","), nil
+}
+
+// CQL returns the CQL statement of the query.
+func (q *Query) CQL() (string, error) {
+  cql, _, err := q.toCQL()
+  return cql, err
+}
+
+// Statement returns the CQL statement of the query along with the values
+// bound to its markers.
+func (q *Query) Statement() (string, []interface{}, error) {
+  return q.toCQL()
+}
+
+// Run returns Iterator by executing the query. The options override the ones
+// the query was created with.
+func (q *Query) Run(session Session, opts ...Option) *Iterator {
+  return q.RunContext(context.Background(), session, opts...)
+}
+
+// RunContext is like Run but executes the query with ctx.
+func (q *Query) RunContext(ctx context.Context, session Session,
+  opts ...Option) *Iterator {
 
+  o := newQueryOptions(ctx, q.opts, opts)
+  if table := o.tableOf(q.codec); table != q.tableName() {
+    // the table is overridden by the run options
+    q = q.clone()
+    q.table = table
+  }
   cql, args, err := q.toCQL()
   if err != nil {
     return &Iterator{err: err}
   }
+  _, post := q.splitFilters()
+  if post, err = bindPostFilters(post, q.params); err != nil {
+    return &Iterator{err: err}
+  }
+  hash := queryHash(cql, args)
+  if q.start != nil {
+    switch {
+    case q.start.hash != hash:
+      return &Iterator{err: ErrInvalidCursor}
+    case q.start.done:
+      // the cursor is at the end of the results
+      return &Iterator{err: Done, hash: hash}
+    }
+  }
 
-  cqlQ := session.Query(cql, args...)
-  iter := cqlQ.Iter()
-
+  stmt := newStatement(o, cql, args)
+  stmt.pageSize, stmt.pageState = q.pageSize, q.pageState
   t := &Iterator{
-    q:        q,
-    iter:     iter,
-    cql:      cql,
-    cqlQuery: cqlQ,
+    q:     q,
+    cql:   cql,
+    limit: q.limit,
+    post:  post,
+    sort:  q.sortsClientSide(),
+    hash:  hash,
   }
+  if q.narrowable() && len(post) == 0 && !t.sort {
+    t.deferRun(ctx, session, stmt)
+    return t
+  }
+  t.iter, t.cancel = run(ctx, session, stmt)
   return t
 }
 
-// First captures the first query result in dst object.
-func (q *Query) First(session *gocql.Session, dst interface{}) error {
-  iter := q.Run(session)
+// First captures the first query result in dst object, an entity or a
+// scalar pointer as accepted by Iterator.Next. ErrNoSuchEntity is returned
+// if nothing matches, dst being left untouched.
+func (q *Query) First(session Session, dst interface{},
+  opts ...Option) error {
+  return q.FirstContext(context.Background(), session, dst, opts...)
+}
+
+// FirstContext is like First but executes the query with ctx.
+func (q *Query) FirstContext(ctx context.Context, session Session,
+  dst interface{}, opts ...Option) error {
+
+  o := newQueryOptions(ctx, q.opts, opts)
+  if key, ok := q.cacheKey(o, dst); ok {
+    err := q.firstCached(ctx, session, o, key, dst, opts)
+    if err == Done {
+      return ErrNoSuchEntity
+    }
+    return err
+  }
+  iter := q.RunContext(ctx, session, opts...)
+  if iter.err == Done {
+    // the cursor is at the end of the results
+    return ErrNoSuchEntity
+  }
   if iter.err != nil {
     return iter.err
   }
-  iter.Next(dst)
+  if err := iter.Next(dst); err != nil {
+    iter.Close()
+    if err == Done {
+      return ErrNoSuchEntity
+    }
+    return err
+  }
   return iter.Close()
 }
 
+// GetAll runs the query and appends every result to dst, which must be a
+// *[]S or *[]*S where S is a struct type, or any column type when the query
+// projects a single column. It returns the number of results appended.
+func (q *Query) GetAll(session Session, dst interface{},
+  opts ...Option) (int, error) {
+  return q.GetAllContext(context.Background(), session, dst, opts...)
+}
+
+// GetAllContext is like GetAll but executes the query with ctx.
+func (q *Query) GetAllContext(ctx context.Context, session Session,
+  dst interface{}, opts ...Option) (int, error) {
+
+  dv := reflect.ValueOf(dst)
+  if dv.Kind() != reflect.Ptr || dv.IsNil() || dv.Elem().Kind() != reflect.Slice {
+    return 0, fmt.Errorf("datastore: dst must be a slice pointer, got %T", dst)
+  }
+  sv := dv.Elem()
+  elemType, isPtr := sv.Type().Elem(), false
+  if elemType.Kind() == reflect.Ptr {
+    elemType, isPtr = elemType.Elem(), true
+  }
+
+  iter := q.RunContext(ctx, session, opts...)
+  n := 0
+  for {
+    elem := reflect.New(elemType)
+    err := iter.Next(elem.Interface())
+    if err == Done {
+      break
+    }
+    if err != nil {
+      iter.Close()
+      return n, err
+    }
+    if isPtr {
+      sv.Set(reflect.Append(sv, elem))
+    } else {
+      sv.Set(reflect.Append(sv, elem.Elem()))
+    }
+    n++
+  }
+  return n, iter.Close()
+}
+
+// Count returns the number of rows matching the query filters by issuing a
+// SELECT COUNT(*) statement. Projection, order and limit are ignored.
+func (q *Query) Count(session Session, opts ...Option) (int64, error) {
+  return q.CountContext(context.Background(), session, opts...)
+}
+
+// CountContext is like Count but executes the query with ctx.
+func (q *Query) CountContext(ctx context.Context, session Session,
+  opts ...Option) (int64, error) {
+
+  var count int64
+  if err := q.AggregateContext(ctx, session, "count(*)", &count,
+    opts...); err != nil {
+    return 0, err
+  }
+  return count, nil
+}
+
+// Exists reports whether a row matches the query filters, selecting the
+// primary key columns of the first one only rather than decoding it.
+func (q *Query) Exists(session Session, opts ...Option) (bool, error) {
+  return q.ExistsContext(context.Background(), session, opts...)
+}
+
+// ExistsContext is like Exists but executes the query with ctx.
+func (q *Query) ExistsContext(ctx context.Context, session Session,
+  opts ...Option) (bool, error) {
+
+  e := q.Limit(1)
+  if q.narrowable() && !q.sortsClientSide() {
+    cols := q.codec.keyColumns()
+    if q.postFilter {
+      // the post filters are applied to the rows read
+      _, post := q.splitFilters()
+      for _, f := range post {
+        if !inColumns(cols, f.FieldName) {
+          cols = append(cols, f.FieldName)
+        }
+      }
+    }
+    e = e.Project(cols...)
+  }
+  iter := e.RunContext(ctx, session, opts...)
+  var err error
+  if q.codec.typ != nil {
+    // loaded like the results, for the post filters to apply
+    err = iter.Next(reflect.New(q.codec.typ).Interface())
+  } else {
+    err = iter.NextMap(make(map[string]interface{}))
+  }
+  if err != nil {
+    iter.Close()
+    if err == Done {
+      return false, nil
+    }
+    return false, err
+  }
+  return true, iter.Close()
+}
+
 // Iterator is the result of running a query.
 type Iterator struct {
-  session  *gocql.Session
-  iter     *gocql.Iter
-  cql      string
-  cqlQuery *gocql.Query
-  err      error
+  iter   Iter
+  cql    string
+  cancel context.CancelFunc
+  err    error
   // limit is the limit on the number of results this iterator should return.
   // A negative value means unlimited.
   limit int32
   // q is the original query which yielded this iterator.
   q *Query
+  // post are the filters applied client-side, see Query.PostFilter.
+  post []filter
+  // scanned and returned count the rows read and the results returned.
+  scanned  int
+  returned int32
+  // sort is set for the results sorted client-side, see Query.PostSort.
+  // They are buffered into rows on the first call to Next.
+  sort     bool
+  buffered bool
+  rows     []reflect.Value
+  // hash identifies the query in its cursors, see Cursor.
+  hash uint64
+  // start executes the statement if deferred until the first call to
+  // Next, see deferRun.
+  start func(dst interface{})
 }
 
 // Next returns row of the next result. When there are no more results,
 // Done is returned as the error.
+//
+// dst is an entity, or a pointer to a scalar such as *string, *int64 or
+// *gocql.UUID when the query projects a single column, for instance to
+// look up ids only. An entity of another type than the query's, a read
+// model with some of its columns, makes the query select these columns
+// only, if passed to the first call to Next of a query that is not
+// projected otherwise.
 func (t *Iterator) Next(dst interface{}) error {
-  iter := t.iter
-  return LoadEntity(dst, iter)
+  t.started(dst)
+  if t.err != nil {
+    return t.err
+  }
+  if t.sort {
+    return t.nextSorted(dst)
+  }
+  if len(t.post) > 0 {
+    return t.nextFiltered(dst)
+  }
+  var err error
+  if isScalarDest(dst) {
+    err = nextScalar(dst, t.iter)
+  } else {
+    err = LoadEntity(dst, t.iter)
+  }
+  if err == nil {
+    t.scanned++
+    t.returned++
+  }
+  return err
+}
+
+// Scanned returns the number of rows read so far, including the rows
+// dropped by the post filters of the query, see Query.PostFilter.
+func (t *Iterator) Scanned() int {
+  return t.scanned
+}
+
+// isScalarDest reports whether dst is a pointer to a single column value
+// rather than an entity.
+func isScalarDest(dst interface{}) bool {
+  if _, ok := dst.(ColumnLoadSaver); ok {
+    return false
+  }
+  t := reflect.TypeOf(dst)
+  return t != nil && t.Kind() == reflect.Ptr && !isUDT(t.Elem())
+}
+
+// nextScalar scans the single column of the next row from iter into dst.
+func nextScalar(dst interface{}, iter Iter) error {
+  rowData, err := iter.RowData()
+  if err != nil {
+    return err
+  }
+  if len(rowData.Columns) != 1 {
+    return fmt.Errorf("datastore: cannot load %d columns into %T",
+      len(rowData.Columns), dst)
+  }
+  if iter.Scan(dst) {
+    return nil
+  }
+  if err := iter.Close(); err != nil {
+    return err
+  }
+  return Done
+}
+
+// NextKey returns the primary key values of the next result, in the order
+// Get takes them, typically of a KeysOnly query. When there are no more
+// results, Done is returned as the error.
+func (t *Iterator) NextKey() ([]interface{}, error) {
+  if t.q == nil {
+    // the query failed to run
+    return nil, t.err
+  }
+  if t.q.codec.typ == nil {
+    return nil, fmt.Errorf("datastore: no primary key known in %s",
+      t.q.codec.columnFamily)
+  }
+  e := reflect.New(t.q.codec.typ).Interface()
+  if err := t.Next(e); err != nil {
+    return nil, err
+  }
+  _, vals, err := KeyOf(e)
+  return vals, err
+}
+
+// NextMap loads the columns of the next result into m, keyed by column
+// name, like gocql's MapScan. When there are no more results, Done is
+// returned as the error.
+func (t *Iterator) NextMap(m map[string]interface{}) error {
+  t.started(nil)
+  if t.err != nil {
+    return t.err
+  }
+  rowData, err := t.iter.RowData()
+  if err != nil {
+    return err
+  }
+  if !t.iter.Scan(rowData.Values...) {
+    if err := t.iter.Close(); err != nil {
+      return err
+    }
+    return Done
+  }
+  for i, col := range rowData.Columns {
+    m[col] = reflect.ValueOf(rowData.Values[i]).Elem().Interface()
+  }
+  return nil
+}
+
+// PageState returns the paging state positioned after the current page of
+// results, to be passed to Query.PageState. It is nil once the last page has
+// been fetched.
+func (t *Iterator) PageState() []byte {
+  t.started(nil)
+  if t.iter == nil {
+    return nil
+  }
+  return t.iter.PageState()
+}
+
+// Warnings returns the warnings Cassandra sent along with the results, e.g.
+// about an aggregation without partition key or a large batch, to log them.
+// They are nil for sessions that do not report them, NewSession's do, and
+// for results served from the cache.
+func (t *Iterator) Warnings() []string {
+  t.started(nil)
+  if t.iter == nil {
+    return nil
+  }
+  return iterWarnings(t.iter)
 }
 
 // Close closed the iterator.
 func (t *Iterator) Close() error {
+  // a statement not executed yet is not executed at all
+  t.start = nil
+  if t.iter == nil {
+    if t.err == Done {
+      return nil
+    }
+    return t.err
+  }
+  defer t.cancel()
   return t.iter.Close()
 }
-
-// Done is returned when a query iteration has completed.
-var Done = errors.New("datastore: query has no more results")
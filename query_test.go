@@ -0,0 +1,65 @@
+package datastore_test
+
+import (
+  "reflect"
+  "strings"
+  "testing"
+
+  "github.com/droot/datastore"
+  "github.com/droot/datastore/memstore"
+)
+
+type tweet struct {
+  ColumnFamily string `cql:"tweets"`
+  ID           string `cql:"id,pk"`
+  Text         string `cql:"text"`
+}
+
+func TestFilterEmptyIn(t *testing.T) {
+  s := memstore.New()
+  if err := s.Register(reflect.TypeOf(tweet{})); err != nil {
+    t.Fatal(err)
+  }
+  if err := datastore.SaveEntity(s, &tweet{ID: "a"}); err != nil {
+    t.Fatal(err)
+  }
+  q, err := datastore.NewQuery(reflect.TypeOf(tweet{}))
+  if err != nil {
+    t.Fatal(err)
+  }
+  iter := q.Filter("id in", []string{}).Run(s)
+  var tw tweet
+  err = iter.Next(&tw)
+  iter.Close()
+  if err == nil || !strings.Contains(err.Error(), "needs values") {
+    t.Fatalf("got %v, want an empty IN error", err)
+  }
+}
+
+func TestFirstAtEndCursor(t *testing.T) {
+  s := memstore.New()
+  if err := s.Register(reflect.TypeOf(tweet{})); err != nil {
+    t.Fatal(err)
+  }
+  if err := datastore.SaveEntity(s, &tweet{ID: "a"}); err != nil {
+    t.Fatal(err)
+  }
+  q, err := datastore.NewQuery(reflect.TypeOf(tweet{}))
+  if err != nil {
+    t.Fatal(err)
+  }
+  iter := q.Run(s)
+  var tw tweet
+  for err = iter.Next(&tw); err == nil; err = iter.Next(&tw) {
+  }
+  if err != datastore.Done {
+    t.Fatal(err)
+  }
+  c, err := iter.Cursor()
+  if err != nil {
+    t.Fatal(err)
+  }
+  if err := q.Start(c).First(s, &tw); err != datastore.ErrNoSuchEntity {
+    t.Errorf("got %v, want ErrNoSuchEntity", err)
+  }
+}
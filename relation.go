@@ -0,0 +1,245 @@
+package datastore
+
+import (
+  "context"
+  "fmt"
+  "reflect"
+  "strings"
+)
+
+// relation is a field holding the related entity whose primary key is held
+// by columns of the entity, declared with the ref option.
+type relation struct {
+  // index is the index path of the field in the struct.
+  index []int
+  // typ is the struct type of the related entity, ptr is set for pointer
+  // fields.
+  typ reflect.Type
+  ptr bool
+  // columns hold the primary key of the related entity, in key order.
+  columns []string
+}
+
+// addRelation adds the related entity field f of the ref option tag.
+func (c *structCodec) addRelation(tag *structTag, f reflect.StructField) error {
+  t := f.Type
+  if t.Kind() == reflect.Ptr {
+    t = t.Elem()
+  }
+  if t.Kind() != reflect.Struct || t == typeOfTime {
+    return fmt.Errorf("datastore: ref option on non struct field %s", f.Name)
+  }
+  for _, col := range tag.ref {
+    if col == "" {
+      return fmt.Errorf("datastore: invalid ref option of field %s", f.Name)
+    }
+  }
+  if _, ok := c.relations[f.Name]; ok {
+    return fmt.Errorf("datastore: duplicate related field %s in %v", f.Name,
+      c.typ)
+  }
+  if c.relations == nil {
+    c.relations = make(map[string]*relation)
+  }
+  c.relations[f.Name] = &relation{
+    index:   tag.index,
+    typ:     t,
+    ptr:     f.Type.Kind() == reflect.Ptr,
+    columns: tag.ref,
+  }
+  return nil
+}
+
+// relatedBatch is the number of related entities looked up per query.
+const relatedBatch = 100
+
+// LoadRelated loads the related entity of the field named field of each of
+// entities, a slice of structs or struct pointers of column family kind, or
+// a pointer to one. The field is declared with the ref option, listing the
+// columns of the entity holding the primary key of the related entity:
+//
+//   type Tweet struct {
+//     ColumnFamily string     `cql:"tweet"`
+//     Id           gocql.UUID `cql:"id,pk"`
+//     AuthorId     string     `cql:"author_id"`
+//     Author       *User      `cql:",ref=author_id"`
+//   }
+//
+//   err := datastore.LoadRelated(session, tweets, "Author")
+//
+// The related entities are looked up in batches of 100 keys, with an IN
+// query per batch rather than a query per entity. The field is reset for the
+// entities whose related entity does not exist or whose key columns are
+// null. Pointer fields referring to the same entity share it.
+func LoadRelated(session Session, entities interface{}, field string,
+  opts ...Option) error {
+  return LoadRelatedContext(context.Background(), session, entities, field,
+    opts...)
+}
+
+// LoadRelatedContext is like LoadRelated but executes the queries with ctx.
+func LoadRelatedContext(ctx context.Context, session Session,
+  entities interface{}, field string, opts ...Option) error {
+
+  v := reflect.ValueOf(entities)
+  if v.Kind() == reflect.Ptr {
+    v = v.Elem()
+  }
+  if v.Kind() != reflect.Slice {
+    return fmt.Errorf("datastore: LoadRelated of %T, want a slice",
+      entities)
+  }
+  et := v.Type().Elem()
+  if et.Kind() == reflect.Ptr {
+    et = et.Elem()
+  }
+  codec, err := getStructCodec(et)
+  if err != nil {
+    return err
+  }
+  rel, ok := codec.relations[field]
+  if !ok {
+    return fmt.Errorf("datastore: no related field %s in %v", field, et)
+  }
+  target, err := getStructCodec(rel.typ)
+  if err != nil {
+    return err
+  }
+  keys := target.keyColumns()
+  if len(keys) != len(rel.columns) {
+    return fmt.Errorf("datastore: related field %s has %d key columns, "+
+      "the primary key of %v %d", field, len(rel.columns), rel.typ,
+      len(keys))
+  }
+  keyTypes := make([]reflect.Type, len(keys))
+  for i, k := range keys {
+    keyTypes[i] = target.typ.FieldByIndex(
+      target.byIndex[target.byName[k].index].index).Type
+  }
+
+  // the keys of the related entities, by entity and distinct
+  refs := make([]string, v.Len())
+  var distinct [][]reflect.Value
+  seen := make(map[string]bool)
+  for i := range refs {
+    e := v.Index(i)
+    if e.Kind() == reflect.Ptr {
+      if e.IsNil() {
+        continue
+      }
+      e = e.Elem()
+    }
+    vals, err := relatedKey(codec, e, rel.columns, keyTypes)
+    if err != nil {
+      return err
+    }
+    if vals == nil {
+      continue
+    }
+    refs[i] = keyString(vals)
+    if !seen[refs[i]] {
+      seen[refs[i]] = true
+      distinct = append(distinct, vals)
+    }
+  }
+
+  q, err := NewQuery(rel.typ, opts...)
+  if err != nil {
+    return err
+  }
+  found := make(map[string]reflect.Value, len(distinct))
+  for start := 0; start < len(distinct); start += relatedBatch {
+    batch := distinct[start:]
+    if len(batch) > relatedBatch {
+      batch = batch[:relatedBatch]
+    }
+    bq := q
+    for i, k := range keys {
+      bq = bq.Filter(k+" in", inValues(batch, i, keyTypes[i]))
+    }
+    // IN filters on several columns select the cartesian product of their
+    // values, the rows of other keys are ignored
+    rows := reflect.New(reflect.SliceOf(rel.typ))
+    if _, err := bq.GetAllContext(ctx, session, rows.Interface()); err != nil {
+      return err
+    }
+    for i := 0; i < rows.Elem().Len(); i++ {
+      row := rows.Elem().Index(i)
+      vals, err := relatedKey(target, row, keys, keyTypes)
+      if err != nil {
+        return err
+      }
+      if k := keyString(vals); seen[k] {
+        found[k] = row.Addr()
+      }
+    }
+  }
+
+  for i := range refs {
+    e := v.Index(i)
+    if e.Kind() == reflect.Ptr {
+      if e.IsNil() {
+        continue
+      }
+      e = e.Elem()
+    }
+    f := e.FieldByIndex(rel.index)
+    p, ok := found[refs[i]]
+    switch {
+    case !ok:
+      f.Set(reflect.Zero(f.Type()))
+    case rel.ptr:
+      f.Set(p)
+    default:
+      f.Set(p.Elem())
+    }
+  }
+  return nil
+}
+
+// relatedKey returns the values of the columns cols of the struct e of the
+// type of codec, converted to the key types of the related entity, nil if
+// one is null.
+func relatedKey(codec *structCodec, e reflect.Value, cols []string,
+  types []reflect.Type) ([]reflect.Value, error) {
+
+  vals := make([]reflect.Value, len(cols))
+  for i, col := range cols {
+    f := e.FieldByIndex(codec.byIndex[codec.byName[col].index].index)
+    if f.Kind() == reflect.Ptr {
+      if f.IsNil() {
+        return nil, nil
+      }
+      f = f.Elem()
+    }
+    if !f.Type().ConvertibleTo(types[i]) {
+      return nil, fmt.Errorf("datastore: column %s of %v cannot hold a "+
+        "key of type %v", col, codec.typ, types[i])
+    }
+    vals[i] = f.Convert(types[i])
+  }
+  return vals, nil
+}
+
+// keyString returns the string identifying the key vals.
+func keyString(vals []reflect.Value) string {
+  parts := make([]string, len(vals))
+  for i, v := range vals {
+    parts[i] = fmt.Sprintf("%#v", v.Interface())
+  }
+  return strings.Join(parts, "\x00")
+}
+
+// inValues returns the distinct values of the i'th column of keys, in a
+// slice of type typ.
+func inValues(keys [][]reflect.Value, i int, typ reflect.Type) interface{} {
+  vals := reflect.MakeSlice(reflect.SliceOf(typ), 0, len(keys))
+  seen := make(map[string]bool, len(keys))
+  for _, key := range keys {
+    if s := keyString(key[i : i+1]); !seen[s] {
+      seen[s] = true
+      vals = reflect.Append(vals, key[i])
+    }
+  }
+  return vals.Interface()
+}
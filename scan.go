@@ -0,0 +1,147 @@
+package datastore
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "reflect"
+  "sync"
+)
+
+// Scanner reads all the rows of a query in parallel: the token ring is split
+// into ranges, see Query.TokenRange, read concurrently. It suits analytics
+// and backfill jobs over large column families.
+type Scanner struct {
+  q           *Query
+  splits      int
+  concurrency int
+}
+
+// NewScanner returns a scanner reading the rows matching q. The filters of
+// q must not restrict the partition key and its limit applies per range.
+// The ring is split into 64 ranges read 8 at a time unless configured
+// otherwise. q must be a query of an entity type, the rows of the tables
+// queried by name having no type to be loaded into.
+func NewScanner(q *Query) (*Scanner, error) {
+  if q.codec.typ == nil {
+    return nil, fmt.Errorf("datastore: scanner of table %s needs an entity "+
+      "type", q.codec.columnFamily)
+  }
+  return &Scanner{q: q, splits: 64, concurrency: 8}, nil
+}
+
+// Splits returns a derivative scanner splitting the token ring into n
+// ranges. More ranges mean smaller, more evenly sized reads.
+func (s *Scanner) Splits(n int) *Scanner {
+  x := *s
+  x.splits = n
+  return &x
+}
+
+// Concurrency returns a derivative scanner reading at most n ranges at a
+// time.
+func (s *Scanner) Concurrency(n int) *Scanner {
+  x := *s
+  x.concurrency = n
+  return &x
+}
+
+// tokenRanges splits the token ring into n contiguous ranges, returned as
+// the (start, end] bounds of TokenRange.
+func tokenRanges(n int) [][2]int64 {
+  ranges := make([][2]int64, n)
+  width := ^uint64(0) / uint64(n)
+  start := MinToken
+  for i := range ranges {
+    end := int64(uint64(start) + width)
+    if i == n-1 {
+      end = MaxToken
+    }
+    ranges[i] = [2]int64{start, end}
+    start = end
+  }
+  return ranges
+}
+
+// Run reads the rows and calls fn with each of them, loaded into a new
+// entity of the query type, as a pointer. fn is called concurrently from
+// the goroutines reading the ranges. The scan stops at the first error, be
+// it returned by fn or met reading the rows, and returns it.
+func (s *Scanner) Run(session Session, fn func(entity interface{}) error,
+  opts ...Option) error {
+  return s.RunContext(context.Background(), session, fn, opts...)
+}
+
+// RunContext is like Run but executes the queries with ctx.
+func (s *Scanner) RunContext(ctx context.Context, session Session,
+  fn func(entity interface{}) error, opts ...Option) error {
+
+  if s.q.err != nil {
+    return s.q.err
+  }
+  if s.splits <= 0 || s.concurrency <= 0 {
+    return errors.New("datastore: scanner needs positive splits and " +
+      "concurrency")
+  }
+  ctx, cancel := context.WithCancel(ctx)
+  defer cancel()
+
+  var (
+    wg       sync.WaitGroup
+    errOnce  sync.Once
+    firstErr error
+  )
+  fail := func(err error) {
+    errOnce.Do(func() {
+      firstErr = err
+      cancel()
+    })
+  }
+  sem := make(chan struct{}, s.concurrency)
+  for _, r := range tokenRanges(s.splits) {
+    select {
+    case sem <- struct{}{}:
+    case <-ctx.Done():
+    }
+    if ctx.Err() != nil {
+      break
+    }
+    wg.Add(1)
+    go func(r [2]int64) {
+      defer func() {
+        <-sem
+        wg.Done()
+      }()
+      if err := s.scanRange(ctx, session, r, fn, opts); err != nil {
+        fail(err)
+      }
+    }(r)
+  }
+  wg.Wait()
+  if firstErr == nil {
+    // the parent context may have been cancelled
+    firstErr = ctx.Err()
+  }
+  return firstErr
+}
+
+// scanRange reads the rows of the token range r.
+func (s *Scanner) scanRange(ctx context.Context, session Session,
+  r [2]int64, fn func(entity interface{}) error, opts []Option) error {
+
+  it := s.q.TokenRange(r[0], r[1]).RunContext(ctx, session, opts...)
+  for {
+    dst := reflect.New(s.q.codec.typ).Interface()
+    err := it.Next(dst)
+    if err == Done {
+      return it.Close()
+    }
+    if err == nil {
+      err = fn(dst)
+    }
+    if err != nil {
+      it.Close()
+      return err
+    }
+  }
+}
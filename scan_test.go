@@ -0,0 +1,25 @@
+package datastore_test
+
+import (
+  "reflect"
+  "testing"
+
+  "github.com/droot/datastore"
+)
+
+func TestNewScanner(t *testing.T) {
+  q, err := datastore.NewQuery(reflect.TypeOf(tweet{}))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if _, err := datastore.NewScanner(q); err != nil {
+    t.Fatal(err)
+  }
+  q, err = datastore.NewTableQuery("tweets")
+  if err != nil {
+    t.Fatal(err)
+  }
+  if _, err := datastore.NewScanner(q); err == nil {
+    t.Fatal("got a scanner of a table query")
+  }
+}
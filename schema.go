@@ -0,0 +1,431 @@
+package datastore
+
+import (
+  "context"
+  "fmt"
+  "math/big"
+  "net"
+  "reflect"
+  "strings"
+  "time"
+
+  "github.com/gocql/gocql"
+  "gopkg.in/inf.v0"
+)
+
+var (
+  typeOfTime     = reflect.TypeOf(time.Time{})
+  typeOfUUID     = reflect.TypeOf(gocql.UUID{})
+  typeOfBytes    = reflect.TypeOf([]byte(nil))
+  typeOfIP       = reflect.TypeOf(net.IP(nil))
+  typeOfBigInt   = reflect.TypeOf(big.Int{})
+  typeOfDec      = reflect.TypeOf(inf.Dec{})
+  typeOfDuration = reflect.TypeOf(gocql.Duration{})
+  typeOfDate     = reflect.TypeOf(Date{})
+  typeOfTOD      = reflect.TypeOf(TimeOfDay{})
+)
+
+// cqlTypeOf returns the CQL type a Go type is stored as by default.
+func cqlTypeOf(t reflect.Type) (string, error) {
+  switch t {
+  case typeOfTime:
+    return "timestamp", nil
+  case typeOfUUID:
+    return "uuid", nil
+  case typeOfBytes:
+    return "blob", nil
+  case typeOfIP:
+    return "inet", nil
+  case typeOfBigInt:
+    return "varint", nil
+  case typeOfDec:
+    return "decimal", nil
+  case typeOfDuration:
+    return "duration", nil
+  case typeOfDate:
+    return "date", nil
+  case typeOfTOD:
+    return "time", nil
+  }
+  switch t.Kind() {
+  case reflect.Ptr:
+    return cqlTypeOf(t.Elem())
+  case reflect.String:
+    return "text", nil
+  case reflect.Bool:
+    return "boolean", nil
+  case reflect.Int, reflect.Int64:
+    return "bigint", nil
+  case reflect.Int32:
+    return "int", nil
+  case reflect.Int16:
+    return "smallint", nil
+  case reflect.Int8:
+    return "tinyint", nil
+  case reflect.Float32:
+    return "float", nil
+  case reflect.Float64:
+    return "double", nil
+  case reflect.Slice, reflect.Array:
+    if t.Elem().Kind() == reflect.Uint8 {
+      return "blob", nil
+    }
+    elem, err := cqlElemTypeOf(t.Elem())
+    if err != nil {
+      return "", err
+    }
+    return fmt.Sprintf("list<%s>", elem), nil
+  case reflect.Map:
+    key, err := cqlElemTypeOf(t.Key())
+    if err != nil {
+      return "", err
+    }
+    elem, err := cqlElemTypeOf(t.Elem())
+    if err != nil {
+      return "", err
+    }
+    return fmt.Sprintf("map<%s, %s>", key, elem), nil
+  case reflect.Struct:
+    if isUDT(t) {
+      return strings.ToLower(t.Name()), nil
+    }
+  }
+  return "", fmt.Errorf("datastore: no CQL type for %v, use a type= tag", t)
+}
+
+// cqlElemTypeOf returns the CQL type of the elements of a collection of
+// Go type t. Collections and UDTs nested in collections must be frozen.
+func cqlElemTypeOf(t reflect.Type) (string, error) {
+  cqlType, err := cqlTypeOf(t)
+  if err != nil || !isFreezable(t) {
+    return cqlType, err
+  }
+  return frozen(cqlType), nil
+}
+
+// frozen returns the frozen version of the CQL type cqlType.
+func frozen(cqlType string) string {
+  if strings.HasPrefix(cqlType, "frozen<") {
+    return cqlType
+  }
+  return "frozen<" + cqlType + ">"
+}
+
+// ColumnDef describes a column of the table an entity type is stored in.
+type ColumnDef struct {
+  Name string
+  // Type is the CQL type of the column.
+  Type          string
+  PartitionKey  bool
+  ClusteringKey bool
+  // Descending is set for clustering columns sorted in descending order.
+  Descending bool
+}
+
+// TableDef describes the table an entity type is stored in.
+type TableDef struct {
+  Name string
+  // Columns lists the columns in struct field order.
+  Columns []ColumnDef
+  // Indexes lists the secondary indexes declared with the index option.
+  Indexes []IndexDef
+}
+
+// IndexDef describes a secondary index on a column.
+type IndexDef struct {
+  Name   string
+  Column string
+}
+
+// GetTableDef returns the table definition derived from the entity type typ.
+// The primary key is made of the columns tagged with the pk and ck options,
+// in field order, and the column types are derived from the field types
+// unless given with the type= option. Collection and UDT columns are frozen
+// with the frozen option, and always when part of the primary key or nested
+// in a collection.
+func GetTableDef(typ reflect.Type) (*TableDef, error) {
+  codec, err := getStructCodec(typ)
+  if err != nil {
+    return nil, err
+  }
+  def := &TableDef{Name: codec.columnFamily}
+  for _, tag := range codec.byIndex {
+    if !tag.stored() {
+      continue
+    }
+    cqlType := tag.cqlType
+    if cqlType == "" && tag.tuple {
+      cqlType, err = tupleTypeOf(codec.typ.FieldByIndex(tag.index).Type)
+      if err != nil {
+        return nil, err
+      }
+    }
+    if cqlType == "" && tag.udt != "" {
+      cqlType = tag.udt
+    }
+    if cqlType == "" && tag.counter {
+      cqlType = "counter"
+    }
+    if cqlType == "" {
+      cqlType, err = cqlTypeOf(codec.typ.FieldByIndex(tag.index).Type)
+      if err != nil {
+        return nil, err
+      }
+      if tag.collection == "set" {
+        cqlType = "set" + strings.TrimPrefix(cqlType, "list")
+      }
+    }
+    if tag.frozen || (tag.partitionKey || tag.clusteringKey) &&
+      isFreezable(codec.typ.FieldByIndex(tag.index).Type) {
+      // collections and UDTs in the primary key must be frozen
+      cqlType = frozen(cqlType)
+    }
+    def.Columns = append(def.Columns, ColumnDef{
+      Name:          tag.name,
+      Type:          cqlType,
+      PartitionKey:  tag.partitionKey,
+      ClusteringKey: tag.clusteringKey && !tag.partitionKey,
+      Descending:    tag.descending,
+    })
+    if tag.indexed {
+      def.Indexes = append(def.Indexes, indexDef(codec, tag))
+    }
+  }
+  return def, nil
+}
+
+// TypeDef describes a user-defined type the nested structs of an entity
+// type are stored in.
+type TypeDef struct {
+  Name string
+  // Fields lists the fields of the type in struct field order, described
+  // by their name and CQL type.
+  Fields []ColumnDef
+}
+
+// GetTypeDefs returns the definitions of the user-defined types the columns
+// of the entity type typ are stored in, see GetTableDef, the types nested
+// in others coming first. The fields of a type are mapped from the fields
+// of its struct like columns, by their cql tag names, collections and
+// nested types being frozen.
+func GetTypeDefs(typ reflect.Type) ([]*TypeDef, error) {
+  codec, err := getStructCodec(typ)
+  if err != nil {
+    return nil, err
+  }
+  var defs []*TypeDef
+  seen := make(map[string]bool)
+  for _, tag := range codec.byIndex {
+    if !tag.stored() || tag.tuple || tag.counter {
+      continue
+    }
+    ft := codec.typ.FieldByIndex(tag.index).Type
+    if tag.udt != "" {
+      if ft.Kind() == reflect.Ptr {
+        ft = ft.Elem()
+      }
+      defs, err = appendTypeDef(defs, seen, tag.udt, ft, nil)
+    } else {
+      defs, err = appendNestedTypeDefs(defs, seen, ft, nil)
+    }
+    if err != nil {
+      return nil, err
+    }
+  }
+  return defs, nil
+}
+
+// appendNestedTypeDefs appends to defs the definitions of the user-defined
+// types of the structs held by a value of type t, but the ones in seen.
+// path lists the types being defined, which t must not be.
+func appendNestedTypeDefs(defs []*TypeDef, seen map[string]bool,
+  t reflect.Type, path []reflect.Type) ([]*TypeDef, error) {
+
+  switch t.Kind() {
+  case reflect.Ptr, reflect.Slice, reflect.Array:
+    return appendNestedTypeDefs(defs, seen, t.Elem(), path)
+  case reflect.Map:
+    defs, err := appendNestedTypeDefs(defs, seen, t.Key(), path)
+    if err != nil {
+      return nil, err
+    }
+    return appendNestedTypeDefs(defs, seen, t.Elem(), path)
+  case reflect.Struct:
+    if isUDT(t) {
+      return appendTypeDef(defs, seen, strings.ToLower(t.Name()), t, path)
+    }
+  }
+  return defs, nil
+}
+
+// appendTypeDef appends to defs the definition of the user-defined type
+// name of the struct type t, after the ones of its nested types, unless
+// seen.
+func appendTypeDef(defs []*TypeDef, seen map[string]bool, name string,
+  t reflect.Type, path []reflect.Type) ([]*TypeDef, error) {
+
+  for _, p := range path {
+    if p == t {
+      return nil, fmt.Errorf("datastore: recursive user-defined type %v", t)
+    }
+  }
+  if seen[name] {
+    return defs, nil
+  }
+  path = append(path, t)
+  def := &TypeDef{Name: name}
+  for i := 0; i < t.NumField(); i++ {
+    f := t.Field(i)
+    tag := f.Tag.Get("cql")
+    if ii := strings.Index(tag, ","); ii != -1 {
+      tag = tag[:ii]
+    }
+    if tag == "-" || f.PkgPath != "" {
+      continue
+    }
+    if tag == "" {
+      tag = f.Name
+    }
+    var err error
+    defs, err = appendNestedTypeDefs(defs, seen, f.Type, path)
+    if err != nil {
+      return nil, err
+    }
+    // the collections and types nested in a type must be frozen
+    cqlType, err := cqlElemTypeOf(f.Type)
+    if err != nil {
+      return nil, err
+    }
+    def.Fields = append(def.Fields, ColumnDef{Name: tag, Type: cqlType})
+  }
+  seen[name] = true
+  return append(defs, def), nil
+}
+
+// CreateTableCQL returns the CREATE TABLE IF NOT EXISTS statement of the
+// column family represented by typ, see GetTableDef, or of the table given
+// with the Table option, in the keyspace given with the Keyspace option.
+func CreateTableCQL(typ reflect.Type, opts ...Option) (string, error) {
+  def, err := GetTableDef(typ)
+  if err != nil {
+    return "", err
+  }
+  def.Name = newOptions(context.Background(), opts).qualify(def.Name)
+  if !hasPartitionKey(def) {
+    return "", fmt.Errorf("datastore: no partition key column in %v", typ)
+  }
+  return tableCQL(def), nil
+}
+
+// CreateTypesCQL returns the CREATE TYPE IF NOT EXISTS statements of the
+// user-defined types the columns of the entity type typ are stored in, see
+// GetTypeDefs, to execute in order before creating its table. The types
+// are created in the keyspace given with the Keyspace option, the one of
+// the table.
+func CreateTypesCQL(typ reflect.Type, opts ...Option) ([]string, error) {
+  defs, err := GetTypeDefs(typ)
+  if err != nil {
+    return nil, err
+  }
+  o := newOptions(context.Background(), opts)
+  stmts := make([]string, len(defs))
+  for i, def := range defs {
+    if o.keyspace != "" {
+      qualified := *def
+      qualified.Name = o.keyspace + "." + def.Name
+      def = &qualified
+    }
+    stmts[i] = TypeCQL(def)
+  }
+  return stmts, nil
+}
+
+// TypeCQL returns the CREATE TYPE IF NOT EXISTS statement of the
+// user-defined type def.
+func TypeCQL(def *TypeDef) string {
+  fields := make([]string, len(def.Fields))
+  for i, f := range def.Fields {
+    fields[i] = f.Name + " " + f.Type
+  }
+  return fmt.Sprintf("CREATE TYPE IF NOT EXISTS %s (%s)", def.Name,
+    strings.Join(fields, ", "))
+}
+
+// hasPartitionKey reports whether the table def has a partition key.
+func hasPartitionKey(def *TableDef) bool {
+  for _, col := range def.Columns {
+    if col.PartitionKey {
+      return true
+    }
+  }
+  return false
+}
+
+// tableCQL returns the CREATE TABLE IF NOT EXISTS statement of the table
+// def, which has a partition key.
+func tableCQL(def *TableDef) string {
+  var cols, pks, cks, order []string
+  for _, col := range def.Columns {
+    cols = append(cols, col.Name+" "+col.Type)
+    switch {
+    case col.PartitionKey:
+      pks = append(pks, col.Name)
+    case col.ClusteringKey:
+      cks = append(cks, col.Name)
+      if col.Descending {
+        order = append(order, col.Name+" DESC")
+      } else {
+        order = append(order, col.Name+" ASC")
+      }
+    }
+  }
+  primaryKey := "(" + strings.Join(pks, ", ") + ")"
+  if len(cks) > 0 {
+    primaryKey += ", " + strings.Join(cks, ", ")
+  }
+  cols = append(cols, "PRIMARY KEY ("+primaryKey+")")
+
+  cql := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)",
+    def.Name, strings.Join(cols, ", "))
+  if len(cks) > 0 {
+    cql += fmt.Sprintf(" WITH CLUSTERING ORDER BY (%s)",
+      strings.Join(order, ", "))
+  }
+  return cql
+}
+
+// CreateTable creates the column family represented by typ if it does not
+// exist yet, after the user-defined types of its columns and along with the
+// lookup tables of its unique columns. See CreateTableCQL for how the table
+// is derived from the type, CreateTypesCQL and UniqueTableDefs.
+func CreateTable(session Session, typ reflect.Type, opts ...Option) error {
+  cql, err := CreateTableCQL(typ, opts...)
+  if err != nil {
+    return err
+  }
+  types, err := CreateTypesCQL(typ, opts...)
+  if err != nil {
+    return err
+  }
+  for _, cql := range types {
+    stmt := newStatement(&options{}, cql, nil)
+    if err := exec(context.Background(), session, stmt); err != nil {
+      return err
+    }
+  }
+  stmt := newStatement(&options{}, cql, nil)
+  if err := exec(context.Background(), session, stmt); err != nil {
+    return err
+  }
+  lookups, err := UniqueTableDefs(typ, opts...)
+  if err != nil {
+    return err
+  }
+  for _, def := range lookups {
+    stmt := newStatement(&options{}, tableCQL(def), nil)
+    if err := exec(context.Background(), session, stmt); err != nil {
+      return err
+    }
+  }
+  return nil
+}
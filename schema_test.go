@@ -0,0 +1,158 @@
+package datastore_test
+
+import (
+  "reflect"
+  "strings"
+  "testing"
+
+  "github.com/droot/datastore"
+  "github.com/droot/datastore/memstore"
+  "github.com/gocql/gocql"
+  "gopkg.in/inf.v0"
+)
+
+type address struct {
+  Street string `cql:"street"`
+}
+
+type invoice struct {
+  ColumnFamily string         `cql:"invoices"`
+  ID           string         `cql:"id,pk"`
+  Amount       inf.Dec        `cql:"amount"`
+  Tax          *inf.Dec       `cql:"tax"`
+  Term         gocql.Duration `cql:"term"`
+  Billing      address        `cql:"billing"`
+}
+
+func TestCreateTableCQLNativeStructs(t *testing.T) {
+  cql, err := datastore.CreateTableCQL(reflect.TypeOf(invoice{}))
+  if err != nil {
+    t.Fatal(err)
+  }
+  for _, col := range []string{"amount decimal", "tax decimal",
+    "term duration", "billing address"} {
+    if !strings.Contains(cql, col) {
+      t.Errorf("%s lacks column %q", cql, col)
+    }
+  }
+}
+
+type payment struct {
+  ColumnFamily string         `cql:"payments"`
+  ID           string         `cql:"id,pk"`
+  Amount       inf.Dec        `cql:"amount"`
+  Term         gocql.Duration `cql:"term"`
+}
+
+func TestSaveNativeStructs(t *testing.T) {
+  s := memstore.New()
+  if err := s.Register(reflect.TypeOf(payment{})); err != nil {
+    t.Fatal(err)
+  }
+  in := &payment{ID: "a", Amount: *inf.NewDec(1250, 2),
+    Term: gocql.Duration{Months: 1, Days: 2}}
+  if err := datastore.SaveEntity(s, in); err != nil {
+    t.Fatal(err)
+  }
+  var out payment
+  if err := datastore.Get(s, &out, "a"); err != nil {
+    t.Fatal(err)
+  }
+  if out.Amount.Cmp(&in.Amount) != 0 || out.Term != in.Term {
+    t.Errorf("got %v %v, want %v %v", &out.Amount, out.Term, &in.Amount,
+      in.Term)
+  }
+}
+
+type geo struct {
+  Lat float64 `cql:"lat"`
+  Lon float64 `cql:"lon"`
+}
+
+type place struct {
+  Street string   `cql:"street"`
+  Tags   []string `cql:"tags"`
+  At     *geo     `cql:"at"`
+}
+
+type venue struct {
+  ColumnFamily string         `cql:"venues"`
+  ID           string         `cql:"id,pk"`
+  Main         place          `cql:"main"`
+  Others       []place        `cql:"others"`
+  Nearby       map[string]geo `cql:"nearby"`
+  Billing      address        `cql:"billing,udt=billing_address"`
+  Opened       gocql.Duration `cql:"opened"`
+}
+
+func TestCreateTypesCQL(t *testing.T) {
+  got, err := datastore.CreateTypesCQL(reflect.TypeOf(venue{}))
+  if err != nil {
+    t.Fatal(err)
+  }
+  want := []string{
+    "CREATE TYPE IF NOT EXISTS geo (lat double, lon double)",
+    "CREATE TYPE IF NOT EXISTS place (street text, " +
+      "tags frozen<list<text>>, at frozen<geo>)",
+    "CREATE TYPE IF NOT EXISTS billing_address (street text)",
+  }
+  if !reflect.DeepEqual(got, want) {
+    t.Errorf("got %q, want %q", got, want)
+  }
+}
+
+type node struct {
+  Next *node `cql:"next"`
+}
+
+type tree struct {
+  ColumnFamily string `cql:"trees"`
+  ID           string `cql:"id,pk"`
+  Root         node   `cql:"root"`
+}
+
+func TestCreateTypesCQLRecursive(t *testing.T) {
+  if _, err := datastore.CreateTypesCQL(reflect.TypeOf(tree{})); err == nil {
+    t.Error("got the types of a recursive type")
+  }
+}
+
+type member struct {
+  ColumnFamily string `cql:"members"`
+  ID           string `cql:"id,pk"`
+  Email        string `cql:"email,unique,index"`
+  Home         geo    `cql:"home"`
+}
+
+func TestDDLKeyspace(t *testing.T) {
+  typ := reflect.TypeOf(member{})
+  ks := datastore.Keyspace("tenant")
+  table, err := datastore.CreateTableCQL(typ, ks)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !strings.HasPrefix(table, "CREATE TABLE IF NOT EXISTS tenant.members ") {
+    t.Errorf("got %q, want a table in tenant", table)
+  }
+  types, err := datastore.CreateTypesCQL(typ, ks)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if want := "CREATE TYPE IF NOT EXISTS tenant.geo (lat double, " +
+    "lon double)"; len(types) != 1 || types[0] != want {
+    t.Errorf("got %q, want %q", types, want)
+  }
+  lookups, err := datastore.UniqueTableDefs(typ, ks)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(lookups) != 1 || lookups[0].Name != "tenant.members_unique_email" {
+    t.Errorf("got %+v, want a lookup table in tenant", lookups)
+  }
+  index, err := datastore.CreateIndexCQL(typ, "email", ks,
+    datastore.Table("old_members"))
+  if want := "CREATE INDEX IF NOT EXISTS old_members_email_idx ON " +
+    "tenant.old_members (email)"; err != nil || index != want {
+    t.Errorf("got %q, %v, want %q", index, err, want)
+  }
+}
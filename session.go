@@ -0,0 +1,322 @@
+package datastore
+
+import (
+  "context"
+  "sync/atomic"
+  "time"
+
+  "github.com/gocql/gocql"
+)
+
+// Session executes the statements built by the package. NewSession adapts a
+// *gocql.Session; other implementations can wrap one to observe or alter the
+// statements, or fake Cassandra altogether in unit tests.
+type Session interface {
+  // Keyspace returns the keyspace statements are executed in.
+  Keyspace() string
+  // Iter executes stmt and returns an iterator over the rows it yields.
+  // Statements yielding no rows, such as inserts, are executed by closing
+  // the returned iterator.
+  Iter(ctx context.Context, stmt *Statement) Iter
+  // ExecBatch executes a batch of statements.
+  ExecBatch(ctx context.Context, batch *BatchStatement) error
+}
+
+// Iter iterates over the rows yielded by a statement. *gocql.Iter implements
+// it.
+type Iter interface {
+  // RowData returns the columns of the rows along with default destinations
+  // to scan them into.
+  RowData() (gocql.RowData, error)
+  // Scan copies the next row into dest, reporting whether there was one.
+  Scan(dest ...interface{}) bool
+  // PageState returns the paging state positioned after the current page.
+  PageState() []byte
+  // Close closes the iterator and returns the error met while iterating.
+  Close() error
+}
+
+// warner is implemented by the iterators reporting the warnings Cassandra
+// sent along with the rows, like *gocql.Iter.
+type warner interface {
+  Warnings() []string
+}
+
+// iterWarnings returns the warnings of iter, nil if it does not report
+// them.
+func iterWarnings(iter Iter) []string {
+  if w, ok := iter.(warner); ok {
+    return w.Warnings()
+  }
+  return nil
+}
+
+// Statement is a CQL statement and the settings it is executed with.
+type Statement struct {
+  CQL  string
+  Args []interface{}
+
+  opts      *options
+  pageSize  int
+  pageState []byte
+  // op and table describe CQL, see describeCQL, op being empty if not
+  // described.
+  op, table string
+}
+
+// newStatement returns a statement executing cql with args, configured by
+// o and at the registered consistency level of its table, see
+// RegisterConsistency.
+func newStatement(o *options, cql string, args []interface{}) *Statement {
+  op, table := describeCQL(cql)
+  return &Statement{CQL: cql, Args: args, op: op, table: table,
+    opts: withTableConsistency(o, op, table)}
+}
+
+// describe returns the kind of stmt and the table it operates on, see
+// describeCQL, which the statements built by the package have parsed
+// once.
+func (stmt *Statement) describe() (op, table string) {
+  if stmt.op == "" {
+    return describeCQL(stmt.CQL)
+  }
+  return stmt.op, stmt.table
+}
+
+// options returns the options of stmt, which has none if it was not built
+// by the package.
+func (stmt *Statement) options() *options {
+  if stmt.opts == nil {
+    return &options{}
+  }
+  return stmt.opts
+}
+
+// Consistency returns the consistency level to execute stmt with, ok being
+// false for the session default.
+func (stmt *Statement) Consistency() (c gocql.Consistency, ok bool) {
+  o := stmt.options()
+  return o.consistency, o.hasConsistency
+}
+
+// Timestamp returns the write timestamp of stmt in microseconds since the
+// epoch, ok being false if it has none.
+func (stmt *Statement) Timestamp() (ts int64, ok bool) {
+  o := stmt.options()
+  return o.timestamp, o.hasTimestamp
+}
+
+// PageSize returns the number of rows to fetch per round trip, 0 for the
+// session default.
+func (stmt *Statement) PageSize() int {
+  return stmt.pageSize
+}
+
+// PageState returns the paging state to resume the statement at, nil to
+// start at the first row.
+func (stmt *Statement) PageState() []byte {
+  return stmt.pageState
+}
+
+// Idempotent reports whether stmt is safe to execute more than once, ok
+// being false if it is not known.
+func (stmt *Statement) Idempotent() (idempotent, ok bool) {
+  return isIdempotent(stmt)
+}
+
+// Timeout returns the time the execution of stmt is bounded by, 0 if it
+// has no timeout of its own. The context given to Session.Iter already
+// expires after it.
+func (stmt *Statement) Timeout() time.Duration {
+  return stmt.options().timeout
+}
+
+// BatchStatement is a batch of statements executed at once. The execution
+// settings of the batch apply, the ones of the individual statements are
+// ignored.
+type BatchStatement struct {
+  Type       gocql.BatchType
+  Statements []*Statement
+
+  opts *options
+}
+
+// options returns the options of batch, which has none if it was not built
+// by the package.
+func (batch *BatchStatement) options() *options {
+  if batch.opts == nil {
+    return &options{}
+  }
+  return batch.opts
+}
+
+// Consistency returns the consistency level to execute batch with, ok being
+// false for the session default.
+func (batch *BatchStatement) Consistency() (c gocql.Consistency, ok bool) {
+  o := batch.options()
+  return o.consistency, o.hasConsistency
+}
+
+// Timestamp returns the write timestamp of batch in microseconds since the
+// epoch, ok being false if it has none.
+func (batch *BatchStatement) Timestamp() (ts int64, ok bool) {
+  o := batch.options()
+  return o.timestamp, o.hasTimestamp
+}
+
+// Timeout returns the time the execution of batch is bounded by, 0 if it
+// has no timeout of its own. The context given to Session.ExecBatch
+// already expires after it.
+func (batch *BatchStatement) Timeout() time.Duration {
+  return batch.options().timeout
+}
+
+// run executes stmt on session, applying the timeout of its options, the
+// errors of the returned iterator being *Error. The returned cancel
+// function must be called once the iterator is closed.
+func run(ctx context.Context, session Session, stmt *Statement) (
+  Iter, context.CancelFunc) {
+
+  cancel := context.CancelFunc(func() {})
+  if d := stmt.options().timeout; d > 0 {
+    ctx, cancel = context.WithTimeout(ctx, d)
+  }
+  iter := logged(session, stmt.options()).Iter(ctx, stmt)
+  return errorIter{Iter: iter, cql: stmt.CQL}, cancel
+}
+
+// exec executes stmt, which yields no rows, on session.
+func exec(ctx context.Context, session Session, stmt *Statement) error {
+  iter, cancel := run(ctx, session, stmt)
+  defer cancel()
+  return iter.Close()
+}
+
+// execBatch executes batch on session, applying the timeout of its options.
+// The error returned is an *Error.
+func execBatch(ctx context.Context, session Session,
+  batch *BatchStatement) error {
+
+  batch = withBatchConsistency(batch)
+  if batch.opts.timeout > 0 {
+    var cancel context.CancelFunc
+    ctx, cancel = context.WithTimeout(ctx, batch.opts.timeout)
+    defer cancel()
+  }
+  return batchError(batch, logged(session, batch.opts).ExecBatch(ctx, batch))
+}
+
+// readRetryPolicy holds the policy set with SetReadRetryPolicy.
+var readRetryPolicy atomic.Value
+
+type retryPolicyHolder struct {
+  p gocql.RetryPolicy
+}
+
+// SetReadRetryPolicy sets the retry policy of the reads, and of the writes
+// marked Idempotent, executed without the Retry option, e.g. a
+// gocql.ExponentialBackoffRetryPolicy to retry timeouts with backoff, nil
+// for the session default. Other writes are left alone: a write that timed
+// out may have been applied, so retrying it is only safe for some.
+func SetReadRetryPolicy(p gocql.RetryPolicy) {
+  readRetryPolicy.Store(retryPolicyHolder{p})
+}
+
+// retryPolicy returns the retry policy to execute stmt with, nil for the
+// session default.
+func retryPolicy(stmt *Statement) gocql.RetryPolicy {
+  if p := stmt.options().retryPolicy; p != nil {
+    return p
+  }
+  if h, _ := readRetryPolicy.Load().(retryPolicyHolder); h.p != nil {
+    if idempotent, _ := isIdempotent(stmt); idempotent {
+      return h.p
+    }
+  }
+  return nil
+}
+
+// isIdempotent reports whether stmt is idempotent, ok being false if it is
+// not known.
+func isIdempotent(stmt *Statement) (idempotent, ok bool) {
+  if o := stmt.options(); o.hasIdempotent {
+    return o.idempotent, true
+  }
+  if op, _ := stmt.describe(); op == "select" {
+    return true, true
+  }
+  return false, false
+}
+
+// gocqlSession adapts a *gocql.Session to a Session.
+type gocqlSession struct {
+  s *gocql.Session
+}
+
+// NewSession returns a Session executing statements with s. gocql prepares
+// the statements and caches them by text; the package builds the same text
+// for the same statement shape, so raise ClusterConfig.MaxPreparedStmts if
+// the application uses more shapes than the cache holds.
+func NewSession(s *gocql.Session) Session {
+  return gocqlSession{s}
+}
+
+func (g gocqlSession) Keyspace() string {
+  return g.s.Query("").Keyspace()
+}
+
+func (g gocqlSession) Iter(ctx context.Context, stmt *Statement) Iter {
+  q := g.s.Query(stmt.CQL, stmt.Args...).WithContext(ctx)
+  o := stmt.options()
+  if o.hasConsistency {
+    q.Consistency(o.consistency)
+  }
+  if o.hasTimestamp {
+    q.WithTimestamp(o.timestamp)
+  }
+  if o.tracer != nil {
+    q.Trace(o.tracer)
+  }
+  if p := retryPolicy(stmt); p != nil {
+    q.RetryPolicy(p)
+  }
+  if idempotent, ok := isIdempotent(stmt); ok {
+    q.Idempotent(idempotent)
+  }
+  if o.speculative != nil {
+    q.SetSpeculativeExecutionPolicy(o.speculative)
+  }
+  if stmt.pageSize > 0 {
+    q.PageSize(stmt.pageSize)
+  }
+  if len(stmt.pageState) > 0 {
+    q.PageState(stmt.pageState)
+  }
+  return q.Iter()
+}
+
+func (g gocqlSession) ExecBatch(ctx context.Context,
+  batch *BatchStatement) error {
+
+  b := g.s.NewBatch(batch.Type).WithContext(ctx)
+  o := batch.options()
+  if o.hasConsistency {
+    b.SetConsistency(o.consistency)
+  }
+  if o.hasTimestamp {
+    b.WithTimestamp(o.timestamp)
+  }
+  if o.tracer != nil {
+    b.Trace(o.tracer)
+  }
+  if o.retryPolicy != nil {
+    b.RetryPolicy(o.retryPolicy)
+  }
+  if o.speculative != nil {
+    b.SpeculativeExecutionPolicy(o.speculative)
+  }
+  for _, stmt := range batch.Statements {
+    b.Query(stmt.CQL, stmt.Args...)
+  }
+  return g.s.ExecuteBatch(b)
+}
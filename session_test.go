@@ -0,0 +1,106 @@
+package datastore_test
+
+import (
+  "context"
+  "reflect"
+  "testing"
+  "time"
+
+  "github.com/droot/datastore"
+  "github.com/droot/datastore/memstore"
+  "github.com/gocql/gocql"
+)
+
+// recordingSession records the statements and batches it executes.
+type recordingSession struct {
+  datastore.Session
+  stmts   []*datastore.Statement
+  batches []*datastore.BatchStatement
+}
+
+func (s *recordingSession) Iter(ctx context.Context,
+  stmt *datastore.Statement) datastore.Iter {
+
+  s.stmts = append(s.stmts, stmt)
+  return s.Session.Iter(ctx, stmt)
+}
+
+func (s *recordingSession) ExecBatch(ctx context.Context,
+  batch *datastore.BatchStatement) error {
+
+  s.batches = append(s.batches, batch)
+  return s.Session.ExecBatch(ctx, batch)
+}
+
+// newRecordingSession returns a session of a store having the table of the
+// entity type typ.
+func newRecordingSession(t *testing.T, typ reflect.Type) *recordingSession {
+  t.Helper()
+  s := memstore.New()
+  if err := s.Register(typ); err != nil {
+    t.Fatal(err)
+  }
+  return &recordingSession{Session: s}
+}
+
+func TestStatementSettings(t *testing.T) {
+  s := newRecordingSession(t, reflect.TypeOf(tweet{}))
+  err := datastore.SaveEntity(s, &tweet{ID: "a"},
+    datastore.Consistency(gocql.Quorum), datastore.Timestamp(42),
+    datastore.Timeout(time.Second), datastore.Idempotent(true))
+  if err != nil {
+    t.Fatal(err)
+  }
+  stmt := s.stmts[0]
+  if c, ok := stmt.Consistency(); !ok || c != gocql.Quorum {
+    t.Errorf("got consistency %v %v, want %v", c, ok, gocql.Quorum)
+  }
+  if ts, ok := stmt.Timestamp(); !ok || ts != 42 {
+    t.Errorf("got timestamp %v %v, want 42", ts, ok)
+  }
+  if d := stmt.Timeout(); d != time.Second {
+    t.Errorf("got timeout %v, want %v", d, time.Second)
+  }
+  if idempotent, ok := stmt.Idempotent(); !ok || !idempotent {
+    t.Errorf("got idempotent %v %v, want true", idempotent, ok)
+  }
+
+  q, err := datastore.NewQuery(reflect.TypeOf(tweet{}))
+  if err != nil {
+    t.Fatal(err)
+  }
+  iter := q.PageSize(10).PageState([]byte("state")).Run(s)
+  var tw tweet
+  iter.Next(&tw)
+  iter.Close()
+  stmt = s.stmts[len(s.stmts)-1]
+  if stmt.PageSize() != 10 || string(stmt.PageState()) != "state" {
+    t.Errorf("got page %d %q, want 10 \"state\"", stmt.PageSize(),
+      stmt.PageState())
+  }
+  if _, ok := stmt.Timestamp(); ok {
+    t.Error("got a timestamp for a select")
+  }
+}
+
+func TestBatchStatementSettings(t *testing.T) {
+  s := newRecordingSession(t, reflect.TypeOf(tweet{}))
+  b := datastore.NewBatch(gocql.LoggedBatch,
+    datastore.Consistency(gocql.All), datastore.Timestamp(42))
+  if err := b.Save(&tweet{ID: "a"}); err != nil {
+    t.Fatal(err)
+  }
+  if err := b.Run(s, datastore.Timeout(time.Second)); err != nil {
+    t.Fatal(err)
+  }
+  batch := s.batches[0]
+  if c, ok := batch.Consistency(); !ok || c != gocql.All {
+    t.Errorf("got consistency %v %v, want %v", c, ok, gocql.All)
+  }
+  if ts, ok := batch.Timestamp(); !ok || ts != 42 {
+    t.Errorf("got timestamp %v %v, want 42", ts, ok)
+  }
+  if d := batch.Timeout(); d != time.Second {
+    t.Errorf("got timeout %v, want %v", d, time.Second)
+  }
+}
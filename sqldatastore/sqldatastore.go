@@ -0,0 +1,360 @@
+// Package sqldatastore is a database/sql driver executing CQL statements
+// with a datastore Session, for code written against sql.DB and sql.Rows:
+//
+//   db := sql.OpenDB(sqldatastore.NewConnector(session))
+//   rows, err := db.QueryContext(ctx,
+//     "SELECT id, name FROM users WHERE id = ?", id)
+//
+// The driver is also registered as "datastore", its data source names
+// listing the hosts of the cluster, the keyspace and settings:
+//
+//   db, err := sql.Open("datastore",
+//     "10.0.0.1,10.0.0.2/app?consistency=quorum&timeout=5s")
+//
+// Statements are executed as is and their arguments bound by position, any
+// value gocql marshals being accepted. The values of the rows are returned
+// as the types database/sql scans: integers as int64, floats as float64,
+// uuids, varints and decimals as strings, and collections, tuples and UDTs
+// as their JSON encoding. Cassandra has no transactions: Begin fails.
+package sqldatastore
+
+import (
+  "context"
+  "database/sql"
+  "database/sql/driver"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io"
+  "net/url"
+  "reflect"
+  "strings"
+  "sync"
+  "time"
+
+  "github.com/droot/datastore"
+  "github.com/gocql/gocql"
+)
+
+func init() {
+  sql.Register("datastore", Driver{})
+}
+
+// Driver is the database/sql driver registered as "datastore".
+type Driver struct{}
+
+// Open returns a connection to the cluster of the data source name, with a
+// session of its own. sql.DB shares the session of its connections through
+// OpenConnector instead.
+func (d Driver) Open(name string) (driver.Conn, error) {
+  c, err := d.OpenConnector(name)
+  if err != nil {
+    return nil, err
+  }
+  return c.Connect(context.Background())
+}
+
+// OpenConnector returns a connector to the cluster of the data source name,
+// of the form host[,host...][/keyspace][?settings]. The settings are:
+//
+//   consistency  the default consistency, e.g. quorum or local_one
+//   timeout      the timeout of the requests, e.g. 5s
+//
+// The session is created by the first connection and closed with the
+// sql.DB.
+func (Driver) OpenConnector(name string) (driver.Connector, error) {
+  cluster, err := parseDSN(name)
+  if err != nil {
+    return nil, err
+  }
+  return &dsnConnector{cluster: cluster}, nil
+}
+
+// parseDSN returns the cluster configuration of the data source name.
+func parseDSN(name string) (*gocql.ClusterConfig, error) {
+  var query string
+  if i := strings.IndexByte(name, '?'); i >= 0 {
+    name, query = name[:i], name[i+1:]
+  }
+  hosts := strings.SplitN(name, "/", 2)
+  if hosts[0] == "" {
+    return nil, fmt.Errorf("sqldatastore: no hosts in %q", name)
+  }
+  cluster := gocql.NewCluster(strings.Split(hosts[0], ",")...)
+  if len(hosts) == 2 {
+    cluster.Keyspace = hosts[1]
+  }
+  settings, err := url.ParseQuery(query)
+  if err != nil {
+    return nil, fmt.Errorf("sqldatastore: invalid settings %q: %v", query,
+      err)
+  }
+  for key, values := range settings {
+    value := values[len(values)-1]
+    switch key {
+    case "consistency":
+      c, err := gocql.ParseConsistencyWrapper(value)
+      if err != nil {
+        return nil, fmt.Errorf("sqldatastore: %v", err)
+      }
+      cluster.Consistency = c
+    case "timeout":
+      d, err := time.ParseDuration(value)
+      if err != nil {
+        return nil, fmt.Errorf("sqldatastore: invalid timeout %q", value)
+      }
+      cluster.Timeout = d
+    default:
+      return nil, fmt.Errorf("sqldatastore: unknown setting %q", key)
+    }
+  }
+  return cluster, nil
+}
+
+// dsnConnector connects to a cluster through a session it creates.
+type dsnConnector struct {
+  cluster *gocql.ClusterConfig
+
+  mu      sync.Mutex
+  session *gocql.Session
+}
+
+func (c *dsnConnector) Connect(ctx context.Context) (driver.Conn, error) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  if c.session == nil {
+    s, err := c.cluster.CreateSession()
+    if err != nil {
+      return nil, err
+    }
+    c.session = s
+  }
+  return &conn{session: datastore.NewSession(c.session)}, nil
+}
+
+func (c *dsnConnector) Driver() driver.Driver {
+  return Driver{}
+}
+
+// Close closes the session, called by sql.DB.Close.
+func (c *dsnConnector) Close() error {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  if c.session != nil {
+    c.session.Close()
+    c.session = nil
+  }
+  return nil
+}
+
+// NewConnector returns a connector whose connections execute statements
+// with session, to open a sql.DB with sql.OpenDB. The session is left open
+// when the sql.DB is closed.
+func NewConnector(session datastore.Session) driver.Connector {
+  return connector{session}
+}
+
+type connector struct {
+  session datastore.Session
+}
+
+func (c connector) Connect(ctx context.Context) (driver.Conn, error) {
+  return &conn{session: c.session}, nil
+}
+
+func (c connector) Driver() driver.Driver {
+  return Driver{}
+}
+
+// conn is a connection, the session being safe for concurrent use.
+type conn struct {
+  session datastore.Session
+}
+
+// ErrTransactions is returned by Begin.
+var ErrTransactions = errors.New("sqldatastore: transactions are not " +
+  "supported")
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+  return &stmt{c, query}, nil
+}
+
+func (c *conn) Close() error {
+  return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+  return nil, ErrTransactions
+}
+
+// CheckNamedValue accepts any value, converted by gocql once bound.
+func (c *conn) CheckNamedValue(v *driver.NamedValue) error {
+  if v.Name != "" {
+    return fmt.Errorf("sqldatastore: named argument %s, arguments are "+
+      "bound by position", v.Name)
+  }
+  return nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string,
+  args []driver.NamedValue) (driver.Result, error) {
+
+  iter := c.session.Iter(ctx, statement(query, args))
+  rowData, err := iter.RowData()
+  if err != nil {
+    iter.Close()
+    return nil, err
+  }
+  applied := true
+  for i, col := range rowData.Columns {
+    if col == "[applied]" {
+      // a conditional statement, whose row tells whether it was applied
+      rowData.Values[i] = &applied
+      iter.Scan(rowData.Values...)
+      break
+    }
+  }
+  if err := iter.Close(); err != nil {
+    return nil, err
+  }
+  if !applied {
+    return nil, datastore.ErrNotApplied
+  }
+  return driver.ResultNoRows, nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string,
+  args []driver.NamedValue) (driver.Rows, error) {
+
+  iter := c.session.Iter(ctx, statement(query, args))
+  rowData, err := iter.RowData()
+  if err != nil {
+    iter.Close()
+    return nil, err
+  }
+  for i, v := range rowData.Values {
+    // scanning through a pointer to a pointer tells nulls apart
+    if t := reflect.TypeOf(v); t.Elem().Kind() != reflect.Interface {
+      rowData.Values[i] = reflect.New(t).Interface()
+    }
+  }
+  return &rows{iter: iter, rowData: rowData}, nil
+}
+
+// statement returns the statement executing query with args.
+func statement(query string, args []driver.NamedValue) *datastore.Statement {
+  vals := make([]interface{}, len(args))
+  for i, arg := range args {
+    vals[i] = arg.Value
+  }
+  return &datastore.Statement{CQL: query, Args: vals}
+}
+
+// stmt is a statement, prepared by gocql when first executed.
+type stmt struct {
+  c     *conn
+  query string
+}
+
+func (s *stmt) Close() error {
+  return nil
+}
+
+// NumInput returns -1, the markers being counted by Cassandra.
+func (s *stmt) NumInput() int {
+  return -1
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+  return s.c.ExecContext(context.Background(), s.query, named(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+  return s.c.QueryContext(context.Background(), s.query, named(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context,
+  args []driver.NamedValue) (driver.Result, error) {
+  return s.c.ExecContext(ctx, s.query, args)
+}
+
+func (s *stmt) QueryContext(ctx context.Context,
+  args []driver.NamedValue) (driver.Rows, error) {
+  return s.c.QueryContext(ctx, s.query, args)
+}
+
+// named returns the arguments args bound by position.
+func named(args []driver.Value) []driver.NamedValue {
+  res := make([]driver.NamedValue, len(args))
+  for i, v := range args {
+    res[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+  }
+  return res
+}
+
+// rows iterates over the rows of a query.
+type rows struct {
+  iter    datastore.Iter
+  rowData gocql.RowData
+}
+
+func (r *rows) Columns() []string {
+  return r.rowData.Columns
+}
+
+func (r *rows) Close() error {
+  return r.iter.Close()
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+  if !r.iter.Scan(r.rowData.Values...) {
+    if err := r.iter.Close(); err != nil {
+      return err
+    }
+    return io.EOF
+  }
+  for i, p := range r.rowData.Values {
+    v, err := value(reflect.ValueOf(p).Elem())
+    if err != nil {
+      return fmt.Errorf("sqldatastore: column %s: %v",
+        r.rowData.Columns[i], err)
+    }
+    dest[i] = v
+  }
+  return nil
+}
+
+// value returns the driver value of the column value v, nil if null.
+func value(v reflect.Value) (driver.Value, error) {
+  for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+    if v.IsNil() {
+      return nil, nil
+    }
+    v = v.Elem()
+  }
+  switch x := v.Interface().(type) {
+  case time.Time:
+    return x, nil
+  case []byte:
+    return x, nil
+  case fmt.Stringer:
+    // uuids, inet addresses, varints, decimals and durations
+    return x.String(), nil
+  }
+  switch v.Kind() {
+  case reflect.String:
+    return v.String(), nil
+  case reflect.Bool:
+    return v.Bool(), nil
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+    reflect.Int64:
+    return v.Int(), nil
+  case reflect.Float32, reflect.Float64:
+    return v.Float(), nil
+  }
+  doc, err := json.Marshal(v.Interface())
+  if err != nil {
+    return nil, err
+  }
+  return doc, nil
+}
@@ -0,0 +1,132 @@
+package datastore
+
+import (
+  "fmt"
+  "reflect"
+  "strconv"
+  "strings"
+
+  "github.com/gocql/gocql"
+)
+
+// Tuple holds the values of a CQL tuple column whose shape is not known at
+// compile time, nil elements being written as nulls. Fields of type Tuple
+// are stored in tuple columns without the tuple option, but need a type=
+// option for GetTableDef. Tuples are also accepted as filter values.
+type Tuple []interface{}
+
+var typeOfTuple = reflect.TypeOf(Tuple(nil))
+
+// MarshalCQL marshals the elements of t as a tuple.
+func (t Tuple) MarshalCQL(info gocql.TypeInfo) ([]byte, error) {
+  if t == nil {
+    return nil, nil
+  }
+  return gocql.Marshal(info, []interface{}(t))
+}
+
+// checkTupleField checks the field f of a column stored as a tuple: fields
+// tagged with the tuple option hold a struct, whose exported fields are the
+// elements of the tuple in order, or an array.
+func checkTupleField(tag *structTag, f reflect.StructField) error {
+  if f.Type == typeOfTuple {
+    tag.tuple = true
+    return nil
+  }
+  if !tag.tuple {
+    return nil
+  }
+  switch f.Type.Kind() {
+  case reflect.Array:
+    if f.Type.Elem().Kind() != reflect.Uint8 {
+      return nil
+    }
+  case reflect.Struct:
+    for i := 0; i < f.Type.NumField(); i++ {
+      if f.Type.Field(i).PkgPath != "" {
+        return fmt.Errorf("datastore: tuple field %s has unexported "+
+          "element %s", f.Name, f.Type.Field(i).Name)
+      }
+    }
+    return nil
+  }
+  return fmt.Errorf("datastore: tuple option on field %s, not a struct "+
+    "or an array", f.Name)
+}
+
+// tupleTypeOf returns the CQL type of the tuple field of type t.
+func tupleTypeOf(t reflect.Type) (string, error) {
+  var elems []string
+  switch t.Kind() {
+  case reflect.Array:
+    elem, err := cqlElemTypeOf(t.Elem())
+    if err != nil {
+      return "", err
+    }
+    for i := 0; i < t.Len(); i++ {
+      elems = append(elems, elem)
+    }
+  case reflect.Struct:
+    for i := 0; i < t.NumField(); i++ {
+      elem, err := cqlElemTypeOf(t.Field(i).Type)
+      if err != nil {
+        return "", err
+      }
+      elems = append(elems, elem)
+    }
+  default:
+    return "", fmt.Errorf("datastore: no CQL type for %v, use a type= tag", t)
+  }
+  return fmt.Sprintf("tuple<%s>", strings.Join(elems, ", ")), nil
+}
+
+// tupleElem parses the name gocql gives to the i'th element of the tuple
+// column name, name[i].
+func tupleElem(col string) (name string, i int, ok bool) {
+  j := strings.LastIndex(col, "[")
+  if j < 0 || !strings.HasSuffix(col, "]") {
+    return "", 0, false
+  }
+  i, err := strconv.Atoi(col[j+1 : len(col)-1])
+  if err != nil {
+    return "", 0, false
+  }
+  return col[:j], i, true
+}
+
+// tupleElemDest returns the destination of the i'th element of the tuple
+// field v, nil if v is a Tuple or has no such element.
+func tupleElemDest(v reflect.Value, i int) interface{} {
+  switch {
+  case v.Type() == typeOfTuple:
+    return nil
+  case v.Kind() == reflect.Array && i < v.Len():
+    return v.Index(i).Addr().Interface()
+  case v.Kind() == reflect.Struct && i < v.NumField():
+    return v.Field(i).Addr().Interface()
+  }
+  return nil
+}
+
+// loadTuples sets the Tuple fields of cls from the elements gocql scanned
+// into the default destinations of rowData.
+func (cls *structCLS) loadTuples(rowData *gocql.RowData) {
+  for i, col := range rowData.Columns {
+    name, j, ok := tupleElem(col)
+    if !ok {
+      continue
+    }
+    f, ok := cls.codec.byName[name]
+    if !ok || name == "-" {
+      continue
+    }
+    v := cls.field(f.index)
+    if v.Type() != typeOfTuple {
+      continue
+    }
+    if j == 0 {
+      v.Set(reflect.ValueOf(Tuple{}))
+    }
+    v.Set(reflect.Append(v, reflect.ValueOf(rowData.Values[i]).Elem()))
+  }
+}
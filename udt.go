@@ -0,0 +1,125 @@
+package datastore
+
+import (
+  "reflect"
+  "strings"
+
+  "github.com/gocql/gocql"
+)
+
+// isUDT reports whether a field of type t is stored in a user-defined type
+// column, which is the case of the structs without a native CQL type nor
+// custom marshaling.
+func isUDT(t reflect.Type) bool {
+  if t.Kind() != reflect.Struct {
+    return false
+  }
+  switch t {
+  case typeOfTime, typeOfBigInt, typeOfDec, typeOfDuration:
+    return false
+  }
+  pt := reflect.PtrTo(t)
+  return !pt.Implements(typeOfUnmarshaler) && !pt.Implements(typeOfMarshaler)
+}
+
+var (
+  typeOfMarshaler   = reflect.TypeOf((*gocql.Marshaler)(nil)).Elem()
+  typeOfUnmarshaler = reflect.TypeOf((*gocql.Unmarshaler)(nil)).Elem()
+)
+
+// isUDTField reports whether a field of type t, a struct or a pointer to
+// one, is stored in a user-defined type column.
+func isUDTField(t reflect.Type) bool {
+  if t.Kind() == reflect.Ptr {
+    t = t.Elem()
+  }
+  return isUDT(t)
+}
+
+// udtOf adapts the UDT field v to gocql's marshaling.
+func udtOf(v reflect.Value) interface{} {
+  if v.Kind() == reflect.Ptr {
+    return nullUDT{v}
+  }
+  return udtValue{v}
+}
+
+// udtValue adapts an addressable nested struct to gocql's UDT marshaling.
+// The UDT fields are mapped to the struct fields with the same cql tags as
+// entity columns, nested structs being UDTs themselves.
+type udtValue struct {
+  v reflect.Value
+}
+
+// field returns the struct field mapped to the UDT field name.
+func (u udtValue) field(name string) (reflect.Value, bool) {
+  t := u.v.Type()
+  for i := 0; i < t.NumField(); i++ {
+    f := t.Field(i)
+    tag := f.Tag.Get("cql")
+    if ii := strings.Index(tag, ","); ii != -1 {
+      tag = tag[:ii]
+    }
+    if tag == "-" || f.PkgPath != "" {
+      continue
+    }
+    if tag == "" {
+      tag = f.Name
+    }
+    if strings.EqualFold(tag, name) {
+      return u.v.Field(i), true
+    }
+  }
+  return reflect.Value{}, false
+}
+
+func (u udtValue) MarshalUDT(name string, info gocql.TypeInfo) (
+  []byte, error) {
+
+  f, ok := u.field(name)
+  if !ok {
+    // not mapped, store a null
+    return nil, nil
+  }
+  if isUDTField(f.Type()) {
+    return gocql.Marshal(info, udtOf(f))
+  }
+  return gocql.Marshal(info, f.Interface())
+}
+
+func (u udtValue) UnmarshalUDT(name string, info gocql.TypeInfo,
+  data []byte) error {
+
+  f, ok := u.field(name)
+  if !ok {
+    return nil
+  }
+  if isUDTField(f.Type()) {
+    return gocql.Unmarshal(info, data, udtOf(f))
+  }
+  return gocql.Unmarshal(info, data, f.Addr().Interface())
+}
+
+// nullUDT adapts an addressable pointer to a nested struct to a nullable UDT
+// column: a nil pointer is stored as null and a null column loads as nil.
+type nullUDT struct {
+  v reflect.Value
+}
+
+func (u nullUDT) MarshalCQL(info gocql.TypeInfo) ([]byte, error) {
+  if u.v.IsNil() {
+    return nil, nil
+  }
+  return gocql.Marshal(info, udtValue{u.v.Elem()})
+}
+
+func (u nullUDT) UnmarshalCQL(info gocql.TypeInfo, data []byte) error {
+  if data == nil {
+    u.v.Set(reflect.Zero(u.v.Type()))
+    return nil
+  }
+  if u.v.IsNil() {
+    u.v.Set(reflect.New(u.v.Type().Elem()))
+  }
+  return gocql.Unmarshal(info, data, udtValue{u.v.Elem()})
+}
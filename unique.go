@@ -0,0 +1,298 @@
+package datastore
+
+import (
+  "context"
+  "encoding/json"
+  "fmt"
+  "reflect"
+  "strings"
+)
+
+// uniqueValue is the column of the lookup tables holding the unique values.
+const uniqueValue = "value"
+
+// uniqueTable returns the lookup table of the unique column col of table.
+func uniqueTable(table, col string) string {
+  return table + "_unique_" + col
+}
+
+// checkUniqueField checks the field f of the unique column tag.
+func checkUniqueField(tag *structTag, f reflect.StructField) error {
+  if !tag.unique {
+    return nil
+  }
+  if tag.partitionKey || tag.clusteringKey || tag.counter ||
+    tag.function != "" {
+    return fmt.Errorf("datastore: unique option on key, counter or "+
+      "function field %s", f.Name)
+  }
+  if isFreezable(f.Type) || tag.tuple || tag.udt != "" {
+    return fmt.Errorf("datastore: unique option on non scalar field %s",
+      f.Name)
+  }
+  return nil
+}
+
+// UniqueTableDefs returns the definitions of the lookup tables enforcing the
+// uniqueness of the columns of the entity type typ tagged unique, in field
+// order. Cassandra has no unique constraint: SaveEntity claims the values
+// of the unique columns of an entity by inserting them into their lookup
+// tables with IF NOT EXISTS before saving it, and fails with a
+// ValidationError whose rule is "unique" when one is claimed by another
+// entity. The values an entity no longer holds are released once it is
+// saved, and all of them when it is deleted with DeleteEntity. Zero values
+// are not claimed.
+//
+// The lookup table of the column c of the table t is named t_unique_c. It
+// is keyed by the claimed value and holds the primary key of the entity
+// claiming it. CreateTable creates the lookup tables along with the table,
+// and honors the Table and Keyspace options like CreateTableCQL.
+//
+// Only the saves and deletes of single reflection based entities maintain
+// the lookup tables: update queries, delete queries and the deletes of
+// batches do not, while Batch.Save fails for the types with unique columns,
+// which SaveMulti saves one by one, and SaveEntity fails for an EntityCodec
+// with unique columns.
+func UniqueTableDefs(typ reflect.Type, opts ...Option) ([]*TableDef,
+  error) {
+
+  codec, err := getStructCodec(typ)
+  if err != nil {
+    return nil, err
+  }
+  def, err := GetTableDef(typ)
+  if err != nil {
+    return nil, err
+  }
+  def.Name = newOptions(context.Background(), opts).qualify(def.Name)
+  var defs []*TableDef
+  for _, i := range codec.uniques {
+    lookup := &TableDef{Name: uniqueTable(def.Name, codec.byIndex[i].name)}
+    for _, col := range def.Columns {
+      switch {
+      case col.Name == codec.byIndex[i].name:
+        lookup.Columns = append([]ColumnDef{{Name: uniqueValue,
+          Type: col.Type, PartitionKey: true}}, lookup.Columns...)
+      case col.PartitionKey || col.ClusteringKey:
+        lookup.Columns = append(lookup.Columns,
+          ColumnDef{Name: col.Name, Type: col.Type})
+      }
+    }
+    defs = append(defs, lookup)
+  }
+  return defs, nil
+}
+
+// saveUnique saves the entity adapted by cls, whose type has unique
+// columns, see UniqueTableDefs.
+func saveUnique(ctx context.Context, session Session, cls *structCLS,
+  o *options) error {
+
+  // the unique values are the ones of the prepared entity
+  if err := cls.prepare(); err != nil {
+    return err
+  }
+  table := o.tableOf(cls.codec)
+  // lightweight transactions cannot be given a timestamp
+  lo := *o
+  lo.hasTimestamp = false
+  old, err := cls.currentUniques(ctx, session, table, &lo)
+  if err != nil {
+    return err
+  }
+  // the claimed values are captured as the row write may load another row
+  // into the entity, see IfNotExists
+  var claimed []int
+  var claimedValues []interface{}
+  for j, i := range cls.codec.uniques {
+    v := cls.field(i)
+    if isEmpty(v) || sameValue(old[j], v) {
+      continue
+    }
+    ok, applied, err := cls.claimUnique(ctx, session, table, i, &lo)
+    if err == nil && !ok {
+      err = ValidationError{{Field: cls.codec.byIndex[i].name,
+        Rule: "unique", Message: "is not unique"}}
+    }
+    if err != nil {
+      cls.releaseUniques(ctx, session, table, claimed, claimedValues, &lo)
+      return err
+    }
+    if applied {
+      claimed = append(claimed, i)
+      claimedValues = append(claimedValues, cls.fieldValue(i))
+    }
+  }
+  if err := saveRow(ctx, session, cls, o); err != nil {
+    cls.releaseUniques(ctx, session, table, claimed, claimedValues, &lo)
+    return err
+  }
+  var released []int
+  var values []interface{}
+  for j, i := range cls.codec.uniques {
+    v := cls.field(i)
+    if old[j] != nil && !sameValue(old[j], v) {
+      released = append(released, i)
+      values = append(values, old[j])
+    }
+  }
+  cls.releaseUniques(ctx, session, table, released, values, &lo)
+  return nil
+}
+
+// sameValue reports whether old, a value read back from a row, is the value
+// v is stored as. Values are compared by their encoding in the JSON format
+// of the CQL types, like columnState, so that a time read back in UTC at
+// the millisecond precision of timestamps equals the time it was saved
+// from.
+func sameValue(old interface{}, v reflect.Value) bool {
+  a, err := json.Marshal(jsonValue(reflect.ValueOf(old), false))
+  if err != nil {
+    return false
+  }
+  b, err := json.Marshal(jsonValue(v, false))
+  return err == nil && string(a) == string(b)
+}
+
+// deleteUnique deletes the entity adapted by cls, whose type has unique
+// columns, with the query q and releases its unique values.
+func deleteUnique(ctx context.Context, session Session, cls *structCLS,
+  q *DeleteQuery) error {
+
+  o := newQueryOptions(ctx, q.opts)
+  table := o.tableOf(cls.codec)
+  lo := *o
+  lo.hasTimestamp = false
+  old, err := cls.currentUniques(ctx, session, table, &lo)
+  if err != nil {
+    return err
+  }
+  if err := q.RunContext(ctx, session); err != nil {
+    return err
+  }
+  var released []int
+  var values []interface{}
+  for j, i := range cls.codec.uniques {
+    if old[j] != nil {
+      released = append(released, i)
+      values = append(values, old[j])
+    }
+  }
+  cls.releaseUniques(ctx, session, table, released, values, &lo)
+  return nil
+}
+
+// keyArgs returns the primary key columns of the entity and their values.
+func (cls *structCLS) keyArgs() ([]string, []interface{}) {
+  keys := cls.codec.keyColumns()
+  args := make([]interface{}, len(keys))
+  for i, k := range keys {
+    args[i] = cls.fieldValue(cls.codec.byName[k].index)
+  }
+  return keys, args
+}
+
+// currentUniques returns the values of the unique columns of the row of the
+// entity in table, in the order of codec.uniques, nil for null columns and
+// when the row does not exist.
+func (cls *structCLS) currentUniques(ctx context.Context, session Session,
+  table string, o *options) ([]interface{}, error) {
+
+  cols := make([]string, len(cls.codec.uniques))
+  dests := make([]interface{}, len(cls.codec.uniques))
+  for j, i := range cls.codec.uniques {
+    cols[j] = cls.codec.byIndex[i].name
+    dests[j] = reflect.New(cls.field(i).Type()).Interface()
+  }
+  keys, args := cls.keyArgs()
+  cql := fmt.Sprintf("SELECT %s FROM %s WHERE %s = ?",
+    strings.Join(cols, ","), table, strings.Join(keys, " = ? AND "))
+  iter, cancel := run(ctx, session, newStatement(o, cql, args))
+  defer cancel()
+  found := iter.Scan(dests...)
+  if err := iter.Close(); err != nil {
+    return nil, err
+  }
+  values := make([]interface{}, len(dests))
+  for j, dest := range dests {
+    v := reflect.ValueOf(dest).Elem()
+    if found && !isEmpty(v) {
+      values[j] = v.Interface()
+    }
+  }
+  return values, nil
+}
+
+// claimUnique claims the value of the i'th column of the entity in its
+// lookup table. It reports whether the entity holds the claim, and whether
+// the claim is new rather than left by a previous save.
+func (cls *structCLS) claimUnique(ctx context.Context, session Session,
+  table string, i int, o *options) (ok, applied bool, err error) {
+
+  keys, args := cls.keyArgs()
+  cols := append([]string{uniqueValue}, keys...)
+  args = append([]interface{}{cls.fieldValue(i)}, args...)
+  using, usingArgs, err := o.usingClause()
+  if err != nil {
+    return false, false, err
+  }
+  cql := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) IF NOT EXISTS%s",
+    uniqueTable(table, cls.codec.byIndex[i].name), strings.Join(cols, ","),
+    strings.TrimSuffix(strings.Repeat("?,", len(cols)), ","), using)
+  iter, cancel := run(ctx, session,
+    newStatement(o, cql, append(args, usingArgs...)))
+  defer cancel()
+  rowData, err := iter.RowData()
+  if err != nil {
+    iter.Close()
+    return false, false, err
+  }
+  // the current owner of the value is loaded into a copy of the entity
+  owner := reflect.New(cls.codec.typ).Elem()
+  for j, col := range rowData.Columns {
+    if col == "[applied]" {
+      rowData.Values[j] = &applied
+    } else if f, ok := cls.codec.byName[col]; ok && inColumns(keys, col) {
+      rowData.Values[j] = owner.FieldByIndex(
+        cls.codec.byIndex[f.index].index).Addr().Interface()
+    }
+  }
+  iter.Scan(rowData.Values...)
+  if err := iter.Close(); err != nil {
+    return false, false, err
+  }
+  if applied {
+    return true, true, nil
+  }
+  for _, k := range keys {
+    index := cls.codec.byIndex[cls.codec.byName[k].index].index
+    if !reflect.DeepEqual(owner.FieldByIndex(index).Interface(),
+      cls.v.FieldByIndex(index).Interface()) {
+      return false, false, nil
+    }
+  }
+  return true, false, nil
+}
+
+// releaseUniques releases the claims of the entity on the values of the
+// columns indices, the current values of the entity when values is nil. The
+// claims of other entities are left alone. Releases are best effort: a
+// failure, logged like any statement, leaves the value claimed.
+func (cls *structCLS) releaseUniques(ctx context.Context, session Session,
+  table string, indices []int, values []interface{}, o *options) {
+
+  keys, keyArgs := cls.keyArgs()
+  for j, i := range indices {
+    value := cls.fieldValue(i)
+    if values != nil {
+      value = values[j]
+    }
+    cql := fmt.Sprintf("DELETE FROM %s WHERE %s = ? IF %s = ?",
+      uniqueTable(table, cls.codec.byIndex[i].name), uniqueValue,
+      strings.Join(keys, " = ? AND "))
+    iter, cancel := run(ctx, session,
+      newStatement(o, cql, append([]interface{}{value}, keyArgs...)))
+    scanCAS(iter, nil)
+    cancel()
+  }
+}
@@ -0,0 +1,30 @@
+package datastore
+
+import (
+  "reflect"
+  "testing"
+  "time"
+)
+
+func TestSameValue(t *testing.T) {
+  saved := time.Date(2020, 1, 2, 3, 4, 5, 6007008,
+    time.FixedZone("CET", 3600))
+  stored := saved.UTC().Truncate(time.Millisecond)
+  tests := []struct {
+    old  interface{}
+    v    interface{}
+    want bool
+  }{
+    {stored, saved, true},
+    {stored, saved.Add(time.Millisecond), false},
+    {"a@", "a@", true},
+    {"a@", "b@", false},
+    {nil, "a@", false},
+  }
+  for _, tt := range tests {
+    if got := sameValue(tt.old, reflect.ValueOf(tt.v)); got != tt.want {
+      t.Errorf("sameValue(%v, %v) = %v, want %v", tt.old, tt.v, got,
+        tt.want)
+    }
+  }
+}
@@ -0,0 +1,172 @@
+package datastore_test
+
+import (
+  "context"
+  "errors"
+  "reflect"
+  "strings"
+  "testing"
+
+  "github.com/droot/datastore"
+  "github.com/droot/datastore/memstore"
+  "github.com/gocql/gocql"
+)
+
+type uniqueAccount struct {
+  ColumnFamily string `cql:"accounts"`
+  ID           string `cql:"id,pk"`
+  Email        string `cql:"email,unique"`
+}
+
+// claimOwner returns the id of the account claiming email, "" if none.
+func claimOwner(t *testing.T, s datastore.Session, email string) string {
+  t.Helper()
+  q, err := datastore.NewTableQuery("accounts_unique_email")
+  if err != nil {
+    t.Fatal(err)
+  }
+  iter := q.Filter("value =", email).Run(s)
+  defer iter.Close()
+  m := make(map[string]interface{})
+  if err := iter.NextMap(m); err != nil {
+    if err == datastore.Done {
+      return ""
+    }
+    t.Fatal(err)
+  }
+  return m["id"].(string)
+}
+
+func newUniqueStore(t *testing.T) *memstore.Store {
+  t.Helper()
+  s := memstore.New()
+  if err := s.Register(reflect.TypeOf(uniqueAccount{})); err != nil {
+    t.Fatal(err)
+  }
+  a := &uniqueAccount{ID: "a", Email: "a@"}
+  if err := datastore.SaveEntity(s, a); err != nil {
+    t.Fatal(err)
+  }
+  return s
+}
+
+func TestSaveUniqueNotApplied(t *testing.T) {
+  s := newUniqueStore(t)
+  a := &uniqueAccount{ID: "a", Email: "b@"}
+  err := datastore.SaveEntity(s, a, datastore.IfNotExists())
+  if err != datastore.ErrNotApplied {
+    t.Fatalf("got %v, want ErrNotApplied", err)
+  }
+  if a.Email != "a@" {
+    t.Errorf("existing row not loaded, got email %q", a.Email)
+  }
+  if got := claimOwner(t, s, "a@"); got != "a" {
+    t.Errorf("a@ claimed by %q, want a", got)
+  }
+  if got := claimOwner(t, s, "b@"); got != "" {
+    t.Errorf("b@ claimed by %q, want released", got)
+  }
+}
+
+// failingSession fails the statements whose CQL has the prefix fail.
+type failingSession struct {
+  datastore.Session
+  fail string
+}
+
+type failedIter struct {
+  datastore.Iter
+}
+
+func (failedIter) Close() error {
+  return errors.New("write failed")
+}
+
+func (s failingSession) Iter(ctx context.Context,
+  stmt *datastore.Statement) datastore.Iter {
+
+  iter := s.Session.Iter(ctx, stmt)
+  if strings.HasPrefix(stmt.CQL, s.fail) {
+    iter.Close()
+    return failedIter{iter}
+  }
+  return iter
+}
+
+func TestSaveUniqueRowError(t *testing.T) {
+  s := newUniqueStore(t)
+  fs := failingSession{s, "INSERT INTO accounts "}
+  b := &uniqueAccount{ID: "b", Email: "b@"}
+  if err := datastore.SaveEntity(fs, b); err == nil {
+    t.Fatal("save succeeded")
+  }
+  if got := claimOwner(t, s, "b@"); got != "" {
+    t.Errorf("b@ claimed by %q, want released", got)
+  }
+  if got := claimOwner(t, s, "a@"); got != "a" {
+    t.Errorf("a@ claimed by %q, want a", got)
+  }
+}
+
+// codecUniqueAccount has unique columns and a hand-written EntityCodec.
+type codecUniqueAccount struct {
+  ColumnFamily string `cql:"accounts"`
+  ID           string `cql:"id,pk"`
+  Email        string `cql:"email,unique"`
+}
+
+func (a *codecUniqueAccount) ColumnDest(name string) interface{} {
+  switch name {
+  case "id":
+    return &a.ID
+  case "email":
+    return &a.Email
+  }
+  return nil
+}
+
+func (a *codecUniqueAccount) SaveColumns() ([]string, []interface{}) {
+  return []string{"id", "email"}, []interface{}{a.ID, a.Email}
+}
+
+func TestSaveUniqueEntityCodec(t *testing.T) {
+  s := memstore.New()
+  if err := s.Register(reflect.TypeOf(codecUniqueAccount{})); err != nil {
+    t.Fatal(err)
+  }
+  err := datastore.SaveEntity(s, &codecUniqueAccount{ID: "a", Email: "a@"})
+  if err == nil || !strings.Contains(err.Error(), "unique columns") {
+    t.Fatalf("got %v, want a unique columns error", err)
+  }
+}
+
+func TestBatchSaveUnique(t *testing.T) {
+  b := datastore.NewBatch(gocql.UnloggedBatch)
+  err := b.Save(&uniqueAccount{ID: "b", Email: "a@"})
+  if err == nil || !strings.Contains(err.Error(), "unique columns") {
+    t.Fatalf("got %v, want a unique columns error", err)
+  }
+}
+
+func TestSaveMultiUnloggedBatchUnique(t *testing.T) {
+  s := newUniqueStore(t)
+  srcs := []interface{}{
+    &uniqueAccount{ID: "b", Email: "a@"},
+    &uniqueAccount{ID: "c", Email: "c@"},
+  }
+  err := datastore.SaveMulti(s, srcs, datastore.UnloggedBatch())
+  var errs datastore.MultiError
+  if !errors.As(err, &errs) {
+    t.Fatalf("got %v, want a MultiError", err)
+  }
+  var verr datastore.ValidationError
+  if !errors.As(errs[0], &verr) || errs[1] != nil {
+    t.Fatalf("got %v, want a validation error of the first entity", err)
+  }
+  if got := claimOwner(t, s, "a@"); got != "a" {
+    t.Errorf("a@ claimed by %q, want a", got)
+  }
+  if got := claimOwner(t, s, "c@"); got != "c" {
+    t.Errorf("c@ claimed by %q, want c", got)
+  }
+}
@@ -1,30 +1,74 @@
 package datastore
 
 import (
+  "context"
   "errors"
   "fmt"
   "reflect"
   "strings"
-
-  "github.com/gocql/gocql"
+  "time"
 )
 
-func NewUpdateQuery(typ reflect.Type) (*UpdateQuery, error) {
+func NewUpdateQuery(typ reflect.Type, opts ...Option) (*UpdateQuery, error) {
   codec, err := getStructCodec(typ)
   if err != nil {
     return nil, err
   }
   return &UpdateQuery{
-    codec:   codec,
-    updates: make(map[string]interface{}),
+    codec: codec,
+    opts:  opts,
   }, nil
 }
 
+type updateOp int
+
+const (
+  assign updateOp = iota
+  increment
+  decrement
+  // appendOp adds elements to the end of a list or to a set, prependOp to
+  // the beginning of a list, removeOp removes them from a list or a set.
+  appendOp
+  prependOp
+  removeOp
+  // putOp adds entries to a map, removeKeyOp removes keys from it.
+  putOp
+  removeKeyOp
+)
+
+// collectionOps gives the names of the collection mutations and the kinds
+// of columns they apply to.
+var collectionOps = map[updateOp]struct {
+  name  string
+  kinds []string
+}{
+  appendOp:    {"Append", []string{"list", "set"}},
+  prependOp:   {"Prepend", []string{"list"}},
+  removeOp:    {"Remove", []string{"list", "set"}},
+  putOp:       {"Put", []string{"map"}},
+  removeKeyOp: {"RemoveKey", []string{"map"}},
+}
+
+// update is a column assignment in the SET clause of an UpdateQuery.
+type update struct {
+  FieldName string
+  Op        updateOp
+  Value     interface{}
+}
+
 type UpdateQuery struct {
-  filter  []filter
-  ttl     int64
-  updates map[string]interface{}
+  filter []filter
+  // conditions are the IF clause conditions, AND'ed together.
+  conditions []filter
+  ifExists   bool
+  // versioned is set by IfVersion.
+  versioned bool
+  // updates are kept in the order they are added, one per column.
+  updates []update
   codec   *structCodec
+  opts    []Option
+  // params are the values bound to the named markers, see Param.
+  params map[string]interface{}
 
   err error
 }
@@ -35,80 +79,282 @@ func (q *UpdateQuery) clone() *UpdateQuery {
     x.filter = make([]filter, len(q.filter))
     copy(x.filter, q.filter)
   }
+  if len(q.conditions) > 0 {
+    x.conditions = make([]filter, len(q.conditions))
+    copy(x.conditions, q.conditions)
+  }
   if len(q.updates) > 0 {
-    x.updates = make(map[string]interface{})
-    for k, v := range q.updates {
-      x.updates[k] = v
-    }
+    x.updates = make([]update, len(q.updates))
+    copy(x.updates, q.updates)
   }
   return &x
 }
 
+// set returns a derivative query with u replacing any previous update of the
+// same column.
+func (q *UpdateQuery) set(u update) *UpdateQuery {
+  q = q.clone()
+  for i := range q.updates {
+    if q.updates[i].FieldName == u.FieldName {
+      q.updates[i] = u
+      return q
+    }
+  }
+  q.updates = append(q.updates, u)
+  return q
+}
+
 // Filter returns a derivative query with a field-based filter.
 // The filterStr argument must be a field name followed by optional space,
-// followed by an operator, one of ">", "<", ">=", "<=", or "=".
-// Fields are compared against the provided value using the operator.
+// followed by an operator, one of ">", "<", ">=", "<=", "=" or "in".
+// Fields are compared against the provided value using the operator; for
+// "in" the value must be a slice of the values to match.
 // Multiple filters are AND'ed together.
 func (q *UpdateQuery) Filter(filterStr string, value interface{}) *UpdateQuery {
   q = q.clone()
-  filterStr = strings.TrimSpace(filterStr)
-  if len(filterStr) < 1 {
-    q.err = errors.New("datastore: invalid filter: " + filterStr)
+  f, err := parseFilter(filterStr, value)
+  if err != nil {
+    q.err = err
     return q
   }
-  f := filter{
-    FieldName: strings.TrimRight(filterStr, " ><=!"),
-    Value:     value,
-  }
-  switch op := strings.TrimSpace(filterStr[len(f.FieldName):]); op {
-  case "<=":
-    f.Op = lessEq
-  case ">=":
-    f.Op = greaterEq
-  case "<":
-    f.Op = lessThan
-  case ">":
-    f.Op = greaterThan
-  case "=":
-    f.Op = equal
-  default:
-    q.err = fmt.Errorf("datastore: invalid operator %q in filter %q", op, filterStr)
+  q.filter = append(q.filter, f)
+  return q
+}
+
+// FilterField returns a derivative query with a filter comparing the field
+// fieldName to value with the operator op, see Query.FilterField.
+func (q *UpdateQuery) FilterField(fieldName string, op Operator,
+  value interface{}) *UpdateQuery {
+
+  q = q.clone()
+  f, err := newFilter(fieldName, op, value)
+  if err != nil {
+    q.err = err
     return q
   }
   q.filter = append(q.filter, f)
   return q
 }
 
+// TTL returns a derivative query whose updated columns expire after ttl
+// seconds, a positive number. It is a shorthand for passing the TTL option.
 func (q *UpdateQuery) TTL(ttl int64) *UpdateQuery {
   q = q.clone()
-  q.ttl = ttl
+  q.opts = append(q.opts[:len(q.opts):len(q.opts)],
+    TTL(time.Duration(ttl)*time.Second))
   return q
 }
 
 func (q *UpdateQuery) Update(fieldName string, fieldVal interface{}) *UpdateQuery {
+  return q.set(update{FieldName: fieldName, Op: assign, Value: fieldVal})
+}
+
+// If returns a derivative query that only applies if the column fieldName
+// currently holds value. Multiple conditions are AND'ed together.
+// Conditional updates are lightweight transactions, see RunCAS.
+func (q *UpdateQuery) If(fieldName string, value interface{}) *UpdateQuery {
+  q = q.clone()
+  q.conditions = append(q.conditions,
+    filter{FieldName: fieldName, Op: Equal, Value: value})
+  return q
+}
+
+// IfExists returns a derivative query that only applies if the row exists,
+// instead of creating it.
+func (q *UpdateQuery) IfExists() *UpdateQuery {
   q = q.clone()
-  q.updates[fieldName] = fieldVal
+  q.ifExists = true
   return q
 }
 
-func (q *UpdateQuery) toCQL() (cql string, args []interface{}, err error) {
-  usingTTL := " "
+// isConditional reports whether the update is a lightweight transaction.
+func (q *UpdateQuery) isConditional() bool {
+  return q.ifExists || len(q.conditions) > 0
+}
+
+// Increment returns a derivative query adding delta to the counter column
+// fieldName.
+func (q *UpdateQuery) Increment(fieldName string, delta int64) *UpdateQuery {
+  return q.set(update{FieldName: fieldName, Op: increment, Value: delta})
+}
+
+// Decrement returns a derivative query subtracting delta from the counter
+// column fieldName.
+func (q *UpdateQuery) Decrement(fieldName string, delta int64) *UpdateQuery {
+  return q.set(update{FieldName: fieldName, Op: decrement, Value: delta})
+}
 
-  if q.ttl > 0 {
-    usingTTL = fmt.Sprint(" USING TTL %d ", q.ttl)
+// Append returns a derivative query appending values, a slice or a single
+// element, to the list column fieldName, with SET col = col + ?. The
+// elements of a set are added the same way, see Add.
+func (q *UpdateQuery) Append(fieldName string,
+  values interface{}) *UpdateQuery {
+  return q.set(update{FieldName: fieldName, Op: appendOp,
+    Value: elems(values)})
+}
+
+// Prepend returns a derivative query prepending values, a slice or a single
+// element, to the list column fieldName, with SET col = ? + col.
+func (q *UpdateQuery) Prepend(fieldName string,
+  values interface{}) *UpdateQuery {
+  return q.set(update{FieldName: fieldName, Op: prependOp,
+    Value: elems(values)})
+}
+
+// Add returns a derivative query adding values, a slice or a single
+// element, to the set column fieldName.
+func (q *UpdateQuery) Add(fieldName string, values interface{}) *UpdateQuery {
+  return q.Append(fieldName, values)
+}
+
+// Remove returns a derivative query removing every occurrence of values, a
+// slice or a single element, from the list or set column fieldName, with
+// SET col = col - ?.
+func (q *UpdateQuery) Remove(fieldName string,
+  values interface{}) *UpdateQuery {
+  return q.set(update{FieldName: fieldName, Op: removeOp,
+    Value: elems(values)})
+}
+
+// Put returns a derivative query setting the entry key of the map column
+// fieldName to value, leaving the other entries alone. The entries put by
+// the query are written with SET col = col + ?.
+func (q *UpdateQuery) Put(fieldName string, key,
+  value interface{}) *UpdateQuery {
+
+  q = q.clone()
+  m, err := q.entries(fieldName)
+  if err != nil {
+    q.err = err
+    return q
+  }
+  k, kok := convertTo(key, m.Type().Key())
+  v, vok := convertTo(value, m.Type().Elem())
+  if !kok || !vok {
+    q.err = fmt.Errorf("datastore: cannot put a %T key and %T value into "+
+      "map column %s", key, value, fieldName)
+    return q
   }
+  m.SetMapIndex(k, v)
+  return q.set(update{FieldName: fieldName, Op: putOp, Value: m.Interface()})
+}
 
-  cql = fmt.Sprintf("UPDATE %s%sSET ", q.codec.columnFamily, usingTTL)
+// entries returns a copy of the entries put into the map column fieldName
+// so far, an empty map of the type of the field if none.
+func (q *UpdateQuery) entries(fieldName string) (reflect.Value, error) {
+  f, ok := q.codec.byName[fieldName]
+  if !ok || fieldName == "-" || q.codec.typ == nil {
+    return reflect.Value{},
+      fmt.Errorf("query : fieldname %s not found", fieldName)
+  }
+  t := q.codec.typ.FieldByIndex(q.codec.byIndex[f.index].index).Type
+  if t.Kind() != reflect.Map {
+    return reflect.Value{}, fmt.Errorf("datastore: Put on column %s, not "+
+      "a map", fieldName)
+  }
+  m := reflect.MakeMap(t)
+  for _, u := range q.updates {
+    if u.FieldName == fieldName && u.Op == putOp {
+      iter := reflect.ValueOf(u.Value).MapRange()
+      for iter.Next() {
+        m.SetMapIndex(iter.Key(), iter.Value())
+      }
+    }
+  }
+  return m, nil
+}
+
+// convertTo returns x converted to the type t. Numbers are not converted to
+// strings.
+func convertTo(x interface{}, t reflect.Type) (reflect.Value, bool) {
+  v := reflect.ValueOf(x)
+  if !v.IsValid() || !v.Type().ConvertibleTo(t) ||
+    t.Kind() == reflect.String && v.Kind() != reflect.String {
+    return reflect.Value{}, false
+  }
+  return v.Convert(t), true
+}
+
+// RemoveKey returns a derivative query removing the entries of keys from
+// the map column fieldName, with SET col = col - ?.
+func (q *UpdateQuery) RemoveKey(fieldName string,
+  keys ...interface{}) *UpdateQuery {
+
+  if len(keys) == 0 {
+    return q.clone()
+  }
+  return q.set(update{FieldName: fieldName, Op: removeKeyOp,
+    Value: elems(keys)})
+}
+
+// elems returns values if it is a slice or an array, or else a slice of
+// the single element values. The elements of a []interface{} are stored
+// in a slice of the type of the first one.
+func elems(values interface{}) interface{} {
+  v := reflect.ValueOf(values)
+  if !v.IsValid() {
+    return values
+  }
+  if s, ok := values.([]interface{}); ok && len(s) > 0 {
+    t := reflect.TypeOf(s[0])
+    res := reflect.MakeSlice(reflect.SliceOf(t), len(s), len(s))
+    for i, x := range s {
+      if x == nil || reflect.TypeOf(x) != t {
+        return values
+      }
+      res.Index(i).Set(reflect.ValueOf(x))
+    }
+    return res.Interface()
+  }
+  switch v.Kind() {
+  case reflect.Slice, reflect.Array:
+    if v.Type().Elem().Kind() != reflect.Uint8 {
+      return values
+    }
+  }
+  res := reflect.MakeSlice(reflect.SliceOf(v.Type()), 1, 1)
+  res.Index(0).Set(v)
+  return res.Interface()
+}
+
+// toCQL returns the statement of the query and the values bound to its
+// markers.
+func (q *UpdateQuery) toCQL(o *options) (string, []interface{}, error) {
+  cql, args, err := q.buildCQL(o)
+  if err != nil {
+    return "", nil, err
+  }
+  if args, err = bindParams(args, q.params); err != nil {
+    return "", nil, err
+  }
+  return cql, args, nil
+}
+
+// buildCQL builds the statement of the query, whose named markers are left
+// unbound.
+func (q *UpdateQuery) buildCQL(o *options) (cql string, args []interface{},
+  err error) {
+
+  if q.err != nil {
+    return "", nil, q.err
+  }
+  if err := q.codec.validateUpdates(q.updates, q.params); err != nil {
+    return "", nil, err
+  }
+  using, args, err := o.usingClause()
+  if err != nil {
+    return "", nil, err
+  }
+  cql = fmt.Sprintf("UPDATE %s%s SET ", o.tableOf(q.codec), using)
 
   if len(q.updates) > 0 {
-    updates := make([]string, len(q.updates))
-    i := 0
-    for k, v := range q.updates {
-      updates[i] = fmt.Sprintf("%s = ?", k)
-      args = append(args, v)
-      i += 1
+    updates, updateArgs, err := getSetClause(q.codec,
+      q.codec.autoUpdates(q.updates))
+    if err != nil {
+      return "", nil, err
     }
-    cql = cql + strings.Join(updates, ", ")
+    cql = cql + updates
+    args = append(args, updateArgs...)
   }
 
   whereClause, whereArgs, err := getWhereClause(q.codec, q.filter)
@@ -118,19 +364,225 @@ func (q *UpdateQuery) toCQL() (cql string, args []interface{}, err error) {
   cql = cql + whereClause
   args = append(args, whereArgs...)
 
+  ifClause, ifArgs, err := getIfClause(q.codec, q.conditions, q.ifExists)
+  if err != nil {
+    return "", nil, err
+  }
+  cql = cql + ifClause
+  args = append(args, ifArgs...)
   return cql, args, nil
 }
 
+// getIfClause is a helper function to get the IF clause of a conditional
+// statement.
+func getIfClause(codec *structCodec, conditions []filter, ifExists bool) (
+  string, []interface{}, error) {
+
+  if ifExists {
+    if len(conditions) > 0 {
+      return "", nil,
+        errors.New("datastore: IF EXISTS cannot be combined with conditions")
+    }
+    return " IF EXISTS", nil, nil
+  }
+  if len(conditions) == 0 {
+    return "", nil, nil
+  }
+  // the IF clause has the same shape as the WHERE clause
+  cond, args, err := getWhereClause(codec, conditions)
+  if err != nil {
+    return "", nil, err
+  }
+  return " IF" + strings.TrimPrefix(cond, " WHERE"), args, nil
+}
+
+// getSetClause is a helper function to get the assignments of the SET clause
+// of an UPDATE statement.
+func getSetClause(codec *structCodec, updates []update) (
+  set string, args []interface{}, err error) {
+
+  assignments := make([]string, len(updates))
+  for i, u := range updates {
+    f, ok := codec.byName[u.FieldName]
+    if !ok || u.FieldName == "-" {
+      return "", nil,
+        fmt.Errorf("query : fieldname %s not found", u.FieldName)
+    }
+    tag := codec.byIndex[f.index]
+    counter := tag.counter
+    if c, ok := collectionOps[u.Op]; ok {
+      if err := checkCollectionOp(codec, tag, c.name, c.kinds); err != nil {
+        return "", nil, err
+      }
+    } else {
+      switch {
+      case u.Op == assign && counter:
+        return "", nil, fmt.Errorf(
+          "datastore: counter column %s can only be incremented", u.FieldName)
+      case u.Op != assign && !counter:
+        return "", nil, fmt.Errorf(
+          "datastore: column %s is not a counter", u.FieldName)
+      }
+    }
+    switch u.Op {
+    case appendOp, putOp:
+      assignments[i] = fmt.Sprintf("%s = %s + %s", u.FieldName, u.FieldName,
+        marker(u.Value))
+    case prependOp:
+      assignments[i] = fmt.Sprintf("%s = %s + %s", u.FieldName,
+        marker(u.Value), u.FieldName)
+    case removeOp, removeKeyOp:
+      assignments[i] = fmt.Sprintf("%s = %s - %s", u.FieldName, u.FieldName,
+        marker(u.Value))
+    case increment:
+      assignments[i] = fmt.Sprintf("%s = %s + %s", u.FieldName, u.FieldName,
+        marker(u.Value))
+    case decrement:
+      assignments[i] = fmt.Sprintf("%s = %s - %s", u.FieldName, u.FieldName,
+        marker(u.Value))
+    default:
+      assignments[i] = fmt.Sprintf("%s = %s", u.FieldName, marker(u.Value))
+    }
+    args = append(args, u.Value)
+  }
+  return strings.Join(assignments, ", "), args, nil
+}
+
+// checkCollectionOp checks that the collection mutation op applies to the
+// column of tag, whose kind must be one of kinds. Frozen collections can
+// only be assigned as a whole.
+func checkCollectionOp(codec *structCodec, tag structTag, op string,
+  kinds []string) error {
+
+  if codec.typ == nil {
+    // the column types of tables queried by name are not known
+    return nil
+  }
+  kind := ""
+  switch t := codec.typ.FieldByIndex(tag.index).Type; {
+  case t.Kind() == reflect.Map:
+    kind = "map"
+  case isCollection(t) && tag.collection == "set":
+    kind = "set"
+  case isCollection(t):
+    kind = "list"
+  }
+  if kind == "" || !inColumns(kinds, kind) {
+    return fmt.Errorf("datastore: %s on column %s, not a %s", op, tag.name,
+      strings.Join(kinds, " or "))
+  }
+  if tag.frozen {
+    return fmt.Errorf("datastore: %s on frozen column %s, which can only "+
+      "be assigned", op, tag.name)
+  }
+  return nil
+}
+
+// CQL returns the CQL statement of the query.
 func (q *UpdateQuery) CQL() (string, error) {
-  cql, _, err := q.toCQL()
+  cql, _, err := q.Statement()
   return cql, err
 }
 
-func (q *UpdateQuery) Run(session *gocql.Session) error {
-  cql, args, err := q.toCQL()
+// Statement returns the CQL statement of the query along with the values
+// bound to its markers.
+func (q *UpdateQuery) Statement() (string, []interface{}, error) {
+  return q.toCQL(newOptions(context.Background(), q.opts))
+}
+
+// Run executes the update. The options override the ones the query was
+// created with. A conditional update that is not applied returns
+// ErrNotApplied.
+func (q *UpdateQuery) Run(session Session, opts ...Option) error {
+  return q.RunContext(context.Background(), session, opts...)
+}
+
+// RunContext is like Run but executes the update with ctx.
+func (q *UpdateQuery) RunContext(ctx context.Context, session Session,
+  opts ...Option) error {
+
+  o := newQueryOptions(ctx, q.opts, opts)
+  err := q.run(ctx, session, o)
+  rows := filteredRows(o, q.codec, q.filter, q.params)
+  if cerr := invalidate(ctx, o, rows); err == nil {
+    err = cerr
+  }
+  return err
+}
+
+// run executes the update with the options o.
+func (q *UpdateQuery) run(ctx context.Context, session Session,
+  o *options) error {
+
+  cql, args, err := q.toCQL(o)
   if err != nil {
     return err
   }
-  cqlQ := session.Query(cql, args...)
-  return cqlQ.Exec()
+  stmt := newStatement(o, cql, args)
+  if q.isConditional() {
+    iter, cancel := run(ctx, session, stmt)
+    defer cancel()
+    applied, err := scanCAS(iter, nil)
+    if err == nil && !applied {
+      err = ErrNotApplied
+      if q.versioned {
+        err = ErrConcurrentModification
+      }
+    }
+    return err
+  }
+  return exec(ctx, session, stmt)
+}
+
+// RunCAS executes a conditional update and reports whether it was applied.
+// If it was not, the current values of the columns involved in the
+// conditions are loaded into dst, a struct pointer of the query's entity
+// type, unless dst is nil.
+func (q *UpdateQuery) RunCAS(session Session, dst interface{},
+  opts ...Option) (applied bool, err error) {
+  return q.RunCASContext(context.Background(), session, dst, opts...)
+}
+
+// RunCASContext is like RunCAS but executes the update with ctx.
+func (q *UpdateQuery) RunCASContext(ctx context.Context,
+  session Session, dst interface{}, opts ...Option) (bool, error) {
+
+  if !q.isConditional() {
+    return false, errors.New("datastore: RunCAS on an unconditional update")
+  }
+  applied, _, err := q.runCAS(ctx, session, dst, opts)
+  return applied, err
+}
+
+// runCAS executes the conditional update like RunCAS, also reporting
+// whether the current values of the columns were loaded into dst, which
+// they are not if the update was not applied for lack of a row.
+func (q *UpdateQuery) runCAS(ctx context.Context, session Session,
+  dst interface{}, opts []Option) (applied, loaded bool, err error) {
+
+  var ls loadSaver
+  if dst != nil {
+    x, err := newLoadSaver(dst)
+    if err != nil {
+      return false, false, err
+    }
+    defer releaseLoadSaver(x)
+    ls = x
+  }
+  o := newQueryOptions(ctx, q.opts, opts)
+  cql, args, err := q.toCQL(o)
+  if err != nil {
+    return false, false, err
+  }
+  iter, cancel := run(ctx, session, newStatement(o, cql, args))
+  defer cancel()
+  cols := &columnsIter{Iter: iter}
+  applied, err = scanCAS(cols, ls)
+  rows := filteredRows(o, q.codec, q.filter, q.params)
+  if cerr := invalidate(ctx, o, rows); err == nil {
+    err = cerr
+  }
+  // a statement not applied for lack of a row returns [applied] only
+  loaded = !applied && ls != nil && len(cols.columns) > 1
+  return applied, loaded, err
 }
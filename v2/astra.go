@@ -0,0 +1,207 @@
+package datastore
+
+import (
+  "archive/zip"
+  "context"
+  "crypto/tls"
+  "crypto/x509"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io"
+  "math/rand"
+  "net"
+  "net/http"
+  "strconv"
+  "time"
+
+  "github.com/gocql/gocql"
+)
+
+// astraConfig is the config.json file of a secure connect bundle.
+type astraConfig struct {
+  Host     string `json:"host"`
+  Port     int    `json:"port"`
+  Keyspace string `json:"keyspace"`
+}
+
+// astraMetadata is the document of the metadata service of an Astra
+// database, telling how to reach its nodes.
+type astraMetadata struct {
+  ContactInfo struct {
+    LocalDC string `json:"local_dc"`
+    // ContactPoints are the host IDs of nodes.
+    ContactPoints []string `json:"contact_points"`
+    // SNIProxy is the address of the proxy the nodes are reached through,
+    // each connection naming its node by host ID in its TLS server name.
+    SNIProxy string `json:"sni_proxy_address"`
+  } `json:"contact_info"`
+}
+
+// astraTimeout bounds the request to the metadata service.
+const astraTimeout = 10 * time.Second
+
+// NewClientFromBundle connects to the DataStax Astra database described by
+// the secure connect bundle at path, authenticating with the client ID and
+// secret of an application token, and returns a Client owning the session.
+// The bundle provides the TLS certificates, the default keyspace and the
+// address of the metadata service, which lists the nodes and the proxy they
+// are reached through. The connect options apply on top of the settings of
+// the bundle.
+func NewClientFromBundle(path, clientID, secret string,
+  copts ...ConnectOption) (*Client, error) {
+
+  cluster, err := bundleCluster(path)
+  if err != nil {
+    return nil, err
+  }
+  cluster.Authenticator = gocql.PasswordAuthenticator{
+    Username: clientID,
+    Password: secret,
+  }
+  return Connect(cluster, copts...)
+}
+
+// bundleCluster returns the configuration of the cluster of the secure
+// connect bundle at path.
+func bundleCluster(path string) (*gocql.ClusterConfig, error) {
+  bundle, err := zip.OpenReader(path)
+  if err != nil {
+    return nil, fmt.Errorf("datastore: opening bundle: %v", err)
+  }
+  defer bundle.Close()
+  files := make(map[string][]byte)
+  for _, name := range []string{"config.json", "ca.crt", "cert", "key"} {
+    if files[name], err = readBundleFile(bundle, name); err != nil {
+      return nil, err
+    }
+  }
+  var cfg astraConfig
+  if err := json.Unmarshal(files["config.json"], &cfg); err != nil {
+    return nil, fmt.Errorf("datastore: decoding bundle config.json: %v", err)
+  }
+  roots := x509.NewCertPool()
+  if !roots.AppendCertsFromPEM(files["ca.crt"]) {
+    return nil, errors.New("datastore: no certificate in bundle ca.crt")
+  }
+  cert, err := tls.X509KeyPair(files["cert"], files["key"])
+  if err != nil {
+    return nil, fmt.Errorf("datastore: bundle certificate: %v", err)
+  }
+  tlsConfig := &tls.Config{
+    RootCAs:      roots,
+    Certificates: []tls.Certificate{cert},
+  }
+  meta, err := astraMetadataOf(cfg, tlsConfig)
+  if err != nil {
+    return nil, err
+  }
+  info := meta.ContactInfo
+  if len(info.ContactPoints) == 0 || info.SNIProxy == "" {
+    return nil, errors.New("datastore: no nodes in Astra metadata")
+  }
+  proxyHost, proxyPort, err := net.SplitHostPort(info.SNIProxy)
+  if err != nil {
+    return nil, fmt.Errorf("datastore: Astra proxy address: %v", err)
+  }
+  cluster := gocql.NewCluster(proxyHost)
+  if cluster.Port, err = strconv.Atoi(proxyPort); err != nil {
+    return nil, fmt.Errorf("datastore: Astra proxy port %q", proxyPort)
+  }
+  cluster.Keyspace = cfg.Keyspace
+  cluster.PoolConfig.HostSelectionPolicy = gocql.TokenAwareHostPolicy(
+    gocql.DCAwareRoundRobinPolicy(info.LocalDC))
+  cluster.HostDialer = &sniDialer{
+    proxy:    info.SNIProxy,
+    tls:      tlsConfig,
+    contacts: info.ContactPoints,
+    dialer:   net.Dialer{Timeout: cluster.ConnectTimeout},
+  }
+  return cluster, nil
+}
+
+// readBundleFile returns the content of the file name of bundle.
+func readBundleFile(bundle *zip.ReadCloser, name string) ([]byte, error) {
+  f, err := bundle.Open(name)
+  if err != nil {
+    return nil, fmt.Errorf("datastore: bundle %s: %v", name, err)
+  }
+  defer f.Close()
+  return io.ReadAll(f)
+}
+
+// astraMetadataOf fetches the metadata of the database of the bundle
+// configuration cfg, authenticating with tlsConfig.
+func astraMetadataOf(cfg astraConfig,
+  tlsConfig *tls.Config) (*astraMetadata, error) {
+
+  client := &http.Client{
+    Timeout:   astraTimeout,
+    Transport: &http.Transport{TLSClientConfig: tlsConfig},
+  }
+  url := "https://" + net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)) +
+    "/metadata"
+  resp, err := client.Get(url)
+  if err != nil {
+    return nil, fmt.Errorf("datastore: Astra metadata: %v", err)
+  }
+  defer resp.Body.Close()
+  if resp.StatusCode != http.StatusOK {
+    return nil, fmt.Errorf("datastore: Astra metadata: %s", resp.Status)
+  }
+  var meta astraMetadata
+  if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+    return nil, fmt.Errorf("datastore: decoding Astra metadata: %v", err)
+  }
+  return &meta, nil
+}
+
+// sniDialer connects to the nodes of an Astra database through its proxy,
+// naming the node by host ID in the TLS server name.
+type sniDialer struct {
+  proxy    string
+  tls      *tls.Config
+  contacts []string
+  dialer   net.Dialer
+}
+
+func (d *sniDialer) DialHost(ctx context.Context,
+  host *gocql.HostInfo) (*gocql.DialedHost, error) {
+
+  id := host.HostID()
+  if id == "" {
+    // the initial contact point, whose ID is not known yet
+    id = d.contacts[rand.Intn(len(d.contacts))]
+  }
+  conn, err := d.dialer.DialContext(ctx, "tcp", d.proxy)
+  if err != nil {
+    return nil, err
+  }
+  cfg := d.tls.Clone()
+  cfg.ServerName = id
+  // the certificate is the one of the proxy, not named after the node
+  cfg.InsecureSkipVerify = true
+  cfg.VerifyConnection = d.verify
+  return gocql.WrapTLS(ctx, conn, d.proxy, cfg)
+}
+
+// verify checks the certificate of the proxy against its host name.
+func (d *sniDialer) verify(cs tls.ConnectionState) error {
+  if len(cs.PeerCertificates) == 0 {
+    return errors.New("datastore: no certificate from the Astra proxy")
+  }
+  host, _, err := net.SplitHostPort(d.proxy)
+  if err != nil {
+    return err
+  }
+  opts := x509.VerifyOptions{
+    DNSName:       host,
+    Roots:         d.tls.RootCAs,
+    Intermediates: x509.NewCertPool(),
+  }
+  for _, cert := range cs.PeerCertificates[1:] {
+    opts.Intermediates.AddCert(cert)
+  }
+  _, err = cs.PeerCertificates[0].Verify(opts)
+  return err
+}
@@ -0,0 +1,42 @@
+package datastore
+
+import (
+  v1 "github.com/droot/datastore"
+  "github.com/gocql/gocql"
+)
+
+// Option configures how an operation is executed. It is the same type as in
+// the first version of the package.
+type Option = v1.Option
+
+// Client executes statements on a Cassandra session with a set of default
+// options.
+type Client struct {
+  session v1.Session
+  opts    []Option
+  // close closes the session, if owned by the client.
+  close func()
+}
+
+// NewClient returns a Client using session. The options are the defaults for
+// every operation executed by the client.
+func NewClient(session *gocql.Session, opts ...Option) *Client {
+  return NewClientWithSession(v1.NewSession(session), opts...)
+}
+
+// NewClientWithSession is like NewClient but executes the statements with
+// any implementation of the Session interface, e.g. a fake one in tests.
+func NewClientWithSession(session v1.Session, opts ...Option) *Client {
+  return &Client{session: session, opts: opts}
+}
+
+// Session returns the underlying session, to be used with the first version
+// of the package while migrating.
+func (c *Client) Session() v1.Session {
+  return c.session
+}
+
+// options returns the client defaults followed by opts.
+func (c *Client) options(opts []Option) []Option {
+  return append(c.opts[:len(c.opts):len(c.opts)], opts...)
+}
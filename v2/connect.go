@@ -0,0 +1,57 @@
+package datastore
+
+import (
+  v1 "github.com/droot/datastore"
+  "github.com/gocql/gocql"
+)
+
+// ConnectOption configures the session created by Connect.
+type ConnectOption func(*connectConfig)
+
+type connectConfig struct {
+  cluster *gocql.ClusterConfig
+  opts    []Option
+  // prepare hooks run once all the options are applied, before the
+  // session is created.
+  prepare []func(*gocql.ClusterConfig) error
+}
+
+// WithDefaults sets the default options of the client, see NewClient.
+func WithDefaults(opts ...Option) ConnectOption {
+  return func(c *connectConfig) {
+    c.opts = append(c.opts, opts...)
+  }
+}
+
+// Connect creates a session on a copy of cluster, configured by the
+// connect options, and returns a Client using it. The client owns the
+// session: Close closes it.
+func Connect(cluster *gocql.ClusterConfig, copts ...ConnectOption) (*Client,
+  error) {
+
+  cfg := *cluster
+  c := &connectConfig{cluster: &cfg}
+  for _, opt := range copts {
+    opt(c)
+  }
+  for _, prepare := range c.prepare {
+    if err := prepare(c.cluster); err != nil {
+      return nil, err
+    }
+  }
+  session, err := c.cluster.CreateSession()
+  if err != nil {
+    return nil, err
+  }
+  client := NewClientWithSession(v1.NewSession(session), c.opts...)
+  client.close = session.Close
+  return client, nil
+}
+
+// Close closes the session of a client created by Connect. It does nothing
+// for the clients of an existing session, which their owner closes.
+func (c *Client) Close() {
+  if c.close != nil {
+    c.close()
+  }
+}
@@ -0,0 +1,23 @@
+// Package datastore is the second major version of the Cassandra datastore
+// client library.
+//
+// Compared to the first version, every operation takes a context, entity
+// types are expressed with type parameters (Query[T], Repository[T]) instead
+// of reflect.Type values and interface{} destinations, statements run
+// through a Client instead of a bare *gocql.Session, and failures are
+// reported with a small error taxonomy (see Error and ErrNotFound).
+//
+// The package is built on top of github.com/droot/datastore and shares its
+// struct tags, codec and options, so both versions can be used side by side
+// while migrating. See MIGRATION.md for a guide.
+//
+// A Client wraps an existing session, or creates its own with Connect,
+// whose options tune the session for the cluster, e.g. Scylla:
+//
+//   client, err := datastore.Connect(cluster,
+//     datastore.Scylla(datastore.ScyllaConfig{Shards: 8, LWT: true}))
+//   defer client.Close()
+//
+// NewClientFromBundle connects to a DataStax Astra database with its secure
+// connect bundle.
+package datastore
@@ -0,0 +1,50 @@
+package datastore
+
+import (
+  "fmt"
+  "reflect"
+
+  v1 "github.com/droot/datastore"
+)
+
+// ErrNotFound is returned when an operation expecting a row finds none. It
+// is the ErrNoSuchEntity of the first version of the package.
+var ErrNotFound = v1.ErrNoSuchEntity
+
+// Op is the kind of operation that failed.
+type Op string
+
+const (
+  OpSave   Op = "save"
+  OpGet    Op = "get"
+  OpQuery  Op = "query"
+  OpUpdate Op = "update"
+  OpDelete Op = "delete"
+)
+
+// Error is returned by every failed operation except for ErrNotFound. Use
+// errors.As to inspect it and errors.Is to match the underlying error.
+type Error struct {
+  Op Op
+  // Type is the entity type the operation was executed for.
+  Type reflect.Type
+  // Err is the underlying error, as returned by the first version of the
+  // package or by gocql.
+  Err error
+}
+
+func (e *Error) Error() string {
+  return fmt.Sprintf("datastore: %s %v: %v", e.Op, e.Type, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+  return e.Err
+}
+
+// wrapErr wraps a non-nil err into an *Error.
+func wrapErr(op Op, typ reflect.Type, err error) error {
+  if err == nil || err == ErrNotFound {
+    return err
+  }
+  return &Error{Op: op, Type: typ, Err: err}
+}
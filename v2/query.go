@@ -0,0 +1,121 @@
+package datastore
+
+import (
+  "context"
+  "reflect"
+
+  v1 "github.com/droot/datastore"
+)
+
+// typeOf returns the reflect.Type of T.
+func typeOf[T any]() reflect.Type {
+  return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// Query is a CQL SELECT statement yielding entities of type T. Like in the
+// first version, the builder methods return derivative queries and errors
+// are reported when the query runs.
+type Query[T any] struct {
+  c *Client
+  q *v1.Query
+}
+
+// NewQuery returns a query over the column family of T.
+func NewQuery[T any](c *Client) (*Query[T], error) {
+  q, err := v1.NewQuery(typeOf[T]())
+  if err != nil {
+    return nil, wrapErr(OpQuery, typeOf[T](), err)
+  }
+  return &Query[T]{c: c, q: q}, nil
+}
+
+// FromV1 adapts a query built with the first version of the package. T must
+// be the entity type q was created for.
+func FromV1[T any](c *Client, q *v1.Query) *Query[T] {
+  return &Query[T]{c: c, q: q}
+}
+
+// Filter returns a derivative query with a field-based filter, see
+// v1.Query.Filter.
+func (q *Query[T]) Filter(filterStr string, value interface{}) *Query[T] {
+  return &Query[T]{c: q.c, q: q.q.Filter(filterStr, value)}
+}
+
+// Order returns a derivative query with a field-based sort order, see
+// v1.Query.Order.
+func (q *Query[T]) Order(fieldName string) *Query[T] {
+  return &Query[T]{c: q.c, q: q.q.Order(fieldName)}
+}
+
+// Project returns a derivative query that yields only the given fields.
+func (q *Query[T]) Project(fieldNames ...string) *Query[T] {
+  return &Query[T]{c: q.c, q: q.q.Project(fieldNames...)}
+}
+
+// Limit returns a derivative query that has a limit on the number of
+// results returned. A negative value means unlimited.
+func (q *Query[T]) Limit(limit int) *Query[T] {
+  return &Query[T]{c: q.c, q: q.q.Limit(limit)}
+}
+
+// Iter runs the query and returns an iterator over its results.
+func (q *Query[T]) Iter(ctx context.Context, opts ...Option) *Iterator[T] {
+  it := q.q.RunContext(ctx, q.c.session, q.c.options(opts)...)
+  return &Iterator[T]{it: it}
+}
+
+// All runs the query and returns all of its results.
+func (q *Query[T]) All(ctx context.Context, opts ...Option) ([]T, error) {
+  it := q.Iter(ctx, opts...)
+  defer it.Close()
+  var all []T
+  for {
+    v, ok, err := it.Next()
+    if err != nil {
+      return nil, err
+    }
+    if !ok {
+      return all, nil
+    }
+    all = append(all, v)
+  }
+}
+
+// First runs the query and returns its first result, or ErrNotFound if it
+// has none.
+func (q *Query[T]) First(ctx context.Context, opts ...Option) (T, error) {
+  it := q.Limit(1).Iter(ctx, opts...)
+  defer it.Close()
+  v, ok, err := it.Next()
+  if err == nil && !ok {
+    err = ErrNotFound
+  }
+  return v, err
+}
+
+// Iterator is the result of running a Query[T].
+type Iterator[T any] struct {
+  it   *v1.Iterator
+  done bool
+}
+
+// Next returns the next result. ok is false once the results are exhausted.
+func (it *Iterator[T]) Next() (v T, ok bool, err error) {
+  if it.done {
+    return v, false, nil
+  }
+  switch err = it.it.Next(&v); err {
+  case nil:
+    return v, true, nil
+  case v1.Done:
+    it.done = true
+    return v, false, nil
+  }
+  it.done = true
+  return v, false, wrapErr(OpQuery, typeOf[T](), err)
+}
+
+// Close releases the iterator.
+func (it *Iterator[T]) Close() error {
+  return wrapErr(OpQuery, typeOf[T](), it.it.Close())
+}
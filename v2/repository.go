@@ -0,0 +1,81 @@
+package datastore
+
+import (
+  "context"
+
+  v1 "github.com/droot/datastore"
+)
+
+// Repository gives access to the entities of type T stored through a
+// Client.
+type Repository[T any] struct {
+  c *Client
+}
+
+// NewRepository returns a repository of T entities using c.
+func NewRepository[T any](c *Client) *Repository[T] {
+  return &Repository[T]{c: c}
+}
+
+// Save inserts or overwrites e.
+func (r *Repository[T]) Save(ctx context.Context, e *T, opts ...Option) error {
+  err := v1.SaveEntityContext(ctx, r.c.session, e, r.c.options(opts)...)
+  return wrapErr(OpSave, typeOf[T](), err)
+}
+
+// Get returns the entity whose primary key columns equal key, given in the
+// order of the partition and clustering keys. ErrNotFound is returned if
+// there is none.
+func (r *Repository[T]) Get(ctx context.Context, key ...interface{}) (*T,
+  error) {
+
+  e := new(T)
+  err := v1.GetContext(ctx, r.c.session, e, key, r.c.options(nil)...)
+  if err != nil {
+    return nil, wrapErr(OpGet, typeOf[T](), err)
+  }
+  return e, nil
+}
+
+// Delete deletes the row of e.
+func (r *Repository[T]) Delete(ctx context.Context, e *T,
+  opts ...Option) error {
+  err := v1.DeleteEntityContext(ctx, r.c.session, e, r.c.options(opts)...)
+  return wrapErr(OpDelete, typeOf[T](), err)
+}
+
+// Query returns a query over all the entities of the repository.
+func (r *Repository[T]) Query() (*Query[T], error) {
+  return NewQuery[T](r.c)
+}
+
+// Update returns an update of the entities of the repository.
+func (r *Repository[T]) Update() (*Update[T], error) {
+  u, err := v1.NewUpdateQuery(typeOf[T]())
+  if err != nil {
+    return nil, wrapErr(OpUpdate, typeOf[T](), err)
+  }
+  return &Update[T]{c: r.c, u: u}, nil
+}
+
+// Update is a CQL UPDATE statement on the column family of T.
+type Update[T any] struct {
+  c *Client
+  u *v1.UpdateQuery
+}
+
+// Filter returns a derivative update with a field-based filter.
+func (u *Update[T]) Filter(filterStr string, value interface{}) *Update[T] {
+  return &Update[T]{c: u.c, u: u.u.Filter(filterStr, value)}
+}
+
+// Set returns a derivative update setting fieldName to value.
+func (u *Update[T]) Set(fieldName string, value interface{}) *Update[T] {
+  return &Update[T]{c: u.c, u: u.u.Update(fieldName, value)}
+}
+
+// Exec executes the update.
+func (u *Update[T]) Exec(ctx context.Context, opts ...Option) error {
+  err := u.u.RunContext(ctx, u.c.session, u.c.options(opts)...)
+  return wrapErr(OpUpdate, typeOf[T](), err)
+}
@@ -0,0 +1,40 @@
+package datastore_test
+
+import (
+  "context"
+  "reflect"
+  "testing"
+
+  "github.com/droot/datastore/memstore"
+  "github.com/droot/datastore/v2"
+)
+
+type note struct {
+  ColumnFamily string `cql:"notes"`
+  Owner        string `cql:"owner,pk"`
+  Seq          int    `cql:"seq,ck"`
+  Text         string `cql:"text"`
+}
+
+func TestRepositoryGetDelete(t *testing.T) {
+  s := memstore.New()
+  if err := s.Register(reflect.TypeOf(note{})); err != nil {
+    t.Fatal(err)
+  }
+  notes := datastore.NewRepository[note](datastore.NewClientWithSession(s))
+  ctx := context.Background()
+  n := &note{Owner: "me", Seq: 1, Text: "hello"}
+  if err := notes.Save(ctx, n); err != nil {
+    t.Fatal(err)
+  }
+  got, err := notes.Get(ctx, "me", 1)
+  if err != nil || *got != *n {
+    t.Fatalf("got %+v, %v, want %+v", got, err, n)
+  }
+  if err := notes.Delete(ctx, got); err != nil {
+    t.Fatal(err)
+  }
+  if _, err := notes.Get(ctx, "me", 1); err != datastore.ErrNotFound {
+    t.Errorf("got %v, want ErrNotFound", err)
+  }
+}
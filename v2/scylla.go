@@ -0,0 +1,168 @@
+package datastore
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "math/rand"
+  "net"
+  "strconv"
+  "sync"
+  "syscall"
+
+  "github.com/gocql/gocql"
+)
+
+// ScyllaConfig tunes the session of a Client for a ScyllaDB cluster, see
+// Scylla. ScyllaDB runs a thread per CPU core, a shard, each owning a slice
+// of the data and the connections it accepts.
+type ScyllaConfig struct {
+  // Shards is the number of shards of the nodes, required.
+  Shards int
+  // ConnsPerShard is the number of connections opened to each shard of
+  // each node, 1 by default.
+  ConnsPerShard int
+  // ShardAwarePort is the shard-aware port of the nodes, 19042 by default,
+  // or 19142 with TLS. A connection to it is handed to the shard given by
+  // its source port modulo the number of shards: the session picks the
+  // source ports so that the connections to a node are spread evenly over
+  // its shards. Set it to -1 to connect to the regular port, where the
+  // connections land on arbitrary shards.
+  ShardAwarePort int
+  // LWT routes the statements of a partition to its replicas in the same
+  // order, so that the lightweight transactions on a partition are
+  // coordinated by its primary replica rather than contending for it from
+  // several nodes. The statements are routed by token in the local
+  // datacenter, if set, or over the whole cluster.
+  LWT bool
+  // LocalDC is the datacenter of the client for LWT routing.
+  LocalDC string
+}
+
+// shardDialAttempts bounds the source ports tried per connection, in use
+// ones being skipped.
+const shardDialAttempts = 8
+
+// Scylla configures the session for a ScyllaDB cluster as described by
+// cfg. The shard-aware port needs the nodes to have the same number of
+// shards; TLS is set with cluster.SslOpts.Config, the certificate paths of
+// SslOpts being ignored.
+func Scylla(cfg ScyllaConfig) ConnectOption {
+  return func(c *connectConfig) {
+    c.prepare = append(c.prepare, cfg.apply)
+  }
+}
+
+// apply configures cluster.
+func (cfg ScyllaConfig) apply(cluster *gocql.ClusterConfig) error {
+  if cfg.Shards <= 0 {
+    return errors.New("datastore: Scylla needs the number of shards")
+  }
+  conns := cfg.ConnsPerShard
+  if conns <= 0 {
+    conns = 1
+  }
+  cluster.NumConns = cfg.Shards * conns
+  if cfg.LWT {
+    fallback := gocql.RoundRobinHostPolicy()
+    if cfg.LocalDC != "" {
+      fallback = gocql.DCAwareRoundRobinPolicy(cfg.LocalDC)
+    }
+    // the replicas are not shuffled, the primary one coming first
+    cluster.PoolConfig.HostSelectionPolicy =
+      gocql.TokenAwareHostPolicy(fallback)
+  }
+  if cfg.ShardAwarePort < 0 {
+    return nil
+  }
+  d := &shardDialer{
+    port:   cfg.ShardAwarePort,
+    shards: cfg.Shards,
+    dialer: net.Dialer{
+      Timeout:   cluster.ConnectTimeout,
+      KeepAlive: cluster.SocketKeepalive,
+    },
+    next: make(map[string]int),
+  }
+  if cluster.SslOpts != nil {
+    if cluster.SslOpts.Config == nil {
+      return errors.New("datastore: Scylla needs the TLS settings in " +
+        "SslOpts.Config")
+    }
+    d.tls = cluster.SslOpts
+  }
+  if d.port == 0 {
+    d.port = 19042
+    if d.tls != nil {
+      d.port = 19142
+    }
+  }
+  cluster.HostDialer = d
+  return nil
+}
+
+// shardDialer connects to the shard-aware port of the nodes, each new
+// connection to a node going to its next shard.
+type shardDialer struct {
+  port   int
+  shards int
+  dialer net.Dialer
+  tls    *gocql.SslOptions
+
+  mu sync.Mutex
+  // next is the shard of the next connection by node.
+  next map[string]int
+}
+
+func (d *shardDialer) DialHost(ctx context.Context,
+  host *gocql.HostInfo) (*gocql.DialedHost, error) {
+
+  ip := host.ConnectAddress()
+  if ip == nil || ip.IsUnspecified() {
+    return nil, fmt.Errorf("datastore: host %s has no address", host)
+  }
+  d.mu.Lock()
+  shard := d.next[ip.String()]
+  d.next[ip.String()] = (shard + 1) % d.shards
+  d.mu.Unlock()
+
+  addr := net.JoinHostPort(ip.String(), strconv.Itoa(d.port))
+  var conn net.Conn
+  var err error
+  for i := 0; i < shardDialAttempts; i++ {
+    dialer := d.dialer
+    dialer.LocalAddr = &net.TCPAddr{Port: shardPort(shard, d.shards)}
+    conn, err = dialer.DialContext(ctx, "tcp", addr)
+    if !errors.Is(err, syscall.EADDRINUSE) {
+      break
+    }
+  }
+  if err != nil {
+    return nil, err
+  }
+  if d.tls == nil {
+    return &gocql.DialedHost{Conn: conn}, nil
+  }
+  return gocql.WrapTLS(ctx, conn, addr, d.tls.Config)
+}
+
+// The range of the source ports picked by shardPort, the ephemeral ports
+// of IANA.
+const (
+  minSourcePort = 49152
+  maxSourcePort = 65535
+)
+
+// shardPort returns a random source port of the connections to shard, the
+// ports equal to the shard modulo the number of shards.
+func shardPort(shard, shards int) int {
+  p := minSourcePort + rand.Intn(maxSourcePort-minSourcePort+1)
+  p += shard - p%shards
+  if p < minSourcePort {
+    p += shards
+  }
+  if p > maxSourcePort {
+    p -= shards
+  }
+  return p
+}
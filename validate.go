@@ -0,0 +1,196 @@
+package datastore
+
+import (
+  "fmt"
+  "reflect"
+  "strings"
+  "unicode/utf8"
+)
+
+// Validator is implemented by entities that validate themselves before
+// being saved, after their BeforeSave hook and the validation rules of their
+// tags. An error aborts the save; returning a ValidationError reports the
+// failures per column.
+type Validator interface {
+  Validate() error
+}
+
+// FieldError is a validation rule failed by the value of a column.
+type FieldError struct {
+  // Field is the name of the column.
+  Field string
+  // Rule is the failed rule, "required", "maxlen" or "regexp" for the
+  // rules of the tags.
+  Rule string
+  // Message describes the failure.
+  Message string
+}
+
+func (e *FieldError) Error() string {
+  return e.Field + " " + e.Message
+}
+
+// ValidationError is returned by the saves of entities, and the updates of
+// columns, whose values fail the validation rules of their tags, before
+// anything is sent to Cassandra:
+//
+//   required  the value is not zero, nor an empty string, slice or map
+//   maxlen=N  the string has at most N characters, the slice or map at
+//             most N elements
+//   regexp=R  the string, unless empty, matches the regular expression R
+//
+// It lists a FieldError per failed rule.
+type ValidationError []*FieldError
+
+func (e ValidationError) Error() string {
+  msgs := make([]string, len(e))
+  for i, fe := range e {
+    msgs[i] = fe.Error()
+  }
+  return "datastore: invalid values: " + strings.Join(msgs, "; ")
+}
+
+// checkValidatedField checks that the validation rules of tag apply to the
+// field f.
+func checkValidatedField(tag *structTag, f reflect.StructField) error {
+  if !tag.validated() {
+    return nil
+  }
+  if tag.function != "" || tag.counter {
+    return fmt.Errorf("datastore: validation rules on function or "+
+      "counter field %s", f.Name)
+  }
+  t := f.Type
+  if t.Kind() == reflect.Ptr {
+    t = t.Elem()
+  }
+  switch {
+  case tag.pattern != nil && t.Kind() != reflect.String:
+    return fmt.Errorf("datastore: regexp option on non string field %s",
+      f.Name)
+  case tag.maxLen > 0 && t.Kind() != reflect.String &&
+    t.Kind() != reflect.Slice && t.Kind() != reflect.Map:
+    return fmt.Errorf("datastore: maxlen option on field %s of type %v",
+      f.Name, f.Type)
+  }
+  return nil
+}
+
+// validated reports whether the column has validation rules.
+func (tag *structTag) validated() bool {
+  return tag.required || tag.maxLen > 0 || tag.pattern != nil
+}
+
+// prepareSave readies the struct v to be saved: it runs its BeforeSave
+// hook, sets its auto times and validates it.
+func (codec *structCodec) prepareSave(v reflect.Value) error {
+  p := v.Addr().Interface()
+  if err := beforeSave(p); err != nil {
+    return err
+  }
+  codec.setAutoTimes(v)
+  var errs ValidationError
+  for _, tag := range codec.byIndex {
+    if tag.validated() {
+      errs = tag.validate(v.FieldByIndex(tag.index), errs)
+    }
+  }
+  if len(errs) > 0 {
+    return errs
+  }
+  return validate(p)
+}
+
+// prepare prepares the entity to be saved, once per save, see prepareSave.
+func (cls *structCLS) prepare() error {
+  if cls.prepared {
+    return nil
+  }
+  cls.prepared = true
+  return cls.codec.prepareSave(cls.v)
+}
+
+// validate calls the Validate method of the entity p, if any.
+func validate(p interface{}) error {
+  if v, ok := p.(Validator); ok {
+    return v.Validate()
+  }
+  return nil
+}
+
+// validateUpdates checks the values assigned by updates against the
+// validation rules of their columns, the values of the named markers being
+// bound in params. The values of unbound markers are not checked.
+func (codec *structCodec) validateUpdates(updates []update,
+  params map[string]interface{}) error {
+
+  var errs ValidationError
+  for _, u := range updates {
+    f, ok := codec.byName[u.FieldName]
+    if !ok || u.Op != assign {
+      continue
+    }
+    tag := codec.byIndex[f.index]
+    if !tag.validated() {
+      continue
+    }
+    value := u.Value
+    if p, ok := value.(param); ok {
+      if value, ok = params[p.name]; !ok {
+        continue
+      }
+    }
+    errs = tag.validate(reflect.ValueOf(value), errs)
+  }
+  if len(errs) > 0 {
+    return errs
+  }
+  return nil
+}
+
+// validate appends to errs the failures of the value v to the validation
+// rules of the column.
+func (tag *structTag) validate(v reflect.Value,
+  errs ValidationError) ValidationError {
+
+  fail := func(rule, format string, args ...interface{}) {
+    errs = append(errs, &FieldError{Field: tag.name, Rule: rule,
+      Message: fmt.Sprintf(format, args...)})
+  }
+  for v.IsValid() && (v.Kind() == reflect.Ptr ||
+    v.Kind() == reflect.Interface) && !v.IsNil() {
+    v = v.Elem()
+  }
+  if !v.IsValid() || isEmpty(v) {
+    if tag.required {
+      fail("required", "is required")
+    }
+    return errs
+  }
+  if tag.maxLen > 0 {
+    n := -1
+    switch v.Kind() {
+    case reflect.String:
+      n = utf8.RuneCountInString(v.String())
+    case reflect.Slice, reflect.Map, reflect.Array:
+      n = v.Len()
+    }
+    if n > tag.maxLen {
+      fail("maxlen", "is longer than %d", tag.maxLen)
+    }
+  }
+  if tag.pattern != nil && v.Kind() == reflect.String &&
+    !tag.pattern.MatchString(v.String()) {
+    fail("regexp", "does not match %s", tag.pattern)
+  }
+  return errs
+}
+
+// isEmpty reports whether v is zero, or an empty string, slice or map.
+func isEmpty(v reflect.Value) bool {
+  switch v.Kind() {
+  case reflect.String, reflect.Slice, reflect.Map:
+    return v.Len() == 0
+  }
+  return v.IsZero()
+}
@@ -0,0 +1,128 @@
+package datastore
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "reflect"
+)
+
+// ErrConcurrentModification is returned when saving an entity with a version
+// column, or running an UpdateQuery conditioned with IfVersion, fails
+// because the row was modified since its version was read.
+//
+// A save of an entity whose version is zero inserts the row if it does not
+// exist yet, with version 1. A save of an entity whose version is not zero
+// updates the row only if it still has the same version, incrementing it.
+// The version field of the entity is left unchanged when the save fails.
+// Only the reflection based entities are versioned: the versions of a
+// ColumnLoadSaver are saved as is, SaveEntity fails for an EntityCodec with
+// a version column, and Batch.Save for any versioned type.
+var ErrConcurrentModification = errors.New(
+  "datastore: concurrent modification")
+
+// checkVersionField checks the field f holding the version column of tag
+// and records it as the version column of the type.
+func (c *structCodec) checkVersionField(tag *structTag,
+  f reflect.StructField) error {
+
+  if !tag.version {
+    return nil
+  }
+  if k := f.Type.Kind(); k != reflect.Int64 && k != reflect.Int {
+    return fmt.Errorf("datastore: version field %s must be an int64",
+      f.Name)
+  }
+  if tag.partitionKey || tag.clusteringKey || tag.counter ||
+    tag.omitEmpty || tag.function != "" {
+    return fmt.Errorf("datastore: version field %s cannot have key, "+
+      "counter, omitempty or function options", f.Name)
+  }
+  if c.version != "" {
+    return fmt.Errorf("datastore: several version fields in %v", c.typ)
+  }
+  c.version = tag.name
+  return nil
+}
+
+// saveVersioned saves the entity adapted by cls, whose type has a version
+// column, see ErrConcurrentModification.
+func saveVersioned(ctx context.Context, session Session, cls *structCLS,
+  o *options) error {
+
+  if cls.codec.hasCounters {
+    return fmt.Errorf("datastore: cannot insert into counter "+
+      "table %s, use UpdateQuery.Increment", cls.codec.columnFamily)
+  }
+  version := cls.field(cls.codec.byName[cls.codec.version].index)
+  cur := version.Int()
+  var (
+    cql  string
+    args []interface{}
+    err  error
+  )
+  version.SetInt(cur + 1)
+  if cur == 0 {
+    insert := *o
+    insert.ifNotExists = true
+    cql, args, err = cls.insertCQL(&insert)
+  } else {
+    cql, args, err = cls.updateVersionedCQL(cur, o)
+  }
+  if err != nil {
+    version.SetInt(cur)
+    return err
+  }
+  iter, cancel := run(ctx, session, newStatement(o, cql, args))
+  defer cancel()
+  applied, err := o.scanCAS(iter, nil)
+  if err == nil && !applied {
+    err = ErrConcurrentModification
+  }
+  if err != nil {
+    version.SetInt(cur)
+  }
+  return err
+}
+
+// updateVersionedCQL returns the UPDATE statement saving the entity if its
+// row still has the version cur, and its bound values. The entity is
+// prepared first, see prepare.
+func (cls *structCLS) updateVersionedCQL(cur int64, o *options) (string,
+  []interface{}, error) {
+
+  if err := cls.prepare(); err != nil {
+    return "", nil, err
+  }
+  q := &UpdateQuery{codec: cls.codec}
+  for i, v := range cls.codec.byIndex {
+    switch {
+    case !v.stored():
+    case v.partitionKey || v.clusteringKey:
+      q.filter = append(q.filter,
+        filter{FieldName: v.name, Op: Equal, Value: cls.fieldValue(i)})
+    case v.omitEmpty && cls.field(i).IsZero():
+    default:
+      q.updates = append(q.updates,
+        update{FieldName: v.name, Op: assign, Value: cls.fieldValue(i)})
+    }
+  }
+  q.conditions = []filter{{FieldName: cls.codec.version, Op: Equal,
+    Value: cur}}
+  return q.toCQL(o)
+}
+
+// IfVersion returns a derivative query that only applies if the version
+// column of the entity type still holds expected, and increments it. A
+// query that is not applied then fails with ErrConcurrentModification
+// rather than ErrNotApplied.
+func (q *UpdateQuery) IfVersion(expected int64) *UpdateQuery {
+  q = q.clone()
+  if q.codec.version == "" {
+    q.err = fmt.Errorf("datastore: %v has no version field", q.codec.typ)
+    return q
+  }
+  q = q.If(q.codec.version, expected).Update(q.codec.version, expected+1)
+  q.versioned = true
+  return q
+}
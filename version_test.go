@@ -0,0 +1,99 @@
+package datastore_test
+
+import (
+  "errors"
+  "reflect"
+  "strings"
+  "testing"
+
+  "github.com/droot/datastore"
+  "github.com/droot/datastore/memstore"
+  "github.com/gocql/gocql"
+)
+
+// codecAccount is a versioned entity with a hand-written EntityCodec.
+type codecAccount struct {
+  ColumnFamily string `cql:"accounts"`
+  ID           string `cql:"id,pk"`
+  Version      int64  `cql:"version,version"`
+}
+
+func (a *codecAccount) ColumnDest(name string) interface{} {
+  switch name {
+  case "id":
+    return &a.ID
+  case "version":
+    return &a.Version
+  }
+  return nil
+}
+
+func (a *codecAccount) SaveColumns() ([]string, []interface{}) {
+  return []string{"id", "version"}, []interface{}{a.ID, a.Version}
+}
+
+func TestSaveVersionedEntityCodec(t *testing.T) {
+  s := memstore.New()
+  if err := s.Register(reflect.TypeOf(codecAccount{})); err != nil {
+    t.Fatal(err)
+  }
+  err := datastore.SaveEntity(s, &codecAccount{ID: "a", Version: 3})
+  if err == nil || !strings.Contains(err.Error(), "version column") {
+    t.Fatalf("got %v, want a version column error", err)
+  }
+}
+
+// versionedAccount is a versioned reflection based entity.
+type versionedAccount struct {
+  ColumnFamily string `cql:"versioned_accounts"`
+  ID           string `cql:"id,pk"`
+  Balance      int64  `cql:"balance"`
+  Version      int64  `cql:"version,version"`
+}
+
+func TestBatchSaveVersioned(t *testing.T) {
+  s := memstore.New()
+  if err := s.Register(reflect.TypeOf(versionedAccount{})); err != nil {
+    t.Fatal(err)
+  }
+  b := datastore.NewBatch(gocql.UnloggedBatch)
+  err := b.Save(&versionedAccount{ID: "a"})
+  if err == nil || !strings.Contains(err.Error(), "version column") {
+    t.Fatalf("got %v, want a version column error", err)
+  }
+}
+
+func TestSaveMultiUnloggedBatchVersioned(t *testing.T) {
+  s := memstore.New()
+  if err := s.Register(reflect.TypeOf(versionedAccount{})); err != nil {
+    t.Fatal(err)
+  }
+  a := &versionedAccount{ID: "a", Balance: 1}
+  if err := datastore.SaveEntity(s, a); err != nil {
+    t.Fatal(err)
+  }
+  stale := *a
+  a.Balance = 2
+  if err := datastore.SaveEntity(s, a); err != nil {
+    t.Fatal(err)
+  }
+  stale.Balance = 3
+  err := datastore.SaveMulti(s, []interface{}{&stale},
+    datastore.UnloggedBatch())
+  var errs datastore.MultiError
+  if !errors.As(err, &errs) ||
+    !errors.Is(errs[0], datastore.ErrConcurrentModification) {
+    t.Fatalf("got %v, want a concurrent modification", err)
+  }
+  var got versionedAccount
+  q, err := datastore.NewQuery(reflect.TypeOf(got))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if err := q.Filter("id =", "a").First(s, &got); err != nil {
+    t.Fatal(err)
+  }
+  if got.Balance != 2 || got.Version != 2 {
+    t.Errorf("got %+v, want balance 2 at version 2", got)
+  }
+}
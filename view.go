@@ -0,0 +1,192 @@
+package datastore
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "reflect"
+  "strings"
+)
+
+// ViewDef declares a materialized view of the table of an entity type: the
+// columns it selects and its primary key.
+type ViewDef struct {
+  Name string
+  // Columns are the columns of the base table selected by the view, all of
+  // them when empty. The primary key columns are always selected.
+  Columns []string
+  // PartitionKeys and ClusteringKeys make up the primary key of the view.
+  // It must include the primary key columns of the base table and at most
+  // one other column.
+  PartitionKeys  []string
+  ClusteringKeys []string
+  // Descending lists the clustering columns sorted in descending order.
+  Descending []string
+}
+
+// View is a materialized view of the table of an entity type, whose rows
+// load into the entity type like the rows of the table.
+type View struct {
+  def  ViewDef
+  base *structCodec
+  // codec describes the entity type as stored in the view.
+  codec *structCodec
+}
+
+// NewView declares the materialized view def of the table of the entity type
+// typ. The view is created with View.Create and queried with NewViewQuery.
+func NewView(typ reflect.Type, def ViewDef) (*View, error) {
+  base, err := getStructCodec(typ)
+  if err != nil {
+    return nil, err
+  }
+  if def.Name == "" {
+    return nil, errors.New("datastore: empty view name")
+  }
+  if len(def.PartitionKeys) == 0 {
+    return nil, fmt.Errorf("datastore: no partition key column in view %s",
+      def.Name)
+  }
+  if base.hasCounters {
+    return nil, fmt.Errorf("datastore: no view of counter table %s",
+      base.columnFamily)
+  }
+  keys := append(append([]string(nil), def.PartitionKeys...),
+    def.ClusteringKeys...)
+  for _, col := range append(keys, def.Columns...) {
+    if f, ok := base.byName[col]; !ok || !base.byIndex[f.index].stored() {
+      return nil, fmt.Errorf("datastore: no column %s in %v", col, typ)
+    }
+  }
+  for _, col := range def.Descending {
+    if !inColumns(def.ClusteringKeys, col) {
+      return nil, fmt.Errorf("datastore: descending column %s of view %s "+
+        "is not a clustering column", col, def.Name)
+    }
+  }
+  extra := 0
+  for _, col := range keys {
+    if !inColumns(base.keyColumns(), col) {
+      extra++
+    }
+  }
+  if len(keys)-extra != len(base.keyColumns()) || extra > 1 {
+    return nil, fmt.Errorf("datastore: primary key of view %s must include "+
+      "the primary key of %s and at most one other column", def.Name,
+      base.columnFamily)
+  }
+  return &View{def: def, base: base, codec: viewCodec(base, def)}, nil
+}
+
+// viewCodec returns the codec of the entity type of base as stored in the
+// view def: columns the view does not select are ignored like "-" tagged
+// fields, and the key options follow the primary key of the view.
+func viewCodec(base *structCodec, def ViewDef) *structCodec {
+  selected := func(col string) bool {
+    return len(def.Columns) == 0 || inColumns(def.Columns, col) ||
+      inColumns(def.PartitionKeys, col) || inColumns(def.ClusteringKeys, col)
+  }
+  c := &structCodec{
+    typ:            base.typ,
+    columnFamily:   def.Name,
+    byIndex:        make([]structTag, len(base.byIndex)),
+    byName:         make(map[string]fieldCodec),
+    partitionKeys:  def.PartitionKeys,
+    clusteringKeys: def.ClusteringKeys,
+  }
+  for i, tag := range base.byIndex {
+    col := tag.name
+    if tag.function != "" {
+      col = strings.TrimSuffix(tag.name[len(tag.function)+1:], ")")
+    }
+    if tag.name != "-" && !selected(col) {
+      tag.name = "-"
+    }
+    tag.partitionKey = inColumns(def.PartitionKeys, tag.name)
+    tag.clusteringKey = inColumns(def.ClusteringKeys, tag.name)
+    tag.descending = inColumns(def.Descending, tag.name)
+    tag.indexed = false
+    c.byIndex[i] = tag
+    if tag.name != "-" {
+      c.byName[tag.name] = fieldCodec{index: i}
+    }
+    if tag.stored() {
+      c.nrDBCols++
+    }
+  }
+  return c
+}
+
+// inColumns reports whether the column col is in cols.
+func inColumns(cols []string, col string) bool {
+  for _, c := range cols {
+    if c == col {
+      return true
+    }
+  }
+  return false
+}
+
+// Name returns the name of the view.
+func (v *View) Name() string {
+  return v.def.Name
+}
+
+// CreateCQL returns the CREATE MATERIALIZED VIEW IF NOT EXISTS statement of
+// the view.
+func (v *View) CreateCQL() string {
+  cols := "*"
+  if len(v.def.Columns) > 0 {
+    cols = v.codec.getColumnStr()
+  }
+  keys := v.codec.keyColumns()
+  conds := make([]string, len(keys))
+  for i, k := range keys {
+    conds[i] = k + " IS NOT NULL"
+  }
+  primaryKey := "(" + strings.Join(v.def.PartitionKeys, ", ") + ")"
+  if len(v.def.ClusteringKeys) > 0 {
+    primaryKey += ", " + strings.Join(v.def.ClusteringKeys, ", ")
+  }
+  cql := fmt.Sprintf("CREATE MATERIALIZED VIEW IF NOT EXISTS %s AS "+
+    "SELECT %s FROM %s WHERE %s PRIMARY KEY (%s)", v.def.Name, cols,
+    v.base.columnFamily, strings.Join(conds, " AND "), primaryKey)
+  if len(v.def.ClusteringKeys) > 0 {
+    order := make([]string, len(v.def.ClusteringKeys))
+    for i, k := range v.def.ClusteringKeys {
+      if inColumns(v.def.Descending, k) {
+        order[i] = k + " DESC"
+      } else {
+        order[i] = k + " ASC"
+      }
+    }
+    cql += fmt.Sprintf(" WITH CLUSTERING ORDER BY (%s)",
+      strings.Join(order, ", "))
+  }
+  return cql
+}
+
+// Create creates the view if it does not exist yet, see CreateCQL.
+func (v *View) Create(session Session) error {
+  stmt := newStatement(&options{}, v.CreateCQL(), nil)
+  return exec(context.Background(), session, stmt)
+}
+
+// Drop drops the view if it exists.
+func (v *View) Drop(session Session) error {
+  cql := fmt.Sprintf("DROP MATERIALIZED VIEW IF EXISTS %s", v.def.Name)
+  stmt := newStatement(&options{}, cql, nil)
+  return exec(context.Background(), session, stmt)
+}
+
+// NewViewQuery creates a new Query reading the view v, like NewQuery does
+// the table of the entity type. Filters and orders apply to the columns of
+// the view and the rows load into the entity type.
+func NewViewQuery(v *View, opts ...Option) (*Query, error) {
+  return &Query{
+    limit: -1,
+    codec: v.codec,
+    opts:  opts,
+    stmt:  &queryStmt{},
+  }, nil
+}